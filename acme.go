@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// Renew certificates this long before expiry
+	acmeRenewBefore = 30 * 24 * time.Hour
+
+	// Period between certificate expiry checks
+	acmeCheckPeriod = 12 * time.Hour
+
+	// Maximum time for a whole certificate order
+	acmeOrderTimeout = 10 * time.Minute
+
+	// ACME state file names within -acme-dir
+	acmeAccountKeyFile = "account.key"
+	acmeCertFile       = "cert.pem"
+	acmeKeyFile        = "key.pem"
+)
+
+var (
+	// Certificate domains, wildcards allowed (ACME disabled if empty)
+	acmeDomains []string
+
+	// ACME account contact email
+	acmeEmail string
+
+	// ACME directory URL
+	acmeDirectory string
+
+	// Directory holding account key and issued certificate
+	acmeDir string
+
+	// Time to wait for DNS-01 TXT records to propagate before validation
+	acmeDNSPropagation time.Duration
+
+	// DNS-01 provider used to publish challenge records
+	acmeDNS acmeDNSProvider
+
+	// Current certificate served by the HTTPS server
+	acmeCert      *tls.Certificate
+	acmeCertMutex sync.RWMutex
+)
+
+func acmeEnabled() bool {
+	return len(acmeDomains) > 0
+}
+
+func parseACMEDomains(str string) []string {
+	domains := []string{}
+	for _, domain := range strings.Split(str, ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func acmeGetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	acmeCertMutex.RLock()
+	defer acmeCertMutex.RUnlock()
+	if acmeCert == nil {
+		return nil, errors.New("no ACME certificate issued yet")
+	}
+	return acmeCert, nil
+}
+
+func loadOrCreateECKey(path string) (*ecdsa.PrivateKey, error) {
+	// Load existing key
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("Invalid key file " + path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Generate and persist new key
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func loadACMECert() error {
+	// Load previously issued certificate, none is fine
+	cert, err := tls.LoadX509KeyPair(filepath.Join(acmeDir, acmeCertFile), filepath.Join(acmeDir, acmeKeyFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	acmeCertMutex.Lock()
+	acmeCert = &cert
+	acmeCertMutex.Unlock()
+	return nil
+}
+
+func acmeNeedsRenewal() bool {
+	acmeCertMutex.RLock()
+	defer acmeCertMutex.RUnlock()
+
+	// Renew if missing, expiring soon, or domains changed
+	if acmeCert == nil || time.Until(acmeCert.Leaf.NotAfter) < acmeRenewBefore {
+		return true
+	}
+	names := map[string]bool{}
+	for _, name := range acmeCert.Leaf.DNSNames {
+		names[name] = true
+	}
+	for _, domain := range acmeDomains {
+		if !names[domain] {
+			return true
+		}
+	}
+	return false
+}
+
+func acmeAuthorize(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	} else if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	// Wildcards can only be validated with DNS-01
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return errors.New("no dns-01 challenge offered for " + authz.Identifier.Value)
+	}
+
+	// Publish the TXT record (wildcard authorizations are for the base domain)
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+	err = acmeDNS.Present(ctx, fqdn, value)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := acmeDNS.CleanUp(globalContext, fqdn, value); err != nil {
+			log.Printf("Failed to clean up ACME challenge record - %s\n", err.Error())
+		}
+	}()
+
+	// Give the record time to propagate, then have the CA validate it
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(acmeDNSPropagation):
+	}
+	_, err = client.Accept(ctx, challenge)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+func obtainACMECert() error {
+	ctx, cancel := context.WithTimeout(globalContext, acmeOrderTimeout)
+	defer cancel()
+
+	// Register (or look up) account
+	accountKey, err := loadOrCreateECKey(filepath.Join(acmeDir, acmeAccountKeyFile))
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: acmeDirectory}
+	account := &acme.Account{}
+	if acmeEmail != "" {
+		account.Contact = []string{"mailto:" + acmeEmail}
+	}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return err
+	}
+
+	// Place order and complete each authorization
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(acmeDomains...))
+	if err != nil {
+		return err
+	}
+	for _, authzURL := range order.AuthzURLs {
+		err = acmeAuthorize(ctx, client, authzURL)
+		if err != nil {
+			return err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return err
+	}
+
+	// Finalize with CSR for a new certificate key
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: acmeDomains[0]},
+		DNSNames: acmeDomains,
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+
+	// Persist certificate chain and key
+	certPEM := []byte{}
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(acmeDir, acmeKeyFile), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(acmeDir, acmeCertFile), certPEM, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Start serving it
+	return loadACMECert()
+}
+
+func setupACME(providerName, providerConfig string) error {
+	// Ensure state directory
+	if acmeDir == "" {
+		return errors.New("ACME requires -acme-dir")
+	}
+	err := os.MkdirAll(acmeDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Setup DNS-01 provider
+	acmeDNS, err = newACMEDNSProvider(providerName, providerConfig)
+	if err != nil {
+		return err
+	}
+
+	// Load existing certificate, obtaining a new one before serving if needed
+	err = loadACMECert()
+	if err != nil {
+		return err
+	}
+	if acmeNeedsRenewal() {
+		log.Printf("Obtaining ACME certificate for %s\n", strings.Join(acmeDomains, ", "))
+		err = obtainACMECert()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Keep renewing in the background
+	go func() {
+		for {
+			select {
+			case <-globalContext.Done():
+				return
+			case <-time.After(acmeCheckPeriod):
+			}
+
+			if !acmeNeedsRenewal() {
+				continue
+			}
+			log.Printf("Renewing ACME certificate for %s\n", strings.Join(acmeDomains, ", "))
+			err := obtainACMECert()
+			if err != nil {
+				log.Printf("Failed to renew ACME certificate - %s\n", err.Error())
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// TTL on published ACME challenge records
+	acmeChallengeTTL = 60
+)
+
+var (
+	// HTTP client used for DNS provider API calls
+	acmeDNSClient = &http.Client{Timeout: 30 * time.Second}
+
+	// Built-in DNS-01 providers by name
+	acmeDNSProviders = map[string]func(config map[string]string) (acmeDNSProvider, error){
+		"cloudflare": newCloudflareDNS,
+		"route53":    newRoute53DNS,
+		"rfc2136":    newRFC2136DNS,
+	}
+)
+
+// acmeDNSProvider publishes and removes DNS-01 challenge TXT records.
+type acmeDNSProvider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+func parseProviderConfig(str string) (map[string]string, error) {
+	// Config is 'key=value,key=value'
+	config := map[string]string{}
+	for _, pair := range strings.Split(str, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("Invalid provider config: " + pair)
+		}
+		config[kv[0]] = kv[1]
+	}
+	return config, nil
+}
+
+func requireConfig(config map[string]string, keys ...string) error {
+	for _, key := range keys {
+		if config[key] == "" {
+			return errors.New("Provider config missing " + key)
+		}
+	}
+	return nil
+}
+
+func newACMEDNSProvider(name, configStr string) (acmeDNSProvider, error) {
+	newProvider, ok := acmeDNSProviders[name]
+	if !ok {
+		return nil, errors.New("Unknown ACME DNS provider: " + name)
+	}
+	config, err := parseProviderConfig(configStr)
+	if err != nil {
+		return nil, err
+	}
+	return newProvider(config)
+}
+
+// Cloudflare: token=<API token with DNS edit>,zone_id=<zone ID>
+
+type cloudflareDNS struct {
+	token   string
+	zoneID  string
+	records map[string]string
+	mutex   sync.Mutex
+}
+
+func newCloudflareDNS(config map[string]string) (acmeDNSProvider, error) {
+	if err := requireConfig(config, "token", "zone_id"); err != nil {
+		return nil, err
+	}
+	return &cloudflareDNS{
+		token:   config["token"],
+		zoneID:  config["zone_id"],
+		records: map[string]string{},
+	}, nil
+}
+
+func (cf *cloudflareDNS) do(ctx context.Context, method, path string, body interface{}) (string, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, "https://api.cloudflare.com/client/v4/zones/"+cf.zoneID+"/dns_records"+path, reader)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+cf.token)
+	request.Header.Set("content-type", "application/json")
+
+	response, err := acmeDNSClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	result := struct {
+		Success bool `json:"success"`
+		Result  struct {
+			ID string `json:"id"`
+		} `json:"result"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return "", err
+	} else if !result.Success {
+		return "", errors.New("Cloudflare API responded with: " + response.Status)
+	}
+	return result.Result.ID, nil
+}
+
+func (cf *cloudflareDNS) Present(ctx context.Context, fqdn, value string) error {
+	id, err := cf.do(ctx, "POST", "", map[string]interface{}{
+		"type":    "TXT",
+		"name":    strings.TrimSuffix(fqdn, "."),
+		"content": value,
+		"ttl":     acmeChallengeTTL,
+	})
+	if err != nil {
+		return err
+	}
+	cf.mutex.Lock()
+	cf.records[fqdn+" "+value] = id
+	cf.mutex.Unlock()
+	return nil
+}
+
+func (cf *cloudflareDNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	cf.mutex.Lock()
+	id, ok := cf.records[fqdn+" "+value]
+	delete(cf.records, fqdn+" "+value)
+	cf.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	_, err := cf.do(ctx, "DELETE", "/"+id, nil)
+	return err
+}
+
+// Route53: access_key=...,secret_key=...,zone_id=<hosted zone ID>
+
+type route53DNS struct {
+	accessKey string
+	secretKey string
+	zoneID    string
+}
+
+func newRoute53DNS(config map[string]string) (acmeDNSProvider, error) {
+	if err := requireConfig(config, "access_key", "secret_key", "zone_id"); err != nil {
+		return nil, err
+	}
+	return &route53DNS{
+		accessKey: config["access_key"],
+		secretKey: config["secret_key"],
+		zoneID:    strings.TrimPrefix(config["zone_id"], "/hostedzone/"),
+	}, nil
+}
+
+func (r53 *route53DNS) change(ctx context.Context, action, fqdn, value string) error {
+	body := []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ChangeResourceRecordSetsRequest xmlns="https://route53.amazonaws.com/doc/2013-04-01/"><ChangeBatch><Changes><Change>
+<Action>%s</Action><ResourceRecordSet><Name>%s</Name><Type>TXT</Type><TTL>%d</TTL>
+<ResourceRecords><ResourceRecord><Value>"%s"</Value></ResourceRecord></ResourceRecords>
+</ResourceRecordSet></Change></Changes></ChangeBatch></ChangeResourceRecordSetsRequest>`, action, fqdn, acmeChallengeTTL, value))
+
+	request, err := http.NewRequestWithContext(ctx, "POST", "https://route53.amazonaws.com/2013-04-01/hostedzone/"+r53.zoneID+"/rrset", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("content-type", "application/xml")
+	signAWSRequest(request, body, "us-east-1", "route53", r53.accessKey, r53.secretKey)
+
+	response, err := acmeDNSClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(response.Body)
+		return errors.New("Route53 API responded with: " + response.Status + " " + string(msg))
+	}
+	return nil
+}
+
+func (r53 *route53DNS) Present(ctx context.Context, fqdn, value string) error {
+	return r53.change(ctx, "UPSERT", fqdn, value)
+}
+
+func (r53 *route53DNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	return r53.change(ctx, "DELETE", fqdn, value)
+}
+
+// RFC2136: server=<host:port>,zone=<zone>[,tsig_name=...,tsig_secret=<base64>,tsig_alg=hmac-sha256.]
+
+type rfc2136DNS struct {
+	server     string
+	zone       string
+	tsigName   string
+	tsigSecret string
+	tsigAlg    string
+}
+
+func newRFC2136DNS(config map[string]string) (acmeDNSProvider, error) {
+	if err := requireConfig(config, "server", "zone"); err != nil {
+		return nil, err
+	}
+	provider := &rfc2136DNS{
+		server:  config["server"],
+		zone:    dns.Fqdn(config["zone"]),
+		tsigAlg: dns.HmacSHA256,
+	}
+	if config["tsig_name"] != "" {
+		if err := requireConfig(config, "tsig_secret"); err != nil {
+			return nil, err
+		}
+		provider.tsigName = dns.Fqdn(config["tsig_name"])
+		provider.tsigSecret = config["tsig_secret"]
+	}
+	if config["tsig_alg"] != "" {
+		provider.tsigAlg = dns.Fqdn(config["tsig_alg"])
+	}
+	return provider, nil
+}
+
+func (rfc *rfc2136DNS) update(ctx context.Context, insert bool, fqdn, value string) error {
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: acmeChallengeTTL},
+		Txt: []string{value},
+	}
+
+	// Build dynamic update, TSIG signed if configured
+	msg := &dns.Msg{}
+	msg.SetUpdate(rfc.zone)
+	if insert {
+		msg.Insert([]dns.RR{rr})
+	} else {
+		msg.Remove([]dns.RR{rr})
+	}
+	client := &dns.Client{Net: "tcp"}
+	if rfc.tsigName != "" {
+		msg.SetTsig(rfc.tsigName, rfc.tsigAlg, 300, time.Now().Unix())
+		client.TsigSecret = map[string]string{rfc.tsigName: rfc.tsigSecret}
+	}
+
+	response, _, err := client.ExchangeContext(ctx, msg, rfc.server)
+	if err != nil {
+		return err
+	} else if response.Rcode != dns.RcodeSuccess {
+		return errors.New("DNS update responded with: " + dns.RcodeToString[response.Rcode])
+	}
+	return nil
+}
+
+func (rfc *rfc2136DNS) Present(ctx context.Context, fqdn, value string) error {
+	return rfc.update(ctx, true, fqdn, value)
+}
+
+func (rfc *rfc2136DNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	return rfc.update(ctx, false, fqdn, value)
+}
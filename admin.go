@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	icorepath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+const (
+	apiPrefix = "/api/"
+)
+
+var (
+	// Admin API bearer token (admin routes disabled if empty)
+	adminToken string
+)
+
+func isAdminRequest(request *http.Request) bool {
+	// Admin routes disabled without a token
+	if adminToken == "" {
+		return false
+	}
+
+	// Get the bearer token from authorization header
+	auth := request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+
+	// Compare in constant time
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+func adminHandler(handle httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		// Ensure request is authenticated before passing on
+		if !isAdminRequest(request) {
+			log.Printf("Unauthorized admin request from %s\n", request.RemoteAddr)
+			http.Error(writer, "Unauthorized!", http.StatusUnauthorized)
+			return
+		}
+		handle(writer, request, params)
+	}
+}
+
+func pinPaste(c cid.Cid) error {
+	if pinner, ok := storageBackend.(Pinner); ok {
+		return pinner.Pin(globalContext, c)
+	}
+	return shardForCID(c).api.Pin().Add(globalContext, icorepath.IpldPath(c))
+}
+
+func unpinPaste(c cid.Cid) error {
+	if pinner, ok := storageBackend.(Pinner); ok {
+		return pinner.Unpin(globalContext, c)
+	}
+	return shardForCID(c).api.Pin().Rm(globalContext, icorepath.IpldPath(c))
+}
+
+func pinHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest(request.Method, apiPrefix+"pin/"+cidStr, request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Invalid paste CID!", http.StatusBadRequest)
+		return
+	}
+
+	// Pin or unpin depending on method
+	if request.Method == http.MethodDelete {
+		err = unpinPaste(c)
+	} else {
+		err = pinPaste(c)
+	}
+	if err != nil {
+		log.Printf("Failed to update paste pin - %s\n", err.Error())
+		http.Error(writer, "Failed to update paste pin", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the paste path in response
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(pastePrefix + cidStr))
+}
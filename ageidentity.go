@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	ageRecipientPath = "/age-recipient"
+)
+
+var (
+	// Comma-separated age identity files (instance decryption disabled if unset)
+	ageIdentityFiles string
+
+	// Bearer token allowing server-side decryption with the instance identities
+	ageDecryptToken string
+
+	// Loaded instance identities and their recipients, first is published
+	instanceIdentities []string
+	instanceRecipients []string
+
+	// Returned when no instance identity opens a paste
+	errNotInstanceRecipient = errors.New("paste not encrypted to this instance")
+)
+
+func setupAgeIdentities() error {
+	// Skip if disabled
+	if ageIdentityFiles == "" {
+		return nil
+	}
+
+	// Load each identity file in turn
+	for _, path := range strings.Split(ageIdentityFiles, ",") {
+		identities, err := readAgeIdentityFile(strings.TrimSpace(path))
+		if err != nil {
+			return errors.New("Failed to load age identities from " + path + " - " + err.Error())
+		}
+		instanceIdentities = append(instanceIdentities, identities...)
+	}
+
+	// Derive recipients so the first can be published
+	for _, identity := range instanceIdentities {
+		secret, _ := parseIdentity(identity)
+		public, err := curve25519.X25519(secret, curve25519.Basepoint)
+		if err != nil {
+			return err
+		}
+		recipient, err := bech32Encode(recipientHRP, public)
+		if err != nil {
+			return err
+		}
+		instanceRecipients = append(instanceRecipients, recipient)
+	}
+
+	log.Printf("Loaded %d age identities, instance recipient %s\n", len(instanceIdentities), instanceRecipients[0])
+	return nil
+}
+
+func readAgeIdentityFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Same format as age-keygen output, one identity per line with # comments
+	identities := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := parseIdentity(line); err != nil {
+			return nil, err
+		}
+		identities = append(identities, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.New("no identities found")
+	}
+	return identities, nil
+}
+
+func isInstanceDecryptRequest(request *http.Request) bool {
+	// Disabled without identities
+	if len(instanceIdentities) == 0 {
+		return false
+	}
+
+	// Admins may always decrypt
+	if isAdminRequest(request) {
+		return true
+	} else if ageDecryptToken == "" {
+		return false
+	}
+
+	// Otherwise compare the bearer token in constant time
+	auth := request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(ageDecryptToken)) == 1
+}
+
+func (p *paste) decryptWithInstanceIdentities(binding *pasteBinding) error {
+	// Try each identity, unwrapping fails fast for those not among the recipients
+	for _, identity := range instanceIdentities {
+		if err := p.decrypt(identity, binding); err == nil {
+			return nil
+		}
+	}
+	return errNotInstanceRecipient
+}
+
+func ageRecipientHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", ageRecipientPath, request.RemoteAddr)
+
+	// Ensure instance identities loaded
+	if len(instanceRecipients) == 0 {
+		http.Error(writer, "Instance decryption not enabled!", http.StatusNotFound)
+		return
+	}
+
+	// Write the instance's published recipient
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(instanceRecipients[0] + "\n"))
+}
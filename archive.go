@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	archivePath = "/archive"
+
+	// Default and maximum pastes per archive page
+	defaultArchiveLimit = 100
+	maxArchiveLimit     = 500
+)
+
+var (
+	// Archive requests allowed per client per minute (0 disables the archive)
+	archiveRate uint
+
+	// Archive request allowance by client
+	archiveRates = newClientRates()
+)
+
+type archiveEntry struct {
+	CID         string    `json:"cid"`
+	Path        string    `json:"path"`
+	Published   time.Time `json:"published"`
+	Title       string    `json:"title,omitempty"`
+	Language    string    `json:"language,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Parent      string    `json:"parent,omitempty"`
+}
+
+type archivePage struct {
+	Pastes []*archiveEntry `json:"pastes"`
+
+	// Cursor to resume from, also when caught up so mirrors can poll for new pastes
+	Next string `json:"next,omitempty"`
+	More bool   `json:"more"`
+}
+
+func parseArchiveCursor(after string) (string, error) {
+	// Cursors from a previous page are feed entry names, else a unix timestamp to start from
+	if after == "" || strings.Contains(after, "_") {
+		return after, nil
+	}
+	ts, err := strconv.ParseInt(after, 10, 64)
+	if err != nil || ts < 0 {
+		return "", fmt.Errorf("Invalid archive cursor: %s", after)
+	}
+	return fmt.Sprintf("%020d", time.Unix(ts, 0).UnixNano()), nil
+}
+
+func archivePublicPastes(after string, limit int) (*archivePage, error) {
+	// Get time-ordered feed entries, oldest first so pages stay stable as pastes are added
+	names, err := indexList("recent")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	start := sort.SearchStrings(names, after)
+	if start < len(names) && names[start] == after {
+		start++
+	}
+
+	// Collect entries still public and not taken down
+	page := &archivePage{Pastes: []*archiveEntry{}, Next: after}
+	for _, name := range names[start:] {
+		if len(page.Pastes) >= limit {
+			page.More = true
+			break
+		}
+		page.Next = name
+
+		split := strings.SplitN(name, "_", 2)
+		if len(split) != 2 {
+			continue
+		}
+		nanos, err := strconv.ParseInt(split[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		c, err := cid.Decode(split[1])
+		if err != nil {
+			continue
+		}
+		public, err := isPublicPaste(split[1])
+		if err != nil {
+			return nil, err
+		} else if !public {
+			continue
+		}
+		blocked, err := isBlockedPaste(c)
+		if err != nil {
+			return nil, err
+		}
+		tombstoned, err := isTombstonedPaste(c)
+		if err != nil {
+			return nil, err
+		} else if blocked || tombstoned {
+			continue
+		}
+
+		// Only public metadata, never hints
+		meta, err := getPasteMeta(c)
+		if err != nil {
+			return nil, err
+		}
+		page.Pastes = append(page.Pastes, &archiveEntry{
+			CID:         split[1],
+			Path:        pastePrefix + split[1],
+			Published:   time.Unix(0, nanos).UTC(),
+			Title:       meta.Title,
+			Language:    meta.Language,
+			Tags:        meta.Tags,
+			ContentType: meta.ContentType,
+			Parent:      meta.Parent,
+		})
+	}
+
+	return page, nil
+}
+
+func archiveHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", archivePath, request.RemoteAddr)
+
+	// Ensure archive enabled
+	if archiveRate == 0 {
+		http.Error(writer, "Archive not enabled!", http.StatusNotFound)
+		return
+	}
+
+	// Crawlers get a strict per-client allowance, told what's left so they can slow down before it runs out
+	wait, remaining := archiveRates.take(request, archiveRate, time.Minute)
+	writer.Header().Set("X-Quota-Limit", strconv.FormatUint(uint64(archiveRate), 10))
+	writer.Header().Set("X-Quota-Remaining", strconv.Itoa(remaining))
+	if wait > 0 {
+		writer.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(writer, "Archive rate limit exceeded, slow down!", http.StatusTooManyRequests)
+		return
+	}
+
+	// Parse cursor and page size
+	after, err := parseArchiveCursor(request.URL.Query().Get("after"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultArchiveLimit
+	if limitStr := request.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxArchiveLimit {
+			http.Error(writer, "Invalid limit!", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get the page of public pastes
+	page, err := archivePublicPastes(after, limit)
+	if err != nil {
+		log.Printf("Failed to list archive - %s\n", err.Error())
+		http.Error(writer, "Failed to list archive", http.StatusInternalServerError)
+		return
+	}
+
+	// Link the next page so crawlers can just follow it
+	if page.More {
+		next := url.Values{"after": {page.Next}, "limit": {strconv.Itoa(limit)}}
+		writer.Header().Set("Link", "<"+archivePath+"?"+next.Encode()+">; rel=\"next\"")
+	}
+	writeJSON(writer, page)
+}
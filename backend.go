@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	icorepath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+const (
+	// Default storage backend name
+	ipfsBackendName = "ipfs"
+
+	// Most a stored paste block exceeds the paste size limit by, beyond compression and stream cipher
+	// growth: envelope header, sealed metadata, wrapped keys, signature and MAC
+	maxPasteOverhead = 64 * 1024
+)
+
+var (
+	// Registered storage backend factories by name
+	backendFactories   = map[string]BackendFactory{}
+	backendFactoriesMu sync.Mutex
+
+	// Storage backend selected by name and its config string
+	storageBackendName   string
+	storageBackendConfig string
+
+	// Storage backend paste blocks are put to and got from
+	storageBackend Backend
+
+	// Pin uploaded pastes by default so garbage collection keeps them
+	pinPastes bool
+
+	// Returned for stored blocks too large to be a paste
+	errPasteBlockTooLarge = errors.New("paste block too large")
+)
+
+// Backend stores paste blocks by CID. Backends must return an error
+// (ds.ErrNotFound preferred) from Get for unknown CIDs, and Put must be
+// idempotent as the same paste may be put more than once. The local index,
+// IPLD objects (bundles, collections, comments) and replication stay on
+// the IPFS repo shards. Puts take per-call options, so behaviour can vary
+// by request and in-process callers needn't touch instance globals.
+type Backend interface {
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+	Put(ctx context.Context, c cid.Cid, b []byte, opts PutOptions) error
+}
+
+// PutOptions are per-call Backend put options, the zero value meaning a
+// plain put. Backends ignore options they have no use for.
+type PutOptions struct {
+	// Keep the block through garbage collection
+	Pin bool
+
+	// ipfs-cluster peers to pin the block on, -1 for every peer, 0 for none
+	Replication int
+
+	// Multihash the paste CID is computed with, 0 for the instance default
+	Hash uint64
+}
+
+// Pinner is implemented by backends that pin blocks themselves, so admin
+// pins, view limits and expiry act on the backend rather than the shards.
+type Pinner interface {
+	Pin(ctx context.Context, c cid.Cid) error
+	Unpin(ctx context.Context, c cid.Cid) error
+}
+
+// BackendFactory constructs a Backend from its -storage-backend-config string.
+type BackendFactory func(config string) (Backend, error)
+
+// RegisterBackend makes a storage backend selectable by name. Third party
+// backends are compiled in by adding a file calling this from init().
+func RegisterBackend(name string, factory BackendFactory) {
+	backendFactoriesMu.Lock()
+	defer backendFactoriesMu.Unlock()
+
+	if _, ok := backendFactories[name]; ok {
+		panic("storage backend registered twice: " + name)
+	}
+	backendFactories[name] = factory
+}
+
+func init() {
+	// Register built-in IPFS shard backend
+	RegisterBackend(ipfsBackendName, func(config string) (Backend, error) {
+		if config != "" {
+			return nil, errors.New("IPFS backend takes no config, use -ipfs-repo")
+		}
+		return ipfsBackend{}, nil
+	})
+}
+
+func registeredBackends() []string {
+	backendFactoriesMu.Lock()
+	defer backendFactoriesMu.Unlock()
+
+	names := []string{}
+	for name := range backendFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func setupStorageBackend() error {
+	// Look for the selected backend
+	backendFactoriesMu.Lock()
+	factory, ok := backendFactories[storageBackendName]
+	backendFactoriesMu.Unlock()
+	if !ok {
+		return errors.New("Unknown storage backend: " + storageBackendName + " (available: " + strings.Join(registeredBackends(), ", ") + ")")
+	}
+
+	// Construct with supplied config
+	backend, err := factory(storageBackendConfig)
+	if err != nil {
+		return errors.New("Failed to setup storage backend " + storageBackendName + " - " + err.Error())
+	}
+	storageBackend = backend
+
+	return nil
+}
+
+func maxPasteBlockSize() int64 {
+	// Incompressible text grows slightly when compressed, as does each stream cipher chunk
+	return maxPasteSize + maxPasteSize/256 + maxPasteOverhead
+}
+
+func readPasteBlock(reader io.Reader) ([]byte, error) {
+	// Refuse blocks too large to be a paste rather than truncating them
+	b, err := ioutil.ReadAll(io.LimitReader(reader, maxPasteBlockSize()+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(b)) > maxPasteBlockSize() {
+		return nil, errPasteBlockTooLarge
+	}
+	return b, nil
+}
+
+// ipfsBackend stores paste blocks in the IPFS repo shards.
+type ipfsBackend struct{}
+
+func (ipfsBackend) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return shardForCID(c).node.Blockstore.Has(c)
+}
+
+func (ipfsBackend) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	// Get reader for object from the responsible shard
+	reader, err := shardForCID(c).api.Block().Get(ctx, icorepath.IpldPath(c))
+	if err != nil {
+		return nil, err
+	}
+
+	// Read from the supplied reader
+	return readPasteBlock(reader)
+}
+
+func (ipfsBackend) Put(ctx context.Context, c cid.Cid, b []byte, opts PutOptions) error {
+	// Block API takes the CID version as its format, v0 or the codec name
+	prefix := c.Prefix()
+	format := "v0"
+	if prefix.Version == 1 {
+		format = cid.CodecToStr[prefix.Codec]
+	}
+
+	// Put in responsible shard, ensuring it resolved to the same CID
+	stat, err := shardForCID(c).api.Block().Put(ctx, bytes.NewReader(b), options.Block.Format(format), options.Block.Hash(prefix.MhType, -1), options.Block.Pin(opts.Pin))
+	if err != nil {
+		return err
+	} else if !stat.Path().Cid().Equals(c) {
+		return errors.New("IPFS block CID mismatch")
+	}
+	return nil
+}
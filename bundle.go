@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	bundlePrefix = "/bundle"
+
+	// Maximum number of files in a bundle
+	maxBundleFiles = 1000
+
+	// Maximum bundle file path length
+	maxBundlePathLength = 256
+
+	// Maximum bundle upload size (as multiple of max paste size)
+	maxBundleSizeFactor = 16
+)
+
+type bundleFile struct {
+	Path  string  `refmt:"path"`
+	Paste cid.Cid `refmt:"paste"`
+	Size  int     `refmt:"size"`
+}
+
+type bundle struct {
+	Files []bundleFile `refmt:"files"`
+}
+
+type bundleFileResponse struct {
+	Path  string `json:"path"`
+	Paste string `json:"paste"`
+	Size  int    `json:"size"`
+}
+
+func init() {
+	// Register bundle for CBOR (un)marshaling
+	cbor.RegisterCborType(bundleFile{})
+	cbor.RegisterCborType(bundle{})
+}
+
+func putBundle(bndl *bundle) (cid.Cid, error) {
+	// Wrap as IPLD CBOR node and add to the responsible shard
+	node, err := cbor.WrapObject(bndl, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = shardForCID(node.Cid()).api.Dag().Add(globalContext, node)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Pin so repo GC keeps it
+	err = pinNode(globalContext, node.Cid())
+	if err != nil {
+		return cid.Undef, err
+	}
+	return node.Cid(), nil
+}
+
+func getBundle(c cid.Cid) (*bundle, error) {
+	// Get the IPLD node from responsible shard
+	node, err := shardForCID(c).api.Dag().Get(globalContext, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the bundle
+	bndl := &bundle{}
+	err = cbor.DecodeInto(node.RawData(), bndl)
+	if err != nil {
+		return nil, err
+	}
+	return bndl, nil
+}
+
+func (bndl *bundle) lookup(filePath string) (*bundleFile, bool) {
+	for i := range bndl.Files {
+		if bndl.Files[i].Path == filePath {
+			return &bndl.Files[i], true
+		}
+	}
+	return nil, false
+}
+
+func cleanBundlePath(filePath string) (string, error) {
+	// Clean to a relative path, rejecting any escape attempts
+	cleaned := path.Clean("/" + strings.ReplaceAll(filePath, "\\", "/"))[1:]
+	if cleaned == "" || len(cleaned) > maxBundlePathLength || strings.ContainsAny(cleaned, "\x00\n\t") {
+		return "", errors.New("Invalid bundle file path: " + filePath)
+	}
+	return cleaned, nil
+}
+
+func putBundleFile(ctx context.Context, filePath string, b []byte, key string, opts PutOptions) (cid.Cid, error) {
+	// Create new paste, compress before any encryption
+	p := &paste{text: b}
+	err := p.compress()
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Keep file name and image type as metadata, sealed inside the ciphertext if key supplied
+	meta := &pasteMeta{Title: truncateTitle(path.Base(filePath))}
+	if contentType := http.DetectContentType(b); imageContentTypes[contentType] {
+		meta.ContentType = contentType
+	}
+	if key != "" {
+		err = p.sealMeta(meta)
+		if err != nil {
+			return cid.Undef, err
+		}
+		err = p.encrypt(key, nil)
+		if err != nil {
+			return cid.Undef, err
+		}
+		meta = nil
+	}
+
+	// Place the paste into the IPFS store
+	c, duplicate, err := putPaste(ctx, p, opts)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Store metadata if any, an existing paste keeps its own
+	if meta != nil && !duplicate {
+		err = updatePasteMeta(c, func(existing *pasteMeta) {
+			existing.Title = meta.Title
+			existing.ContentType = meta.ContentType
+		})
+		if err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	// Record upload for operator reports and run create hook
+	if !duplicate {
+		recordReportUpload("bundle", len(b))
+		runHook(hookOnCreate, c)
+	}
+
+	return c, nil
+}
+
+func readMultipartFiles(writer http.ResponseWriter, request *http.Request, handle func(filePath string, b []byte) error) (int, error) {
+	// Read files from multipart body
+	request.Body = http.MaxBytesReader(writer, request.Body, maxPasteSize*maxBundleSizeFactor)
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return 0, err
+	}
+
+	seen := map[string]bool{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+
+		// Get the full relative file path (part.FileName() strips directories)
+		_, dispParams, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil || dispParams["filename"] == "" {
+			continue
+		}
+		filePath, err := cleanBundlePath(dispParams["filename"])
+		if err != nil {
+			return 0, err
+		} else if seen[filePath] {
+			return 0, errors.New("Duplicate file path: " + filePath)
+		} else if len(seen) >= maxBundleFiles {
+			return 0, errors.New("Too many files")
+		}
+		seen[filePath] = true
+
+		// Read file content with paste size limit
+		b, err := ioutil.ReadAll(io.LimitReader(part, maxPasteSize+1))
+		if err != nil {
+			return 0, err
+		} else if int64(len(b)) > maxPasteSize {
+			return 0, errors.New("File too large: " + filePath)
+		}
+
+		// Hand the file over
+		err = handle(filePath, b)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(seen) == 0 {
+		return 0, errors.New("No files supplied")
+	}
+	return len(seen), nil
+}
+
+func readBundleFiles(writer http.ResponseWriter, request *http.Request, key string) (*bundle, error) {
+	opts, err := pastePutOptions(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store each file as its own paste
+	bndl := &bundle{Files: []bundleFile{}}
+	_, err = readMultipartFiles(writer, request, func(filePath string, b []byte) error {
+		c, err := putBundleFile(request.Context(), filePath, b, key, opts)
+		if err != nil {
+			return err
+		}
+		bndl.Files = append(bndl.Files, bundleFile{Path: filePath, Paste: c, Size: len(b)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bndl, nil
+}
+
+func renderBundle(bndl *bundle, asJSON bool) ([]byte, error) {
+	// Render JSON if requested
+	if asJSON {
+		files := []*bundleFileResponse{}
+		for _, file := range bndl.Files {
+			files = append(files, &bundleFileResponse{
+				Path:  file.Path,
+				Paste: file.Paste.String(),
+				Size:  file.Size,
+			})
+		}
+		return json.Marshal(files)
+	}
+
+	// Render the file paste paths
+	buf := &bytes.Buffer{}
+	for _, file := range bndl.Files {
+		buf.WriteString(pastePrefix + file.Paste.String() + "\t" + file.Path + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func createBundleHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", bundlePrefix, request.RemoteAddr)
+
+	// Get encryption key if supplied
+	key, err := requestSecret(request, "key")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Read and store each uploaded file
+	bndl, err := readBundleFiles(writer, request, key)
+	if err != nil {
+		log.Printf("Failed to read bundle files - %s\n", err.Error())
+		http.Error(writer, "Invalid bundle upload!", http.StatusBadRequest)
+		return
+	}
+
+	// Store the new bundle
+	c, err := putBundle(bndl)
+	if err != nil {
+		log.Printf("Failed to put bundle - %s\n", err.Error())
+		http.Error(writer, "Failed to put bundle", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the bundle path in response
+	size := 0
+	for _, file := range bndl.Files {
+		size += file.Size
+	}
+	writePutResponse(writer, request, &putResponse{
+		Path: bundlePrefix + "/" + c.String(),
+		CID:  c.String(),
+		Size: size,
+	})
+}
+
+func getBundleHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", bundlePrefix+"/"+cidStr, request.RemoteAddr)
+
+	// Decode the bundle CID and look for it
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Bundle not found!", http.StatusNotFound)
+		return
+	}
+	bndl, err := getBundle(c)
+	if err != nil {
+		log.Printf("Bundle not retrieved - %s\n", err.Error())
+		http.Error(writer, "Bundle not found!", http.StatusNotFound)
+		return
+	}
+
+	// Render bundle (cached, bundles are immutable)
+	asJSON := wantsJSON(request)
+	b, err := renderCached(renderCacheKey(c, "bundle", strconv.FormatBool(asJSON)), func() ([]byte, error) {
+		return renderBundle(bndl, asJSON)
+	})
+	if err != nil {
+		log.Printf("Failed to render bundle - %s\n", err.Error())
+		http.Error(writer, "Failed to render bundle", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the rendered bundle
+	if asJSON {
+		writer.Header().Set("content-type", "application/json")
+	} else {
+		writer.Header().Set("content-type", "text/plain")
+	}
+	writer.Write(b)
+}
+
+func getBundleFileHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string and file path
+	cidStr := params.ByName("cid")
+	filePath := strings.TrimPrefix(params.ByName("file"), "/")
+
+	// Log the request
+	logRequest("GET", bundlePrefix+"/"+cidStr+"/"+filePath, request.RemoteAddr)
+
+	// Decode the bundle CID and look for it
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Bundle not found!", http.StatusNotFound)
+		return
+	}
+	bndl, err := getBundle(c)
+	if err != nil {
+		log.Printf("Bundle not retrieved - %s\n", err.Error())
+		http.Error(writer, "Bundle not found!", http.StatusNotFound)
+		return
+	}
+
+	// Look for the file in bundle
+	file, ok := bndl.lookup(filePath)
+	if !ok {
+		http.Error(writer, "Bundle file not found!", http.StatusNotFound)
+		return
+	}
+
+	// Serve as the file's paste
+	getPasteHandler(writer, request, httprouter.Params{{Key: "cid", Value: file.Paste.String()}})
+}
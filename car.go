@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+const (
+	// CARv2 header size, following the pragma
+	carV2HeaderSize = 40
+)
+
+var (
+	// CARv2 files open with this fixed pragma, a CARv1 style header of version 2
+	carV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+)
+
+type carHeader struct {
+	Roots   []cid.Cid `refmt:"roots"`
+	Version uint64    `refmt:"version"`
+}
+
+type carReader struct {
+	reader *bufio.Reader
+	header carHeader
+}
+
+func init() {
+	// Register CAR header for CBOR (un)marshaling
+	cbor.RegisterCborType(carHeader{})
+}
+
+func writeCarSection(writer io.Writer, data ...[]byte) error {
+	// Get total section length
+	length := 0
+	for _, d := range data {
+		length += len(d)
+	}
+
+	// Write varint length prefix
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(length))
+	_, err := writer.Write(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	// Write each piece of section data
+	for _, d := range data {
+		_, err = writer.Write(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCarHeader(writer io.Writer, roots []cid.Cid) error {
+	// Marshal CARv1 header
+	b, err := cbor.DumpObject(&carHeader{Roots: roots, Version: 1})
+	if err != nil {
+		return err
+	}
+
+	return writeCarSection(writer, b)
+}
+
+func writeCarV2Header(writer io.Writer, dataSize uint64) error {
+	// No characteristics, CARv1 data straight after the header, no index
+	header := make([]byte, carV2HeaderSize)
+	binary.LittleEndian.PutUint64(header[16:], uint64(len(carV2Pragma)+carV2HeaderSize))
+	binary.LittleEndian.PutUint64(header[24:], dataSize)
+
+	_, err := writer.Write(carV2Pragma)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(header)
+	return err
+}
+
+func writeCarBlock(writer io.Writer, block blocks.Block) error {
+	return writeCarSection(writer, block.Cid().Bytes(), block.RawData())
+}
+
+func newCarReader(reader io.Reader) (*carReader, error) {
+	cr := &carReader{reader: bufio.NewReader(reader)}
+
+	// Read first section as header
+	b, err := cr.readSection()
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal and check header
+	err = cbor.DecodeInto(b, &cr.header)
+	if err != nil {
+		return nil, err
+	} else if cr.header.Version != 1 {
+		return nil, errors.New("Unsupported CAR version")
+	}
+
+	return cr, nil
+}
+
+func (cr *carReader) readSection() ([]byte, error) {
+	// Read varint length prefix
+	length, err := binary.ReadUvarint(cr.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure section size is sane
+	if length > uint64(maxPasteBlockSize())+1024 {
+		return nil, errors.New("CAR section too large")
+	}
+
+	// Read section data
+	b := make([]byte, length)
+	_, err = io.ReadFull(cr.reader, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (cr *carReader) next() (blocks.Block, error) {
+	// Read next section (io.EOF at end)
+	b, err := cr.readSection()
+	if err != nil {
+		return nil, err
+	}
+
+	// Split section into CID and data
+	n, c, err := cid.CidFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	data := b[n:]
+
+	// Verify data matches the CID
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	} else if !chk.Equals(c) {
+		return nil, errors.New("Block data does not match CID")
+	}
+
+	return blocks.NewBlockWithCid(data, c)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var (
+	// CID version new pastes are stored under, 0 for legacy base58 paths
+	pasteCIDVersion uint64
+
+	// Multihash new pastes are stored under unless an upload asks otherwise
+	pasteHash uint64
+
+	// Multihashes pastes may be stored under, those the IPFS blockservice accepts
+	pasteHashes = map[string]uint64{
+		"sha2-256":    mh.SHA2_256,
+		"sha2-512":    mh.SHA2_512,
+		"sha3-256":    mh.SHA3_256,
+		"sha3-512":    mh.SHA3_512,
+		"blake2b-256": mh.BLAKE2B_MIN + 31,
+	}
+)
+
+func parsePasteHash(name string) (uint64, error) {
+	// BLAKE3 is asked for often, but unknown to the bundled go-multihash and refused by the blockservice
+	if name == "blake3" {
+		return 0, errors.New("blake3 unsupported by the bundled IPFS node, use blake2b-256")
+	}
+
+	code, ok := pasteHashes[name]
+	if !ok {
+		return 0, errors.New("Unsupported paste hash: " + name)
+	}
+	return code, nil
+}
+
+func setupPasteCIDs(version uint, hashName string) error {
+	// Check version and default hash are usable together
+	if version > 1 {
+		return errors.New("CID version must be 0 or 1")
+	}
+	hash, err := parsePasteHash(hashName)
+	if err != nil {
+		return err
+	} else if version == 0 && hash != mh.SHA2_256 {
+		return errors.New("CIDv0 requires sha2-256")
+	}
+
+	pasteCIDVersion = uint64(version)
+	pasteHash = hash
+	return nil
+}
+
+func newPasteCIDPrefix(hash uint64) cid.Prefix {
+	// CIDv0 only exists for sha2-256, other hashes are always CIDv1
+	version := pasteCIDVersion
+	if hash != mh.SHA2_256 {
+		version = 1
+	}
+
+	// Codec matches that given legacy CIDv0 pastes, so either version is a paste
+	return cid.Prefix{
+		Version:  version,
+		Codec:    cid.DagProtobuf,
+		MhType:   hash,
+		MhLength: -1,
+	}
+}
+
+func isPasteCID(c cid.Cid) bool {
+	return c.Type() == cid.DagProtobuf
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Deadline for each ipfs-cluster API call
+	clusterTimeout = 30 * time.Second
+
+	// Maximum cluster status response size
+	maxClusterResponseSize = 1024 * 1024
+)
+
+var (
+	// ipfs-cluster REST API base URL (cluster pinning disabled if empty)
+	clusterAPI string
+
+	// ipfs-cluster REST API basic auth as 'user:password' (none if empty)
+	clusterAuth string
+
+	// Cluster peers each new paste is pinned on, -1 for every peer
+	clusterReplication int
+
+	// HTTP client used for ipfs-cluster API calls
+	clusterClient = &http.Client{Timeout: clusterTimeout}
+)
+
+type clusterPeerStatus struct {
+	PeerName string `json:"peername"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+type clusterPinStatus struct {
+	PeerMap map[string]*clusterPeerStatus `json:"peer_map"`
+}
+
+type replicationPeer struct {
+	Peer   string `json:"peer"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type replicationStatus struct {
+	CID    string             `json:"cid"`
+	Pinned int                `json:"pinned"`
+	Peers  []*replicationPeer `json:"peers"`
+}
+
+func clusterEnabled() bool {
+	return clusterAPI != ""
+}
+
+func setupCluster() error {
+	// Validate cluster settings
+	if clusterReplication == 0 || clusterReplication < -1 {
+		return errors.New("Cluster replication must be -1 (every peer) or at least 1")
+	} else if clusterAuth != "" && !strings.Contains(clusterAuth, ":") {
+		return errors.New("Cluster auth must be 'user:password'")
+	}
+	clusterAPI = strings.TrimSuffix(clusterAPI, "/")
+
+	// Cluster peers fetch pastes from us over the swarm
+	if !ipfsOnline {
+		log.Println("Cluster pinning enabled without -online, cluster peers must be connected to this node to fetch pastes")
+	}
+	return nil
+}
+
+func clusterRequest(ctx context.Context, method, path string) (*http.Response, error) {
+	// Build request, authenticated if configured
+	request, err := http.NewRequestWithContext(ctx, method, clusterAPI+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if clusterAuth != "" {
+		split := strings.SplitN(clusterAuth, ":", 2)
+		request.SetBasicAuth(split[0], split[1])
+	}
+
+	// Send, anything but success is an error
+	response, err := clusterClient.Do(request)
+	if err != nil {
+		return nil, err
+	} else if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		return nil, errors.New("Cluster API responded with: " + response.Status)
+	}
+	return response, nil
+}
+
+func clusterPin(c cid.Cid, replication int) {
+	ctx, cancel := context.WithTimeout(globalContext, clusterTimeout)
+	defer cancel()
+
+	// Pin with the replication factor as both minimum and maximum
+	factor := strconv.Itoa(replication)
+	response, err := clusterRequest(ctx, "POST", "/pins/"+c.String()+"?replication-min="+factor+"&replication-max="+factor+"&name=gibon")
+	if err != nil {
+		log.Printf("Failed to pin paste %s on cluster - %s\n", c.String(), err.Error())
+		return
+	}
+	response.Body.Close()
+}
+
+func clusterStatus(ctx context.Context, c cid.Cid) (*replicationStatus, error) {
+	// Get the cluster's global pin status
+	response, err := clusterRequest(ctx, "GET", "/pins/"+c.String())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	pinStatus := &clusterPinStatus{}
+	err = json.NewDecoder(io.LimitReader(response.Body, maxClusterResponseSize)).Decode(pinStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	// Summarise per peer, in a stable order
+	status := &replicationStatus{CID: c.String(), Peers: []*replicationPeer{}}
+	for peer, peerStatus := range pinStatus.PeerMap {
+		status.Peers = append(status.Peers, &replicationPeer{
+			Peer:   peer,
+			Name:   peerStatus.PeerName,
+			Status: peerStatus.Status,
+			Error:  peerStatus.Error,
+		})
+		if peerStatus.Status == "pinned" {
+			status.Pinned++
+		}
+	}
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].Peer < status.Peers[j].Peer
+	})
+	return status, nil
+}
+
+func replicationHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", apiPrefix+"replication/"+cidStr, request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Invalid paste CID!", http.StatusBadRequest)
+		return
+	}
+
+	// Ask the cluster
+	status, err := clusterStatus(request.Context(), c)
+	if err != nil {
+		log.Printf("Failed to get cluster pin status - %s\n", err.Error())
+		http.Error(writer, "Failed to get replication status", http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(writer, status)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	collectionPrefix = "/collection"
+
+	// Maximum number of pastes in a collection
+	maxCollectionMembers = 1000
+
+	// Maximum collection request body size (in bytes)
+	maxCollectionBodySize = 128 * 1024
+)
+
+type collection struct {
+	Name    string    `refmt:"name"`
+	Members []cid.Cid `refmt:"members"`
+}
+
+type collectionResponse struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+func init() {
+	// Register collection for CBOR (un)marshaling
+	cbor.RegisterCborType(collection{})
+}
+
+func putCollection(coll *collection) (cid.Cid, error) {
+	// Wrap as IPLD CBOR node and add to the responsible shard
+	node, err := cbor.WrapObject(coll, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = shardForCID(node.Cid()).api.Dag().Add(globalContext, node)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Pin so repo GC keeps it
+	err = pinNode(globalContext, node.Cid())
+	if err != nil {
+		return cid.Undef, err
+	}
+	return node.Cid(), nil
+}
+
+func getCollection(c cid.Cid) (*collection, error) {
+	// Get the IPLD node from responsible shard
+	node, err := shardForCID(c).api.Dag().Get(globalContext, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the collection
+	coll := &collection{}
+	err = cbor.DecodeInto(node.RawData(), coll)
+	if err != nil {
+		return nil, err
+	}
+	return coll, nil
+}
+
+func readCollectionMembers(writer http.ResponseWriter, request *http.Request) ([]cid.Cid, error) {
+	// Read newline-separated paste CIDs (or paths) from body
+	request.Body = http.MaxBytesReader(writer, request.Body, maxCollectionBodySize)
+	members := []cid.Cid{}
+	scanner := bufio.NewScanner(request.Body)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), pastePrefix)
+		if line == "" {
+			continue
+		}
+		c, err := cid.Decode(line)
+		if err != nil {
+			return nil, errors.New("Invalid paste CID: " + line)
+		}
+		members = append(members, c)
+	}
+	return members, scanner.Err()
+}
+
+func renderCollection(coll *collection, asJSON bool) ([]byte, error) {
+	// Render JSON if requested
+	if asJSON {
+		response := &collectionResponse{Name: coll.Name, Members: []string{}}
+		for _, member := range coll.Members {
+			response.Members = append(response.Members, member.String())
+		}
+		return json.Marshal(response)
+	}
+
+	// Render the member paste paths
+	buf := &bytes.Buffer{}
+	for _, member := range coll.Members {
+		buf.WriteString(pasteListLine(member.String()))
+	}
+	return buf.Bytes(), nil
+}
+
+func createCollectionHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", collectionPrefix, request.RemoteAddr)
+
+	// Read the collection members
+	members, err := readCollectionMembers(writer, request)
+	if err != nil {
+		http.Error(writer, "Invalid collection members!", http.StatusBadRequest)
+		return
+	} else if len(members) > maxCollectionMembers {
+		http.Error(writer, "Too many collection members!", http.StatusBadRequest)
+		return
+	}
+
+	// Store the new collection
+	coll := &collection{
+		Name:    truncateTitle(strings.TrimSpace(request.URL.Query().Get("name"))),
+		Members: members,
+	}
+	c, err := putCollection(coll)
+	if err != nil {
+		log.Printf("Failed to put collection - %s\n", err.Error())
+		http.Error(writer, "Failed to put collection", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the collection path in response
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(collectionPrefix + "/" + c.String()))
+}
+
+func getCollectionHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", collectionPrefix+"/"+cidStr, request.RemoteAddr)
+
+	// Decode the collection CID and look for it
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Collection not found!", http.StatusNotFound)
+		return
+	}
+	coll, err := getCollection(c)
+	if err != nil {
+		log.Printf("Collection not retrieved - %s\n", err.Error())
+		http.Error(writer, "Collection not found!", http.StatusNotFound)
+		return
+	}
+
+	// Render collection (cached, collections are immutable)
+	asJSON := wantsJSON(request)
+	b, err := renderCached(renderCacheKey(c, "collection", strconv.FormatBool(asJSON)), func() ([]byte, error) {
+		return renderCollection(coll, asJSON)
+	})
+	if err != nil {
+		log.Printf("Failed to render collection - %s\n", err.Error())
+		http.Error(writer, "Failed to render collection", http.StatusInternalServerError)
+		return
+	}
+
+	// Include name if set
+	if coll.Name != "" {
+		writer.Header().Set("X-Collection-Name", coll.Name)
+	}
+
+	// Write the rendered collection
+	if asJSON {
+		writer.Header().Set("content-type", "application/json")
+	} else {
+		writer.Header().Set("content-type", "text/plain")
+	}
+	writer.Write(b)
+}
+
+func updateCollectionHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string and action
+	cidStr := params.ByName("cid")
+	action := params.ByName("action")
+
+	// Log the request
+	logRequest("POST", collectionPrefix+"/"+cidStr+"/"+action, request.RemoteAddr)
+
+	// Decode the collection CID and look for it
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Collection not found!", http.StatusNotFound)
+		return
+	}
+	coll, err := getCollection(c)
+	if err != nil {
+		log.Printf("Collection not retrieved - %s\n", err.Error())
+		http.Error(writer, "Collection not found!", http.StatusNotFound)
+		return
+	}
+
+	// Read the members to append / remove
+	members, err := readCollectionMembers(writer, request)
+	if err != nil {
+		http.Error(writer, "Invalid collection members!", http.StatusBadRequest)
+		return
+	}
+
+	// Apply the action
+	switch action {
+	case "append":
+		coll.Members = append(coll.Members, members...)
+		if len(coll.Members) > maxCollectionMembers {
+			http.Error(writer, "Too many collection members!", http.StatusBadRequest)
+			return
+		}
+
+	case "remove":
+		remove := map[cid.Cid]bool{}
+		for _, member := range members {
+			remove[member] = true
+		}
+		kept := []cid.Cid{}
+		for _, member := range coll.Members {
+			if !remove[member] {
+				kept = append(kept, member)
+			}
+		}
+		coll.Members = kept
+
+	default:
+		http.Error(writer, "Unknown collection action!", http.StatusNotFound)
+		return
+	}
+
+	// Store the updated collection (as a new CID)
+	c, err = putCollection(coll)
+	if err != nil {
+		log.Printf("Failed to put collection - %s\n", err.Error())
+		http.Error(writer, "Failed to put collection", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the new collection path in response
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(collectionPrefix + "/" + c.String()))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// Maximum comment size (in bytes)
+	maxCommentSize = 16 * 1024
+
+	// Maximum comment author length (in runes)
+	maxCommentAuthorLength = 64
+
+	// Maximum number of comments returned in a thread
+	maxThreadLength = 1000
+)
+
+var (
+	// Guards comment thread head updates
+	commentsMutex sync.Mutex
+)
+
+type comment struct {
+	Paste   cid.Cid  `refmt:"paste"`
+	Prev    *cid.Cid `refmt:"prev,omitempty"`
+	Author  string   `refmt:"author"`
+	Text    string   `refmt:"text"`
+	Created int64    `refmt:"created"`
+}
+
+type commentResponse struct {
+	CID     string `json:"cid"`
+	Author  string `json:"author"`
+	Text    string `json:"text"`
+	Created int64  `json:"created"`
+}
+
+func init() {
+	// Register comment for CBOR (un)marshaling
+	cbor.RegisterCborType(comment{})
+}
+
+func addComment(pasteCID cid.Cid, author, text string) (cid.Cid, error) {
+	commentsMutex.Lock()
+	defer commentsMutex.Unlock()
+
+	// Build comment linked to the current thread head
+	c := &comment{
+		Paste:   pasteCID,
+		Author:  author,
+		Text:    text,
+		Created: time.Now().Unix(),
+	}
+	headKey := indexKey("comments", pasteCID.String())
+	head, err := indexStore.Get(headKey)
+	if err == nil {
+		prev, err := cid.Cast(head)
+		if err != nil {
+			return cid.Undef, err
+		}
+		c.Prev = &prev
+	} else if err != ds.ErrNotFound {
+		return cid.Undef, err
+	}
+
+	// Wrap as IPLD CBOR node and add to the responsible shard
+	node, err := cbor.WrapObject(c, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = shardForCID(node.Cid()).api.Dag().Add(globalContext, node)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Pin so repo GC keeps it
+	err = pinNode(globalContext, node.Cid())
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Update the thread head
+	err = indexStore.Put(headKey, node.Cid().Bytes())
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return node.Cid(), nil
+}
+
+func getCommentThread(pasteCID cid.Cid) ([]*commentResponse, error) {
+	// Get the thread head, none means no comments
+	head, err := indexStore.Get(indexKey("comments", pasteCID.String()))
+	if err == ds.ErrNotFound {
+		return []*commentResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	next, err := cid.Cast(head)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk back through the linked comments
+	thread := []*commentResponse{}
+	for len(thread) < maxThreadLength {
+		node, err := shardForCID(next).api.Dag().Get(globalContext, next)
+		if err != nil {
+			return nil, err
+		}
+
+		c := &comment{}
+		err = cbor.DecodeInto(node.RawData(), c)
+		if err != nil {
+			return nil, err
+		}
+		thread = append(thread, &commentResponse{
+			CID:     next.String(),
+			Author:  c.Author,
+			Text:    c.Text,
+			Created: c.Created,
+		})
+
+		if c.Prev == nil {
+			break
+		}
+		next = *c.Prev
+	}
+
+	// Reverse into chronological order
+	for i, j := 0, len(thread)-1; i < j; i, j = i+1, j-1 {
+		thread[i], thread[j] = thread[j], thread[i]
+	}
+
+	return thread, nil
+}
+
+func postCommentHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("POST", pastePrefix+cidStr+"/comments", request.RemoteAddr)
+
+	// Decode the paste CID and ensure paste exists
+	pasteCID, err := cid.Decode(cidStr)
+	if err == nil {
+		var has bool
+		has, err = storageBackend.Has(globalContext, pasteCID)
+		if err == nil && !has {
+			err = ds.ErrNotFound
+		}
+	}
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, pasteCID) {
+		return
+	}
+
+	// Read comment text from body
+	request.Body = http.MaxBytesReader(writer, request.Body, maxCommentSize)
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, "Comment too large!", http.StatusRequestEntityTooLarge)
+		return
+	}
+	text := strings.TrimSpace(string(b))
+	if text == "" || !utf8.ValidString(text) {
+		http.Error(writer, "Invalid comment!", http.StatusBadRequest)
+		return
+	}
+
+	// Get optional author, stripping control characters
+	author := strings.TrimSpace(request.URL.Query().Get("author"))
+	if utf8.RuneCountInString(author) > maxCommentAuthorLength {
+		http.Error(writer, "Author name too long!", http.StatusBadRequest)
+		return
+	}
+	author = truncateTitle(author)
+	if author == "" {
+		author = "anonymous"
+	}
+
+	// Add the comment
+	c, err := addComment(pasteCID, author, text)
+	if err != nil {
+		log.Printf("Failed to add comment - %s\n", err.Error())
+		http.Error(writer, "Failed to add comment", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the comment CID in response
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(c.String()))
+}
+
+func getCommentsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/comments", request.RemoteAddr)
+
+	// Decode the paste CID
+	pasteCID, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, pasteCID) {
+		return
+	}
+
+	// Get the comment thread
+	thread, err := getCommentThread(pasteCID)
+	if err != nil {
+		log.Printf("Failed to get comments - %s\n", err.Error())
+		http.Error(writer, "Failed to get comments", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(writer, thread)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/sha256"
+
+	"github.com/grufwub/gibon/crypto"
+)
+
+// convergentSalt returns the Argon2id salt for convergent pastes. It must be
+// stable for equal pastes to converge, so it's derived from the instance
+// hostname, keeping precomputed guesses from carrying between instances.
+func convergentSalt() []byte {
+	hash := sha256.Sum256([]byte("gibon-convergent:" + instanceHostname))
+	return hash[:crypto.Argon2SaltSize]
+}
+
+func (p *paste) encryptConvergent(key string, binding *pasteBinding) error {
+	// Derive key from content and key with configured Argon2id costs and stable salt
+	params := &crypto.Argon2Params{
+		Time:    uint32(argon2Time),
+		Memory:  uint32(argon2Memory),
+		Threads: uint8(argon2Threads),
+		Salt:    convergentSalt(),
+	}
+	if err := params.Validate(); err != nil {
+		return err
+	}
+
+	// Seal deterministically, so equal text, key and metadata give the same CID
+	return p.seal(crypto.NewConvergentEncrypter(key, params), binding)
+}
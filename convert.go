@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/yuin/goldmark"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Conversion source formats
+	convertFromText     = "text"
+	convertFromMarkdown = "markdown"
+	convertFromANSI     = "ansi"
+	convertFromCSV      = "csv"
+	convertFromNotebook = "ipynb"
+	convertFromDiff     = "diff"
+
+	// Maximum CSV table size rendered to HTML
+	maxConvertTableCells = 100000
+
+	// Converted HTML is a standalone document, no scripts or external loads
+	convertHTMLPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src data:"
+)
+
+var (
+	// Content types served by conversion target
+	convertContentTypes = map[string]string{
+		"html": "text/html; charset=utf-8",
+		"pdf":  "application/pdf",
+		"txt":  "text/plain; charset=utf-8",
+	}
+
+	// Source formats by paste language hint
+	convertLanguageSources = map[string]string{
+		"markdown": convertFromMarkdown,
+		"md":       convertFromMarkdown,
+		"csv":      convertFromCSV,
+		"ansi":     convertFromANSI,
+		"ipynb":    convertFromNotebook,
+		"jupyter":  convertFromNotebook,
+		"diff":     convertFromDiff,
+		"patch":    convertFromDiff,
+	}
+
+	// ANSI escape sequences (SGR captured, others stripped)
+	ansiEscapeRegexp = regexp.MustCompile(`\x1b\[([0-9;]*)([A-Za-z])`)
+
+	// ANSI standard colours for SGR 30-37 / 40-47 (and bright 90-97 / 100-107)
+	ansiColours = [16]string{
+		"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+		"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+	}
+)
+
+func convertSource(from string, meta *pasteMeta, b []byte) (string, error) {
+	// Explicit source format
+	switch from {
+	case convertFromText, convertFromMarkdown, convertFromANSI, convertFromCSV, convertFromNotebook, convertFromDiff:
+		return from, nil
+	case "":
+	default:
+		return "", errors.New("unsupported source format")
+	}
+
+	// Guess from language hint, else look at content
+	if source, ok := convertLanguageSources[meta.Language]; ok {
+		return source, nil
+	} else if isNotebook(b) {
+		return convertFromNotebook, nil
+	} else if isDiff(b) {
+		return convertFromDiff, nil
+	} else if ansiEscapeRegexp.Match(b) {
+		return convertFromANSI, nil
+	}
+	return convertFromText, nil
+}
+
+func convertPaste(b []byte, from, to, title string) ([]byte, error) {
+	switch to {
+	case "txt":
+		return convertToText(b, from)
+
+	case "pdf":
+		text, err := convertToText(b, from)
+		if err != nil {
+			return nil, err
+		}
+		return textToPDF(string(text))
+
+	case "html":
+		var body []byte
+		var err error
+		switch from {
+		case convertFromMarkdown:
+			// Goldmark escapes raw HTML unless explicitly made unsafe
+			buf := &bytes.Buffer{}
+			err = goldmark.Convert(b, buf)
+			body = buf.Bytes()
+		case convertFromANSI:
+			body = ansiToHTML(b)
+		case convertFromCSV:
+			body, err = csvToHTML(b)
+		case convertFromNotebook:
+			body, err = notebookToHTML(b)
+		case convertFromDiff:
+			body = diffToHTML(b)
+		default:
+			body = []byte("<pre>" + html.EscapeString(string(b)) + "</pre>")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return wrapHTMLDocument(title, body), nil
+
+	default:
+		return nil, errors.New("unsupported conversion target")
+	}
+}
+
+func convertToText(b []byte, from string) ([]byte, error) {
+	switch from {
+	case convertFromANSI:
+		return stripANSI(b), nil
+	case convertFromNotebook:
+		return notebookToText(b)
+	default:
+		return b, nil
+	}
+}
+
+func wrapHTMLDocument(title string, body []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>")
+	buf.WriteString(html.EscapeString(title))
+	buf.WriteString("</title><style>body{font-family:sans-serif;max-width:60em;margin:2em auto;padding:0 1em}pre{white-space:pre-wrap}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:.2em .5em}" +
+		".diff-add{color:#22863a;background:#f0fff4}.diff-del{color:#b31d28;background:#ffeef0}.diff-hunk{color:#6f42c1}.diff-meta{font-weight:bold}" +
+		"summary{cursor:pointer;font-family:monospace;font-weight:bold}.nb-code{background:#f6f8fa;padding:.5em}.nb-output{margin-left:2em}img.nb-output{max-width:100%}</style></head><body>\n")
+	buf.Write(body)
+	buf.WriteString("\n</body></html>\n")
+	return buf.Bytes()
+}
+
+func stripANSI(b []byte) []byte {
+	return ansiEscapeRegexp.ReplaceAll(b, nil)
+}
+
+func ansiStyle(params string, style map[string]string) {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		code, _ := strconv.Atoi(codes[i])
+		switch {
+		case code == 0:
+			for k := range style {
+				delete(style, k)
+			}
+		case code == 1:
+			style["font-weight"] = "bold"
+		case code == 3:
+			style["font-style"] = "italic"
+		case code == 4:
+			style["text-decoration"] = "underline"
+		case code == 22:
+			delete(style, "font-weight")
+		case code == 23:
+			delete(style, "font-style")
+		case code == 24:
+			delete(style, "text-decoration")
+		case code >= 30 && code <= 37:
+			style["color"] = ansiColours[code-30]
+		case code >= 90 && code <= 97:
+			style["color"] = ansiColours[code-90+8]
+		case code == 39:
+			delete(style, "color")
+		case code >= 40 && code <= 47:
+			style["background"] = ansiColours[code-40]
+		case code >= 100 && code <= 107:
+			style["background"] = ansiColours[code-100+8]
+		case code == 49:
+			delete(style, "background")
+		case (code == 38 || code == 48) && i+2 < len(codes) && codes[i+1] == "5":
+			// 256 colour, only the standard 16 are mapped, others skipped
+			n, _ := strconv.Atoi(codes[i+2])
+			prop := "color"
+			if code == 48 {
+				prop = "background"
+			}
+			if n < 16 {
+				style[prop] = ansiColours[n]
+			}
+			i += 2
+		}
+	}
+}
+
+func ansiToHTML(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("<pre>")
+
+	style := map[string]string{}
+	open := false
+	last := 0
+	for _, match := range ansiEscapeRegexp.FindAllSubmatchIndex(b, -1) {
+		// Write text before the escape
+		buf.WriteString(html.EscapeString(string(b[last:match[0]])))
+		last = match[1]
+
+		// Only SGR (colour / style) sequences are rendered
+		if string(b[match[4]:match[5]]) != "m" {
+			continue
+		}
+		ansiStyle(string(b[match[2]:match[3]]), style)
+
+		// Close previous span, open new one if any style set
+		if open {
+			buf.WriteString("</span>")
+			open = false
+		}
+		if len(style) > 0 {
+			buf.WriteString("<span style=\"")
+			for _, prop := range []string{"color", "background", "font-weight", "font-style", "text-decoration"} {
+				if value, ok := style[prop]; ok {
+					buf.WriteString(prop + ":" + value + ";")
+				}
+			}
+			buf.WriteString("\">")
+			open = true
+		}
+	}
+	buf.WriteString(html.EscapeString(string(b[last:])))
+	if open {
+		buf.WriteString("</span>")
+	}
+
+	buf.WriteString("</pre>")
+	return buf.Bytes()
+}
+
+func csvToHTML(b []byte) ([]byte, error) {
+	reader := csv.NewReader(bytes.NewReader(b))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("<table>\n")
+	cells := 0
+	for row := 0; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Guard against huge tables
+		cells += len(record)
+		if cells > maxConvertTableCells {
+			return nil, errors.New("CSV table too large to convert")
+		}
+
+		// First row is the header
+		tag := "td"
+		if row == 0 {
+			tag = "th"
+		}
+		buf.WriteString("<tr>")
+		for _, field := range record {
+			buf.WriteString("<" + tag + ">" + html.EscapeString(field) + "</" + tag + ">")
+		}
+		buf.WriteString("</tr>\n")
+	}
+	buf.WriteString("</table>")
+
+	return buf.Bytes(), nil
+}
+
+func convertPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/convert", request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Ensure supported target
+	to := request.URL.Query().Get("to")
+	contentType, ok := convertContentTypes[to]
+	if !ok {
+		http.Error(writer, "Unsupported conversion target!", http.StatusBadRequest)
+		return
+	}
+
+	// Look for unencrypted text paste (conversions are cached, so never decrypted)
+	p, err := getPaste(c)
+	if err != nil {
+		log.Printf("Paste not retrieved - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	} else if p.encrypted {
+		http.Error(writer, "Only unencrypted pastes can be converted!", http.StatusBadRequest)
+		return
+	}
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		log.Printf("Failed to get paste metadata - %s\n", err.Error())
+		http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+		return
+	} else if meta.ContentType != "" {
+		http.Error(writer, "Only text pastes can be converted!", http.StatusBadRequest)
+		return
+	}
+	err = p.decompress()
+	if err != nil {
+		log.Printf("Failed to decompress paste - %s\n", err.Error())
+		http.Error(writer, "Paste decompression failed!", http.StatusInternalServerError)
+		return
+	}
+
+	// Get source format
+	from, err := convertSource(request.URL.Query().Get("from"), meta, p.text)
+	if err != nil {
+		http.Error(writer, "Unsupported source format!", http.StatusBadRequest)
+		return
+	}
+
+	// Count this view against any view limit
+	ok, err = takeView(c)
+	if err != nil {
+		log.Printf("Failed to check paste view limit - %s\n", err.Error())
+		http.Error(writer, "Failed to check paste view limit", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(writer, "Paste view limit reached!", http.StatusGone)
+		return
+	}
+
+	// Convert (cached, pastes are immutable)
+	b, err := renderCached(renderCacheKey(c, "convert", from, to), func() ([]byte, error) {
+		return convertPaste(p.text, from, to, meta.Title)
+	})
+	if err != nil {
+		log.Printf("Failed to convert paste - %s\n", err.Error())
+		http.Error(writer, "Paste conversion failed!", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Write the converted paste, sandboxed if HTML
+	if to == "html" {
+		writer.Header().Set("Content-Security-Policy", convertHTMLPolicy)
+	} else if to == "pdf" {
+		writer.Header().Set("Content-Disposition", "inline; filename=\""+c.String()+".pdf\"")
+	}
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.Header().Set("content-type", contentType)
+	writer.Write(b)
+}
@@ -0,0 +1,135 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// Content tag size (in bytes), stored after the Argon2id params
+	ConvergentTagSize = 16
+
+	// Convergent params size: Argon2id params, content tag
+	ConvergentParamsSize = Argon2ParamsSize + ConvergentTagSize
+
+	// HKDF info for content keys
+	convergentKeyInfo = "gibon-convergent"
+)
+
+type convergentEncrypter struct {
+	params *Argon2Params
+	master []byte
+
+	// Secret to derive keys for envelopes with other KDF params
+	secret string
+}
+
+// NewConvergentEncrypter seals deterministically: the same secret, params,
+// plaintext and associated data always give the same envelope, so equal
+// messages deduplicate. That also lets anyone holding two envelopes see they
+// are equal, and anyone holding the secret confirm a guessed plaintext. The
+// params salt must be stable (not from NewArgon2Params) for sealing to
+// converge. Opens envelopes sealed with any passphrase KDF; streaming seals
+// aren't supported, the whole plaintext is needed up front.
+func NewConvergentEncrypter(secret string, params *Argon2Params) Encrypter {
+	return &convergentEncrypter{
+		params: params,
+		master: params.DeriveKey(secret),
+		secret: secret,
+	}
+}
+
+func convergentKey(master, tag []byte) ([]byte, error) {
+	// Derive content key from the stretched secret, one per tag
+	key := make([]byte, KeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, master, tag, []byte(convergentKeyInfo)), key)
+	return key, err
+}
+
+func (e *convergentEncrypter) tag(plaintext, associatedData []byte) []byte {
+	// Keyed hash of everything sealed, so the content key is never reused for anything else
+	mac := hmac.New(sha256.New, e.master)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(associatedData)))
+	mac.Write(size)
+	mac.Write(associatedData)
+	mac.Write(plaintext)
+	return mac.Sum(nil)[:ConvergentTagSize]
+}
+
+func (e *convergentEncrypter) Seal(plaintext, associatedData []byte) ([]byte, error) {
+	tag := e.tag(plaintext, associatedData)
+	key, err := convergentKey(e.master, tag)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := NewAEAD(CipherAES256GCM, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Zero nonce is safe, each key seals exactly one plaintext and associated data
+	env := &Envelope{
+		Version:   EnvelopeVersion,
+		KDF:       KDFConvergent,
+		Cipher:    CipherAES256GCM,
+		KDFParams: append(e.params.Marshal(), tag...),
+		Nonce:     make([]byte, aead.NonceSize()),
+	}
+	env.Sealed = aead.Seal(nil, env.Nonce, plaintext, env.AdditionalData(associatedData))
+	return env.Marshal(), nil
+}
+
+func (e *convergentEncrypter) Open(b, associatedData []byte) ([]byte, error) {
+	env, err := UnmarshalEnvelope(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reuse our stretched secret if it applies, else derive from secret
+	params := e.params.Marshal()
+	if env.KDF == KDFConvergent && len(env.KDFParams) == ConvergentParamsSize && bytes.Equal(env.KDFParams[:Argon2ParamsSize], params) {
+		key, err := convergentKey(e.master, env.KDFParams[Argon2ParamsSize:])
+		if err != nil {
+			return nil, err
+		}
+		return env.OpenWithKey(key, associatedData)
+	}
+	return env.Open(e.secret, associatedData)
+}
+
+func (e *convergentEncrypter) SealStream(w io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	return nil, newError(ErrUnsupported, "convergent encryption can't stream")
+}
+
+func (e *convergentEncrypter) OpenStream(r io.Reader, associatedData []byte) (io.Reader, error) {
+	// Convergent envelopes are never chunked, so open whole
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text, err := e.Open(b, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(text), nil
+}
+
+func init() {
+	// Register convergent KDF, opened with the secret alone
+	RegisterKDF(KDFConvergent, KDFFunc(func(b []byte, secret string) ([]byte, error) {
+		params, tag, err := UnmarshalArgon2Params(b)
+		if err != nil {
+			return nil, err
+		} else if len(tag) != ConvergentTagSize {
+			return nil, newError(ErrMalformed, "convergent tag size mismatch")
+		}
+		return convergentKey(params.DeriveKey(secret), tag)
+	}))
+}
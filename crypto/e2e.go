@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"encoding/binary"
+)
+
+// End-to-end encrypted paste format
+//
+// Clients encrypt before upload and POST the result with ?e2e=1. The server
+// only checks the header below, stores the blob verbatim and never attempts
+// to decrypt it, so keys never reach the server.
+//
+//	byte 0      marker 0xE2
+//	byte 1      format version (1)
+//	byte 2      client KDF: 0 raw 256-bit key, 1 PBKDF2-HMAC-SHA256, 2 Argon2id
+//	byte 3      cipher: 1 AES-256-GCM
+//	byte 4      nonce size (12 for AES-256-GCM)
+//	bytes 5-6   KDF params size, big endian
+//	...         KDF params (PBKDF2: 4 byte big endian iterations + salt,
+//	            Argon2id: same layout as Argon2Params.Marshal)
+//	...         nonce
+//	...         ciphertext with GCM tag
+const (
+	// E2E format marker byte and version
+	E2EMarker  = 0xE2
+	E2EVersion = 1
+
+	// E2E header: marker, version, kdf, cipher, nonce size, kdf params size
+	E2EHeaderSize = 7
+
+	// Client-side key derivation functions
+	E2EKDFRaw      = 0
+	E2EKDFPBKDF2   = 1
+	E2EKDFArgon2id = 2
+
+	// Maximum E2E KDF params size
+	MaxE2EParamsSize = 1024
+)
+
+// ValidateE2E checks b is a well-formed E2E blob, without opening it.
+func ValidateE2E(b []byte) error {
+	// Ensure header present and supported
+	if len(b) < E2EHeaderSize || b[0] != E2EMarker {
+		return newError(ErrMalformed, "E2E header missing")
+	} else if b[1] != E2EVersion {
+		return newError(ErrUnsupported, "Unsupported E2E format version")
+	}
+
+	// Ensure known KDF and cipher
+	switch b[2] {
+	case E2EKDFRaw, E2EKDFPBKDF2, E2EKDFArgon2id:
+	default:
+		return newError(ErrUnsupported, "Unsupported E2E key derivation function")
+	}
+	if b[3] != CipherAES256GCM || b[4] != GCMNonceSize {
+		return newError(ErrUnsupported, "Unsupported E2E cipher")
+	}
+
+	// Ensure params, nonce and at least a tag present
+	paramsSize := int(binary.BigEndian.Uint16(b[5:7]))
+	if paramsSize > MaxE2EParamsSize {
+		return newError(ErrTooLarge, "E2E params too large")
+	} else if len(b) < E2EHeaderSize+paramsSize+GCMNonceSize+GCMTagSize {
+		return newError(ErrMalformed, "E2E blob truncated")
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// Encrypter seals and opens envelopes for a single passphrase or key. The
+// streaming variants use the chunked cipher, so neither side has to hold the
+// whole message; Open also accepts streamed envelopes and vice versa.
+// Associated data is authenticated but not stored, opening needs the same.
+type Encrypter interface {
+	Seal(plaintext, associatedData []byte) ([]byte, error)
+	Open(envelope, associatedData []byte) ([]byte, error)
+	SealStream(w io.Writer, associatedData []byte) (io.WriteCloser, error)
+	OpenStream(r io.Reader, associatedData []byte) (io.Reader, error)
+}
+
+type encrypter struct {
+	kdf       byte
+	kdfParams []byte
+	key       []byte
+
+	// Passphrase to derive keys for envelopes with other KDF params
+	passphrase    string
+	hasPassphrase bool
+}
+
+// NewPassphraseEncrypter seals with a key derived from passphrase using
+// params, and opens envelopes sealed with any passphrase KDF.
+func NewPassphraseEncrypter(passphrase string, params PassphraseParams) Encrypter {
+	return &encrypter{
+		kdf:           params.KDF(),
+		kdfParams:     params.Marshal(),
+		key:           params.DeriveKey(passphrase),
+		passphrase:    passphrase,
+		hasPassphrase: true,
+	}
+}
+
+// NewKeyEncrypter seals with an already derived key, recording kdf and
+// kdfParams so the key can be recovered (e.g. wrapped content keys).
+func NewKeyEncrypter(kdf byte, kdfParams, key []byte) Encrypter {
+	return &encrypter{kdf: kdf, kdfParams: kdfParams, key: key}
+}
+
+func (e *encrypter) openKey(env *Envelope) ([]byte, error) {
+	// Reuse our key if it applies, else derive from passphrase
+	if env.KDF == e.kdf && bytes.Equal(env.KDFParams, e.kdfParams) {
+		return e.key, nil
+	} else if !e.hasPassphrase {
+		return nil, newError(ErrDecrypt, "envelope not sealed with this key")
+	}
+	return DeriveKey(env.KDF, env.KDFParams, e.passphrase)
+}
+
+func (e *encrypter) Seal(plaintext, associatedData []byte) ([]byte, error) {
+	aead, err := NewAEAD(CipherAES256GCM, e.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Random nonce, sealed text in envelope describing how to open it
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	env := &Envelope{
+		Version:   EnvelopeVersion,
+		KDF:       e.kdf,
+		Cipher:    CipherAES256GCM,
+		KDFParams: e.kdfParams,
+		Nonce:     nonce,
+	}
+	env.Sealed = aead.Seal(nil, nonce, plaintext, env.AdditionalData(associatedData))
+	return env.Marshal(), nil
+}
+
+func (e *encrypter) Open(b, associatedData []byte) ([]byte, error) {
+	env, err := UnmarshalEnvelope(b)
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.openKey(env)
+	if err != nil {
+		return nil, err
+	}
+	return env.OpenWithKey(key, associatedData)
+}
+
+func (e *encrypter) SealStream(w io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	aead, err := NewAEAD(CipherAES256GCMStream, e.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write header with random nonce prefix, chunks follow
+	prefix := make([]byte, StreamNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	env := &Envelope{
+		Version:   EnvelopeVersion,
+		KDF:       e.kdf,
+		Cipher:    CipherAES256GCMStream,
+		KDFParams: e.kdfParams,
+		Nonce:     prefix,
+	}
+	if _, err := w.Write(env.MarshalHeader()); err != nil {
+		return nil, err
+	}
+	return newChunkWriter(w, aead, prefix, env.AdditionalData(associatedData)), nil
+}
+
+func (e *encrypter) OpenStream(r io.Reader, associatedData []byte) (io.Reader, error) {
+	// Read header, streams are never v1
+	header := make([]byte, envelopeHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	} else if header[0] != EnvelopeMagic || header[1] < 2 || header[1] > EnvelopeVersion {
+		return nil, newError(ErrUnsupported, "unsupported crypto envelope header")
+	}
+	rest := make([]byte, int(binary.BigEndian.Uint16(header[5:7]))+int(header[4]))
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	env, err := UnmarshalEnvelope(append(header, rest...))
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.openKey(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunked envelopes are opened as read, others need the whole text
+	c, err := lookupCipher(env.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	if c.Chunked {
+		aead, err := c.New(key)
+		if err != nil {
+			return nil, err
+		} else if len(env.Nonce) != StreamNoncePrefixSize {
+			return nil, newError(ErrMalformed, "envelope nonce size does not match cipher")
+		}
+		return newChunkReader(r, aead, env.Nonce, env.AdditionalData(associatedData)), nil
+	}
+	env.Sealed, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := env.OpenWithKey(key, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
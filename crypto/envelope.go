@@ -0,0 +1,137 @@
+// Package crypto implements gibon's paste encryption: the versioned crypto
+// envelope, registered ciphers and key derivation functions, recipient key
+// wrapping and the client-side E2E format. It is shared by the server and
+// any client that wants to produce or open gibon ciphertext itself; golden
+// vectors in vectors.json pin the formats down for other implementations.
+package crypto
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// Envelope magic byte and current version
+	EnvelopeMagic   = 0xC7
+	EnvelopeVersion = 3
+
+	// Envelope header: magic, version, kdf, cipher, nonce size, kdf params size (1 byte in v1, 2 bytes since v2)
+	// Since v3 the header and any caller associated data are authenticated along with the sealed text
+	envelopeHeaderSizeV1 = 6
+	envelopeHeaderSize   = 7
+)
+
+// Envelope describes how a ciphertext was sealed, so it can be opened again
+// given only the user secret. Version is zero for envelopes describing
+// pre-envelope layouts, which authenticate nothing beyond the sealed text.
+type Envelope struct {
+	Version   byte
+	KDF       byte
+	Cipher    byte
+	KDFParams []byte
+	Nonce     []byte
+	Sealed    []byte
+}
+
+// MarshalHeader returns the envelope up to (not including) the sealed text.
+func (env *Envelope) MarshalHeader() []byte {
+	b := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(env.KDFParams)+len(env.Nonce))
+	b[0] = EnvelopeMagic
+	b[1] = env.Version
+	if env.Version < 2 {
+		b[1] = EnvelopeVersion
+	}
+	b[2] = env.KDF
+	b[3] = env.Cipher
+	b[4] = byte(len(env.Nonce))
+	binary.BigEndian.PutUint16(b[5:7], uint16(len(env.KDFParams)))
+	b = append(b, env.KDFParams...)
+	return append(b, env.Nonce...)
+}
+
+// Marshal returns the full envelope.
+func (env *Envelope) Marshal() []byte {
+	return append(env.MarshalHeader(), env.Sealed...)
+}
+
+// UnmarshalEnvelope parses an envelope, its fields sharing b.
+func UnmarshalEnvelope(b []byte) (*Envelope, error) {
+	// Ensure header present and supported
+	if len(b) < envelopeHeaderSizeV1 || b[0] != EnvelopeMagic {
+		return nil, newError(ErrMalformed, "crypto envelope header missing")
+	}
+	var nonceSize, paramsSize int
+	var rest []byte
+	switch b[1] {
+	case 1:
+		nonceSize, paramsSize = int(b[4]), int(b[5])
+		rest = b[envelopeHeaderSizeV1:]
+	case 2, 3:
+		if len(b) < envelopeHeaderSize {
+			return nil, newError(ErrMalformed, "crypto envelope header missing")
+		}
+		nonceSize, paramsSize = int(b[4]), int(binary.BigEndian.Uint16(b[5:7]))
+		rest = b[envelopeHeaderSize:]
+	default:
+		return nil, newError(ErrUnsupported, "unsupported crypto envelope version")
+	}
+
+	// Ensure described params and nonce present
+	if len(rest) < paramsSize+nonceSize {
+		return nil, newError(ErrMalformed, "crypto envelope truncated")
+	}
+
+	return &Envelope{
+		Version:   b[1],
+		KDF:       b[2],
+		Cipher:    b[3],
+		KDFParams: rest[:paramsSize],
+		Nonce:     rest[paramsSize : paramsSize+nonceSize],
+		Sealed:    rest[paramsSize+nonceSize:],
+	}, nil
+}
+
+// AdditionalData returns the AEAD additional data for the envelope: its
+// header followed by the caller's associated data, or nil before v3.
+func (env *Envelope) AdditionalData(associatedData []byte) []byte {
+	if env.Version < 3 {
+		return nil
+	}
+	return append(env.MarshalHeader(), associatedData...)
+}
+
+// Open derives the key from secret using the envelope's KDF and opens it,
+// checking associatedData matches that given when sealing.
+func (env *Envelope) Open(secret string, associatedData []byte) ([]byte, error) {
+	key, err := DeriveKey(env.KDF, env.KDFParams, secret)
+	if err != nil {
+		return nil, err
+	}
+	return env.OpenWithKey(key, associatedData)
+}
+
+// OpenWithKey opens the envelope with an already derived key.
+func (env *Envelope) OpenWithKey(key, associatedData []byte) ([]byte, error) {
+	c, err := lookupCipher(env.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunked ciphers carry a nonce prefix, the rest is per chunk
+	if c.Chunked {
+		return openChunks(aead, env.Nonce, env.AdditionalData(associatedData), env.Sealed)
+	}
+
+	// Ensure nonce matches the cipher
+	if aead.NonceSize() != len(env.Nonce) {
+		return nil, newError(ErrMalformed, "envelope nonce size does not match cipher")
+	}
+	text, err := aead.Open(nil, env.Nonce, env.Sealed, env.AdditionalData(associatedData))
+	if err != nil {
+		return nil, newError(ErrDecrypt, err.Error())
+	}
+	return text, nil
+}
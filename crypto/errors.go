@@ -0,0 +1,39 @@
+package crypto
+
+import (
+	"errors"
+)
+
+// Error kinds returned by this package, match with errors.Is. The errors
+// returned keep their own more detailed messages.
+var (
+	// ErrDecrypt means authentication failed: the wrong secret, or tampered
+	// ciphertext or associated data.
+	ErrDecrypt = errors.New("decryption failed")
+
+	// ErrMalformed means a header or params were truncated or out of bounds.
+	ErrMalformed = errors.New("malformed ciphertext")
+
+	// ErrUnsupported means an unknown version, KDF or cipher.
+	ErrUnsupported = errors.New("unsupported format")
+
+	// ErrTooLarge means a size limit was exceeded.
+	ErrTooLarge = errors.New("size limit exceeded")
+)
+
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func newError(kind error, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
+
+func (err *kindError) Error() string {
+	return err.msg
+}
+
+func (err *kindError) Unwrap() error {
+	return err.kind
+}
@@ -0,0 +1,272 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// Registered key derivation functions
+	KDFSHA256     = 0 // Single-round SHA-256 of the passphrase, only for opening old pastes
+	KDFArgon2id   = 1 // Argon2id, params stored in the envelope
+	KDFX25519     = 2 // Content key wrapped to recipient public keys, see recipients.go
+	KDFMasterKey  = 3 // Content key wrapped by a server master key, registered by the server
+	KDFPBKDF2     = 4 // PBKDF2-HMAC-SHA256, params stored in the envelope
+	KDFConvergent = 5 // Argon2id of the secret and a content tag, see convergent.go
+
+	// Argon2id salt size (in bytes)
+	Argon2SaltSize = 16
+
+	// Argon2id params size: time, memory, threads, salt
+	Argon2ParamsSize = 4 + 4 + 1 + Argon2SaltSize
+
+	// Format upper bounds on stored Argon2id parameters, see SetArgon2Limits for lower ones
+	Argon2MaxTime    = 16
+	Argon2MaxMemory  = 1024 * 1024
+	Argon2MaxThreads = 16
+
+	// PBKDF2 salt size (in bytes)
+	PBKDF2SaltSize = 16
+
+	// PBKDF2 params size: iterations, salt
+	PBKDF2ParamsSize = 4 + PBKDF2SaltSize
+
+	// Upper bound on stored PBKDF2 iterations
+	PBKDF2MaxIterations = 10000000
+)
+
+var (
+	// Registered KDFs by envelope ID
+	kdfs      = map[byte]KDF{}
+	kdfsMutex sync.RWMutex
+
+	// Accepted Argon2id parameters, guards against costly crafted envelopes
+	argon2Limits      = Argon2Params{Time: Argon2MaxTime, Memory: Argon2MaxMemory, Threads: Argon2MaxThreads}
+	argon2LimitsMutex sync.RWMutex
+)
+
+// KDF derives an envelope key from the params stored in the envelope and a
+// user secret (passphrase, identity, or nothing for server-held keys).
+type KDF interface {
+	DeriveKey(params []byte, secret string) ([]byte, error)
+}
+
+// KDFFunc adapts a function to the KDF interface.
+type KDFFunc func(params []byte, secret string) ([]byte, error)
+
+// DeriveKey calls f.
+func (f KDFFunc) DeriveKey(params []byte, secret string) ([]byte, error) {
+	return f(params, secret)
+}
+
+// RegisterKDF makes a KDF usable in envelopes under id.
+func RegisterKDF(id byte, kdf KDF) {
+	kdfsMutex.Lock()
+	defer kdfsMutex.Unlock()
+
+	if _, ok := kdfs[id]; ok {
+		panic("crypto: KDF registered twice: " + strconv.Itoa(int(id)))
+	}
+	kdfs[id] = kdf
+}
+
+// DeriveKey derives a key with the KDF registered under id.
+func DeriveKey(id byte, params []byte, secret string) ([]byte, error) {
+	kdfsMutex.RLock()
+	kdf, ok := kdfs[id]
+	kdfsMutex.RUnlock()
+	if !ok {
+		return nil, newError(ErrUnsupported, "unsupported key derivation function")
+	}
+	return kdf.DeriveKey(params, secret)
+}
+
+// PassphraseParams are the stored params of a passphrase KDF, generated
+// per message so costs can be raised without breaking old envelopes.
+type PassphraseParams interface {
+	KDF() byte
+	Marshal() []byte
+	DeriveKey(passphrase string) []byte
+}
+
+// Argon2Params are the Argon2id parameters stored in envelopes (memory in KiB).
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	Salt    []byte
+}
+
+// NewArgon2Params returns params with a new random salt.
+func NewArgon2Params(time, memory uint32, threads uint8) (*Argon2Params, error) {
+	salt := make([]byte, Argon2SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	params := &Argon2Params{Time: time, Memory: memory, Threads: threads, Salt: salt}
+	return params, params.Validate()
+}
+
+// SetArgon2Limits lowers the Argon2id parameters accepted from envelopes, so
+// untrusted ones can't cost more than the caller is willing to spend opening
+// them. Limits above the format bounds are clamped to them.
+func SetArgon2Limits(time, memory uint32, threads uint8) {
+	argon2LimitsMutex.Lock()
+	defer argon2LimitsMutex.Unlock()
+
+	if time > Argon2MaxTime {
+		time = Argon2MaxTime
+	}
+	if memory > Argon2MaxMemory {
+		memory = Argon2MaxMemory
+	}
+	if threads > Argon2MaxThreads {
+		threads = Argon2MaxThreads
+	}
+	argon2Limits = Argon2Params{Time: time, Memory: memory, Threads: threads}
+}
+
+// Validate checks the params are within the bounds accepted when opening.
+func (params *Argon2Params) Validate() error {
+	argon2LimitsMutex.RLock()
+	limits := argon2Limits
+	argon2LimitsMutex.RUnlock()
+
+	if params.Time < 1 || params.Time > limits.Time ||
+		params.Memory < 8*uint32(params.Threads) || params.Memory > limits.Memory ||
+		params.Threads < 1 || params.Threads > limits.Threads {
+		return newError(ErrMalformed, "argon2 parameters out of bounds")
+	}
+	return nil
+}
+
+// KDF returns KDFArgon2id.
+func (params *Argon2Params) KDF() byte {
+	return KDFArgon2id
+}
+
+// Marshal returns the params as stored in envelopes.
+func (params *Argon2Params) Marshal() []byte {
+	b := make([]byte, Argon2ParamsSize)
+	binary.BigEndian.PutUint32(b[0:4], params.Time)
+	binary.BigEndian.PutUint32(b[4:8], params.Memory)
+	b[8] = params.Threads
+	copy(b[9:], params.Salt)
+	return b
+}
+
+// UnmarshalArgon2Params parses and bounds checks params, returning any bytes after them.
+func UnmarshalArgon2Params(b []byte) (*Argon2Params, []byte, error) {
+	// Ensure full params present
+	if len(b) < Argon2ParamsSize {
+		return nil, nil, newError(ErrMalformed, "argon2 header truncated")
+	}
+
+	// Parse and bounds check the parameters
+	params := &Argon2Params{
+		Time:    binary.BigEndian.Uint32(b[0:4]),
+		Memory:  binary.BigEndian.Uint32(b[4:8]),
+		Threads: b[8],
+		Salt:    b[9:Argon2ParamsSize],
+	}
+	if err := params.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return params, b[Argon2ParamsSize:], nil
+}
+
+// DeriveKey derives a key from passphrase.
+func (params *Argon2Params) DeriveKey(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), params.Salt, params.Time, params.Memory, params.Threads, KeySize)
+}
+
+// PBKDF2Params are the PBKDF2-HMAC-SHA256 parameters stored in envelopes,
+// for deployments that need a FIPS-approved KDF.
+type PBKDF2Params struct {
+	Iterations uint32
+	Salt       []byte
+}
+
+// NewPBKDF2Params returns params with a new random salt.
+func NewPBKDF2Params(iterations uint32) (*PBKDF2Params, error) {
+	salt := make([]byte, PBKDF2SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	params := &PBKDF2Params{Iterations: iterations, Salt: salt}
+	return params, params.Validate()
+}
+
+// Validate checks the params are within the bounds accepted when opening.
+func (params *PBKDF2Params) Validate() error {
+	if params.Iterations < 1 || params.Iterations > PBKDF2MaxIterations {
+		return newError(ErrMalformed, "pbkdf2 iterations out of bounds")
+	}
+	return nil
+}
+
+// KDF returns KDFPBKDF2.
+func (params *PBKDF2Params) KDF() byte {
+	return KDFPBKDF2
+}
+
+// Marshal returns the params as stored in envelopes.
+func (params *PBKDF2Params) Marshal() []byte {
+	b := make([]byte, PBKDF2ParamsSize)
+	binary.BigEndian.PutUint32(b[0:4], params.Iterations)
+	copy(b[4:], params.Salt)
+	return b
+}
+
+// UnmarshalPBKDF2Params parses and bounds checks params.
+func UnmarshalPBKDF2Params(b []byte) (*PBKDF2Params, error) {
+	if len(b) != PBKDF2ParamsSize {
+		return nil, newError(ErrMalformed, "pbkdf2 params size mismatch")
+	}
+	params := &PBKDF2Params{
+		Iterations: binary.BigEndian.Uint32(b[0:4]),
+		Salt:       b[4:],
+	}
+	return params, params.Validate()
+}
+
+// DeriveKey derives a key from passphrase.
+func (params *PBKDF2Params) DeriveKey(passphrase string) []byte {
+	return pbkdf2.Key([]byte(passphrase), params.Salt, int(params.Iterations), KeySize, sha256.New)
+}
+
+// LegacyDeriveKey is the original single-round SHA-256 key derivation.
+func LegacyDeriveKey(passphrase string) []byte {
+	hash := sha256.Sum256([]byte(passphrase))
+	return hash[:]
+}
+
+func init() {
+	// Register built-in passphrase KDFs
+	RegisterKDF(KDFSHA256, KDFFunc(func(_ []byte, secret string) ([]byte, error) {
+		return LegacyDeriveKey(secret), nil
+	}))
+	RegisterKDF(KDFArgon2id, KDFFunc(func(b []byte, secret string) ([]byte, error) {
+		params, rest, err := UnmarshalArgon2Params(b)
+		if err != nil {
+			return nil, err
+		} else if len(rest) != 0 {
+			return nil, newError(ErrMalformed, "unexpected trailing argon2 params")
+		}
+		return params.DeriveKey(secret), nil
+	}))
+	RegisterKDF(KDFPBKDF2, KDFFunc(func(b []byte, secret string) ([]byte, error) {
+		params, err := UnmarshalPBKDF2Params(b)
+		if err != nil {
+			return nil, err
+		}
+		return params.DeriveKey(secret), nil
+	}))
+}
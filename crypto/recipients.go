@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// Recipient stanza: ephemeral public key, wrapped content key with tag
+	RecipientStanzaSize = curve25519.PointSize + KeySize + GCMTagSize
+
+	// HKDF info for wrapping keys
+	recipientWrapInfo = "gibon-x25519"
+)
+
+func recipientWrapKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	// Derive wrapping key bound to both public keys
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	wrapKey := make([]byte, KeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(recipientWrapInfo)), wrapKey)
+	return wrapKey, err
+}
+
+// WrapRecipientKey wraps contentKey to an X25519 recipient public key,
+// returning a stanza for KDFX25519 params (one per recipient, concatenated).
+func WrapRecipientKey(contentKey, recipient []byte) ([]byte, error) {
+	// Generate ephemeral key pair
+	ephemeralSecret := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeralSecret); err != nil {
+		return nil, err
+	}
+	ephemeral, err := curve25519.X25519(ephemeralSecret, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get shared secret with recipient, derive wrapping key
+	shared, err := curve25519.X25519(ephemeralSecret, recipient)
+	if err != nil {
+		return nil, err
+	}
+	wrapKey, err := recipientWrapKey(shared, ephemeral, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Seal content key (wrapping key is single use, so zero nonce is safe)
+	aead, err := NewAEAD(CipherAES256GCM, wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	return append(ephemeral, aead.Seal(nil, nonce, contentKey, nil)...), nil
+}
+
+// UnwrapRecipientKey finds the stanza wrapped to secret's public key and unwraps the content key.
+func UnwrapRecipientKey(stanzas, secret []byte) ([]byte, error) {
+	// Get our public key
+	public, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Try unwrap each recipient stanza in turn
+	if len(stanzas)%RecipientStanzaSize != 0 {
+		return nil, newError(ErrMalformed, "recipient stanzas truncated")
+	}
+	for i := 0; i < len(stanzas); i += RecipientStanzaSize {
+		stanza := stanzas[i : i+RecipientStanzaSize]
+		ephemeral := stanza[:curve25519.PointSize]
+
+		shared, err := curve25519.X25519(secret, ephemeral)
+		if err != nil {
+			continue
+		}
+		wrapKey, err := recipientWrapKey(shared, ephemeral, public)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := NewAEAD(CipherAES256GCM, wrapKey)
+		if err != nil {
+			return nil, err
+		}
+		contentKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), stanza[curve25519.PointSize:], nil)
+		if err == nil {
+			return contentKey, nil
+		}
+	}
+
+	return nil, newError(ErrDecrypt, "identity is not a recipient")
+}
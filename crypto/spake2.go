@@ -0,0 +1,192 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// SPAKE2 messages are uncompressed P-256 points
+	SPAKE2MessageSize = 65
+
+	// Size of SPAKE2 password scalars and key confirmation MACs
+	SPAKE2ScalarSize  = 32
+	SPAKE2ConfirmSize = sha256.Size
+
+	// HKDF info for password scalars, confirmation keys and the shared key
+	spake2ScalarInfo  = "gibon-spake2-w"
+	spake2ConfirmInfo = "ConfirmationKeys"
+	spake2KeyInfo     = "gibon-spake2-key"
+)
+
+var (
+	// RFC 9382 P-256 points M and N, nobody knows their discrete logs
+	spake2M = mustSPAKE2Point("02886e2f97ace46e55ba9dd7242579f2993b64e16ef3dcab95afd497333d8fa12f")
+	spake2N = mustSPAKE2Point("03d8bbd6c639c62937b04d997f38c3770719c629d7014d49a24b4f98baa1292b49")
+
+	errSPAKE2Point = newError(ErrMalformed, "invalid SPAKE2 message")
+)
+
+type spake2Point struct {
+	x, y *big.Int
+}
+
+func mustSPAKE2Point(compressed string) spake2Point {
+	b, _ := hex.DecodeString(compressed)
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), b)
+	if x == nil {
+		panic("invalid SPAKE2 constant")
+	}
+	return spake2Point{x, y}
+}
+
+// SPAKE2 is one side of a SPAKE2 (RFC 9382) exchange over P-256 with
+// SHA-256, the client being party A and the server party B.
+type SPAKE2 struct {
+	client   bool
+	w        *big.Int
+	secret   *big.Int
+	message  []byte
+	idA, idB []byte
+}
+
+// SPAKE2Result holds the shared key and key confirmation MACs of a
+// completed exchange, each side sends its own MAC and checks the other's.
+type SPAKE2Result struct {
+	Key           []byte
+	ClientConfirm []byte
+	ServerConfirm []byte
+}
+
+// SPAKE2PasswordScalar derives the password scalar w from a passphrase,
+// the server stores it along with params for clients to derive it again.
+func SPAKE2PasswordScalar(passphrase string, params *Argon2Params) ([]byte, error) {
+	// Stretch, then widen before reducing so w is near uniform
+	wide := make([]byte, SPAKE2ScalarSize+16)
+	_, err := io.ReadFull(hkdf.New(sha256.New, params.DeriveKey(passphrase), nil, []byte(spake2ScalarInfo)), wide)
+	if err != nil {
+		return nil, err
+	}
+	w := new(big.Int).Mod(new(big.Int).SetBytes(wide), elliptic.P256().Params().N)
+	return w.FillBytes(make([]byte, SPAKE2ScalarSize)), nil
+}
+
+// ValidateSPAKE2Scalar checks w is a usable password scalar.
+func ValidateSPAKE2Scalar(w []byte) error {
+	n := new(big.Int).SetBytes(w)
+	if len(w) != SPAKE2ScalarSize || n.Sign() == 0 || n.Cmp(elliptic.P256().Params().N) >= 0 {
+		return newError(ErrMalformed, "invalid SPAKE2 password scalar")
+	}
+	return nil
+}
+
+// NewSPAKE2 starts an exchange with password scalar w, identities may be empty.
+func NewSPAKE2(client bool, w, idA, idB []byte) (*SPAKE2, error) {
+	if err := ValidateSPAKE2Scalar(w); err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+
+	// Random secret scalar in [1, n)
+	secret, err := rand.Int(rand.Reader, new(big.Int).Sub(curve.Params().N, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	secret.Add(secret, big.NewInt(1))
+
+	// Message is secret*G + w*M from the client, secret*G + w*N from the server
+	blind := spake2N
+	if client {
+		blind = spake2M
+	}
+	sx, sy := curve.ScalarBaseMult(secret.FillBytes(make([]byte, SPAKE2ScalarSize)))
+	bx, by := curve.ScalarMult(blind.x, blind.y, w)
+	x, y := curve.Add(sx, sy, bx, by)
+
+	return &SPAKE2{
+		client:  client,
+		w:       new(big.Int).SetBytes(w),
+		secret:  secret,
+		message: elliptic.Marshal(curve, x, y),
+		idA:     idA,
+		idB:     idB,
+	}, nil
+}
+
+// Message returns this side's message to send to the peer.
+func (s *SPAKE2) Message() []byte {
+	return s.message
+}
+
+// Finish completes the exchange with the peer's message.
+func (s *SPAKE2) Finish(peer []byte) (*SPAKE2Result, error) {
+	curve := elliptic.P256()
+
+	// Peer message must be a point on the curve
+	px, py := elliptic.Unmarshal(curve, peer)
+	if px == nil {
+		return nil, errSPAKE2Point
+	}
+
+	// Remove the peer's blinding, adding the negated w*M or w*N
+	blind := spake2M
+	if s.client {
+		blind = spake2N
+	}
+	w := s.w.FillBytes(make([]byte, SPAKE2ScalarSize))
+	bx, by := curve.ScalarMult(blind.x, blind.y, w)
+	bx, by = curve.Add(px, py, bx, new(big.Int).Sub(curve.Params().P, by))
+	if bx.Sign() == 0 && by.Sign() == 0 {
+		return nil, errSPAKE2Point
+	}
+
+	// Shared point is secret times that, never the identity
+	kx, ky := curve.ScalarMult(bx, by, s.secret.FillBytes(make([]byte, SPAKE2ScalarSize)))
+	if kx.Sign() == 0 && ky.Sign() == 0 {
+		return nil, errSPAKE2Point
+	}
+
+	// Transcript orders messages client first
+	pA, pB := s.message, peer
+	if !s.client {
+		pA, pB = peer, s.message
+	}
+	tt := []byte{}
+	for _, field := range [][]byte{s.idA, s.idB, pA, pB, elliptic.Marshal(curve, kx, ky), w} {
+		tt = append(tt, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(tt[len(tt)-8:], uint64(len(field)))
+		tt = append(tt, field...)
+	}
+
+	// Split transcript hash into key and confirmation secrets
+	hash := sha256.Sum256(tt)
+	ke, ka := hash[:sha256.Size/2], hash[sha256.Size/2:]
+	confirmKeys := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ka, nil, []byte(spake2ConfirmInfo)), confirmKeys); err != nil {
+		return nil, err
+	}
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ke, nil, []byte(spake2KeyInfo)), key); err != nil {
+		return nil, err
+	}
+
+	return &SPAKE2Result{
+		Key:           key,
+		ClientConfirm: spake2MAC(confirmKeys[:sha256.Size/2], tt),
+		ServerConfirm: spake2MAC(confirmKeys[sha256.Size/2:], tt),
+	}, nil
+}
+
+func spake2MAC(key, tt []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(tt)
+	return mac.Sum(nil)
+}
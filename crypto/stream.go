@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Chunked ciphers follow the STREAM construction: plaintext is sealed in
+// StreamChunkSize chunks, each with nonce = prefix || counter || last flag,
+// the prefix being stored as the envelope nonce. The final chunk (possibly
+// empty) carries the last flag, so truncation and reordering are detected.
+const (
+	// Plaintext bytes per sealed chunk
+	StreamChunkSize = 64 * 1024
+
+	// Random per-message nonce prefix: GCM nonce less 4 byte counter and last flag
+	StreamNoncePrefixSize = GCMNonceSize - 5
+)
+
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, GCMNonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[StreamNoncePrefixSize:], counter)
+	if last {
+		nonce[GCMNonceSize-1] = 1
+	}
+	return nonce
+}
+
+type chunkWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	ad      []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+func newChunkWriter(w io.Writer, aead cipher.AEAD, prefix, ad []byte) *chunkWriter {
+	return &chunkWriter{w: w, aead: aead, prefix: prefix, ad: ad, buf: make([]byte, 0, StreamChunkSize)}
+}
+
+func (cw *chunkWriter) flush(chunk []byte, last bool) error {
+	if cw.counter == math.MaxUint32 {
+		return newError(ErrTooLarge, "stream too long")
+	}
+	_, err := cw.w.Write(cw.aead.Seal(nil, streamNonce(cw.prefix, cw.counter, last), chunk, cw.ad))
+	cw.counter++
+	return err
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, errors.New("write to closed stream")
+	}
+
+	// Only flush once more follows, the final chunk must wait for Close
+	n := len(p)
+	for len(p) > 0 {
+		take := StreamChunkSize - len(cw.buf)
+		if take == 0 {
+			if err := cw.flush(cw.buf, false); err != nil {
+				return n - len(p), err
+			}
+			cw.buf = cw.buf[:0]
+			continue
+		}
+		if take > len(p) {
+			take = len(p)
+		}
+		cw.buf = append(cw.buf, p[:take]...)
+		p = p[take:]
+	}
+	return n, nil
+}
+
+func (cw *chunkWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	return cw.flush(cw.buf, true)
+}
+
+type chunkReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	ad      []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	done    bool
+}
+
+func newChunkReader(r io.Reader, aead cipher.AEAD, prefix, ad []byte) *chunkReader {
+	return &chunkReader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		prefix: prefix,
+		ad:     ad,
+		chunk:  make([]byte, StreamChunkSize+aead.Overhead()),
+	}
+}
+
+func (cr *chunkReader) next() error {
+	// Short chunk, or a full one at EOF, is the last
+	n, err := io.ReadFull(cr.r, cr.chunk)
+	last := false
+	switch err {
+	case io.EOF:
+		return newError(ErrMalformed, "stream truncated")
+	case io.ErrUnexpectedEOF:
+		last = true
+	case nil:
+		_, err = cr.r.Peek(1)
+		if err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	// Open with the nonce for this position, reusing the plaintext buffer
+	buf, err := cr.aead.Open(cr.buf[:0], streamNonce(cr.prefix, cr.counter, last), cr.chunk[:n], cr.ad)
+	if err != nil {
+		return newError(ErrDecrypt, err.Error())
+	}
+	cr.buf = buf
+	cr.counter++
+	cr.done = last
+	return nil
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.buf) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
+
+func openChunks(aead cipher.AEAD, prefix, ad, sealed []byte) ([]byte, error) {
+	if len(prefix) != StreamNoncePrefixSize {
+		return nil, newError(ErrMalformed, "envelope nonce size does not match cipher")
+	}
+	return ioutil.ReadAll(newChunkReader(bytes.NewReader(sealed), aead, prefix, ad))
+}
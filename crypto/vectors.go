@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+//go:embed vectors.json
+var vectorsJSON []byte
+
+// Vector is a golden envelope with the secret and plaintext it opens to.
+// Exactly one of Passphrase, Key (hex) or Identity (hex X25519 secret key)
+// is set; AssociatedData is hex, Plaintext is UTF-8, Envelope is hex.
+type Vector struct {
+	Name           string `json:"name"`
+	Passphrase     string `json:"passphrase,omitempty"`
+	Key            string `json:"key,omitempty"`
+	Identity       string `json:"identity,omitempty"`
+	AssociatedData string `json:"associated_data,omitempty"`
+	Plaintext      string `json:"plaintext"`
+	Envelope       string `json:"envelope"`
+}
+
+// Vectors returns the golden test vectors, for checking other implementations.
+func Vectors() ([]Vector, error) {
+	vectors := []Vector{}
+	return vectors, json.Unmarshal(vectorsJSON, &vectors)
+}
+
+func (v *Vector) open() ([]byte, error) {
+	b, err := hex.DecodeString(v.Envelope)
+	if err != nil {
+		return nil, err
+	}
+	env, err := UnmarshalEnvelope(b)
+	if err != nil {
+		return nil, err
+	}
+	ad, err := hex.DecodeString(v.AssociatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open with whichever secret the vector gives
+	switch {
+	case v.Key != "":
+		key, err := hex.DecodeString(v.Key)
+		if err != nil {
+			return nil, err
+		}
+		return env.OpenWithKey(key, ad)
+	case v.Identity != "":
+		secret, err := hex.DecodeString(v.Identity)
+		if err != nil {
+			return nil, err
+		}
+		key, err := UnwrapRecipientKey(env.KDFParams, secret)
+		if err != nil {
+			return nil, err
+		}
+		return env.OpenWithKey(key, ad)
+	default:
+		return env.Open(v.Passphrase, ad)
+	}
+}
+
+// SelfTest opens every golden vector, catching format regressions at startup.
+func SelfTest() error {
+	vectors, err := Vectors()
+	if err != nil {
+		return err
+	}
+	for _, v := range vectors {
+		text, err := v.open()
+		if err != nil {
+			return errors.New("crypto vector " + v.Name + " - " + err.Error())
+		} else if !bytes.Equal(text, []byte(v.Plaintext)) {
+			return errors.New("crypto vector " + v.Name + " - plaintext mismatch")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func loadVectors(t *testing.T) []Vector {
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(vectors) == 0 {
+		t.Fatal("no golden vectors")
+	}
+	return vectors
+}
+
+func vectorVersion(t *testing.T, v Vector) byte {
+	b, err := hex.DecodeString(v.Envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := UnmarshalEnvelope(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env.Version
+}
+
+func expectDecryptFailure(t *testing.T, v Vector, what string) {
+	text, err := v.open()
+	if err == nil {
+		t.Fatalf("%s opened to %q", what, text)
+	} else if !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("%s failed with %v, expected a decryption failure", what, err)
+	}
+}
+
+func TestVectorsOpen(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			text, err := v.open()
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(text, []byte(v.Plaintext)) {
+				t.Fatalf("opened to %q, expected %q", text, v.Plaintext)
+			}
+		})
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVectorsTamperedAssociatedData(t *testing.T) {
+	tested := 0
+	for _, v := range loadVectors(t) {
+		// Associated data is only authenticated since v3
+		if vectorVersion(t, v) < 3 {
+			continue
+		}
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			// Extra byte, as for vectors sealed without any
+			tampered := v
+			tampered.AssociatedData = v.AssociatedData + "00"
+			expectDecryptFailure(t, tampered, "appended associated data")
+
+			// Flipped byte
+			if v.AssociatedData != "" {
+				ad, err := hex.DecodeString(v.AssociatedData)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ad[0] ^= 0x01
+				tampered.AssociatedData = hex.EncodeToString(ad)
+				expectDecryptFailure(t, tampered, "flipped associated data")
+			}
+		})
+		tested++
+	}
+	if tested == 0 {
+		t.Fatal("no v3 vectors to tamper with")
+	}
+}
+
+func TestVectorsTamperedHeader(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			b, err := hex.DecodeString(v.Envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env, err := UnmarshalEnvelope(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			headerSize := len(b) - len(env.Sealed)
+
+			// Flipped last nonce byte, the end of the header in every version
+			tampered := v
+			flipped := append([]byte{}, b...)
+			flipped[headerSize-1] ^= 0x01
+			tampered.Envelope = hex.EncodeToString(flipped)
+			expectDecryptFailure(t, tampered, "flipped nonce")
+
+			// Downgraded to v2, which doesn't authenticate the header
+			if env.Version >= 3 {
+				downgraded := append([]byte{}, b...)
+				downgraded[1] = 2
+				tampered.Envelope = hex.EncodeToString(downgraded)
+				expectDecryptFailure(t, tampered, "downgraded version")
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/grufwub/gibon/crypto"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	// Encrypted paste text layouts
+	pasteSealingLegacy   = 0 // SHA-256 key, nonce+ciphertext
+	pasteSealingArgon2id = 1 // Argon2id params+nonce+ciphertext
+	pasteSealingEnvelope = 2 // Versioned crypto envelope, see crypto/envelope.go
+	pasteSealingE2E      = 3 // Client-side E2E format, see crypto/e2e.go
+)
+
+// pasteBinding is paste metadata kept outside the ciphertext (in the index)
+// that is bound to it as associated data, so changing it breaks decryption.
+type pasteBinding struct {
+	ContentType string
+	MaxViews    uint64
+}
+
+func getPasteBinding(c cid.Cid) (*pasteBinding, error) {
+	// Served content type from metadata
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// View limit as set on upload, none is zero
+	limit := viewLimit{}
+	err = indexGet(indexKey("views", c.String()), &limit)
+	if err != nil && err != ds.ErrNotFound {
+		return nil, err
+	}
+
+	return &pasteBinding{ContentType: meta.ContentType, MaxViews: limit.Max}, nil
+}
+
+func (p *paste) associatedData(binding *pasteBinding) []byte {
+	// Paste header fields describing the plaintext: version, codec, sealed metadata flag
+	ad := append([]byte{}, pasteEnvelopeMagic...)
+	ad = append(ad, pasteEnvelopeVersion, p.codec, 0)
+	if p.metaSealed {
+		ad[len(ad)-1] = 1
+	}
+
+	// Then the bound metadata, none binds the zero values
+	if binding == nil {
+		binding = &pasteBinding{}
+	}
+	views := make([]byte, 8)
+	binary.BigEndian.PutUint64(views, binding.MaxViews)
+	ad = append(ad, views...)
+	return append(ad, binding.ContentType...)
+}
+
+func (p *paste) seal(enc crypto.Encrypter, binding *pasteBinding) error {
+	// Seal text in versioned crypto envelope describing how to open it, bound to the metadata
+	text, err := enc.Seal(p.text, p.associatedData(binding))
+	if err != nil {
+		return err
+	}
+
+	// Set paste text as crypto envelope, set encrypted
+	p.text = text
+	p.encrypted = true
+	p.sealing = pasteSealingEnvelope
+
+	return nil
+}
+
+func (p *paste) cryptoEnvelope() (*crypto.Envelope, error) {
+	switch p.sealing {
+	case pasteSealingEnvelope:
+		return crypto.UnmarshalEnvelope(p.text)
+
+	// Describe older layouts as envelopes too, they used AES-GCM with standard nonce
+	case pasteSealingArgon2id:
+		if len(p.text) < crypto.Argon2ParamsSize+crypto.GCMNonceSize {
+			return nil, errors.New("text not long enough to contain nonce")
+		}
+		return &crypto.Envelope{
+			KDF:       crypto.KDFArgon2id,
+			Cipher:    crypto.CipherAES256GCM,
+			KDFParams: p.text[:crypto.Argon2ParamsSize],
+			Nonce:     p.text[crypto.Argon2ParamsSize : crypto.Argon2ParamsSize+crypto.GCMNonceSize],
+			Sealed:    p.text[crypto.Argon2ParamsSize+crypto.GCMNonceSize:],
+		}, nil
+
+	case pasteSealingLegacy:
+		if len(p.text) < crypto.GCMNonceSize {
+			return nil, errors.New("text not long enough to contain nonce")
+		}
+		return &crypto.Envelope{
+			KDF:    crypto.KDFSHA256,
+			Cipher: crypto.CipherAES256GCM,
+			Nonce:  p.text[:crypto.GCMNonceSize],
+			Sealed: p.text[crypto.GCMNonceSize:],
+		}, nil
+
+	case pasteSealingE2E:
+		return nil, errE2EPaste
+
+	default:
+		return nil, errors.New("unsupported paste sealing")
+	}
+}
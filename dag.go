@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// Maximum versions walked for a paste's history
+	maxPasteHistory = 100
+)
+
+var (
+	// Store each new paste as an IPLD DAG node linking its block, metadata and previous version
+	pasteDAG bool
+
+	// Returned for DAG CIDs that aren't paste nodes
+	errNotPasteNode = errors.New("not a paste DAG node")
+)
+
+// pasteNode is the dag-cbor IPLD form of a paste, in IPLD schema terms:
+//
+//	type Paste struct {
+//	  body      &Any               # paste block, an opaque envelope
+//	  meta      {String:String}    # public metadata (title, language, tags...)
+//	  previous  optional &Paste    # version this one forks or replaces
+//	  signer    optional Bytes     # server Ed25519 public key
+//	  signature optional Bytes     # over "gibon-dag-v1\x00" + node without signer / signature
+//	}
+//
+// Bodies aren't valid dag-pb, so nodes are pinned directly, never recursively.
+type pasteNode struct {
+	Body      cid.Cid           `refmt:"body"`
+	Meta      map[string]string `refmt:"meta,omitempty"`
+	Previous  *cid.Cid          `refmt:"previous,omitempty"`
+	Signer    []byte            `refmt:"signer,omitempty"`
+	Signature []byte            `refmt:"signature,omitempty"`
+}
+
+type pasteVersion struct {
+	DAG    string            `json:"dag"`
+	Paste  string            `json:"paste"`
+	Meta   map[string]string `json:"meta,omitempty"`
+	Signer string            `json:"signer,omitempty"`
+	Valid  bool              `json:"valid,omitempty"`
+}
+
+func init() {
+	// Register paste node for CBOR (un)marshaling
+	cbor.RegisterCborType(pasteNode{})
+}
+
+func isPasteNodeCID(c cid.Cid) bool {
+	return c.Type() == cid.DagCBOR
+}
+
+func pasteNodeMeta(meta *pasteMeta) map[string]string {
+	// Only public metadata, hints and sealed fields stay in the index
+	fields := map[string]string{}
+	if meta.Title != "" {
+		fields["title"] = meta.Title
+	}
+	if meta.Language != "" {
+		fields["language"] = meta.Language
+	}
+	if meta.ContentType != "" {
+		fields["content_type"] = meta.ContentType
+	}
+	if len(meta.Tags) > 0 {
+		fields["tags"] = strings.Join(meta.Tags, ",")
+	}
+	if meta.E2E {
+		fields["e2e"] = "true"
+	}
+	if meta.Expires != 0 {
+		fields["expires"] = strconv.FormatInt(meta.Expires, 10)
+	}
+	return fields
+}
+
+func (node *pasteNode) signingBytes() ([]byte, error) {
+	unsigned := *node
+	unsigned.Signer, unsigned.Signature = nil, nil
+	return cbor.DumpObject(&unsigned)
+}
+
+func (node *pasteNode) verify() bool {
+	if len(node.Signer) != ed25519.PublicKeySize {
+		return false
+	}
+	b, err := node.signingBytes()
+	return err == nil && verifyWithContext(node.Signer, dagSigContext, b, node.Signature)
+}
+
+func pinNode(ctx context.Context, c cid.Cid) error {
+	return pinNodes(ctx, []cid.Cid{c})
+}
+
+func pinNodes(ctx context.Context, cids []cid.Cid) error {
+	// Group by responsible shard, so each flushes its pins once
+	byShard := map[*ipfsShard][]cid.Cid{}
+	for _, c := range cids {
+		shard := shardForCID(c)
+		byShard[shard] = append(byShard[shard], c)
+	}
+
+	// Direct pins by CID, paste blocks aren't valid dag-pb so can't be resolved, nor links to them traversed
+	for shard, shardCIDs := range byShard {
+		unlocker := shard.node.Blockstore.PinLock()
+		for _, c := range shardCIDs {
+			shard.node.Pinning.PinWithMode(c, pin.Direct)
+		}
+		err := shard.node.Pinning.Flush(ctx)
+		unlocker.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pasteNodeFor(c cid.Cid) (cid.Cid, error) {
+	b, err := indexStore.Get(indexKey("dag", c.String()))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.Decode(string(b))
+}
+
+func putPasteNode(ctx context.Context, body, previous cid.Cid) (cid.Cid, error) {
+	// Pastes put again keep their node
+	existing, err := pasteNodeFor(body)
+	if err == nil {
+		return existing, nil
+	} else if err != ds.ErrNotFound {
+		return cid.Undef, err
+	}
+
+	// Link the block, its public metadata and any previous version's node
+	meta, err := getPasteMeta(body)
+	if err != nil {
+		return cid.Undef, err
+	}
+	node := &pasteNode{Body: body, Meta: pasteNodeMeta(meta)}
+	if previous.Defined() {
+		prevNode, err := pasteNodeFor(previous)
+		if err == nil {
+			node.Previous = &prevNode
+		} else if err != ds.ErrNotFound {
+			return cid.Undef, err
+		}
+	}
+
+	// Sign with the server identity if any
+	if serverSigningKey != nil {
+		b, err := node.signingBytes()
+		if err != nil {
+			return cid.Undef, err
+		}
+		node.Signer = serverSigningKey.Public().(ed25519.PublicKey)
+		node.Signature = signWithContext(serverSigningKey, dagSigContext, b)
+	}
+
+	// Wrap as IPLD CBOR node, add and pin it (directly, the body isn't traversable)
+	wrapped, err := cbor.WrapObject(node, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = shardForCID(wrapped.Cid()).api.Dag().Add(ctx, wrapped)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = pinNode(ctx, wrapped.Cid())
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Index by body, so reads and later versions find it
+	err = indexStore.Put(indexKey("dag", body.String()), []byte(wrapped.Cid().String()))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return wrapped.Cid(), nil
+}
+
+func getPasteNode(ctx context.Context, c cid.Cid) (*pasteNode, error) {
+	// Get the IPLD node from responsible shard
+	if !isPasteNodeCID(c) {
+		return nil, errNotPasteNode
+	}
+	ipldNode, err := shardForCID(c).api.Dag().Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode, it must link a paste block
+	node := &pasteNode{}
+	err = cbor.DecodeInto(ipldNode.RawData(), node)
+	if err != nil || !node.Body.Defined() || !isPasteCID(node.Body) {
+		return nil, errNotPasteNode
+	}
+	return node, nil
+}
+
+func resolvePasteCID(ctx context.Context, c cid.Cid) (cid.Cid, error) {
+	// Paste blocks are their own paste, nodes resolve to their body
+	if !isPasteNodeCID(c) {
+		return c, nil
+	}
+	node, err := getPasteNode(ctx, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return node.Body, nil
+}
+
+func createPasteNode(ctx context.Context, c, previous cid.Cid) string {
+	// Skip if disabled, view-limited pastes must only be served from here
+	if !pasteDAG {
+		return ""
+	}
+	limited, err := indexStore.Has(indexKey("views", c.String()))
+	if err != nil || limited {
+		return ""
+	}
+
+	// Non-fatal, the paste is stored
+	node, err := putPasteNode(ctx, c, previous)
+	if err != nil {
+		log.Printf("Failed to put paste %s DAG node - %s\n", c.String(), err.Error())
+		return ""
+	}
+	return node.String()
+}
+
+func pasteHistoryHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/history", request.RemoteAddr)
+
+	// Start from the paste's node, or the node itself
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+	if !isPasteNodeCID(c) {
+		c, err = pasteNodeFor(c)
+		if err != nil {
+			http.Error(writer, "Paste has no DAG node!", http.StatusNotFound)
+			return
+		}
+	}
+
+	// Walk previous links, newest first
+	versions := []*pasteVersion{}
+	for i := 0; i < maxPasteHistory; i++ {
+		node, err := getPasteNode(request.Context(), c)
+		if err != nil {
+			// Older versions may never have been stored here
+			if len(versions) > 0 {
+				break
+			}
+			log.Printf("Failed to get paste DAG node - %s\n", err.Error())
+			http.Error(writer, "Paste not found!", http.StatusNotFound)
+			return
+		}
+
+		// Stop at blocked pastes, they're never served
+		blocked, err := isBlockedPaste(node.Body)
+		if err != nil || blocked {
+			break
+		}
+		version := &pasteVersion{DAG: c.String(), Paste: node.Body.String(), Meta: node.Meta}
+		if node.Signature != nil {
+			version.Signer = encodeSigningKey(node.Signer)
+			version.Valid = node.verify()
+		}
+		versions = append(versions, version)
+
+		if node.Previous == nil {
+			break
+		}
+		c = *node.Previous
+	}
+
+	writeJSON(writer, versions)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	// Directory diagnostic dumps are written to on SIGQUIT (logged if empty)
+	diagnosticsDir string
+
+	// Requests currently being served by ID, guarded by mutex
+	openRequests      = map[uint64]*openRequest{}
+	openRequestsMutex sync.Mutex
+	nextRequestID     uint64
+)
+
+type openRequest struct {
+	method  string
+	path    string
+	remote  string
+	started time.Time
+}
+
+func trackRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Record the request while it is served, path only as queries may carry keys
+		openRequestsMutex.Lock()
+		nextRequestID++
+		id := nextRequestID
+		openRequests[id] = &openRequest{
+			method:  request.Method,
+			path:    request.URL.Path,
+			remote:  request.RemoteAddr,
+			started: time.Now(),
+		}
+		openRequestsMutex.Unlock()
+
+		defer func() {
+			openRequestsMutex.Lock()
+			delete(openRequests, id)
+			openRequestsMutex.Unlock()
+		}()
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+func writeDiagnostics(writer io.Writer) {
+	now := time.Now()
+	fmt.Fprintf(writer, "gibon %s diagnostics at %s\n", versionStr, now.UTC().Format(time.RFC3339))
+
+	// Open requests, longest running first
+	openRequestsMutex.Lock()
+	requests := make([]*openRequest, 0, len(openRequests))
+	for _, request := range openRequests {
+		requests = append(requests, request)
+	}
+	openRequestsMutex.Unlock()
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].started.Before(requests[j].started)
+	})
+	fmt.Fprintf(writer, "\n== open requests (%d)\n", len(requests))
+	for _, request := range requests {
+		fmt.Fprintf(writer, "%s %s (%s) for %s\n", request.method, request.path, request.remote, now.Sub(request.started).Round(time.Millisecond))
+	}
+
+	// Concurrency slots in use, requests beyond these are queued
+	fmt.Fprintf(writer, "\n== queues (in use / capacity, 0 capacity is unlimited)\n")
+	for _, queue := range []struct {
+		name string
+		l    limiter
+	}{
+		{"global", globalLimiter},
+		{"upload", uploadLimiter},
+		{"download", downloadLimiter},
+		{"render", renderLimiter},
+		{"hooks", hookLimiter},
+	} {
+		fmt.Fprintf(writer, "%s: %d / %d\n", queue.name, len(queue.l), cap(queue.l))
+	}
+
+	// Cache sizes and hit rates
+	fmt.Fprintf(writer, "\n== caches\n")
+	if renderCache != nil {
+		stats := renderCache.stats()
+		fmt.Fprintf(writer, "render: %d entries, %d / %d bytes, %d hits, %d misses\n", stats.Entries, stats.Bytes, stats.MaxBytes, stats.Hits, stats.Misses)
+	} else {
+		fmt.Fprintf(writer, "render: disabled\n")
+	}
+	gatewayMissesMutex.Lock()
+	fmt.Fprintf(writer, "gateway misses: %d entries\n", len(gatewayMisses))
+	gatewayMissesMutex.Unlock()
+	fallbackCacheMutex.Lock()
+	fmt.Fprintf(writer, "federation fallback: %d entries\n", len(fallbackCache))
+	fallbackCacheMutex.Unlock()
+	pakeSessionsMutex.Lock()
+	fmt.Fprintf(writer, "PAKE sessions: %d\n", len(pakeSessions))
+	pakeSessionsMutex.Unlock()
+	decryptFailuresMutex.Lock()
+	fmt.Fprintf(writer, "decrypt throttle: %d entries\n", len(decryptFailures))
+	decryptFailuresMutex.Unlock()
+
+	// IPFS node status per shard
+	fmt.Fprintf(writer, "\n== ipfs shards (%d)\n", len(ipfsShards))
+	for i, shard := range ipfsShards {
+		fmt.Fprintf(writer, "shard %d: %s", i, shard.repoPath)
+		if shard.node == nil {
+			fmt.Fprintf(writer, ", not open\n")
+			continue
+		}
+		fmt.Fprintf(writer, ", peer %s, online %t", shard.node.Identity.Pretty(), shard.node.IsOnline)
+		if shard.node.IsOnline && shard.node.PeerHost != nil {
+			fmt.Fprintf(writer, ", %d swarm peers", len(shard.node.PeerHost.Network().Peers()))
+		}
+		if usage, err := shard.node.Repo.GetStorageUsage(); err == nil {
+			fmt.Fprintf(writer, ", %d bytes stored", usage)
+		}
+		fmt.Fprintf(writer, "\n")
+	}
+
+	// Memory, then every goroutine's stack
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+	fmt.Fprintf(writer, "\n== runtime\n")
+	fmt.Fprintf(writer, "goroutines: %d, heap: %d bytes, GC cycles: %d\n", runtime.NumGoroutine(), memStats.HeapAlloc, memStats.NumGC)
+	fmt.Fprintf(writer, "\n== goroutines\n")
+	pprof.Lookup("goroutine").WriteTo(writer, 2)
+}
+
+func dumpDiagnostics() {
+	buf := &bytes.Buffer{}
+	writeDiagnostics(buf)
+
+	// Log if no directory set
+	if diagnosticsDir == "" {
+		log.Printf("Diagnostic dump follows\n%s", buf.String())
+		return
+	}
+
+	// Else write to a timestamped file
+	path := filepath.Join(diagnosticsDir, "gibon-diagnostics-"+time.Now().UTC().Format("20060102T150405Z")+".txt")
+	err := ioutil.WriteFile(path, buf.Bytes(), 0600)
+	if err != nil {
+		log.Printf("Failed to write diagnostic dump - %s\n", err.Error())
+		return
+	}
+	log.Printf("Diagnostic dump written to %s\n", path)
+}
+
+func diagnosticsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", apiPrefix+"diagnostics", request.RemoteAddr)
+
+	// Write the dump, never cached
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("content-type", "text/plain")
+	writeDiagnostics(writer)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"strings"
+)
+
+const (
+	// Lines sampled when detecting diff pastes
+	diffDetectLines = 50
+)
+
+func isDiff(b []byte) bool {
+	// Look for file headers followed by a hunk header near the start
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	sawHeader := false
+	for i := 0; i < diffDetectLines && scanner.Scan(); i++ {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "diff --git "), strings.HasPrefix(line, "+++ "):
+			sawHeader = true
+		case strings.HasPrefix(line, "@@ ") && sawHeader:
+			return true
+		}
+	}
+	return false
+}
+
+func diffFileName(line string) string {
+	// Use the new side of 'diff --git a/x b/x' or '+++ b/x'
+	if strings.HasPrefix(line, "diff --git ") {
+		fields := strings.Fields(line)
+		line = fields[len(fields)-1]
+	} else {
+		line = strings.TrimPrefix(line, "+++ ")
+		if tab := strings.IndexByte(line, '\t'); tab >= 0 {
+			line = line[:tab]
+		}
+	}
+	return strings.TrimPrefix(line, "b/")
+}
+
+func diffToHTML(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	open := false
+	inFile := false
+
+	// Close the current section, if any
+	closeSection := func() {
+		if open {
+			buf.WriteString("</pre>")
+		}
+		if inFile {
+			buf.WriteString("</details>\n")
+		}
+		open, inFile = false, false
+	}
+
+	// Open a collapsible section per file
+	openFile := func(name string) {
+		closeSection()
+		buf.WriteString("<details class=\"diff-file\" open><summary>" + html.EscapeString(name) + "</summary><pre class=\"diff\">")
+		open, inFile = true, true
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	header := false
+	for i, line := range lines {
+		// File headers run until the first hunk ('---' only counts when followed by '+++')
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			openFile(diffFileName(line))
+			header = true
+		case !header && strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			// Deleted files only have an old name
+			name := diffFileName(lines[i+1])
+			if name == "/dev/null" {
+				name = strings.TrimPrefix(diffFileName("+++ "+strings.TrimPrefix(line, "--- ")), "a/")
+			}
+			openFile(name)
+			header = true
+		case strings.HasPrefix(line, "@@"):
+			header = false
+		}
+
+		// Text before any file header (e.g. patch email) goes in a plain section
+		if !open {
+			buf.WriteString("<pre class=\"diff\">")
+			open = true
+		}
+
+		// Classify line for colouring
+		class := ""
+		switch {
+		case header:
+			class = "diff-meta"
+		case strings.HasPrefix(line, "@@"):
+			class = "diff-hunk"
+		case inFile && strings.HasPrefix(line, "+"):
+			class = "diff-add"
+		case inFile && strings.HasPrefix(line, "-"):
+			class = "diff-del"
+		}
+		if class != "" {
+			buf.WriteString("<span class=\"" + class + "\">" + html.EscapeString(line) + "</span>\n")
+		} else {
+			buf.WriteString(html.EscapeString(line) + "\n")
+		}
+	}
+
+	closeSection()
+	return buf.Bytes()
+}
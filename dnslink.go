@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	icorepath "github.com/ipfs/interface-go-ipfs-core/path"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	dnslinkPath = "/dnslink"
+
+	// Maximum paste CIDs per public index page block
+	maxPublicIndexPage = 4096
+)
+
+var (
+	// Domain the public paste index is published under as DNSLink (disabled if empty)
+	dnslinkDomain string
+
+	// Period between public paste index rebuilds
+	dnslinkPeriod time.Duration
+
+	// Provider updating the _dnslink TXT record, logged for manual setup if nil
+	dnslinkDNS acmeDNSProvider
+)
+
+// publicIndexPage is a CBOR block of public paste CIDs. They're kept as
+// strings, not links, so pinning the index doesn't traverse into pastes.
+type publicIndexPage struct {
+	Pastes []string `refmt:"pastes"`
+}
+
+// publicIndex is the DNSLink root, linking every page in CID order
+type publicIndex struct {
+	Instance string    `refmt:"instance"`
+	Count    int       `refmt:"count"`
+	Pages    []cid.Cid `refmt:"pages"`
+}
+
+func init() {
+	// Register public index nodes for CBOR (un)marshaling
+	cbor.RegisterCborType(publicIndexPage{})
+	cbor.RegisterCborType(publicIndex{})
+}
+
+func dnslinkEnabled() bool {
+	return dnslinkDomain != ""
+}
+
+func setupDNSLink(providerName, providerConfig string) error {
+	// Other IPFS nodes must be able to fetch the index
+	if !ipfsOnline {
+		return errors.New("DNSLink publishing requires -online")
+	}
+
+	// Without a provider the record is only logged
+	if providerName == "" {
+		return nil
+	}
+	var err error
+	dnslinkDNS, err = newACMEDNSProvider(providerName, providerConfig)
+	return err
+}
+
+func buildPublicIndex() (*cbor.Node, []*cbor.Node, error) {
+	// Every public paste still allowed to spread, sorted so unchanged sets give the same root
+	names, err := indexList("public")
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(names)
+	pastes := []string{}
+	for _, name := range names {
+		c, err := cid.Decode(name)
+		if err != nil || !isReplicable(c) {
+			continue
+		}
+		expired, err := indexStore.Has(indexKey("expired", name))
+		if err != nil {
+			return nil, nil, err
+		} else if !expired {
+			pastes = append(pastes, name)
+		}
+	}
+
+	// Split into page blocks linked from the root
+	root := &publicIndex{Instance: instanceHostname, Count: len(pastes), Pages: []cid.Cid{}}
+	nodes := []*cbor.Node{}
+	for start := 0; start < len(pastes); start += maxPublicIndexPage {
+		end := start + maxPublicIndexPage
+		if end > len(pastes) {
+			end = len(pastes)
+		}
+		node, err := cbor.WrapObject(&publicIndexPage{Pastes: pastes[start:end]}, mh.SHA2_256, -1)
+		if err != nil {
+			return nil, nil, err
+		}
+		root.Pages = append(root.Pages, node.Cid())
+		nodes = append(nodes, node)
+	}
+
+	rootNode, err := cbor.WrapObject(root, mh.SHA2_256, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rootNode, append(nodes, rootNode), nil
+}
+
+func currentDNSLinkRoot() (cid.Cid, error) {
+	b, err := indexStore.Get(indexKey("dnslink", "root"))
+	if err == ds.ErrNotFound {
+		return cid.Undef, nil
+	} else if err != nil {
+		return cid.Undef, err
+	}
+	return cid.Decode(string(b))
+}
+
+func dnslinkRecord(root cid.Cid) string {
+	return "dnslink=/ipfs/" + root.String()
+}
+
+func updateDNSLink(ctx context.Context) error {
+	// Build the index, nothing to do if unchanged
+	root, nodes, err := buildPublicIndex()
+	if err != nil {
+		return err
+	}
+	previous, err := currentDNSLinkRoot()
+	if err != nil {
+		return err
+	} else if previous.Equals(root.Cid()) {
+		return nil
+	}
+
+	// Keep the whole index in one shard, so its recursive pin resolves locally
+	shard := shardForCID(root.Cid())
+	for _, node := range nodes {
+		err = shard.api.Dag().Add(ctx, node)
+		if err != nil {
+			return err
+		}
+	}
+	err = shard.api.Pin().Add(ctx, icorepath.IpldPath(root.Cid()))
+	if err != nil {
+		return err
+	}
+
+	// Swap the TXT record, removing first as more than one dnslink= record is ambiguous
+	fqdn := "_dnslink." + dnslinkDomain + "."
+	if dnslinkDNS != nil {
+		if previous.Defined() {
+			err = dnslinkDNS.CleanUp(ctx, fqdn, dnslinkRecord(previous))
+			if err != nil {
+				log.Printf("Failed to remove previous DNSLink record - %s\n", err.Error())
+			}
+		}
+		err = dnslinkDNS.Present(ctx, fqdn, dnslinkRecord(root.Cid()))
+		if err != nil {
+			return err
+		}
+		log.Printf("Published public paste index %s at /ipns/%s\n", root.Cid().String(), dnslinkDomain)
+	} else {
+		log.Printf("Public paste index updated, set TXT record %s to \"%s\"\n", fqdn, dnslinkRecord(root.Cid()))
+	}
+
+	// Remember the new root, then release the old one to repo GC
+	err = indexStore.Put(indexKey("dnslink", "root"), []byte(root.Cid().String()))
+	if err != nil {
+		return err
+	}
+	if previous.Defined() {
+		err = shardForCID(previous).api.Pin().Rm(ctx, icorepath.IpldPath(previous))
+		if err != nil {
+			log.Printf("Failed to unpin previous public paste index - %s\n", err.Error())
+		}
+	}
+	return nil
+}
+
+func startDNSLink() {
+	log.Printf("Publishing public paste index at /ipns/%s every %s\n", dnslinkDomain, dnslinkPeriod)
+	go func() {
+		for {
+			// Hold off IPFS restarts while updating
+			ipfsGateMutex.RLock()
+			err := updateDNSLink(globalContext)
+			ipfsGateMutex.RUnlock()
+			if err != nil {
+				log.Printf("Failed to update DNSLink - %s\n", err.Error())
+			}
+
+			select {
+			case <-globalContext.Done():
+				return
+			case <-time.After(dnslinkPeriod):
+			}
+		}
+	}()
+}
+
+func dnslinkHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", dnslinkPath, request.RemoteAddr)
+
+	// Get the published root
+	root, err := currentDNSLinkRoot()
+	if err != nil {
+		log.Printf("Failed to get DNSLink root - %s\n", err.Error())
+		http.Error(writer, "Failed to get DNSLink root", http.StatusInternalServerError)
+		return
+	} else if !root.Defined() {
+		http.Error(writer, "Not yet published!", http.StatusNotFound)
+		return
+	}
+
+	// Serve the expected TXT record value
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(dnslinkRecord(root) + "\n"))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// Raw paste bytes per TXT chunk (base64 encoded fits one TXT string, small enough for plain UDP)
+	dnsChunkSize = 180
+
+	// TTL on DNS TXT answers, pastes are immutable
+	dnsTTL = 3600
+)
+
+var (
+	// DNS zone pastes are served under (DNS responder disabled if empty)
+	dnsZone string
+
+	// DNS responder bind address
+	dnsBindAddr string
+
+	// Maximum paste size served over DNS (in bytes)
+	dnsMaxPasteSize int64
+)
+
+func dnsEnabled() bool {
+	return dnsZone != ""
+}
+
+func dnsPasteCID(label string) (cid.Cid, bool) {
+	// Names are case-insensitive, so CIDs must be base32 CIDv1
+	c, err := cid.Decode(strings.ToLower(label))
+	if err != nil || c.Version() != 1 {
+		return cid.Undef, false
+	}
+
+	if !isPasteCID(c) {
+		return cid.Undef, false
+	}
+
+	// Pastes uploaded before CIDv1 paths are stored under CIDv0
+	if c.Prefix().MhType == mh.SHA2_256 {
+		has, err := storageBackend.Has(globalContext, c)
+		if err == nil && !has {
+			return cid.NewCidV0(c.Hash()), true
+		}
+	}
+	return c, true
+}
+
+func dnsPasteText(c cid.Cid) ([]byte, bool) {
+	// View-limited and blocked pastes are never served over DNS
+	if !isReplicable(c) {
+		return nil, false
+	}
+
+	// Only small unencrypted pastes
+	p, err := getPaste(c)
+	if err != nil || p.encrypted {
+		return nil, false
+	}
+	err = p.decompress()
+	if err != nil || int64(len(p.text)) > dnsMaxPasteSize {
+		return nil, false
+	}
+	return p.text, true
+}
+
+func dnsAnswer(name string) []string {
+	// Names are '<CID>.<zone>' (summary) or '<chunk>.<CID>.<zone>' (base64 chunk)
+	labels := dns.SplitDomainName(strings.TrimSuffix(strings.ToLower(name), strings.ToLower(dns.Fqdn(dnsZone))))
+	var chunkStr string
+	switch len(labels) {
+	case 1:
+	case 2:
+		chunkStr = labels[0]
+		labels = labels[1:]
+	default:
+		return nil
+	}
+
+	// Look for the paste
+	c, ok := dnsPasteCID(labels[0])
+	if !ok {
+		return nil
+	}
+	text, ok := dnsPasteText(c)
+	if !ok {
+		return nil
+	}
+	chunks := (len(text) + dnsChunkSize - 1) / dnsChunkSize
+
+	// Summary tells the client how many chunks to fetch
+	if chunkStr == "" {
+		return []string{"v=gibon1 size=" + strconv.Itoa(len(text)) + " chunks=" + strconv.Itoa(chunks)}
+	}
+
+	// Return the requested chunk
+	chunk, err := strconv.Atoi(chunkStr)
+	if err != nil || chunk < 0 || chunk >= chunks {
+		return nil
+	}
+	end := (chunk + 1) * dnsChunkSize
+	if end > len(text) {
+		end = len(text)
+	}
+	return []string{base64.StdEncoding.EncodeToString(text[chunk*dnsChunkSize : end])}
+}
+
+func dnsHandler(writer dns.ResponseWriter, request *dns.Msg) {
+	response := &dns.Msg{}
+	response.SetReply(request)
+	response.Authoritative = true
+
+	// Only single TXT questions within our zone are answered
+	if len(request.Question) != 1 {
+		response.SetRcode(request, dns.RcodeFormatError)
+		writer.WriteMsg(response)
+		return
+	}
+	question := request.Question[0]
+	if !dns.IsSubDomain(dns.Fqdn(dnsZone), question.Name) {
+		response.SetRcode(request, dns.RcodeRefused)
+		writer.WriteMsg(response)
+		return
+	}
+
+	// Log the request
+	logRequest("DNS", question.Name, writer.RemoteAddr().String())
+
+	txt := dnsAnswer(question.Name)
+	switch {
+	case txt == nil:
+		response.SetRcode(request, dns.RcodeNameError)
+	case question.Qtype == dns.TypeTXT && question.Qclass == dns.ClassINET:
+		response.Answer = append(response.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    dnsTTL,
+			},
+			Txt: txt,
+		})
+	}
+
+	// Other types on existing names get an empty (NODATA) answer
+	writer.WriteMsg(response)
+}
+
+func startDNSResponder() {
+	log.Printf("Starting experimental DNS TXT responder for %s on: %s\n", dnsZone, dnsBindAddr)
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{
+			Addr:    dnsBindAddr,
+			Net:     network,
+			Handler: dns.HandlerFunc(dnsHandler),
+		}
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil {
+				fatalf("DNS responder failed - %s", err.Error())
+			}
+		}()
+	}
+}
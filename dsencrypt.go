@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+
+	"github.com/grufwub/gibon/crypto"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-ipfs/repo"
+)
+
+const (
+	// File in each repo holding its datastore key, wrapped by the master key
+	datastoreKeyName = "datastore.key"
+)
+
+var (
+	// Encrypt every repo datastore value at rest with a per-repo key wrapped by the master key
+	encryptDatastore bool
+
+	// Leads every encrypted datastore value
+	encryptedValueMagic = []byte("gbE1")
+)
+
+type datastoreKeyFile struct {
+	MasterKey string `json:"master_key"`
+	Wrapped   []byte `json:"wrapped"`
+
+	// Set once every value written before encryption was enabled is encrypted
+	Sealed bool `json:"sealed"`
+}
+
+// encryptedDatastore seals values with AES-256-GCM, bound to their key so
+// they can't be swapped around on disk. Keys themselves (block CIDs in
+// flatfs file names) stay readable.
+type encryptedDatastore struct {
+	child repo.Datastore
+	aead  cipher.AEAD
+}
+
+type encryptedBatch struct {
+	ds.Batch
+	eds *encryptedDatastore
+}
+
+// encryptedRepo is a repo whose datastore is encrypted
+type encryptedRepo struct {
+	repo.Repo
+	datastore *encryptedDatastore
+}
+
+func writeDatastoreKeyFile(keyPath string, keyFile *datastoreKeyFile) error {
+	b, err := json.Marshal(keyFile)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, losing the key would lose the repo
+	err = ioutil.WriteFile(keyPath+".tmp", b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(keyPath+".tmp", keyPath)
+}
+
+func loadDatastoreKey(repoPath string) (*datastoreKeyFile, []byte, error) {
+	ctx, cancel := context.WithTimeout(globalContext, masterKeyTimeout)
+	defer cancel()
+	keyPath := path.Join(repoPath, datastoreKeyName)
+
+	// Generate a new key on first use
+	b, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		key := make([]byte, crypto.KeySize)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		wrapped, err := masterKey.Wrap(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyFile := &datastoreKeyFile{MasterKey: masterKey.ID(), Wrapped: wrapped}
+		err = writeDatastoreKeyFile(keyPath, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Generated new datastore key for IPFS repo at %s\n", repoPath)
+		return keyFile, key, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	// Unwrap with whichever master key wrapped it, current or retired
+	keyFile := &datastoreKeyFile{}
+	err = json.Unmarshal(b, keyFile)
+	if err != nil {
+		return nil, nil, errors.New("Invalid datastore key file " + keyPath + " - " + err.Error())
+	}
+	unwrapper, ok := masterKeyRing[keyFile.MasterKey]
+	if !ok {
+		return nil, nil, errors.New("Datastore key " + keyPath + " wrapped by unknown master key: " + keyFile.MasterKey)
+	}
+	key, err := unwrapper.Unwrap(ctx, keyFile.Wrapped)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Rewrap under a rotated master key, so the retired one can be dropped later
+	if keyFile.MasterKey != masterKey.ID() {
+		keyFile.Wrapped, err = masterKey.Wrap(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyFile.MasterKey = masterKey.ID()
+		err = writeDatastoreKeyFile(keyPath, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Rewrapped datastore key for IPFS repo at %s under master key %s\n", repoPath, masterKey.ID())
+	}
+	return keyFile, key, nil
+}
+
+func encryptRepo(repoPath string, r repo.Repo) (repo.Repo, error) {
+	keyFile, key, err := loadDatastoreKey(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := crypto.NewAEAD(crypto.CipherAES256GCM, key)
+	if err != nil {
+		return nil, err
+	}
+	eds := &encryptedDatastore{child: r.Datastore(), aead: aead}
+
+	// Encrypt anything written before encryption was enabled, resumed if interrupted
+	if !keyFile.Sealed {
+		log.Printf("Encrypting existing datastore values in IPFS repo at %s...\n", repoPath)
+		count, err := eds.sealExisting()
+		if err != nil {
+			return nil, err
+		}
+		keyFile.Sealed = true
+		err = writeDatastoreKeyFile(path.Join(repoPath, datastoreKeyName), keyFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("... encrypted %d values\n", count)
+	}
+
+	return &encryptedRepo{Repo: r, datastore: eds}, nil
+}
+
+func (r *encryptedRepo) Datastore() repo.Datastore {
+	return r.datastore
+}
+
+func (eds *encryptedDatastore) overhead() int {
+	return len(encryptedValueMagic) + eds.aead.NonceSize() + eds.aead.Overhead()
+}
+
+func (eds *encryptedDatastore) seal(key ds.Key, value []byte) ([]byte, error) {
+	// Value is: magic, nonce, ciphertext
+	nonce := make([]byte, eds.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, len(value)+eds.overhead())
+	b = append(b, encryptedValueMagic...)
+	b = append(b, nonce...)
+	return eds.aead.Seal(b, nonce, value, []byte(key.String())), nil
+}
+
+func (eds *encryptedDatastore) open(key ds.Key, b []byte) ([]byte, error) {
+	if len(b) < eds.overhead() || !bytes.HasPrefix(b, encryptedValueMagic) {
+		return nil, errors.New("datastore value not encrypted: " + key.String())
+	}
+	b = b[len(encryptedValueMagic):]
+	nonceSize := eds.aead.NonceSize()
+	return eds.aead.Open(nil, b[:nonceSize], b[nonceSize:], []byte(key.String()))
+}
+
+func (eds *encryptedDatastore) sealExisting() (int, error) {
+	// Gather keys first, rewriting values mid-query isn't safe on every datastore
+	results, err := eds.child.Query(dsq.Query{KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	keys, err := results.Rest()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range keys {
+		key := ds.RawKey(entry.Key)
+		b, err := eds.child.Get(key)
+		if err == ds.ErrNotFound {
+			continue
+		} else if err != nil {
+			return count, err
+		}
+
+		// Skip values already encrypted, plaintext could carry the magic by chance
+		if _, err := eds.open(key, b); err == nil {
+			continue
+		}
+		sealed, err := eds.seal(key, b)
+		if err != nil {
+			return count, err
+		}
+		err = eds.child.Put(key, sealed)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, eds.child.Sync(ds.NewKey("/"))
+}
+
+func (eds *encryptedDatastore) Get(key ds.Key) ([]byte, error) {
+	b, err := eds.child.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return eds.open(key, b)
+}
+
+func (eds *encryptedDatastore) Has(key ds.Key) (bool, error) {
+	return eds.child.Has(key)
+}
+
+func (eds *encryptedDatastore) GetSize(key ds.Key) (int, error) {
+	// Plaintext size, every value carries the same overhead
+	size, err := eds.child.GetSize(key)
+	if err != nil {
+		return size, err
+	} else if size < eds.overhead() {
+		return -1, errors.New("datastore value not encrypted: " + key.String())
+	}
+	return size - eds.overhead(), nil
+}
+
+func (eds *encryptedDatastore) Query(q dsq.Query) (dsq.Results, error) {
+	// Filters and orders may look at values, so they're applied after decrypting
+	childQuery := dsq.Query{Prefix: q.Prefix, KeysOnly: q.KeysOnly}
+	results, err := eds.child.Query(childQuery)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := dsq.ResultsFromIterator(childQuery, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			result, ok := results.NextSync()
+			if ok && result.Error == nil && !q.KeysOnly {
+				result.Value, result.Error = eds.open(ds.RawKey(result.Key), result.Value)
+			}
+			return result, ok
+		},
+		Close: results.Close,
+	})
+	return dsq.NaiveQueryApply(dsq.Query{
+		Filters: q.Filters,
+		Orders:  q.Orders,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+	}, decrypted), nil
+}
+
+func (eds *encryptedDatastore) Put(key ds.Key, value []byte) error {
+	sealed, err := eds.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return eds.child.Put(key, sealed)
+}
+
+func (eds *encryptedDatastore) Delete(key ds.Key) error {
+	return eds.child.Delete(key)
+}
+
+func (eds *encryptedDatastore) Sync(prefix ds.Key) error {
+	return eds.child.Sync(prefix)
+}
+
+func (eds *encryptedDatastore) Close() error {
+	return eds.child.Close()
+}
+
+func (eds *encryptedDatastore) Batch() (ds.Batch, error) {
+	batch, err := eds.child.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedBatch{Batch: batch, eds: eds}, nil
+}
+
+func (eds *encryptedDatastore) DiskUsage() (uint64, error) {
+	return ds.DiskUsage(eds.child)
+}
+
+func (eds *encryptedDatastore) CollectGarbage() error {
+	// Let badger reclaim space as it would unwrapped
+	if gcds, ok := eds.child.(ds.GCDatastore); ok {
+		return gcds.CollectGarbage()
+	}
+	return nil
+}
+
+func (batch *encryptedBatch) Put(key ds.Key, value []byte) error {
+	sealed, err := batch.eds.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return batch.Batch.Put(key, sealed)
+}
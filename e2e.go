@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/grufwub/gibon/crypto"
+)
+
+var (
+	// Returned when server-side decryption of an E2E paste is attempted
+	errE2EPaste = errors.New("end-to-end encrypted paste can only be decrypted client-side")
+)
+
+func newE2EPaste(b []byte) (*paste, error) {
+	// Format is described in crypto/e2e.go
+	err := crypto.ValidateE2E(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored verbatim, flagged so the server never tries to open it
+	return &paste{
+		text:      b,
+		encrypted: true,
+		sealing:   pasteSealingE2E,
+	}, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// Paste envelope header version
+	pasteEnvelopeVersion = 1
+
+	// Paste envelope flags
+	pasteFlagEncrypted      = 1 << 0
+	pasteFlagArgon2id       = 1 << 1 // Argon2id params+nonce+ciphertext, before crypto envelopes
+	pasteFlagCryptoEnvelope = 1 << 2
+	pasteFlagE2E            = 1 << 3 // Client-side encrypted, never opened by server
+	pasteFlagSigned         = 1 << 4 // Ed25519 signer public key+signature precede text
+	pasteFlagMAC            = 1 << 5 // HMAC-SHA256 tag follows any signature block
+	pasteFlagSealedMeta     = 1 << 6 // Length-prefixed metadata JSON precedes (encrypted) text
+
+	// Paste body compression codecs
+	pasteCodecNone = 0
+	pasteCodecGzip = 1
+)
+
+var (
+	// Magic prefix identifying an enveloped paste (legacy pastes are raw)
+	pasteEnvelopeMagic = []byte("GBN")
+
+	// Transparently compress paste bodies
+	compressPastes bool
+)
+
+func (p *paste) marshal() []byte {
+	// Header is magic, version, flags, codec
+	var flags byte
+	if p.encrypted {
+		flags |= pasteFlagEncrypted
+	}
+	switch p.sealing {
+	case pasteSealingArgon2id:
+		flags |= pasteFlagArgon2id
+	case pasteSealingEnvelope:
+		flags |= pasteFlagCryptoEnvelope
+	case pasteSealingE2E:
+		flags |= pasteFlagE2E
+	}
+	if p.signature != nil {
+		flags |= pasteFlagSigned
+	}
+	if p.mac != nil {
+		flags |= pasteFlagMAC
+	}
+	if p.metaSealed {
+		flags |= pasteFlagSealedMeta
+	}
+	header := append(append([]byte{}, pasteEnvelopeMagic...), pasteEnvelopeVersion, flags, p.codec)
+	if p.signature != nil {
+		header = append(append(header, p.signer...), p.signature...)
+	}
+	if p.mac != nil {
+		header = append(header, p.mac...)
+	}
+	return append(header, p.text...)
+}
+
+func unmarshalPaste(b []byte) (*paste, error) {
+	// No magic, this is a legacy raw paste
+	if !bytes.HasPrefix(b, pasteEnvelopeMagic) {
+		return &paste{text: b}, nil
+	}
+
+	// Ensure full header present and supported
+	headerLen := len(pasteEnvelopeMagic) + 3
+	if len(b) < headerLen {
+		return nil, errors.New("paste envelope header truncated")
+	}
+	header := b[len(pasteEnvelopeMagic):headerLen]
+	if header[0] != pasteEnvelopeVersion {
+		return nil, errors.New("unsupported paste envelope version")
+	}
+
+	// Get encrypted text layout from flags
+	var sealing byte = pasteSealingLegacy
+	if header[1]&pasteFlagE2E != 0 {
+		sealing = pasteSealingE2E
+	} else if header[1]&pasteFlagCryptoEnvelope != 0 {
+		sealing = pasteSealingEnvelope
+	} else if header[1]&pasteFlagArgon2id != 0 {
+		sealing = pasteSealingArgon2id
+	}
+
+	p := &paste{
+		text:       b[headerLen:],
+		encrypted:  header[1]&pasteFlagEncrypted != 0,
+		codec:      header[2],
+		sealing:    sealing,
+		metaSealed: header[1]&pasteFlagSealedMeta != 0,
+	}
+
+	// Split off signature if signed
+	if header[1]&pasteFlagSigned != 0 {
+		if len(p.text) < pasteSignatureBlockSize {
+			return nil, errors.New("paste signature truncated")
+		}
+		p.signer = p.text[:ed25519.PublicKeySize]
+		p.signature = p.text[ed25519.PublicKeySize:pasteSignatureBlockSize]
+		p.text = p.text[pasteSignatureBlockSize:]
+	}
+
+	// Split off integrity MAC if present
+	if header[1]&pasteFlagMAC != 0 {
+		if len(p.text) < pasteMACSize {
+			return nil, errors.New("paste integrity MAC truncated")
+		}
+		p.mac = p.text[:pasteMACSize]
+		p.text = p.text[pasteMACSize:]
+	}
+
+	return p, nil
+}
+
+func (p *paste) compress() error {
+	// Only compress if enabled and not already
+	if !compressPastes || p.codec != pasteCodecNone {
+		return nil
+	}
+
+	// Gzip the paste text
+	buf := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buf)
+	_, err := gzipWriter.Write(p.text)
+	if err != nil {
+		return err
+	}
+	err = gzipWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	// Only keep compressed if actually smaller
+	if buf.Len() < len(p.text) {
+		p.text = buf.Bytes()
+		p.codec = pasteCodecGzip
+	}
+
+	return nil
+}
+
+func (p *paste) decompress() error {
+	switch p.codec {
+	case pasteCodecNone:
+		return p.openMeta()
+
+	case pasteCodecGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(p.text))
+		if err != nil {
+			return err
+		}
+
+		// Read with paste size limit to guard against decompression bombs
+		b, err := ioutil.ReadAll(io.LimitReader(gzipReader, maxPasteSize+1))
+		if err != nil {
+			return err
+		} else if int64(len(b)) > maxPasteSize {
+			return errors.New("decompressed paste exceeds max paste size")
+		}
+
+		p.text = b
+		p.codec = pasteCodecNone
+		return p.openMeta()
+
+	default:
+		return errors.New("unsupported paste codec")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// Returned when sealed metadata says the paste has expired
+	errPasteExpired = errors.New("paste has expired")
+)
+
+func parseExpiry(str string) (int64, error) {
+	// Lifetime from now, e.g. 24h
+	lifetime, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, err
+	} else if lifetime <= 0 {
+		return 0, errors.New("expiry must be in the future")
+	}
+	return time.Now().Add(lifetime).Unix(), nil
+}
+
+func (meta *pasteMeta) expired() bool {
+	return meta.Expires != 0 && time.Now().Unix() >= meta.Expires
+}
+
+func expiryTime(expires int64) *time.Time {
+	// Nil for pastes that never expire, so JSON fields are omitted
+	if expires == 0 {
+		return nil
+	}
+	at := time.Unix(expires, 0).UTC()
+	return &at
+}
+
+func writeExpiryHeaders(writer http.ResponseWriter, at *time.Time) {
+	// Tell caches and clients how long the link stays valid
+	if at == nil {
+		return
+	}
+	writer.Header().Set("Expires", at.Format(http.TimeFormat))
+	writer.Header().Set("X-Paste-Expires-At", at.Format(time.RFC3339))
+}
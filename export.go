@@ -0,0 +1,428 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	dsq "github.com/ipfs/go-datastore/query"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	exportCommand = "export"
+
+	// Archive directory holding one file per block, named by CID
+	exportBlocksDir = "blocks/"
+
+	// Maximum index keys and values per CAR export page block
+	maxExportIndexPage = 256 * 1024
+)
+
+var (
+	// Timestamp of every entry in deterministic exports
+	exportEpoch = time.Unix(0, 0)
+)
+
+type exportOptions struct {
+	deterministic bool
+	output        string
+	car           string
+}
+
+// exportIndexEntry is one local index key and value
+type exportIndexEntry struct {
+	Key   string `refmt:"key"`
+	Value []byte `refmt:"value"`
+}
+
+// exportIndexPage is a CBOR block of index entries in CAR exports
+type exportIndexPage struct {
+	Entries []exportIndexEntry `refmt:"entries"`
+}
+
+// exportRoot is the CAR export root, linking every index page
+type exportRoot struct {
+	Index []cid.Cid `refmt:"index"`
+}
+
+func init() {
+	// Register CAR export nodes for CBOR (un)marshaling
+	cbor.RegisterCborType(exportIndexEntry{})
+	cbor.RegisterCborType(exportIndexPage{})
+	cbor.RegisterCborType(exportRoot{})
+}
+
+func parseExportArgs(args []string) (*exportOptions, error) {
+	opts := &exportOptions{}
+	flags := flag.NewFlagSet(exportCommand, flag.ContinueOnError)
+	flags.BoolVar(&opts.deterministic, "deterministic", false, "Byte-identical archives of just the pinned pastes and DAG nodes (sorted, fixed timestamps and owners) so they can be compared by hash")
+	flags.StringVar(&opts.output, "o", "", "Archive output file (stdout if unset)")
+	flags.StringVar(&opts.car, "car", "", "Write every pinned block plus the local index to this CARv2 file instead, for backup or migration")
+	return opts, flags.Parse(args)
+}
+
+type exportBlock struct {
+	c     cid.Cid
+	shard *ipfsShard
+	err   error
+}
+
+func pinnedBlocks(shard *ipfsShard) ([]cid.Cid, error) {
+	// Direct pins are pastes and DAG nodes, exported as is
+	direct, err := shard.node.Pinning.DirectKeys(globalContext)
+	if err != nil {
+		return nil, err
+	}
+	recursive, err := shard.node.Pinning.RecursiveKeys(globalContext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Recursive pins are pastes or CBOR roots, whose links live in the same shard
+	seen := cid.NewSet()
+	blocks := []cid.Cid{}
+	for _, c := range direct {
+		if seen.Visit(c) {
+			blocks = append(blocks, c)
+		}
+	}
+	for len(recursive) > 0 {
+		c := recursive[len(recursive)-1]
+		recursive = recursive[:len(recursive)-1]
+		if !seen.Visit(c) {
+			continue
+		}
+		blocks = append(blocks, c)
+		if c.Type() != cid.DagCBOR {
+			continue
+		}
+		node, err := shard.api.Dag().Get(globalContext, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range node.Links() {
+			recursive = append(recursive, link.Cid)
+		}
+	}
+	return blocks, nil
+}
+
+func exportBlocks(deterministic bool) (<-chan exportBlock, error) {
+	out := make(chan exportBlock)
+
+	// Stream straight from each shard unless the order must be stable
+	if !deterministic {
+		go func() {
+			defer close(out)
+			for _, shard := range ipfsShards {
+				// A shard that can't be listed fails the export, rather than leaving it out
+				keys, err := pinnedBlocks(shard)
+				if err != nil {
+					out <- exportBlock{shard: shard, err: err}
+					return
+				}
+				for _, c := range keys {
+					out <- exportBlock{c: c, shard: shard}
+				}
+			}
+		}()
+		return out, nil
+	}
+
+	// Gather every shard's pinned pastes and DAG nodes, sorted by CID string and deduplicated
+	blocks := []exportBlock{}
+	for _, shard := range ipfsShards {
+		keys, err := pinnedBlocks(shard)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range keys {
+			if isPasteCID(c) || c.Type() == cid.DagCBOR {
+				blocks = append(blocks, exportBlock{c: c, shard: shard})
+			}
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].c.String() < blocks[j].c.String()
+	})
+	go func() {
+		defer close(out)
+		for i, block := range blocks {
+			if i > 0 && block.c.Equals(blocks[i-1].c) {
+				continue
+			}
+			out <- block
+		}
+	}()
+	return out, nil
+}
+
+func exportIndexNodes(namespaces ...string) (*cbor.Node, []*cbor.Node, error) {
+	// Every local index entry, unless limited to some namespaces
+	prefixes := []string{"/gibon/"}
+	if len(namespaces) > 0 {
+		prefixes = []string{}
+		for _, namespace := range namespaces {
+			prefixes = append(prefixes, "/gibon/"+namespace+"/")
+		}
+	}
+
+	// Gather into CBOR page blocks well within import section limits, linked from the root
+	root := &exportRoot{Index: []cid.Cid{}}
+	pages := []*cbor.Node{}
+	page, pageSize := &exportIndexPage{}, 0
+	flush := func() error {
+		node, err := cbor.WrapObject(page, mh.SHA2_256, -1)
+		if err != nil {
+			return err
+		}
+		root.Index = append(root.Index, node.Cid())
+		pages = append(pages, node)
+		page, pageSize = &exportIndexPage{}, 0
+		return nil
+	}
+	for _, prefix := range prefixes {
+		results, err := indexStore.Query(dsq.Query{
+			Prefix: prefix,
+			Orders: []dsq.Order{dsq.OrderByKey{}},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for result := range results.Next() {
+			if result.Error != nil {
+				results.Close()
+				return nil, nil, result.Error
+			}
+			if len(page.Entries) > 0 && pageSize+len(result.Key)+len(result.Value) > maxExportIndexPage {
+				if err := flush(); err != nil {
+					results.Close()
+					return nil, nil, err
+				}
+			}
+			page.Entries = append(page.Entries, exportIndexEntry{Key: result.Key, Value: result.Value})
+			pageSize += len(result.Key) + len(result.Value)
+		}
+		results.Close()
+	}
+	if len(page.Entries) > 0 {
+		if err := flush(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rootNode, err := cbor.WrapObject(root, mh.SHA2_256, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rootNode, pages, nil
+}
+
+func spoolCarExport(deterministic bool) (*os.File, int, error) {
+	// Get the index blocks, the root names the export
+	root, pages, err := exportIndexNodes()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// CARv2 leads with the data size, so spool the CARv1 data first
+	spool, err := ioutil.TempFile("", "gibon-export-*.car")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Unlinked straight away, so it's cleaned up on close however we return
+	os.Remove(spool.Name())
+
+	// Write the header, root and index pages
+	err = writeCarHeader(spool, []cid.Cid{root.Cid()})
+	if err == nil {
+		err = writeCarBlock(spool, root)
+	}
+	for _, page := range pages {
+		if err == nil {
+			err = writeCarBlock(spool, page)
+		}
+	}
+	if err != nil {
+		spool.Close()
+		return nil, 0, err
+	}
+
+	// Then every pinned block, from every shard
+	blocks, err := exportBlocks(deterministic)
+	if err != nil {
+		spool.Close()
+		return nil, 0, err
+	}
+	count := 0
+	for exported := range blocks {
+		if exported.err != nil {
+			spool.Close()
+			return nil, 0, exported.err
+		}
+		block, err := exported.shard.node.Blockstore.Get(exported.c)
+		if err == nil {
+			err = writeCarBlock(spool, block)
+		}
+		if err != nil {
+			spool.Close()
+			return nil, 0, err
+		}
+		count++
+	}
+
+	return spool, count, nil
+}
+
+func writeSpooledCar(writer io.Writer, spool *os.File) error {
+	// Data size is everything spooled
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = spool.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// Write the CARv2 header, then the CARv1 data after it
+	err = writeCarV2Header(writer, uint64(size))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, spool)
+	return err
+}
+
+func runCarExport(opts *exportOptions) error {
+	// Spool the export
+	spool, count, err := spoolCarExport(opts.deterministic)
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+
+	// Write to the CAR file, hashing as we go
+	file, err := os.Create(opts.car)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	err = writeSpooledCar(io.MultiWriter(file, hash), spool)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d blocks and the local index, CAR sha256 %s\n", count, hex.EncodeToString(hash.Sum(nil)))
+	return file.Close()
+}
+
+func exportCarHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", apiPrefix+"export.car", request.RemoteAddr)
+
+	// Spooling and writing the whole repo outlasts the server write timeout
+	extendWriteDeadline(request, 0)
+
+	// Spool the export
+	spool, count, err := spoolCarExport(request.URL.Query().Get("deterministic") == "1")
+	if err != nil {
+		log.Printf("Failed to export CAR - %s\n", err.Error())
+		http.Error(writer, "Failed to export CAR", http.StatusInternalServerError)
+		return
+	}
+	defer spool.Close()
+
+	// Write the CAR as a download
+	writer.Header().Set("content-type", "application/vnd.ipld.car; version=2")
+	writer.Header().Set("Content-Disposition", "attachment; filename=\"gibon-export.car\"")
+	err = writeSpooledCar(writer, spool)
+	if err != nil {
+		log.Printf("Failed to write CAR export - %s\n", err.Error())
+		return
+	}
+	log.Printf("Exported %d blocks and the local index over HTTP\n", count)
+}
+
+func runExport(opts *exportOptions) error {
+	// CAR exports carry the index too
+	if opts.car != "" {
+		return runCarExport(opts)
+	}
+
+	// Write to file or stdout, hashing as we go
+	var writer io.Writer = os.Stdout
+	if opts.output != "" {
+		file, err := os.Create(opts.output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+	hash := sha256.New()
+	archive := tar.NewWriter(io.MultiWriter(writer, hash))
+
+	// Entry timestamps are export time unless deterministic
+	modTime := time.Now().UTC().Truncate(time.Second)
+	if opts.deterministic {
+		modTime = exportEpoch
+	}
+
+	// Get the pinned blocks to export, from every shard
+	blocks, err := exportBlocks(opts.deterministic)
+	if err != nil {
+		return err
+	}
+
+	// Write each block as a file, USTAR headers only so no extended time records
+	count := 0
+	for exported := range blocks {
+		if exported.err != nil {
+			return exported.err
+		}
+		block, err := exported.shard.node.Blockstore.Get(exported.c)
+		if err != nil {
+			return err
+		}
+		err = archive.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     exportBlocksDir + exported.c.String(),
+			Mode:     0644,
+			Size:     int64(len(block.RawData())),
+			ModTime:  modTime,
+			Format:   tar.FormatUSTAR,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = archive.Write(block.RawData())
+		if err != nil {
+			return err
+		}
+		count++
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d blocks, archive sha256 %s\n", count, hex.EncodeToString(hash.Sum(nil)))
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Fallback modes for pastes not stored here
+	fallbackRedirect = "redirect"
+	fallbackProxy    = "proxy"
+
+	// Marks requests made by a peer's fallback, which never fall back again
+	federatedHeader = "X-Gibon-Federated"
+	federatedQuery  = "federated"
+
+	// How long found (and not found) peer locations are remembered
+	fallbackCacheTTL = 10 * time.Minute
+
+	// Maximum remembered peer locations
+	maxFallbackCacheEntries = 10000
+)
+
+var (
+	// Fallback mode for pastes not stored here (disabled if empty)
+	federationFallback string
+
+	// Peer found holding each CID by CID string, empty if none, guarded by mutex
+	fallbackCache      = map[string]*fallbackLocation{}
+	fallbackCacheMutex sync.Mutex
+)
+
+type fallbackLocation struct {
+	peer    string
+	expires time.Time
+}
+
+func validFallbackMode(mode string) bool {
+	switch mode {
+	case "", fallbackRedirect, fallbackProxy:
+		return true
+	default:
+		return false
+	}
+}
+
+func isFederatedRequest(request *http.Request) bool {
+	return request.Header.Get(federatedHeader) != "" || request.URL.Query().Get(federatedQuery) == "1"
+}
+
+func hasRequestSecrets(request *http.Request) bool {
+	// Anything a peer shouldn't see without the client choosing to go there
+	if request.Header.Get("Authorization") != "" || request.Header.Get("Cookie") != "" {
+		return true
+	}
+	for name, header := range secretHeaders {
+		if request.Header.Get(header) != "" || request.URL.Query().Get(name) != "" {
+			return true
+		}
+		if request.PostForm != nil && request.PostForm.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func cachedFallbackPeer(c cid.Cid) (string, bool) {
+	fallbackCacheMutex.Lock()
+	defer fallbackCacheMutex.Unlock()
+	location, ok := fallbackCache[c.String()]
+	if !ok || time.Now().After(location.expires) {
+		return "", false
+	}
+	return location.peer, true
+}
+
+func cacheFallbackPeer(c cid.Cid, peer string) {
+	fallbackCacheMutex.Lock()
+	defer fallbackCacheMutex.Unlock()
+
+	// Drop expired locations when full, then any if still full
+	now := time.Now()
+	if len(fallbackCache) >= maxFallbackCacheEntries {
+		for key, location := range fallbackCache {
+			if now.After(location.expires) {
+				delete(fallbackCache, key)
+			}
+		}
+		for key := range fallbackCache {
+			if len(fallbackCache) < maxFallbackCacheEntries {
+				break
+			}
+			delete(fallbackCache, key)
+		}
+	}
+	fallbackCache[c.String()] = &fallbackLocation{peer: peer, expires: now.Add(fallbackCacheTTL)}
+}
+
+func peerHasPaste(ctx context.Context, peerURL string, c cid.Cid) bool {
+	request, err := http.NewRequestWithContext(ctx, "HEAD", peerURL+pastePrefix+c.String(), nil)
+	if err != nil {
+		return false
+	}
+	request.Header.Set(federatedHeader, "1")
+	response, err := federationClient.Do(request)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode == http.StatusOK
+}
+
+func findFallbackPeer(ctx context.Context, c cid.Cid) string {
+	// Use remembered location if any
+	if peer, ok := cachedFallbackPeer(c); ok {
+		return peer
+	}
+
+	// Only ask peers the operator configured, anyone can announce themselves
+	peers := federationPeers
+	if len(peers) > maxFederationMirrorChecks {
+		peers = peers[:maxFederationMirrorChecks]
+	}
+
+	// Ask every peer at once, first to have it wins
+	ctx, cancel := context.WithTimeout(ctx, federationMirrorTimeout)
+	defer cancel()
+	found := make(chan string, len(peers))
+	wg := sync.WaitGroup{}
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peerURL string) {
+			defer wg.Done()
+			if peerHasPaste(ctx, peerURL, c) {
+				found <- peerURL
+			}
+		}(peer)
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+	peer := <-found
+
+	// Remember the answer, misses too so unknown CIDs don't fan out every time
+	cacheFallbackPeer(c, peer)
+	return peer
+}
+
+func serveFromPeer(writer http.ResponseWriter, request *http.Request, c cid.Cid) bool {
+	// Skip if disabled or this request came from a peer's fallback
+	if federationFallback == "" || isFederatedRequest(request) {
+		return false
+	}
+
+	// Only for pastes we really don't have
+	has, err := storageBackend.Has(request.Context(), c)
+	if err != nil || has {
+		return false
+	}
+
+	// Find a peer holding the paste
+	peerURL := findFallbackPeer(request.Context(), c)
+	if peerURL == "" {
+		return false
+	}
+	target, err := url.Parse(peerURL)
+	if err != nil {
+		return false
+	}
+
+	// Proxy plain reads, secrets only go to a peer if the client follows a redirect there
+	if federationFallback == fallbackProxy && request.Method == http.MethodGet && !hasRequestSecrets(request) {
+		proxy := &httputil.ReverseProxy{
+			Director: func(out *http.Request) {
+				out.URL.Scheme = target.Scheme
+				out.URL.Host = target.Host
+				out.Host = target.Host
+				out.Header.Del("Cookie")
+				out.Header.Set(federatedHeader, "1")
+			},
+			ModifyResponse: func(response *http.Response) error {
+				response.Header.Del("Set-Cookie")
+				return nil
+			},
+			ErrorHandler: func(writer http.ResponseWriter, _ *http.Request, err error) {
+				log.Printf("Failed to proxy paste from %s - %s\n", peerURL, err.Error())
+				http.Error(writer, "Failed to fetch paste from federated peer", http.StatusBadGateway)
+			},
+			Transport: federationClient.Transport,
+		}
+		proxy.ServeHTTP(writer, request)
+		return true
+	}
+
+	// Redirect without any secrets, marked so the peer doesn't fall back in turn.
+	// See Other so posted key forms aren't sent on, clients re-enter keys there
+	query := request.URL.Query()
+	for name := range secretHeaders {
+		query.Del(name)
+	}
+	query.Set(federatedQuery, "1")
+	location := peerURL + request.URL.Path + "?" + query.Encode()
+	http.Redirect(writer, request, location, http.StatusSeeOther)
+	return true
+}
+
+func headPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("HEAD", pastePrefix+cidStr, request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Only answers for pastes stored here, never asking peers
+	has, err := storageBackend.Has(request.Context(), c)
+	if err != nil || !has {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	federationPrefix = "/federation"
+
+	// Maximum stored peers, announcements past this are refused
+	maxFederationPeers = 1000
+
+	// Maximum peers verified from each peer's list per announce round
+	maxFederationDiscover = 50
+
+	// Maximum peers asked whether they mirror a CID, and how long they get to answer
+	maxFederationMirrorChecks = 64
+	federationMirrorTimeout   = 5 * time.Second
+
+	// Maximum federation response size read from peers
+	maxFederationResponseSize = 1048576
+
+	// Delay before first announcing, peers fetch our details back so the server must be up
+	federationStartDelay = 10 * time.Second
+
+	// Minimum time between accepted announcements from the same peer
+	federationMinAnnounceInterval = time.Minute
+)
+
+var (
+	// Whether this instance takes part in the federation directory
+	federationEnabled bool
+
+	// Instance name and policy advertised to peers
+	federationName   string
+	federationPolicy string
+
+	// Advertised storage capacity (in bytes, 0 is unadvertised)
+	federationCapacity uint64
+
+	// Peer base URLs announced to
+	federationPeers []string
+
+	// Period between announcements, peers not heard from in three are dropped
+	federationAnnouncePeriod time.Duration
+
+	// HTTP client used for federation requests
+	federationClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+type federationInfo struct {
+	URL      string `json:"url"`
+	Name     string `json:"name"`
+	Policy   string `json:"policy,omitempty"`
+	Capacity uint64 `json:"capacity,omitempty"`
+	Used     uint64 `json:"used,omitempty"`
+	Replica  bool   `json:"replica"`
+	Key      string `json:"key,omitempty"`
+}
+
+type federationPeer struct {
+	federationInfo
+	Seen time.Time `json:"seen"`
+}
+
+type federationAnnouncement struct {
+	URL string `json:"url"`
+}
+
+func parseFederationURL(str string) (string, error) {
+	// Instances are named by HTTPS origin only
+	u, err := url.Parse(strings.TrimSpace(str))
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("Invalid federation URL: " + str)
+	}
+	return "https://" + strings.ToLower(u.Host), nil
+}
+
+func parseFederationPeers(str string) ([]string, error) {
+	peers := []string{}
+	for _, peerStr := range strings.Split(str, ",") {
+		if strings.TrimSpace(peerStr) == "" {
+			continue
+		}
+		peer, err := parseFederationURL(peerStr)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+func federationPeerAllowed(peerURL string) bool {
+	for _, allowed := range federationPeers {
+		if allowed == peerURL {
+			return true
+		}
+	}
+	return false
+}
+
+func federationSelfURL() string {
+	return "https://" + strings.ToLower(instanceHostname)
+}
+
+func federationSelf() *federationInfo {
+	info := &federationInfo{
+		URL:      federationSelfURL(),
+		Name:     federationName,
+		Policy:   federationPolicy,
+		Capacity: federationCapacity,
+		Replica:  isReplica(),
+	}
+
+	// Usage is only meaningful alongside capacity
+	if federationCapacity > 0 {
+		used, err := storageUsage()
+		if err != nil {
+			log.Printf("Failed to get storage usage - %s\n", err.Error())
+		}
+		info.Used = used
+	}
+
+	// Key lets clients check signed pastes and receipts came from this instance
+	if serverSigningKey != nil {
+		info.Key = encodeSigningKey(serverSigningKey.Public().(ed25519.PublicKey))
+	}
+	return info
+}
+
+func federationPeerKey(peerURL string) ds.Key {
+	return indexKey("federation", strings.TrimPrefix(peerURL, "https://"))
+}
+
+func federationGet(peerURL, path string, v interface{}) error {
+	// Get JSON from peer, bounded in size
+	request, err := http.NewRequestWithContext(globalContext, "GET", peerURL+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := federationClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New("Federation peer responded with: " + response.Status)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, maxFederationResponseSize)).Decode(v)
+}
+
+func verifyFederationPeer(peerURL string) (*federationPeer, error) {
+	// Ask the URL itself, so only an instance serving it can register it
+	info := &federationInfo{}
+	err := federationGet(peerURL, federationPrefix+"/self", info)
+	if err != nil {
+		return nil, err
+	}
+	normalized, err := parseFederationURL(info.URL)
+	if err != nil {
+		return nil, err
+	} else if normalized != peerURL {
+		return nil, errors.New("Federation peer " + peerURL + " names itself " + info.URL)
+	}
+
+	// Keep advertised strings short, they're shown to clients
+	if len(info.Name) > 64 {
+		info.Name = info.Name[:64]
+	}
+	if len(info.Policy) > 256 {
+		info.Policy = info.Policy[:256]
+	}
+
+	return &federationPeer{federationInfo: *info, Seen: time.Now()}, nil
+}
+
+func putFederationPeer(peer *federationPeer) error {
+	// Refuse new peers once full, known ones are still refreshed
+	key := federationPeerKey(peer.URL)
+	known, err := indexStore.Has(key)
+	if err != nil {
+		return err
+	} else if !known {
+		names, err := indexList("federation")
+		if err != nil {
+			return err
+		} else if len(names) >= maxFederationPeers {
+			return errors.New("Federation peer limit reached")
+		}
+	}
+	return indexPut(key, peer)
+}
+
+func listFederationPeers() ([]*federationPeer, error) {
+	names, err := indexList("federation")
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect peers heard from recently, dropping the rest
+	peers := []*federationPeer{}
+	for _, name := range names {
+		peer := &federationPeer{}
+		key := indexKey("federation", name)
+		err = indexGet(key, peer)
+		if err == ds.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if time.Since(peer.Seen) > 3*federationAnnouncePeriod {
+			err = indexDelete(key)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+func announceToPeer(peerURL string) error {
+	// Announce our URL, the peer fetches our details back from it
+	body, err := json.Marshal(&federationAnnouncement{URL: federationSelfURL()})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(globalContext, "POST", peerURL+federationPrefix+"/announce", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("content-type", "application/json")
+
+	// Signed, so peers trusting our key accept it
+	err = signHTTPRequest(request, body)
+	if err != nil {
+		return err
+	}
+	response, err := federationClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		return errors.New("Federation peer responded with: " + response.Status)
+	}
+	return nil
+}
+
+func exchangePeers(peerURL string) error {
+	// Record the peer itself
+	peer, err := verifyFederationPeer(peerURL)
+	if err != nil {
+		return err
+	}
+	err = putFederationPeer(peer)
+	if err != nil {
+		return err
+	}
+
+	// Learn (or refresh) the peers it knows, verifying each before listing it
+	theirs := []*federationPeer{}
+	err = federationGet(peerURL, federationPrefix+"/peers", &theirs)
+	if err != nil {
+		return err
+	}
+	verified := 0
+	for _, their := range theirs {
+		theirURL, err := parseFederationURL(their.URL)
+		if err != nil || theirURL == federationSelfURL() || theirURL == peerURL {
+			continue
+		} else if verified >= maxFederationDiscover {
+			break
+		}
+		verified++
+
+		peer, err := verifyFederationPeer(theirURL)
+		if err != nil {
+			log.Printf("Failed to verify federation peer %s - %s\n", theirURL, err.Error())
+			continue
+		}
+		err = putFederationPeer(peer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startFederation() {
+	log.Printf("Announcing to %d federation peers every %s\n", len(federationPeers), federationAnnouncePeriod)
+	go func() {
+		select {
+		case <-globalContext.Done():
+			return
+		case <-time.After(federationStartDelay):
+		}
+
+		for {
+			for _, peerURL := range federationPeers {
+				err := announceToPeer(peerURL)
+				if err != nil {
+					log.Printf("Failed to announce to federation peer %s - %s\n", peerURL, err.Error())
+				}
+				err = exchangePeers(peerURL)
+				if err != nil {
+					log.Printf("Failed to exchange peers with %s - %s\n", peerURL, err.Error())
+				}
+			}
+
+			select {
+			case <-globalContext.Done():
+				return
+			case <-time.After(federationAnnouncePeriod):
+			}
+		}
+	}()
+}
+
+func federationSelfHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", federationPrefix+"/self", request.RemoteAddr)
+
+	writeJSON(writer, federationSelf())
+}
+
+func federationAnnounceHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", federationPrefix+"/announce", request.RemoteAddr)
+
+	// Read the announced URL
+	b, err := ioutil.ReadAll(io.LimitReader(request.Body, maxFederationResponseSize))
+	if err != nil {
+		http.Error(writer, "Invalid announcement!", http.StatusBadRequest)
+		return
+	}
+	announcement := &federationAnnouncement{}
+	err = json.Unmarshal(b, announcement)
+	if err != nil {
+		http.Error(writer, "Invalid announcement!", http.StatusBadRequest)
+		return
+	}
+	peerURL, err := parseFederationURL(announcement.URL)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	} else if peerURL == federationSelfURL() {
+		http.Error(writer, "Can't announce this instance to itself!", http.StatusBadRequest)
+		return
+	}
+
+	// Only peers the operator configured, or signed by a trusted peer key, may announce
+	if !federationPeerAllowed(peerURL) && (len(syncPeerKeys) == 0 || verifyHTTPRequest(request, b, syncPeerKeys) != nil) {
+		http.Error(writer, "Announcement not from a trusted peer!", http.StatusForbidden)
+		return
+	}
+
+	// Peers seen recently aren't fetched from again, so announces can't drive requests
+	known := &federationPeer{}
+	err = indexGet(federationPeerKey(peerURL), known)
+	if err == nil && time.Since(known.Seen) < federationMinAnnounceInterval {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	} else if err != nil && err != ds.ErrNotFound {
+		log.Printf("Failed to get federation peer - %s\n", err.Error())
+		http.Error(writer, "Failed to get federation peer", http.StatusInternalServerError)
+		return
+	}
+
+	// Verify by fetching the peer's details from its own URL
+	peer, err := verifyFederationPeer(peerURL)
+	if err != nil {
+		log.Printf("Failed to verify federation peer %s - %s\n", peerURL, err.Error())
+		http.Error(writer, "Failed to verify announced instance!", http.StatusBadGateway)
+		return
+	}
+	err = putFederationPeer(peer)
+	if err != nil {
+		log.Printf("Failed to put federation peer - %s\n", err.Error())
+		http.Error(writer, "Failed to record announced instance", http.StatusServiceUnavailable)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func federationHasHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", federationPrefix+"/has/"+cidStr, request.RemoteAddr)
+
+	// Only report pastes stored here that may be served elsewhere
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+	has, err := storageBackend.Has(request.Context(), c)
+	if err != nil || !has || !isReplicable(c) {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func peerMirrors(ctx context.Context, peerURL string, c cid.Cid) bool {
+	request, err := http.NewRequestWithContext(ctx, "GET", peerURL+federationPrefix+"/has/"+c.String(), nil)
+	if err != nil {
+		return false
+	}
+	response, err := federationClient.Do(request)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode == http.StatusNoContent
+}
+
+func federationPeersHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", federationPrefix+"/peers", request.RemoteAddr)
+
+	// Get peers heard from recently
+	peers, err := listFederationPeers()
+	if err != nil {
+		log.Printf("Failed to list federation peers - %s\n", err.Error())
+		http.Error(writer, "Failed to list federation peers", http.StatusInternalServerError)
+		return
+	}
+
+	// Filter to peers mirroring a CID if requested
+	if cidStr := request.URL.Query().Get("cid"); cidStr != "" {
+		c, err := cid.Decode(cidStr)
+		if err != nil {
+			http.Error(writer, "Invalid CID!", http.StatusBadRequest)
+			return
+		}
+		if len(peers) > maxFederationMirrorChecks {
+			peers = peers[:maxFederationMirrorChecks]
+		}
+
+		// Ask every peer at once, each answers for itself
+		ctx, cancel := context.WithTimeout(request.Context(), federationMirrorTimeout)
+		defer cancel()
+		mirrors := make([]bool, len(peers))
+		wg := sync.WaitGroup{}
+		for i, peer := range peers {
+			wg.Add(1)
+			go func(i int, peer *federationPeer) {
+				defer wg.Done()
+				mirrors[i] = peerMirrors(ctx, peer.URL, c)
+			}(i, peer)
+		}
+		wg.Wait()
+
+		mirroring := []*federationPeer{}
+		for i, peer := range peers {
+			if mirrors[i] {
+				mirroring = append(mirroring, peer)
+			}
+		}
+		peers = mirroring
+	}
+
+	writeJSON(writer, peers)
+}
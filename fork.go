@@ -0,0 +1,214 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+func forkPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("POST", pastePrefix+cidStr+"/fork", request.RemoteAddr)
+
+	// Decode the parent paste CID, DAG nodes resolving to their paste
+	parent, err := cid.Decode(cidStr)
+	if err == nil {
+		parent, err = resolvePasteCID(request.Context(), parent)
+	}
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, parent) {
+		return
+	}
+
+	// Refuse view-limited and PAKE pastes, a fork would be an ungated copy read without spending a view
+	gated, err := isGatedPaste(parent)
+	if err != nil {
+		log.Printf("Failed to check paste access limits - %s\n", err.Error())
+		http.Error(writer, "Failed to check paste access limits", http.StatusInternalServerError)
+		return
+	} else if gated {
+		http.Error(writer, "View-limited and PAKE pastes can't be forked!", http.StatusForbidden)
+		return
+	}
+
+	// Try look for parent paste with CID
+	p, err := getPaste(parent)
+	if err != nil {
+		log.Printf("Paste not retrieved - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Get decryption key or identity, and new key, if supplied
+	key, err := decryptionKey(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	newKey, err := requestSecret(request, "new_key")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Never attempt server-side decryption or re-encryption of E2E pastes
+	if (key != "" || newKey != "") && p.sealing == pasteSealingE2E {
+		http.Error(writer, "E2E paste, decrypt client-side!", http.StatusBadRequest)
+		return
+	}
+
+	// If decryption key or identity supplied, try decrypt (throttled against guessing)
+	if key != "" {
+		if !checkDecryptThrottle(writer, request, parent) {
+			return
+		}
+		binding, err := getPasteBinding(parent)
+		if err != nil {
+			log.Printf("Failed to get paste binding - %s\n", err.Error())
+			http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+			return
+		}
+		started := time.Now()
+		err = p.decrypt(key, binding)
+		if err != nil {
+			log.Printf("Failed to decrypt paste - %s\n", err.Error())
+			recordDecryptFailure(request, parent)
+			writeDecryptFailure(writer, request, started, "")
+			return
+		}
+	}
+
+	// Decompress paste if no longer encrypted
+	if !p.encrypted {
+		err = p.decompress()
+		if err == errPasteExpired {
+			http.Error(writer, "Paste has expired!", http.StatusGone)
+			return
+		} else if err != nil {
+			log.Printf("Failed to decompress paste - %s\n", err.Error())
+			http.Error(writer, "Paste decompression failed!", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Read optional replacement content from body
+	request.Body = http.MaxBytesReader(writer, request.Body, maxPasteSize)
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Println("Failed to read request body")
+		http.Error(writer, "Failed to read request", http.StatusInternalServerError)
+		return
+	}
+	if len(b) > 0 {
+		p = &paste{text: b}
+	}
+
+	// Compress paste (no-op if still encrypted)
+	if !p.encrypted {
+		err = p.compress()
+		if err != nil {
+			log.Printf("Failed to compress paste - %s\n", err.Error())
+			http.Error(writer, "Paste compression failed!", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// If new encryption key supplied, try encrypt (keeping any sealed metadata sealed)
+	if newKey != "" {
+		if p.sealedMeta != nil {
+			err = p.sealMeta(p.sealedMeta)
+			if err != nil {
+				log.Printf("Failed to seal paste metadata - %s\n", err.Error())
+				http.Error(writer, "Paste metadata sealing failed!", http.StatusInternalServerError)
+				return
+			}
+		}
+		err = p.encrypt(newKey, nil)
+		if err != nil {
+			log.Printf("Failed to encrypt paste - %s\n", err.Error())
+			http.Error(writer, "Paste encryption failed!", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	opts, err := pastePutOptions(request)
+	if err != nil {
+		http.Error(writer, "Unsupported hash!", http.StatusBadRequest)
+		return
+	}
+
+	// Place the forked paste into the IPFS store
+	c, duplicate, err := putPaste(request.Context(), p, opts)
+	if err != nil {
+		log.Printf("Failed to put paste in store - %s\n", err.Error())
+		http.Error(writer, "Failed to put paste in store", http.StatusInternalServerError)
+		return
+	}
+
+	// Record the parent link in metadata (unchanged or existing content keeps its own)
+	if !c.Equals(parent) && !duplicate {
+		err = updatePasteMeta(c, func(meta *pasteMeta) { meta.Parent = parent.String() })
+		if err != nil {
+			log.Printf("Failed to put paste metadata - %s\n", err.Error())
+			http.Error(writer, "Failed to put paste metadata", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Run create hook for new pastes
+	if !duplicate {
+		runHook(hookOnCreate, c)
+	}
+
+	// Link to the parent's DAG node as the previous version if enabled
+	dag := ""
+	if !c.Equals(parent) {
+		dag = createPasteNode(request.Context(), c, parent)
+	}
+
+	// Move the editor's IPNS name to the edited paste if supplied
+	ipns, err := republishEditedPaste(request, c)
+	if err == errInvalidName || err == errQueryKey {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	} else if err == errNameToken || err == ds.ErrNotFound {
+		http.Error(writer, "Unauthorized!", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Printf("Failed to republish paste name - %s\n", err.Error())
+		http.Error(writer, "Failed to republish paste name", http.StatusInternalServerError)
+		return
+	}
+
+	// Allocate short ID for sharing
+	short, err := shortIDForPaste(c)
+	if err != nil {
+		log.Printf("Failed to allocate short paste ID - %s\n", err.Error())
+		http.Error(writer, "Failed to allocate short paste ID", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the store path in response
+	writePutResponse(writer, request, &putResponse{
+		Path:      pastePrefix + c.String(),
+		CID:       c.String(),
+		Short:     shortPrefix + short,
+		Duplicate: duplicate,
+		IPNS:      ipns,
+		DAG:       dag,
+	})
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+func freeSpace(path string) (uint64, error) {
+	// Space available to unprivileged users, as the repo isn't written as root
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
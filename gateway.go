@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// How long CIDs no gateway had are remembered, so they don't fan out every time
+	gatewayMissTTL = 10 * time.Minute
+
+	// Maximum remembered gateway misses
+	maxGatewayMisses = 10000
+)
+
+var (
+	// Public IPFS gateway base URLs unknown pastes are fetched from (disabled if empty)
+	fetchGateways []string
+
+	// Deadline for fetching a paste block across all gateways
+	gatewayTimeout time.Duration
+
+	// Maximum paste block size fetched from gateways (in bytes)
+	gatewayMaxSize int64
+
+	// HTTP client used for gateway fetches
+	gatewayClient = &http.Client{}
+
+	// Expiry of each remembered gateway miss by CID string, guarded by mutex
+	gatewayMisses      = map[string]time.Time{}
+	gatewayMissesMutex sync.Mutex
+
+	// Returned for gateway responses that aren't the requested block
+	errGatewayBlock = errors.New("Gateway returned wrong block")
+)
+
+func gatewaysEnabled() bool {
+	return len(fetchGateways) > 0
+}
+
+func parseGateways(gateways string) []string {
+	urls := []string{}
+	for _, gateway := range strings.Split(gateways, ",") {
+		gateway = strings.TrimSuffix(strings.TrimSpace(gateway), "/")
+		if gateway != "" {
+			urls = append(urls, gateway)
+		}
+	}
+	return urls
+}
+
+func gatewayMissed(c cid.Cid) bool {
+	gatewayMissesMutex.Lock()
+	defer gatewayMissesMutex.Unlock()
+	expires, ok := gatewayMisses[c.String()]
+	return ok && time.Now().Before(expires)
+}
+
+func recordGatewayMiss(c cid.Cid) {
+	gatewayMissesMutex.Lock()
+	defer gatewayMissesMutex.Unlock()
+
+	// Drop expired misses when full, then any if still full
+	now := time.Now()
+	if len(gatewayMisses) >= maxGatewayMisses {
+		for key, expires := range gatewayMisses {
+			if now.After(expires) {
+				delete(gatewayMisses, key)
+			}
+		}
+		for key := range gatewayMisses {
+			if len(gatewayMisses) < maxGatewayMisses {
+				break
+			}
+			delete(gatewayMisses, key)
+		}
+	}
+	gatewayMisses[c.String()] = now.Add(gatewayMissTTL)
+}
+
+func fetchGatewayBlock(ctx context.Context, gateway string, c cid.Cid) ([]byte, error) {
+	// Ask for the raw block, pastes aren't UnixFS so must not be decoded by the gateway
+	request, err := http.NewRequestWithContext(ctx, "GET", gateway+"/ipfs/"+c.String()+"?format=raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/vnd.ipld.raw")
+	response, err := gatewayClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("Gateway responded with: " + response.Status)
+	}
+
+	// Read no more than allowed, anything larger is refused outright
+	b, err := ioutil.ReadAll(io.LimitReader(response.Body, gatewayMaxSize+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(b)) > gatewayMaxSize {
+		return nil, errors.New("Gateway block too large")
+	}
+
+	// Gateways are untrusted, the block must hash to the CID
+	chk, err := c.Prefix().Sum(b)
+	if err != nil {
+		return nil, err
+	} else if !chk.Equals(c) {
+		return nil, errGatewayBlock
+	}
+	return b, nil
+}
+
+func cacheFromGateways(ctx context.Context, c cid.Cid) bool {
+	// Skip if disabled, not a paste, or recently missed
+	if !gatewaysEnabled() || !isPasteCID(c) || gatewayMissed(c) {
+		return false
+	}
+
+	// Only for pastes we really don't have
+	has, err := storageBackend.Has(ctx, c)
+	if err != nil || has {
+		return false
+	}
+
+	// Try each gateway in order of preference, within one deadline
+	ctx, cancel := context.WithTimeout(ctx, gatewayTimeout)
+	defer cancel()
+	for _, gateway := range fetchGateways {
+		b, err := fetchGatewayBlock(ctx, gateway, c)
+		if err != nil {
+			log.Printf("Failed to fetch paste %s from gateway %s - %s\n", c.String(), gateway, err.Error())
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		// Cache locally, unpinned so repo GC may drop it again
+		err = storageBackend.Put(globalContext, c, b, PutOptions{})
+		if err != nil {
+			log.Printf("Failed to cache gateway paste %s - %s\n", c.String(), err.Error())
+			return false
+		}
+		log.Printf("Cached paste %s from gateway %s\n", c.String(), gateway)
+		return true
+	}
+
+	recordGatewayMiss(c)
+	return false
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/ipfs/go-ipfs/core/corerepo"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	// Period between scheduled repo GC checks (disabled if zero)
+	repoGCPeriod time.Duration
+
+	// Free space (in bytes) below which scheduled GC runs, always runs if zero
+	repoGCMinFree uint64
+
+	// Set while a GC runs, only one runs at a time
+	repoGCRunning int32
+
+	// Returned when a GC is requested while one is already running
+	errGCRunning = errors.New("GC already running")
+
+	// Returned when a GC is requested without pinned uploads, it would collect every paste
+	errGCUnpinned = errors.New("repo GC requires -pin-pastes, it collects every unpinned paste")
+)
+
+type gcShardResult struct {
+	Repo      string `json:"repo"`
+	Reclaimed uint64 `json:"reclaimed"`
+}
+
+type gcResult struct {
+	Expired   int              `json:"expired"`
+	Reclaimed uint64           `json:"reclaimed"`
+	Skipped   bool             `json:"skipped,omitempty"`
+	Shards    []*gcShardResult `json:"shards"`
+}
+
+func expirePastes() (int, error) {
+	// Check every paste with metadata for a passed expiry
+	cids, err := indexList("meta")
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, cidStr := range cids {
+		// Skip unexpired pastes, and those already expired by an earlier run
+		meta, err := getPasteMetaStr(cidStr)
+		if err != nil || !meta.expired() {
+			continue
+		}
+		has, err := indexStore.Has(indexKey("expired", cidStr))
+		if err != nil || has {
+			continue
+		}
+		c, err := cid.Decode(cidStr)
+		if err != nil {
+			continue
+		}
+
+		// Unpin so GC collects it even if pinned, reads are already refused
+		err = unpinPaste(c)
+		if err != nil {
+			log.Printf("Failed to unpin expired paste %s - %s\n", cidStr, err.Error())
+		}
+		err = unmirrorPaste(c)
+		if err != nil {
+			log.Printf("Failed to unmirror expired paste %s - %s\n", cidStr, err.Error())
+		}
+		err = indexStore.Put(indexKey("expired", cidStr), []byte{})
+		if err != nil {
+			return expired, err
+		}
+		runHook(hookOnExpire, c)
+		expired++
+	}
+
+	return expired, nil
+}
+
+func lowestFreeSpace() (uint64, error) {
+	// Shards may sit on different disks, the fullest decides
+	var lowest uint64
+	for i, shard := range ipfsShards {
+		free, err := freeSpace(shard.repoPath)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || free < lowest {
+			lowest = free
+		}
+	}
+	return lowest, nil
+}
+
+func runRepoGC(force bool) (*gcResult, error) {
+	// Only pinned blocks survive GC
+	if !pinPastes {
+		return nil, errGCUnpinned
+	}
+	if !atomic.CompareAndSwapInt32(&repoGCRunning, 0, 1) {
+		return nil, errGCRunning
+	}
+	defer atomic.StoreInt32(&repoGCRunning, 0)
+
+	// Expired pastes are unpinned first so this run collects them
+	result := &gcResult{Shards: []*gcShardResult{}}
+	expired, err := expirePastes()
+	if err != nil {
+		return nil, err
+	}
+	result.Expired = expired
+
+	// Scheduled runs only collect when short of space
+	if !force && repoGCMinFree > 0 {
+		free, err := lowestFreeSpace()
+		if err != nil {
+			return nil, err
+		} else if free >= repoGCMinFree {
+			result.Skipped = true
+			return result, nil
+		}
+	}
+
+	// Collect each shard, pinned blocks are kept
+	for _, shard := range ipfsShards {
+		before, err := shard.node.Repo.GetStorageUsage()
+		if err != nil {
+			return nil, err
+		}
+		err = corerepo.GarbageCollect(shard.node, globalContext)
+		if err != nil {
+			return nil, err
+		}
+		after, err := shard.node.Repo.GetStorageUsage()
+		if err != nil {
+			return nil, err
+		}
+		shardResult := &gcShardResult{Repo: shard.repoPath}
+		if before > after {
+			shardResult.Reclaimed = before - after
+		}
+		result.Shards = append(result.Shards, shardResult)
+		result.Reclaimed += shardResult.Reclaimed
+	}
+
+	log.Printf("Repo GC expired %d pastes, reclaimed %d bytes\n", result.Expired, result.Reclaimed)
+	return result, nil
+}
+
+func startRepoGC() {
+	log.Println("Starting scheduled repo GC...")
+	go func() {
+		for {
+			select {
+			case <-time.After(repoGCPeriod):
+			case <-globalContext.Done():
+				return
+			}
+
+			// Hold the nodes like a request, so a restart waits for the GC
+			ipfsGateMutex.RLock()
+			_, err := runRepoGC(false)
+			ipfsGateMutex.RUnlock()
+			if err != nil && err != errGCRunning {
+				log.Printf("Scheduled repo GC failed - %s\n", err.Error())
+			}
+		}
+	}()
+}
+
+func repoGCHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", apiPrefix+"gc", request.RemoteAddr)
+
+	// Run now, regardless of free space
+	result, err := runRepoGC(true)
+	if err == errGCRunning {
+		http.Error(writer, "GC already running!", http.StatusConflict)
+		return
+	} else if err == errGCUnpinned {
+		http.Error(writer, "GC requires -pin-pastes!", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Failed to run repo GC - %s\n", err.Error())
+		http.Error(writer, "Failed to run repo GC", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(writer, result)
+}
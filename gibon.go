@@ -1,15 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha256"
+	"crypto/tls"
 	"errors"
 	"flag"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -29,15 +25,16 @@ import (
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/grufwub/gibon/crypto"
+
+	cid "github.com/ipfs/go-cid"
 	config "github.com/ipfs/go-ipfs-config"
 	icore "github.com/ipfs/interface-go-ipfs-core"
-	icorepath "github.com/ipfs/interface-go-ipfs-core/path"
 )
 
 const (
 	versionStr  = "v0.1.0-beta"
 	pastePrefix = "/paste/"
-	ipfsPrefix  = "/ipld/"
 )
 
 var (
@@ -56,6 +53,171 @@ $ curl https://%s/?key=awful_password --data 'paste text goes here'
 
 $ curl https://%s/paste/<PASTE_ID>?key=awful_password
 --> 'paste text goes here'
+
+$ curl -i https://%s --data 'paste text goes here'
+--> 'X-Paste-Short: /p/<SHORT_ID>' (short path serving the same paste)
+
+$ curl https://%s/?recipients=age1...,age1... --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (encrypted to X25519 public keys, e.g. from age-keygen)
+
+$ curl https://%s/paste/<PASTE_ID>?identity=AGE-SECRET-KEY-1...
+--> 'paste text goes here'
+
+$ curl -H 'Authorization: Bearer <TOKEN>' https://%s/paste/<PASTE_ID>
+--> 'paste text goes here' (pastes encrypted to the instance recipient from /age-recipient, when the server holds age identities)
+
+$ curl https://%s/?pgp_keys=<KEY_PASTE_ID> --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (encrypted server-side to the armored PGP public key(s) in the given pastes)
+
+$ curl https://%s/paste/<PASTE_ID>?format=pgp
+--> '-----BEGIN PGP MESSAGE-----...' (served as application/pgp-encrypted, armored pastes are detected on upload)
+
+$ curl https://%s/?key=awful_password&hint=usual+one --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (unencrypted hint returned in X-Paste-Hint when fetched without a key or decryption fails, unless the instance reports failed decryptions as 404)
+
+$ curl https://%s/?key=team_secret&convergent=1 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (deterministic encryption, the same text and key always give the same ID; reveals equal pastes, so only for deduplicating shared configs)
+
+$ curl https://%s/?genkey=1 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' followed by 'key: <KEY>' (random key generated by the server, never stored so keep it safe)
+
+$ curl https://%s/?genkey=1&qr=1 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' followed by QR codes for the short link and, separately, the key (scan both on a phone to decrypt there)
+
+$ curl https://%s/paste/<PASTE_ID> -H 'X-Gibon-Key: awful_password'
+--> 'paste text goes here' (also X-Gibon-New-Key / X-Gibon-Identity, or POST key=... as a form, keeps keys out of URLs)
+
+$ curl https://%s/?e2e=1 --data-binary @encrypted.bin
+--> '/paste/<PASTE_ID>' (client-side encrypted blob, see crypto/e2e.go for the format, served with X-Paste-E2E: 1 and never decrypted by the server)
+
+$ curl https://%s/paste/<PASTE_ID>/raw
+--> stored paste envelope bytes (browsers opening /paste/<PASTE_ID>#<KEY> decrypt E2E and SHA-256 keyed pastes locally, the key never reaches the server)
+
+$ echo 'paste text goes here' | curl -F 'sprunge=<-' https://%s
+--> 'https://%s/p/<SHORT_ID>' (sprunge / ix.io 'f:1=<-' style form uploads also accepted, urlencoded forms at /compat)
+
+$ curl https://%s/?key=secret&pake=1 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (only released through a SPAKE2 handshake, so the key never crosses the wire again)
+
+$ curl https://%s/paste/<PASTE_ID>/pake
+--> '{"params":"..."}' (Argon2id params to derive the password scalar, then POST {"message"} here and {"session","confirm"} to /pake/finish)
+
+$ curl https://%s/?key=secret&expires=24h --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (expiry sealed inside the encryption, the paste never decrypts after it even if the block is kept; given in Expires and X-Paste-Expires-At headers)
+
+$ curl https://%s/?nopin=1 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (left unpinned, locally and on any ipfs-cluster, on instances pinning uploads by default)
+
+$ curl https://%s/?hash=blake2b-256 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (CIDv1 under the chosen multihash: sha2-256, sha2-512, sha3-256, sha3-512 or blake2b-256)
+
+$ curl https://%s/?max_views=5 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (returns 410 Gone after 5 views)
+
+$ curl https://%s/paste/<PASTE_ID>/fork?key=old_password&new_key=new_password --data 'edited text'
+--> '/paste/<NEW_PASTE_ID>' (body optional, records parent paste)
+
+$ curl https://%s/api/ipns --data '{"paste":"<PASTE_ID>"}'
+--> '{"path":"/name/<IPNS_NAME>","token":"..."}' (stable URL, re-pointed with {"paste","name"} and X-Gibon-IPNS-Token, or on fork with ?ipns=<IPNS_NAME>)
+
+$ curl https://%s/paste/<PASTE_ID>/rekey?tombstone=1 -H 'X-Gibon-Key: leaked_password' -H 'X-Gibon-New-Key: new_password' -X POST
+--> '/paste/<NEW_PASTE_ID>' (re-encrypted with the new key, tombstone=1 makes the old ID return 410 Gone)
+
+$ curl https://%s/?visibility=public&tags=go,tls --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (default visibility is unlisted, public and tags only allowed on unencrypted pastes)
+
+$ curl https://%s/tags/go
+--> '/paste/<PASTE_ID>	<TITLE>' (public pastes only, one per line)
+
+$ curl https://%s/recent
+--> '/paste/<PASTE_ID>	<TITLE>' (most recent public pastes, one per line)
+
+$ curl https://%s/dnslink
+--> 'dnslink=/ipfs/<INDEX_CID>' (if enabled, CBOR index of public pastes also resolvable as /ipns/<DOMAIN> from any IPFS node)
+
+$ curl https://%s/archive?after=<UNIX_TIME_OR_CURSOR>&limit=100
+--> '{"pastes":[...],"next":"<CURSOR>","more":true}' (public paste metadata oldest first, strictly rate limited per client with X-Quota-Remaining, follow Link rel=next)
+
+$ curl https://%s/paste/<PASTE_ID>/related
+--> '/paste/<PASTE_ID>	<TITLE>' (similar public pastes, one per line)
+
+$ curl https://%s/paste/<PASTE_ID>/convert?to=pdf
+--> '%PDF-1.4...' (to: html, pdf, txt; from: markdown, ansi, csv, ipynb, diff, text, default guessed from lang hint or content; unencrypted pastes only)
+
+$ curl https://%s/paste/<PASTE_ID>?columns=name,3
+--> 'name,total...' (CSV/TSV pastes only, columns by header name or 1-based index, sortable HTML table at /paste/<PASTE_ID>/table)
+
+$ curl https://%s/paste/<PASTE_ID>/icon.svg
+--> '<svg ...' (identicon derived from the paste ID, compare with the sender's to check you have the same content)
+
+$ curl https://%s/?sign=1 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' (signed with the server's Ed25519 key from /signing-key, or supply your own with X-Gibon-Signer / X-Gibon-Signature, base64url, signing "gibon-paste-sig-v1\x00" followed by the content)
+
+$ curl https://%s/?receipt=1 --data 'paste text goes here'
+--> '/paste/<PASTE_ID>' followed by 'receipt: <JWS>' (EdDSA signed CID, size and time; verify offline with /.well-known/jwks.json, or POST it to /receipt/verify)
+
+$ curl https://%s/paste/<PASTE_ID>/verify
+--> 'valid signature from <SIGNER>' (encrypted pastes need the key, signatures cover the content as uploaded)
+
+$ curl -i https://%s/paste/<PASTE_ID>
+--> 'X-Paste-Integrity: valid' (none, valid or unverified; unencrypted pastes carry a server HMAC when enabled, tampered pastes return 502)
+
+$ dig +short TXT 0.<PASTE_ID_BASE32>.<DNS_ZONE>
+--> '"<BASE64_CHUNK>"' (experimental DNS egress if enabled, CIDv1 base32 ID from 'ipfs cid base32', '<PASTE_ID_BASE32>.<DNS_ZONE>' gives the chunk count, small unencrypted pastes only)
+
+$ curl -c cookies https://%s/prefs -H 'Accept: application/json' -d theme=dark -d font_size=14 -d wrap=1
+--> '{"theme":"dark","wrap":true,"font_size":14}' (web UI preferences kept in a signed HttpOnly cookie, GET /prefs reads them back)
+
+$ curl https://%s/paste/<PASTE_ID>/stats
+--> '{"views":1,"last_access":"..."}'
+
+$ curl https://%s/stats/content
+--> '{"pastes":10,"encrypted":2,"plaintext":8,"public_pastes":5,"languages":{"go":3,...},"size_histogram":{"<1KiB":4,...}}'
+
+$ curl https://%s/paste/<PASTE_ID>/comments?author=me --data 'looks good to me'
+--> '<COMMENT_ID>'
+
+$ curl https://%s/paste/<PASTE_ID>/comments
+--> '[{"cid":"<COMMENT_ID>","author":"me","text":"looks good to me","created":...}]'
+
+$ curl https://%s/paste/<PASTE_ID>/report --data 'reason for report'
+--> 'Report received!' (blocked pastes return 451)
+
+$ curl https://%s/collection?name=configs --data-binary $'<PASTE_ID>\n<PASTE_ID>'
+--> '/collection/<COLLECTION_ID>'
+
+$ curl https://%s/collection/<COLLECTION_ID>/append --data '<PASTE_ID>'
+--> '/collection/<NEW_COLLECTION_ID>' (also /remove, collections are immutable so a new ID is returned)
+
+$ curl https://%s/collection/<COLLECTION_ID>
+--> '/paste/<PASTE_ID>	<TITLE>' (one per line)
+
+$ curl https://%s/bundle -F 'file=@main.go;filename=src/main.go' -F 'file=@README.md'
+--> '/bundle/<BUNDLE_ID>' (each file stored as its own paste, optional key encrypts all)
+
+$ curl https://%s/bundle/<BUNDLE_ID>
+--> '/paste/<PASTE_ID>	<FILE_PATH>' (one per line, files also served at /bundle/<BUNDLE_ID>/<FILE_PATH>)
+
+$ curl https://%s/site -F 'file=@index.html' -F 'file=@style.css;filename=css/style.css'
+--> '/site/<SITE_ID>/' (static site served sandboxed, needs a root index.html; admins may add ipns=<NAME>)
+
+$ curl https://%s/federation/peers?cid=<PASTE_ID>
+--> '[{"url":"https://<PEER>","name":"<NAME>","policy":"...","replica":false,"seen":"..."}]' (federated instances mirroring the paste, all known peers without cid; if enabled)
+
+$ curl -L https://%s/paste/<PASTE_ID>
+--> 'paste text goes here' (pastes not stored here are redirected to, or proxied from, a federated peer that has them; if enabled)
+
+$ curl https://%s/?lint=json --data '{"broken": json}'
+--> '/paste/<PASTE_ID>' followed by lint warnings (formats: json, yaml, toml, dotenv; add strict=1 to reject)
+
+$ curl https://%s/?lang=go --data 'package main'
+--> '/paste/<PASTE_ID>' (language hint returned in X-Paste-Language header)
+
+$ curl https://%s/?type=image/png --data-binary @screenshot.png
+--> '/paste/<PASTE_ID>' (image pastes: png, jpeg, gif, webp; served with their content type)
+
+$ curl https://%s -H 'Accept: application/json' --data 'paste text goes here'
+--> '{"path":"/paste/<PASTE_ID>","cid":"<PASTE_ID>","short":"/p/<SHORT_ID>","duplicate":false}'
 `
 
 	// Store global context and cancel for global error exit function
@@ -73,126 +235,219 @@ $ curl https://%s/paste/<PASTE_ID>?key=awful_password
 )
 
 type paste struct {
-	text []byte
+	text       []byte
+	encrypted  bool
+	codec      byte
+	sealing    byte
+	signer     []byte
+	signature  []byte
+	mac        []byte
+	integrity  string
+	metaSealed bool
+	sealedMeta *pasteMeta
 }
 
-func (p *paste) encrypt(key string) error {
-	// Get new GCM wrapped AES block cipher for key
-	gcmBlockCipher, err := newAESGCMBlockCiperForKey(key)
+func (p *paste) encrypt(key string, binding *pasteBinding) error {
+	// Derive key with configured KDF using new random salt
+	params, err := newPassphraseParams()
 	if err != nil {
 		return err
 	}
 
-	// Create nonce of requested length
-	nonce := make([]byte, gcmBlockCipher.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
+	// Seal with derived key, storing params to derive it again
+	return p.seal(crypto.NewPassphraseEncrypter(key, params), binding)
+}
+
+func (p *paste) decrypt(key string, binding *pasteBinding) error {
+	// Get crypto envelope for however this paste was sealed
+	env, err := p.cryptoEnvelope()
+	if err != nil {
 		return err
 	}
 
-	// Create cipher text
-	cipherText := gcmBlockCipher.Seal(
-		nil,
-		nonce,
-		p.text,
-		nil,
-	)
+	// Try decrypt using the envelope's KDF and cipher, failing if the bound metadata changed
+	text, err := env.Open(key, p.associatedData(binding))
+	if err != nil {
+		return err
+	}
 
-	// Set paste text as nonce+cipherText
-	p.text = append(nonce, cipherText...)
+	// Set new decrypted text, set not-encrypted
+	p.text = text
+	p.encrypted = false
+	p.sealing = pasteSealingLegacy
 
-	// Return all good :)
 	return nil
 }
 
-func (p *paste) decrypt(key string) error {
-	// Get new GCM wrapped AES block cipher for key
-	gcmBlockCipher, err := newAESGCMBlockCiperForKey(key)
+type pasteHandler struct {
+	ipfs icore.CoreAPI
+}
+
+func getPaste(c cid.Cid) (*paste, error) {
+	// Get new deadline context (timeout on no paste found)
+	ctx, cancel := context.WithDeadline(globalContext, time.Now().Add(unixfsGetTimeout))
+	defer cancel()
+
+	// Traverse paste DAG nodes to their body
+	c, err := resolvePasteCID(ctx, c)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Ensure paste long enough for nonce
-	if gcmBlockCipher.NonceSize() > len(p.text) {
-		return errors.New("text not long enough to contain nonce")
+	// Get paste block from the storage backend
+	b, err := storageBackend.Get(ctx, c)
+	if err != nil {
+		return nil, err
 	}
 
-	// Try decrypt using nonce and cipherText from raw paste text
-	text, err := gcmBlockCipher.Open(
-		nil,
-		p.text[:gcmBlockCipher.NonceSize()],
-		p.text[gcmBlockCipher.NonceSize():],
-		nil,
-	)
+	// Unmarshal the paste
+	p, err := unmarshalPaste(b)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Set new decrypted text, set not-encrypted
-	p.text = text
+	// Reject tampered blocks
+	err = p.checkIntegrity()
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	// Transparently open pastes sealed at rest with the master key
+	err = p.openAtRest()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
 }
 
-func newAESGCMBlockCiperForKey(key string) (cipher.AEAD, error) {
-	// Hash the supplied key
-	hash := sha256.Sum256([]byte(key))
+func pastePutOptions(request *http.Request) (PutOptions, error) {
+	// Instance defaults, which uploads may opt out of
+	opts := PutOptions{
+		Pin:  pinPastes && request.URL.Query().Get("nopin") != "1",
+		Hash: pasteHash,
+	}
 
-	// Create new AES block cipher based on key
-	blockCipher, err := aes.NewCipher(hash[:])
-	if err != nil {
-		return nil, err
+	// Uploads may choose their own hash
+	if name := request.URL.Query().Get("hash"); name != "" {
+		hash, err := parsePasteHash(name)
+		if err != nil {
+			return opts, err
+		}
+		opts.Hash = hash
 	}
 
-	// Return block cipher wrapped in GCM
-	return cipher.NewGCM(blockCipher)
+	// Replicate across the cluster too, except view-limited pastes which must only be served from here
+	if clusterEnabled() && request.URL.Query().Get("nopin") != "1" && request.URL.Query().Get("max_views") == "" {
+		opts.Replication = clusterReplication
+	}
+	return opts, nil
 }
 
-type pasteHandler struct {
-	ipfs icore.CoreAPI
+func putPaste(ctx context.Context, p *paste, opts PutOptions) (cid.Cid, bool, error) {
+	// Store, then share new pastes beyond this instance
+	c, b, duplicate, err := storePaste(ctx, p, opts)
+	if err != nil || duplicate {
+		return c, duplicate, err
+	}
+	sharePaste(ctx, c, b, opts)
+	return c, false, nil
 }
 
-func getPaste(pathStr string) (*paste, error) {
-	// Create new IPFS path from input
-	ipfsPath := icorepath.New(pathStr)
+func storePaste(ctx context.Context, p *paste, opts PutOptions) (cid.Cid, []byte, bool, error) {
+	// Seal plaintext pastes at rest with the master key if enabled (on a copy, callers keep the plaintext)
+	if sealAtRest && masterKey != nil && !p.encrypted {
+		sealed := *p
+		err := sealed.sealWithMasterKey()
+		if err != nil {
+			return cid.Undef, nil, false, err
+		}
+		p = &sealed
+	}
 
-	// Get new deadline context (timeout on no paste found)
-	ctx, cancel := context.WithDeadline(globalContext, time.Now().Add(unixfsGetTimeout))
-	defer cancel()
+	// MAC plaintext pastes if enabled, then marshal with envelope header
+	p.sealIntegrity()
+	b := p.marshal()
 
-	// Get reader for object
-	reader, err := ipfsAPI.Block().Get(ctx, ipfsPath)
+	// Compute the CID locally, under the instance default hash unless asked otherwise
+	hash := opts.Hash
+	if hash == 0 {
+		hash = pasteHash
+	}
+	c, err := newPasteCIDPrefix(hash).Sum(b)
 	if err != nil {
-		return nil, err
+		return cid.Undef, nil, false, err
 	}
 
-	// Read from the supplied reader
-	b, err := ioutil.ReadAll(io.LimitReader(reader, maxPasteSize))
+	// Skip the put if we already have this paste, unless it should now be pinned
+	has, err := storageBackend.Has(ctx, c)
 	if err != nil {
-		return nil, err
+		return cid.Undef, nil, false, err
+	} else if has && !opts.Pin {
+		return c, b, true, nil
+	}
+
+	// Put paste in the storage backend
+	err = storageBackend.Put(ctx, c, b, opts)
+	if err != nil {
+		return cid.Undef, nil, false, err
+	} else if has {
+		return c, b, true, nil
+	}
+
+	// Record content info for aggregate content stats
+	err = recordPasteContent(c, len(b), p.encrypted)
+	if err != nil {
+		return cid.Undef, nil, false, err
 	}
 
-	// Return the paste
-	return &paste{b}, nil
+	return c, b, false, nil
 }
 
-func putPaste(p *paste) (string, error) {
-	// Create new bytes reader based on Paste JSON
-	reader := bytes.NewReader(p.text)
+func sharePaste(ctx context.Context, c cid.Cid, b []byte, opts PutOptions) {
+	// Gated pastes (view-limited, PAKE) must only be served from here, so
+	// their gates are registered before this is called
+	if !isReplicable(c) {
+		return
+	}
 
-	// Put Paste JSON in IPFS storage
-	stat, err := ipfsAPI.Block().Put(globalContext, reader)
-	if err != nil {
-		return "", err
+	// Mirror into MFS if enabled (non-fatal, the paste is stored)
+	if mfsMirror {
+		err := mirrorPaste(ctx, c, b)
+		if err != nil {
+			log.Printf("Failed to mirror paste %s into MFS - %s\n", c.String(), err.Error())
+		}
+	}
+
+	// Push to peer instances if enabled
+	if replicateEnabled() {
+		queueReplicate(c)
+	}
+
+	// Announce to the DHT straight away if enabled, rather than on the next reprovide
+	if provideNew {
+		queueProvide(c)
 	}
 
-	// Return the resolved path
-	return stat.Path().String(), nil
+	// Pin across the cluster in the background, peers fetch from us
+	if opts.Replication != 0 && clusterEnabled() {
+		go clusterPin(c, opts.Replication)
+	}
 }
 
 func helpHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
 	// Log request
 	logRequest("GET", "/", request.RemoteAddr)
 
+	// Browsers get the web UI
+	if wantsHTML(request) {
+		renderPage(writer, request, "index.html", &uiPageData{
+			Version:      versionStr,
+			MaxPasteSize: maxPasteSize,
+		})
+		return
+	}
+
 	// Serve help page
 	writer.Header().Set("content-type", "text/plain")
 	writer.Write([]byte(rootHelpStr))
@@ -203,72 +458,670 @@ func getPasteHandler(writer http.ResponseWriter, request *http.Request, params h
 	cidStr := params.ByName("cid")
 
 	// Log the request
-	logRequest("GET", pastePrefix+cidStr, request.RemoteAddr)
+	logRequest(request.Method, pastePrefix+cidStr, request.RemoteAddr)
+
+	// Decode the paste CID, DAG nodes resolving to their paste
+	c, err := cid.Decode(cidStr)
+	if err == nil {
+		c, err = resolvePasteCID(request.Context(), c)
+	}
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Get decryption key or identity if supplied (may be posted as form)
+	err = parseKeyForm(writer, request)
+	if err != nil {
+		http.Error(writer, "Invalid key form!", http.StatusBadRequest)
+		return
+	}
+	key, err := decryptionKey(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Keyed requests are throttled before lookup, so missing pastes and wrong keys fail alike
+	started := time.Now()
+	if key != "" && !checkDecryptThrottle(writer, request, c) {
+		return
+	}
+
+	// Try look for paste with CID, fetching from public gateways if not stored here
+	p, err := getPaste(c)
+	if err != nil && err != errPasteIntegrity && cacheFromGateways(request.Context(), c) {
+		p, err = getPaste(c)
+	}
+	if err == errPasteIntegrity {
+		log.Printf("Paste %s failed integrity check\n", c.String())
+		http.Error(writer, "Paste failed integrity check!", http.StatusBadGateway)
+		return
+	} else if err != nil {
+		log.Printf("Paste not retrieved - %s\n", err.Error())
+		if serveFromPeer(writer, request, c) {
+			return
+		} else if key != "" {
+			writeKeyedNotFound(writer, request, c, started)
+			return
+		}
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Get paste metadata
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		log.Printf("Failed to get paste metadata - %s\n", err.Error())
+		http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+		return
+	}
+
+	// Authorized callers without a key may open pastes encrypted to the instance recipient
+	instance := key == "" && p.encrypted && p.sealing != pasteSealingE2E && isInstanceDecryptRequest(request)
+
+	// Browsers without a key get the in-browser decryption page (key read from URL fragment)
+	if key == "" && !instance && p.encrypted && request.Method == http.MethodGet && wantsHTML(request) {
+		renderDecryptPage(writer, request, c, p, meta)
+		return
+	}
+
+	// Include password hint when no key given
+	if key == "" && p.encrypted && meta.Hint != "" {
+		writer.Header().Set("X-Paste-Hint", meta.Hint)
+	}
+
+	// Never attempt server-side decryption of E2E pastes
+	if key != "" && p.sealing == pasteSealingE2E {
+		http.Error(writer, "E2E paste, decrypt client-side!", http.StatusBadRequest)
+		return
+	}
+
+	// If decryption key or identity supplied, try decrypt (throttled above)
+	if key != "" || instance {
+		var binding *pasteBinding
+		binding, err = getPasteBinding(c)
+		if err != nil {
+			log.Printf("Failed to get paste binding - %s\n", err.Error())
+			http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+			return
+		}
+		if instance {
+			err = p.decryptWithInstanceIdentities(binding)
+			if err != nil {
+				http.Error(writer, "Paste not encrypted to this instance!", http.StatusForbidden)
+				return
+			}
+		} else if err = p.decrypt(key, binding); err != nil {
+			log.Printf("Failed to decrypt paste - %s\n", err.Error())
+			recordDecryptFailure(request, c)
+			writeDecryptFailure(writer, request, started, meta.Hint)
+			return
+		}
+	}
+
+	// Decompress paste if no longer encrypted
+	if !p.encrypted {
+		err = p.decompress()
+		if err == errPasteExpired {
+			http.Error(writer, "Paste has expired!", http.StatusGone)
+			return
+		} else if err != nil {
+			log.Printf("Failed to decompress paste - %s\n", err.Error())
+			http.Error(writer, "Paste decompression failed!", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Metadata sealed with the content is only known once decrypted
+	meta.mergeSealed(p.sealedMeta)
+
+	// Serve with stored content type once readable, else plain text
+	contentType := "text/plain"
+	if meta.ContentType != "" && !p.encrypted {
+		contentType = meta.ContentType
+	}
+
+	// Serve PGP armored pastes with their PGP content type if requested
+	if format := request.URL.Query().Get("format"); format == "pgp" {
+		if meta.PGP == "" || p.encrypted {
+			http.Error(writer, "Paste is not PGP armored!", http.StatusNotAcceptable)
+			return
+		}
+		contentType = pgpContentTypes[meta.PGP]
+	} else if format != "" {
+		http.Error(writer, "Unsupported paste format!", http.StatusBadRequest)
+		return
+	}
+
+	// Project CSV/TSV columns if requested
+	if columnsStr := request.URL.Query().Get("columns"); columnsStr != "" {
+		if p.encrypted || meta.ContentType != "" {
+			http.Error(writer, "Paste is not CSV/TSV!", http.StatusNotAcceptable)
+			return
+		}
+		delim, ok := detectDelimiter(p.text, meta.Language)
+		if !ok {
+			http.Error(writer, "Paste is not CSV/TSV!", http.StatusNotAcceptable)
+			return
+		}
+		p.text, err = projectTable(p.text, delim, columnsStr)
+		if err != nil {
+			http.Error(writer, "Invalid columns!", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Count this view against any view limit
+	ok, err := takeView(c)
+	if err != nil {
+		log.Printf("Failed to check paste view limit - %s\n", err.Error())
+		http.Error(writer, "Failed to check paste view limit", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(writer, "Paste view limit reached!", http.StatusGone)
+		return
+	}
+
+	// Record view statistics (non-fatal)
+	err = recordPasteView(c)
+	if err != nil {
+		log.Printf("Failed to record paste view - %s\n", err.Error())
+	}
+
+	// Include integrity status (checked on fetch, so never invalid here)
+	writer.Header().Set("X-Paste-Integrity", p.integrity)
+
+	// Link to parent paste if forked
+	if meta.Parent != "" {
+		writer.Header().Set("X-Paste-Parent", pastePrefix+meta.Parent)
+	}
+
+	// Include title if known
+	if meta.Title != "" {
+		writer.Header().Set("X-Paste-Title", meta.Title)
+	}
+
+	// Include language hint if known
+	if meta.Language != "" {
+		writer.Header().Set("X-Paste-Language", meta.Language)
+	}
+
+	// Flag PGP armored pastes
+	if meta.PGP != "" {
+		writer.Header().Set("X-Paste-PGP", meta.PGP)
+	}
+
+	// Flag E2E pastes so clients know to decrypt
+	if p.sealing == pasteSealingE2E {
+		writer.Header().Set("X-Paste-E2E", "1")
+	}
+
+	// Include signer so clients know to check /verify
+	if p.signature != nil {
+		writer.Header().Set("X-Paste-Signer", encodeSigningKey(p.signer))
+	}
+
+	// Never let browsers sniff a different content type
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Write the paste!
+	writer.Header().Set("content-type", contentType)
+	writer.Write(p.text)
+}
+
+func putPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Form uploads come from sprunge / ix.io style clients
+	if isLegacyPasteRequest(request) {
+		legacyPasteHandler(writer, request, params)
+		return
+	}
+
+	// Log the request
+	logRequest("POST", "/", request.RemoteAddr)
+
+	// Set max read size to 1MB
+	request.Body = http.MaxBytesReader(writer, request.Body, maxPasteSize)
+
+	// Read body content
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Println("Failed to read request body")
+		http.Error(writer, "Failed to read request", http.StatusInternalServerError)
+		return
+	}
+
+	// End-to-end encrypted pastes are opaque, so no server-side processing applies
+	e2e := request.URL.Query().Get("e2e") == "1"
+	if e2e && (request.URL.Query().Get("pgp_keys") != "" || request.URL.Query().Get("lint") != "" || request.URL.Query().Get("type") != "") {
+		http.Error(writer, "E2E pastes can't be PGP encrypted, linted or typed!", http.StatusBadRequest)
+		return
+	}
+
+	// Run WASM content plugins, which may transform, reject or tag the paste
+	var pluginTags []string
+	if len(wasmPlugins) > 0 && !e2e {
+		result, err := runWASMPlugins(b)
+		if err == errPluginRejected {
+			http.Error(writer, "Paste rejected: "+result.reason, http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			log.Printf("Failed to run WASM plugins - %s\n", err.Error())
+			http.Error(writer, "Paste plugins failed!", http.StatusInternalServerError)
+			return
+		}
+		b, pluginTags = result.text, result.tags
+	}
+
+	// Check against known sensitive material, refusing or flagging matches
+	var leakMatches int
+	if leakCheckEnabled() && !e2e {
+		leakMatches = matchLeakHashes(b)
+		if leakMatches > 0 {
+			log.Printf("Upload from %s matched %d leak corpus hashes\n", request.RemoteAddr, leakMatches)
+			if leakAction == leakActionBlock {
+				http.Error(writer, "Paste matches known sensitive material!", http.StatusUnprocessableEntity)
+				return
+			}
+		}
+	}
+
+	// Encrypt to PGP public key pastes server-side if requested
+	if keysStr := request.URL.Query().Get("pgp_keys"); keysStr != "" {
+		keyring, err := readPGPKeyPastes(keysStr)
+		if err != nil {
+			http.Error(writer, "Invalid PGP keys!", http.StatusBadRequest)
+			return
+		}
+		b, err = encryptPGP(b, keyring)
+		if err != nil {
+			log.Printf("Failed to PGP encrypt paste - %s\n", err.Error())
+			http.Error(writer, "Paste PGP encryption failed!", http.StatusInternalServerError)
+			return
+		} else if int64(len(b)) > maxPasteSize {
+			http.Error(writer, "PGP encrypted paste too large!", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	// Detect PGP armored content
+	var pgpKind string
+	if !e2e {
+		pgpKind = detectPGPArmor(b)
+	}
+
+	// Parse view limit if supplied
+	var maxViews uint64
+	if maxViewsStr := request.URL.Query().Get("max_views"); maxViewsStr != "" {
+		maxViews, err = strconv.ParseUint(maxViewsStr, 10, 64)
+		if err != nil || maxViews == 0 {
+			http.Error(writer, "Invalid max views!", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Lint paste content if format supplied, rejecting on warnings if strict
+	var warnings []string
+	if format := request.URL.Query().Get("lint"); format != "" {
+		warnings, err = lintPaste(format, b)
+		if err != nil {
+			http.Error(writer, "Unsupported lint format!", http.StatusBadRequest)
+			return
+		}
+		if len(warnings) > 0 && request.URL.Query().Get("strict") == "1" {
+			http.Error(writer, "Paste failed lint:\n"+strings.Join(warnings, "\n"), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// Parse tags if supplied
+	var tags []string
+	if tagsStr := request.URL.Query().Get("tags"); tagsStr != "" {
+		tags, err = parseTags(tagsStr)
+		if err != nil {
+			http.Error(writer, "Invalid tags!", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Parse content type if supplied, default plain text
+	contentType, err := parseContentType(request.URL.Query().Get("type"), b)
+	if err != nil {
+		http.Error(writer, "Invalid content type!", http.StatusBadRequest)
+		return
+	}
+
+	// Parse syntax language hint if supplied
+	language, err := parseLanguage(request.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(writer, "Invalid language!", http.StatusBadRequest)
+		return
+	}
+
+	// Parse visibility, default unlisted
+	public, err := parseVisibility(request.URL.Query().Get("visibility"))
+	if err != nil {
+		http.Error(writer, "Invalid visibility!", http.StatusBadRequest)
+		return
+	}
+
+	// Create new paste, E2E blobs are stored verbatim
+	var p *paste
+	if e2e {
+		p, err = newE2EPaste(b)
+		if err != nil {
+			http.Error(writer, "Invalid E2E paste: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		// Compress before any encryption (ciphertext doesn't compress)
+		p = &paste{text: b}
+		err = p.compress()
+		if err != nil {
+			log.Printf("Failed to compress paste - %s\n", err.Error())
+			http.Error(writer, "Paste compression failed!", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Attach uploader signature (verified) or server signature over content as uploaded
+	p.signer, p.signature, err = requestSignature(request, b)
+	if err != nil {
+		http.Error(writer, err.Error()+"!", http.StatusBadRequest)
+		return
+	}
+
+	// If encryption key or recipients provided, try encrypt!
+	title := ""
+	key, err := requestSecret(request, "key")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recipientsStr := request.URL.Query().Get("recipients")
+
+	// Parse password hint if supplied
+	hint, err := parseHint(request.URL.Query().Get("hint"))
+	if err != nil {
+		http.Error(writer, "Invalid hint!", http.StatusBadRequest)
+		return
+	}
+
+	// Generate random key if requested, returned once and never stored
+	genKey := request.URL.Query().Get("genkey") == "1"
+	if genKey {
+		if key != "" || recipientsStr != "" || e2e {
+			http.Error(writer, "Generated keys can't be combined with key, recipients or E2E!", http.StatusBadRequest)
+			return
+		}
+		key, err = generatePasteKey()
+		if err != nil {
+			log.Printf("Failed to generate paste key - %s\n", err.Error())
+			http.Error(writer, "Failed to generate paste key", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Convergent encryption deduplicates equal pastes under the same key, so needs a chosen key
+	convergent := request.URL.Query().Get("convergent") == "1"
+	if convergent {
+		if key == "" || genKey {
+			http.Error(writer, "Convergent encryption requires a key!", http.StatusBadRequest)
+			return
+		} else if maxViews > 0 {
+			http.Error(writer, "Convergent pastes can't be view-limited!", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// PAKE retrieval needs a passphrase, or a client computed verifier for E2E pastes
+	var verifier *pakeVerifier
+	if request.URL.Query().Get("pake") == "1" {
+		if convergent || recipientsStr != "" {
+			http.Error(writer, "PAKE can't be combined with convergent encryption or recipients!", http.StatusBadRequest)
+			return
+		} else if e2e {
+			verifier, err = parsePakeVerifier(request.Header.Get(pakeVerifierHeader))
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if key == "" {
+			http.Error(writer, "PAKE requires a key!", http.StatusBadRequest)
+			return
+		} else {
+			verifier, err = newPakeVerifier(key)
+			if err != nil {
+				log.Printf("Failed to derive PAKE verifier - %s\n", err.Error())
+				http.Error(writer, "Failed to derive PAKE verifier", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// Parse expiry if supplied, sealed inside so only server-side encrypted pastes can carry it
+	var expires int64
+	if expiresStr := request.URL.Query().Get("expires"); expiresStr != "" {
+		expires, err = parseExpiry(expiresStr)
+		if err != nil {
+			http.Error(writer, "Invalid expiry!", http.StatusBadRequest)
+			return
+		} else if (key == "" && recipientsStr == "") || e2e {
+			http.Error(writer, "Expiry only supported on server-side encrypted pastes!", http.StatusBadRequest)
+			return
+		} else if convergent {
+			http.Error(writer, "Convergent pastes can't expire!", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if key != "" && recipientsStr != "" {
+		http.Error(writer, "Only one of key or recipients may be supplied!", http.StatusBadRequest)
+		return
+	} else if e2e && (key != "" || recipientsStr != "") {
+		http.Error(writer, "E2E pastes are already encrypted!", http.StatusBadRequest)
+		return
+	} else if key == "" && recipientsStr == "" && !e2e {
+		// Only derive title for unencrypted text pastes (PGP messages are ciphertext)
+		if contentType == "" && pgpKind != "message" {
+			title = extractTitle(b)
+		}
+
+		// Plugin classification tags only go on unencrypted pastes
+		tags = mergeTags(tags, pluginTags)
+
+		// Hints are only meaningful on encrypted pastes
+		if hint != "" {
+			http.Error(writer, "Hints only supported on encrypted pastes!", http.StatusBadRequest)
+			return
+		}
+	} else {
+		// Tags and listings are public, don't allow on encrypted pastes
+		if len(tags) > 0 || public {
+			http.Error(writer, "Tags and public visibility only supported on unencrypted pastes!", http.StatusBadRequest)
+			return
+		}
+
+		// Seal content metadata inside the ciphertext, so only key holders see it
+		if !e2e && (language != "" || contentType != "" || pgpKind != "" || expires != 0) {
+			err = p.sealMeta(&pasteMeta{Language: language, ContentType: contentType, PGP: pgpKind, Expires: expires})
+			if err != nil {
+				log.Printf("Failed to seal paste metadata - %s\n", err.Error())
+				http.Error(writer, "Paste metadata sealing failed!", http.StatusInternalServerError)
+				return
+			}
+			language, contentType, pgpKind = "", "", ""
+		}
+
+		// Bind metadata stored outside the ciphertext, as it will be stored
+		binding := &pasteBinding{ContentType: contentType, MaxViews: maxViews}
+		if convergent {
+			err = p.encryptConvergent(key, binding)
+		} else if key != "" {
+			err = p.encrypt(key, binding)
+		} else if recipientsStr != "" {
+			var recipients [][]byte
+			recipients, err = parseRecipients(recipientsStr)
+			if err != nil {
+				http.Error(writer, "Invalid recipients!", http.StatusBadRequest)
+				return
+			}
+			err = p.encryptToRecipients(recipients, binding)
+		}
+		if err != nil {
+			log.Printf("Failed to encrypt paste - %s\n", err.Error())
+			http.Error(writer, "Paste encryption failed!", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	// Get paste path
-	pastePath := ipfsPrefix + cidStr
+	opts, err := pastePutOptions(request)
+	if err != nil {
+		http.Error(writer, "Unsupported hash!", http.StatusBadRequest)
+		return
+	}
 
-	// Try look for paste with CID
-	p, err := getPaste(pastePath)
+	// Place the paste into the IPFS store, shared beyond this instance once its gates are registered
+	c, stored, duplicate, err := storePaste(request.Context(), p, opts)
 	if err != nil {
-		log.Printf("Paste not retrieved - %s\n", err.Error())
-		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		log.Printf("Failed to put paste in store - %s\n", err.Error())
+		http.Error(writer, "Failed to put paste in store", http.StatusInternalServerError)
 		return
 	}
+	pathStr := pastePrefix + c.String()
 
-	// If decryption key supplied, try decrypt
-	if key := request.URL.Query().Get("key"); key != "" {
-		err = p.decrypt(key)
-		if err != nil {
-			log.Printf("Failed to decrypt paste - %s\n", err.Error())
-			http.Error(writer, "Paste decryption failed!", http.StatusInternalServerError)
-			return
-		}
+	// Register PAKE verifier and view limit, never on an existing paste others may already read
+	err = gatePaste(c, duplicate, verifier, maxViews)
+	if err == errPasteExists {
+		http.Error(writer, "Paste already exists, PAKE or view limit can't be added!", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Failed to set paste PAKE verifier / view limit - %s\n", err.Error())
+		http.Error(writer, "Failed to set paste access limits", http.StatusInternalServerError)
+		return
 	}
 
-	// Write the paste!
-	writer.Header().Set("content-type", "text/plain")
-	writer.Write(p.text)
-}
+	// Gates are set, now mirror, push and provide new pastes
+	if !duplicate {
+		sharePaste(request.Context(), c, stored, opts)
+	}
 
-func putPasteHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
-	// Log the request
-	logRequest("POST", "/", request.RemoteAddr)
+	// Queue flagged leak matches for moderation
+	if leakMatches > 0 {
+		err = addAbuseReport(c, "matched "+strconv.Itoa(leakMatches)+" leak corpus hashes")
+		if err != nil {
+			log.Printf("Failed to flag leaked paste - %s\n", err.Error())
+		}
+	}
 
-	// Set max read size to 1MB
-	request.Body = http.MaxBytesReader(writer, request.Body, maxPasteSize)
+	// Existing pastes keep their metadata and visibility, re-uploads must not change someone else's paste
+	if duplicate {
+		meta, err := getPasteMeta(c)
+		if err != nil {
+			log.Printf("Failed to get paste metadata - %s\n", err.Error())
+			http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+			return
+		}
+		if title != "" || len(tags) > 0 || language != "" || contentType != "" || hint != "" || expires != 0 || public {
+			warnings = append(warnings, "paste already exists, its metadata and visibility were left unchanged")
+		}
+		expires = meta.Expires
+	}
 
-	// Read body content
-	b, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		log.Println("Failed to read request body")
-		http.Error(writer, "Failed to read request", http.StatusInternalServerError)
-		return
+	// Store title, tags, language, type, PGP kind, E2E flag, hint and expiry in metadata, tags in index
+	if !duplicate && (title != "" || len(tags) > 0 || language != "" || contentType != "" || pgpKind != "" || e2e || hint != "" || expires != 0) {
+		var oldTags []string
+		err = updatePasteMeta(c, func(meta *pasteMeta) {
+			oldTags = meta.Tags
+			meta.Title = title
+			meta.Tags = tags
+			meta.Language = language
+			meta.ContentType = contentType
+			meta.PGP = pgpKind
+			meta.E2E = e2e
+			meta.Hint = hint
+			meta.Expires = expires
+		})
+		if err == nil {
+			err = retagPaste(c, oldTags, tags)
+		}
+		if err != nil {
+			log.Printf("Failed to put paste metadata - %s\n", err.Error())
+			http.Error(writer, "Failed to put paste metadata", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// Create new paste, if encryption key provided, try encrypt!
-	p := &paste{b}
-	if key := request.URL.Query().Get("key"); key != "" {
-		err = p.encrypt(key)
+	// Add new pastes to public listings if requested
+	if public && !duplicate {
+		err = publishPaste(c, b)
 		if err != nil {
-			log.Printf("Failed to encrypt paste - %s\n", err.Error())
-			http.Error(writer, "Paste encryption failed!", http.StatusInternalServerError)
+			log.Printf("Failed to publish paste - %s\n", err.Error())
+			http.Error(writer, "Failed to publish paste", http.StatusInternalServerError)
 			return
 		}
 	}
 
-	// Place the paste into the IPFS store
-	pathStr, err := putPaste(p)
+	// Allocate short ID for sharing
+	short, err := shortIDForPaste(c)
 	if err != nil {
-		log.Printf("Failed to put paste in store - %s\n", err.Error())
-		http.Error(writer, "Failed to put paste in store", http.StatusInternalServerError)
+		log.Printf("Failed to allocate short paste ID - %s\n", err.Error())
+		http.Error(writer, "Failed to allocate short paste ID", http.StatusInternalServerError)
 		return
 	}
-	pathStr = strings.Replace(pathStr, ipfsPrefix, pastePrefix, 1)
 
-	// Write the store path in response
-	writer.Header().Set("content-type", "text/plain")
-	writer.Write([]byte(pathStr))
+	// Only return the key if we generated it
+	generatedKey := ""
+	if genKey {
+		generatedKey = key
+	}
+
+	// Record upload for operator reports and run create hook
+	if !duplicate {
+		recordReportUpload(request.UserAgent(), len(b))
+		runHook(hookOnCreate, c)
+	}
+
+	// Announce new public pastes for mirroring, never view-limited ones which must only be served from here
+	if !duplicate && public && maxViews == 0 && pubsubEnabled() {
+		flags := []string{}
+		if p.encrypted {
+			flags = append(flags, "encrypted")
+		}
+		if e2e {
+			flags = append(flags, "e2e")
+		}
+		if pgpKind != "" {
+			flags = append(flags, "pgp")
+		}
+		if expires != 0 {
+			flags = append(flags, "expires")
+		}
+		go announcePaste(c, len(b), flags)
+	}
+
+	// Store as a paste DAG node if enabled, once its metadata is set
+	dag := createPasteNode(request.Context(), c, cid.Undef)
+
+	// Write the store path in response, with pairing QR codes if requested
+	response := &putResponse{
+		Path:      pathStr,
+		CID:       c.String(),
+		Short:     shortPrefix + short,
+		Duplicate: duplicate,
+		Warnings:  warnings,
+		Key:       generatedKey,
+		Size:      len(b),
+		ExpiresAt: expiryTime(expires),
+		DAG:       dag,
+	}
+	addPairingCodes(request, response, key)
+	writePutResponse(writer, request, response)
 }
 
 func initIPFSRepo(repoPath string) error {
@@ -299,6 +1152,14 @@ func initIPFSRepo(repoPath string) error {
 		return err
 	}
 
+	// Apply datastore profile, badger suits many tiny paste blocks far better than flatfs
+	profile := config.Profiles[ipfsDatastore]
+	log.Printf("... using %s datastore...\n", ipfsDatastore)
+	err = profile.Transform(cfg)
+	if err != nil {
+		return err
+	}
+
 	// Init new repo on repo path
 	log.Println("Initializing new IPFS repo...")
 	err = fsrepo.Init(repoPath, cfg)
@@ -334,31 +1195,47 @@ func setupIPFSPlugins(repoPath string) error {
 	return nil
 }
 
-func constructIPFSNodeAPI(repoPath string) (icore.CoreAPI, error) {
+func constructIPFSNodeAPI(repoPath string, online bool) (*core.IpfsNode, icore.CoreAPI, error) {
 	// Open the repo
 	log.Println("Opening IPFS repo path...")
 	repo, err := fsrepo.Open(repoPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// Encrypt its datastore if enabled, everything the node stores goes through it
+	if encryptDatastore {
+		repo, err = encryptRepo(repoPath, repo)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	// Construct the node
+	// Construct the node, online nodes bootstrap from the repo's peers
 	log.Println("Constructing IPFS node object...")
 	node, err := core.NewNode(
 		globalContext,
 		&core.BuildCfg{
-			Online:  false,
+			Online:  online,
 			Routing: libp2p.DHTOption,
 			Repo:    repo,
+			ExtraOpts: map[string]bool{
+				"pubsub": pubsubEnabled(),
+			},
 		},
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Return core API wrapping the node
 	log.Println("Wrapping IPFS node in core API...")
-	return coreapi.NewCoreAPI(node)
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return node, api, nil
 }
 
 func logRequest(reqMethod, reqPath, reqAddr string) {
@@ -392,25 +1269,176 @@ func main() {
 	httpHostname := flag.String("http-hostname", "", "Set HTTP hostname for printed help message")
 	httpBindAddr := flag.String("http-bind-addr", "localhost", "Bind HTTP server to address")
 	httpPort := flag.Uint("http-port", 443, "Bind HTTP server to port")
-	ipfsRepo := flag.String("ipfs-repo", "", "IPFS repo path")
+	ipfsRepo := flag.String("ipfs-repo", "", "IPFS repo path(s), comma-separated for sharding as 'path[:storageMax[:gcPeriod]]', e.g. 'C:\\ipfs:10GB' (shard count must not change once in use)")
 	certFile := flag.String("cert-file", "", "TLS certificate file")
 	keyFile := flag.String("key-file", "", "TLS key file")
 	pasteMax := flag.Float64("paste-size-max", 1.0, "Maximum paste size (in megabytes)")
+	flag.BoolVar(&autoMigrate, "auto-migrate", false, "Migrate out-of-date IPFS repos on startup with fs-repo-migrations (from $PATH, else downloaded from dist.ipfs.io) instead of refusing to start")
+	flag.StringVar(&ipfsDatastore, "datastore", "flatfs", "IPFS datastore for newly initialized repos, flatfs or badgerds (existing repos keep theirs)")
+	flag.BoolVar(&ipfsOnline, "online", false, "Run IPFS shards online, bootstrapping into the DHT and providing paste blocks so public gateways can fetch them")
+	bootstrapPeers := flag.String("bootstrap", "", "Comma-separated bootstrap peer multiaddrs (with /p2p/<peer ID>) replacing each repo's, or 'none' for private deployments (repo's kept if unset)")
+	flag.StringVar(&swarmKeyFile, "swarm-key", "", "Private IPFS network swarm key file installed in each repo, peering only with nodes sharing it, generated if missing (public network if unset)")
+	swarmAddrs := flag.String("swarm-addrs", "", "Comma-separated swarm listen multiaddrs when online, {port} is replaced by each shard's swarm port (all interfaces if unset)")
+	flag.UintVar(&ipfsSwarmPort, "online-swarm-port", 4001, "IPFS swarm port of the first shard when online, each further shard uses the next port")
+	flag.StringVar(&clusterAPI, "cluster-api", "", "ipfs-cluster REST API URL new pastes are pinned through, e.g. http://127.0.0.1:9094 (disabled if unset)")
+	flag.StringVar(&clusterAuth, "cluster-auth", "", "ipfs-cluster REST API basic auth as 'user:password'")
+	flag.IntVar(&clusterReplication, "cluster-replication", -1, "Cluster peers each new paste is pinned on (-1 for every peer)")
 	flag.DurationVar(&unixfsGetTimeout, "ipfs-get-timeout", time.Millisecond*250, "IPFS unixfs API get timeout")
+	maxConcurrent := flag.Uint("max-concurrent", 0, "Maximum concurrent requests across limited routes (0 is unlimited)")
+	maxUploads := flag.Uint("max-concurrent-uploads", 0, "Maximum concurrent upload requests (0 is unlimited)")
+	maxDownloads := flag.Uint("max-concurrent-downloads", 0, "Maximum concurrent download requests (0 is unlimited)")
+	maxRenders := flag.Uint("max-concurrent-renders", 0, "Maximum concurrent CPU-heavy render requests (0 is unlimited)")
+	flag.DurationVar(&queueTimeout, "queue-timeout", time.Second, "Maximum time a request waits for a concurrency slot before 503")
+	renderCacheSize := flag.Float64("render-cache-size", 32.0, "Rendered view cache size (in megabytes, 0 disables)")
+	flag.BoolVar(&compressPastes, "compress", true, "Transparently gzip compress paste bodies")
+	flag.StringVar(&diagnosticsDir, "diagnostics-dir", "", "Directory SIGQUIT diagnostic dumps are written to (logged if unset)")
+	cidVersion := flag.Uint("cid-version", 1, "CID version new pastes are stored under, 1 for base32 paths (subdomain gateway safe) or 0 for legacy base58 (sha2-256 only)")
+	cidHash := flag.String("cid-hash", "sha2-256", "Multihash new pastes are stored under (sha2-256, sha2-512, sha3-256, sha3-512 or blake2b-256), uploads may choose with ?hash=")
+	flag.BoolVar(&mfsMirror, "mfs-mirror", false, "Mirror a UnixFS copy of each new paste into MFS at /gibon/<date>/<cid> for 'ipfs files' tooling, listed at /api/pastes (doubles paste storage)")
+	flag.BoolVar(&pasteDAG, "paste-dag", false, "Also store each new paste as a dag-cbor IPLD node linking its block, metadata, previous version and server signature, returned as 'dag' and walked at /paste/<cid>/history")
+	flag.BoolVar(&pinPastes, "pin-pastes", false, "Pin uploaded pastes so garbage collection keeps them (uploads may opt out with ?nopin=1)")
+	flag.StringVar(&kdfName, "kdf", kdfArgon2id, "Passphrase KDF for new encrypted pastes (argon2id or pbkdf2), parameters are stored per paste so changing it never breaks old ones")
+	flag.UintVar(&argon2Time, "argon2-time", 3, "Argon2id key derivation passes for new encrypted pastes")
+	flag.UintVar(&argon2Memory, "argon2-memory", 64*1024, "Argon2id key derivation memory for new encrypted pastes (in KiB)")
+	flag.UintVar(&argon2Threads, "argon2-threads", 4, "Argon2id key derivation parallelism for new encrypted pastes")
+	flag.UintVar(&argon2AcceptFactor, "argon2-accept", 4, "Open pastes whose stored Argon2id parameters are at most this multiple of the above, refusing costlier ones from peers or imports (raise before lowering the above)")
+	flag.UintVar(&pbkdf2Iterations, "pbkdf2-iterations", 600000, "PBKDF2-HMAC-SHA256 iterations for new encrypted pastes")
+	flag.BoolVar(&rejectQueryKeys, "reject-query-keys", false, "Reject keys supplied in the query string, requiring X-Gibon-Key style headers or form fields")
+	flag.StringVar(hookCommands[hookOnCreate], "hook-on-create", "", "Shell command run when a paste is created (event JSON on stdin, GIBON_* env vars)")
+	flag.StringVar(hookCommands[hookOnExpire], "hook-on-expire", "", "Shell command run when a paste reaches its view limit or expiry")
+	flag.StringVar(hookCommands[hookOnDelete], "hook-on-delete", "", "Shell command run when a paste is blocked by moderation")
+	flag.DurationVar(&hookTimeout, "hook-timeout", time.Second*10, "Maximum paste event hook run time")
+	maxHooks := flag.Uint("hook-max-concurrent", 4, "Maximum concurrently running paste event hooks (0 is unlimited)")
+	flag.DurationVar(&repoGCPeriod, "gc-period", 0, "Period between repo GC runs, unpinning expired pastes and collecting unpinned blocks, requires -pin-pastes (disabled if zero)")
+	gcMinFree := flag.Float64("gc-min-free", 0, "Free disk space below which scheduled repo GC collects blocks (in megabytes, 0 always collects)")
+	flag.StringVar(&adminToken, "admin-token", "", "Admin API bearer token (admin API disabled if unset)")
+	flag.StringVar(&reportWebhook, "report-webhook", "", "Operator report webhook URL (reports disabled if unset)")
+	flag.DurationVar(&reportPeriod, "report-period", time.Hour*24*7, "Period between operator reports")
+	flag.StringVar(&replicaOf, "replica-of", "", "Run as read-only replica of primary instance at base URL")
+	flag.StringVar(&replicaToken, "replica-token", "", "Primary instance admin token used for replication")
+	flag.DurationVar(&replicaSyncPeriod, "replica-sync-period", time.Minute*5, "Period between replica syncs from primary")
+	flag.BoolVar(&federationEnabled, "federation", false, "Take part in the instance federation directory, serving /federation/* and accepting announcements from -federation-peers or signed by -sync-peer-keys")
+	flag.StringVar(&federationName, "federation-name", "", "Instance name advertised to federation peers (defaults to hostname)")
+	flag.StringVar(&federationPolicy, "federation-policy", "", "Short instance policy advertised to federation peers, e.g. retention and content rules")
+	federationCapacityMax := flag.Float64("federation-capacity", 0, "Storage capacity advertised to federation peers (in megabytes, 0 is unadvertised)")
+	federationPeersStr := flag.String("federation-peers", "", "Comma-separated federation peer base URLs (https://host) to announce to and exchange peers with")
+	gatewaysStr := flag.String("fetch-gateways", "", "Comma-separated public IPFS gateway base URLs pastes not stored here are fetched from, verified and cached (disabled if unset)")
+	flag.DurationVar(&gatewayTimeout, "fetch-gateway-timeout", time.Second*10, "Maximum time fetching a paste across all gateways")
+	gatewayMax := flag.Float64("fetch-gateway-size-max", 1.0, "Maximum paste block size fetched from gateways (in megabytes)")
+	flag.StringVar(&pubsubTopic, "pubsub-topic", "", "libp2p pubsub topic new public pastes are announced on, requires -online (disabled if unset)")
+	flag.BoolVar(&pubsubMirror, "pubsub-mirror", false, "Mirror pastes announced on -pubsub-topic by other instances")
+	flag.BoolVar(&provideNew, "provide", false, "Provide each new paste to the DHT as soon as it's stored and re-provide it on our own schedule, so it's fetchable from the public network straight away (requires -online)")
+	flag.DurationVar(&reprovidePeriod, "reprovide-period", time.Hour*12, "Period between re-provides of each paste with -provide, well within the DHT's 24 hour provider record lifetime")
+	flag.StringVar(&dnslinkDomain, "dnslink-domain", "", "Publish a CBOR index of public pastes as DNSLink for this domain, resolvable as /ipns/<domain>, requires -online (disabled if unset)")
+	flag.DurationVar(&dnslinkPeriod, "dnslink-period", time.Minute*10, "Period between public paste index rebuilds, the DNSLink record only changes with the index")
+	dnslinkProvider := flag.String("dnslink-dns-provider", "", "Provider updating the _dnslink TXT record, as for -acme-dns-provider (record logged for manual update if unset)")
+	dnslinkProviderConfig := flag.String("dnslink-dns-config", "", "DNSLink TXT record provider config, as for -acme-dns-config")
+	flag.StringVar(&federationFallback, "federation-fallback", "", "Serve pastes not stored here from -federation-peers that have them, by 'redirect' (keys stripped) or 'proxy' (disabled if unset)")
+	flag.DurationVar(&federationAnnouncePeriod, "federation-announce-period", time.Hour, "Period between federation announcements, peers silent for three periods are dropped")
+	flag.StringVar(&wasmPluginsDir, "wasm-plugins-dir", "", "Directory of sandboxed .wasm content transform/validate/classify plugins (disabled if unset)")
+	flag.DurationVar(&wasmPluginTimeout, "wasm-plugin-timeout", time.Second*2, "Maximum WASM content plugin run time per paste")
+	wasmPluginMemoryMax := flag.Float64("wasm-plugin-memory", 64.0, "Maximum WASM content plugin memory (in megabytes)")
+	flag.StringVar(&storageBackendName, "storage-backend", ipfsBackendName, "Storage backend for paste blocks, by registered name")
+	flag.StringVar(&storageBackendConfig, "storage-backend-config", "", "Storage backend config string, format defined by the backend")
+	ipfsAPIURL := flag.String("ipfs-api", "", "Store paste blocks in an existing IPFS daemon through its HTTP API at this URL, e.g. http://127.0.0.1:5001, the embedded repos then stay offline holding only the local index (disabled if unset)")
+	flag.StringVar(&signingKeyFile, "signing-key", "", "Server Ed25519 identity key file for ?sign=1 pastes, ?receipt=1 upload receipts and RFC 9421 signed webhooks / sync / replicate requests (signature base prefixed with 'gibon-httpsig-v1\\x00'), generated if missing (disabled if unset)")
+	flag.StringVar(&ageIdentityFiles, "age-identities", "", "Comma-separated age identity files, pastes encrypted to the first's recipient (served at /age-recipient) are decrypted for authorized GETs (disabled if unset)")
+	flag.StringVar(&ageDecryptToken, "age-decrypt-token", "", "Bearer token allowing decryption with the server's age identities (admin token only if unset)")
+	flag.StringVar(&integrityKeyFile, "integrity-key", "", "Server secret file for HMACs on unencrypted pastes, generated if missing (tampered pastes are then refused)")
+	peerKeys := flag.String("sync-peer-keys", "", "Comma-separated Ed25519 public keys (base64url, from peers' /signing-key) required to sign sync requests (unsigned syncs accepted if unset), and accepted on pushed pastes")
+	replicatePeersStr := flag.String("replicate-peers", "", "Comma-separated peer gibon instance base URLs (https://host) every new paste is pushed to, signed with -signing-key, for HA without ipfs-cluster (disabled if unset)")
+	flag.StringVar(&dnsZone, "dns-zone", "", "Experimental: serve small unencrypted pastes as DNS TXT chunks under this zone (disabled if unset)")
+	flag.StringVar(&dnsBindAddr, "dns-bind-addr", ":53", "Bind DNS TXT responder to address")
+	dnsPasteMax := flag.Float64("dns-paste-size-max", 4.0, "Maximum paste size served over DNS (in kilobytes)")
+	acmeDomainsStr := flag.String("acme-domains", "", "Obtain TLS certificate via ACME DNS-01 for these comma-separated domains, wildcards allowed (uses -cert-file / -key-file if unset)")
+	flag.StringVar(&acmeEmail, "acme-email", "", "ACME account contact email")
+	flag.StringVar(&acmeDirectory, "acme-directory", "https://acme-v02.api.letsencrypt.org/directory", "ACME directory URL")
+	flag.StringVar(&acmeDir, "acme-dir", "", "Directory storing the ACME account key and issued certificate")
+	acmeProvider := flag.String("acme-dns-provider", "", "ACME DNS-01 provider: cloudflare, route53 or rfc2136")
+	acmeProviderConfig := flag.String("acme-dns-config", "", "ACME DNS-01 provider config as 'key=value,...' (cloudflare: token, zone_id; route53: access_key, secret_key, zone_id; rfc2136: server, zone, tsig_name, tsig_secret, tsig_alg)")
+	flag.DurationVar(&acmeDNSPropagation, "acme-dns-propagation", time.Minute, "Time to wait for ACME challenge records to propagate")
+	masterKeyName := flag.String("master-key", "", "Server master key provider wrapping per-paste data keys: local, awskms, or pkcs11 in cgo builds (disabled if unset)")
+	masterKeyConfig := flag.String("master-key-config", "", "Master key provider config as 'key=value,...' (local: path; awskms: key_id, region, access_key, secret_key; pkcs11: module, slot, pin, label)")
+	retiredMasterKeys := flag.String("master-key-retired", "", "Rotated-out master keys still used to open older pastes, as 'provider:key=value,...;...'")
+	flag.BoolVar(&encryptDatastore, "encrypt-datastore", false, "Encrypt every IPFS repo datastore value (blocks, pins, local index) at rest with a per-repo key wrapped by -master-key, encrypting existing values on first start (block CIDs stay visible as file names)")
+	flag.BoolVar(&sealAtRest, "seal-at-rest", false, "Seal unencrypted pastes at rest with the master key, opened transparently on read (disables deduplication)")
+	flag.UintVar(&decryptFreeAttempts, "decrypt-attempts", 5, "Failed decryptions allowed per client before exponential backoff, with 100 times as many per paste across all clients (0 disables throttling)")
+	flag.DurationVar(&decryptLockoutMax, "decrypt-lockout-max", time.Hour, "Maximum lockout after repeated failed decryptions")
+	flag.UintVar(&archiveRate, "archive-rate", 6, "Public archive requests allowed per client per minute (0 disables the archive)")
+	flag.UintVar(&nameRate, "ipns-rate", 10, "New IPNS paste names allowed per client per hour (0 disables creating names)")
+	flag.UintVar(&maxNames, "ipns-max-names", 10000, "Maximum IPNS paste names, each holding a node keystore key (0 is unlimited)")
+	flag.BoolVar(&decryptFailureNotFound, "decrypt-failure-not-found", false, "Report failed decryptions as paste not found, hiding whether the paste exists")
+	flag.DurationVar(&decryptFailureFloor, "decrypt-failure-floor", 500*time.Millisecond, "Minimum response time for failed decryptions and keyed misses (should exceed key derivation time, 0 disables)")
+	leakHashFiles := flag.String("leak-hashes", "", "Comma-separated files of hex SHA-256 hashes of known sensitive content, matched against whole uploads and each line (disabled if unset)")
+	flag.StringVar(&leakAction, "leak-action", leakActionBlock, "Action on leak corpus match: block refuses the upload, flag stores it and adds it to the moderation queue")
+	flag.IntVar(&leakMinLineLength, "leak-min-line", 32, "Minimum trimmed line length matched against leak hashes")
+	flag.StringVar(&cookieKeyFile, "cookie-key", "", "Web UI preference cookie signing secret file, generated if missing (random per run if unset)")
+	oldCookieKeys := flag.String("cookie-old-keys", "", "Comma-separated retired cookie signing secret files, still accepted and re-signed with -cookie-key")
+	flag.StringVar(&uiOverrideDir, "ui-dir", "", "Directory of web UI files overriding the embedded ones (same layout as ui/)")
 	flag.Parse()
 
 	// Get current context (cancellable)
 	globalContext, globalCancel = context.WithCancel(context.Background())
 
+	// Check for e2e / export / import / migrate commands, which run and exit instead of serving
+	var export *exportOptions
+	var imports *importOptions
+	var migrates *migrateOptions
+	if flag.Arg(0) == smokeCommand {
+		// End-to-end checks only talk to a running instance, exiting non-zero on failure
+		opts, err := parseSmokeArgs(flag.Args()[1:])
+		if err != nil {
+			fatalf(err.Error())
+		}
+		if !runSmokeTests(opts) {
+			os.Exit(1)
+		}
+		return
+	} else if flag.Arg(0) == exportCommand {
+		export, err = parseExportArgs(flag.Args()[1:])
+		if err != nil {
+			fatalf(err.Error())
+		}
+	} else if flag.Arg(0) == importCommand {
+		imports, err = parseImportArgs(flag.Args()[1:])
+		if err != nil {
+			fatalf(err.Error())
+		}
+	} else if flag.Arg(0) == migrateCommand {
+		migrates, err = parseMigrateArgs(flag.Args()[1:])
+		if err != nil {
+			fatalf(err.Error())
+		}
+	} else if flag.NArg() > 0 {
+		fatalf("Unknown command: %s", flag.Arg(0))
+	}
+	serving := export == nil && imports == nil && migrates == nil
+
+	// Exports and imports only touch local blocks
+	if !serving {
+		ipfsOnline = false
+	}
+
+	// Use an external IPFS daemon for paste blocks if set, it joins the network in our place
+	if *ipfsAPIURL != "" {
+		if storageBackendName != ipfsBackendName {
+			fatalf("-ipfs-api replaces -storage-backend!")
+		} else if ipfsOnline {
+			fatalf("-ipfs-api can't be combined with -online, the daemon provides pastes to the network!")
+		}
+		storageBackendName = ipfsAPIBackendName
+		storageBackendConfig = *ipfsAPIURL
+	}
+
 	// Check we have been supplied IPFS repo
 	if *ipfsRepo == "" {
 		fatalf("No IPFS repo path supplied!")
 	}
 
-	// Check we have been supplied necessary TLS cert + Key files
-	if *certFile == "" {
+	// Check we have been supplied necessary TLS cert + Key files (unless only exporting / importing / migrating)
+	if serving && *certFile == "" {
 		fatalf("No TLS certificate file supplied!")
-	} else if *keyFile == "" {
+	} else if serving && *keyFile == "" {
 		fatalf("No TLS key file supplied!")
 	}
 
@@ -420,35 +1448,297 @@ func main() {
 	}
 	maxPasteSize = int64(*pasteMax * 1048576.0)
 
-	// Check if repo initialized
-	if !fsrepo.IsInitialized(*ipfsRepo) {
-		log.Printf("IPFS repo at %s does not exist!\n", *ipfsRepo)
+	// Ensure KDF known and parameters are within decryptable bounds
+	if !validKDFConfig() {
+		fatalf("KDF parameters out of bounds!")
+	}
+	if argon2AcceptFactor < 1 {
+		fatalf("Argon2id accept multiple must be at least 1!")
+	}
+	limitKDFParams()
+
+	// Check crypto against golden vectors before sealing anything
+	err = crypto.SelfTest()
+	if err != nil {
+		fatalf("Crypto self-test failed - %s", err.Error())
+	}
+
+	// Check datastore choice before any repo is initialized with it
+	if ipfsDatastore != "flatfs" && ipfsDatastore != "badgerds" {
+		fatalf("IPFS datastore must be flatfs or badgerds!")
+	}
+
+	// Check paste CID settings, legacy CIDv0 paths resolve either way
+	err = setupPasteCIDs(*cidVersion, *cidHash)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Parse IPFS repo shard specs
+	ipfsShards, err = parseShardSpecs(*ipfsRepo)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Parse bootstrap peers and swarm addresses, applied to each repo's config
+	ipfsBootstrap, err = parseBootstrapPeers(*bootstrapPeers)
+	if err != nil {
+		fatalf(err.Error())
+	}
+	ipfsSwarmAddrs, err = parseSwarmAddrs(*swarmAddrs, len(ipfsShards))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Migrate repos and exit if requested
+	if migrates != nil {
+		err = runMigrate(migrates)
+		if err != nil {
+			fatalf(err.Error())
+		}
+		return
+	}
+
+	// Check repos are at the version embedded go-ipfs expects, migrating if enabled
+	err = checkRepoVersions(autoMigrate)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Connect to master key provider if enabled, before repos whose datastore key it may wrap
+	err = setupMasterKey(*masterKeyName, *masterKeyConfig, *retiredMasterKeys)
+	if err != nil {
+		fatalf(err.Error())
+	} else if encryptDatastore && masterKey == nil {
+		fatalf("Datastore encryption requires -master-key!")
+	}
+
+	// Load private network swarm key if enabled
+	err = setupSwarmKey()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Load plugins, external ones only from an initialized first repo
+	pluginsRepo := ipfsShards[0].repoPath
+	if !fsrepo.IsInitialized(pluginsRepo) {
+		pluginsRepo = ""
+	}
+	err = setupIPFSPlugins(pluginsRepo)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Open each IPFS repo shard
+	for _, shard := range ipfsShards {
+		err = shard.open()
+		if err != nil {
+			fatalf(err.Error())
+		}
+		if serving {
+			shard.startGC()
+		}
+	}
+
+	// First shard's API is used for any non-block operations
+	ipfsAPI = ipfsShards[0].api
+
+	// First shard's datastore holds the local index
+	indexStore = ipfsShards[0].node.Repo.Datastore()
+
+	// Export blocks and exit if requested
+	if export != nil {
+		err = runExport(export)
+		if err != nil {
+			fatalf("Export failed - %s", err.Error())
+		}
+		globalCancel()
+		return
+	}
+
+	// Import blocks and exit if requested
+	if imports != nil {
+		err = runImport(imports)
+		if err != nil {
+			fatalf("Import failed - %s", err.Error())
+		}
+		globalCancel()
+		return
+	}
+
+	// Setup paste block storage backend (replication only syncs IPFS shards)
+	if isReplica() && storageBackendName != ipfsBackendName {
+		fatalf("Replicas must use the IPFS storage backend!")
+	}
+	err = setupStorageBackend()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Setup proactive DHT provides if enabled
+	if provideNew {
+		err = setupProvide()
+		if err != nil {
+			fatalf(err.Error())
+		}
+	}
 
-		// First load plugins
-		err = setupIPFSPlugins("")
+	// Setup DNSLink publishing if enabled
+	if dnslinkEnabled() {
+		err = setupDNSLink(*dnslinkProvider, *dnslinkProviderConfig)
 		if err != nil {
 			fatalf(err.Error())
 		}
+	}
 
-		// Try initialize repo
-		err = initIPFSRepo(*ipfsRepo)
+	// Setup pubsub announcements if enabled
+	if pubsubMirror && !pubsubEnabled() {
+		fatalf("Pubsub mirroring requires -pubsub-topic!")
+	} else if pubsubEnabled() {
+		err = setupPubsub()
 		if err != nil {
 			fatalf(err.Error())
 		}
-	} else {
-		// First load plugins
-		err = setupIPFSPlugins(*ipfsRepo)
+	}
+
+	// Setup ipfs-cluster pinning if enabled
+	if clusterEnabled() {
+		err = setupCluster()
+		if err != nil {
+			fatalf(err.Error())
+		}
+	}
+
+	// If running as replica, start syncing from primary
+	if isReplica() {
+		startReplicaSync()
+	}
+
+	// Start periodic operator reports if enabled
+	if reportsEnabled() {
+		startReports()
+	}
+
+	// Start publishing the public paste index if enabled
+	if dnslinkEnabled() {
+		startDNSLink()
+	}
+
+	// Start scheduled repo GC if enabled
+	repoGCMinFree = uint64(*gcMinFree * 1048576.0)
+	if repoGCPeriod > 0 {
+		if !pinPastes {
+			fatalf(errGCUnpinned.Error())
+		}
+		startRepoGC()
+	}
+
+	// Load WASM content plugins if enabled
+	wasmPluginMemory = uint64(*wasmPluginMemoryMax * 1048576.0)
+	err = setupWASMPlugins()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Load server signing key if enabled
+	err = setupSigningKey()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Load age identities for instance decryption if enabled
+	err = setupAgeIdentities()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Parse trusted sync peer keys
+	syncPeerKeys, err = parsePeerKeys(*peerKeys)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Parse federation peers and advertised capacity
+	federationPeers, err = parseFederationPeers(*federationPeersStr)
+	if err != nil {
+		fatalf(err.Error())
+	} else if len(federationPeers) > 0 && !federationEnabled {
+		fatalf("Federation peers require -federation!")
+	}
+	federationCapacity = uint64(*federationCapacityMax * 1048576.0)
+
+	// Parse peer instances new pastes are pushed to
+	replicatePeers, err = parseFederationPeers(*replicatePeersStr)
+	if err != nil {
+		fatalf(err.Error())
+	} else if replicateEnabled() {
+		err = setupReplicate()
+		if err != nil {
+			fatalf(err.Error())
+		}
+	}
+
+	// Set public gateways unknown pastes are fetched from
+	fetchGateways = parseGateways(*gatewaysStr)
+	gatewayMaxSize = int64(*gatewayMax * 1048576.0)
+
+	// Check federation fallback mode
+	if !validFallbackMode(federationFallback) {
+		fatalf("Unsupported federation fallback: %s", federationFallback)
+	} else if federationFallback != "" && !federationEnabled {
+		fatalf("Federation fallback requires -federation!")
+	}
+
+	// Load integrity MAC secret if enabled
+	err = setupIntegrityKey()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	// Load leak corpus hash lists
+	if *leakHashFiles != "" {
+		err = setupLeakHashes(strings.Split(*leakHashFiles, ","))
 		if err != nil {
 			fatalf(err.Error())
 		}
 	}
 
-	// Get new IPFS node API instance
-	ipfsAPI, err = constructIPFSNodeAPI(*ipfsRepo)
+	// Start pruning failed decryption records
+	startDecryptThrottle()
+
+	// Load cookie signing keys
+	if *oldCookieKeys != "" {
+		cookieOldKeyFiles = strings.Split(*oldCookieKeys, ",")
+	}
+	err = setupCookieKeys()
 	if err != nil {
 		fatalf(err.Error())
 	}
 
+	// Start experimental DNS TXT responder if enabled
+	if dnsEnabled() {
+		dnsMaxPasteSize = int64(*dnsPasteMax * 1024.0)
+		startDNSResponder()
+	}
+
+	// Load web UI assets and pre-compile templates
+	err = setupUI()
+	if err != nil {
+		fatalf("Failed to setup web UI - %s", err.Error())
+	}
+
+	// Setup render cache if enabled
+	if *renderCacheSize > 0.0 {
+		renderCache = newLRUCache(int(*renderCacheSize * 1048576.0))
+	}
+
+	// Setup concurrency limiters
+	globalLimiter = newLimiter(*maxConcurrent)
+	uploadLimiter = newLimiter(*maxUploads)
+	downloadLimiter = newLimiter(*maxDownloads)
+	renderLimiter = newLimiter(*maxRenders)
+	hookLimiter = newLimiter(*maxHooks)
+
 	// Setup HTTP router
 	router := &httprouter.Router{
 		RedirectTrailingSlash:  true,
@@ -462,8 +1752,103 @@ func main() {
 
 	// Add HTTP routes
 	router.GET("/", helpHandler)
-	router.POST("/", putPasteHandler)
-	router.GET(pastePrefix+":cid", getPasteHandler)
+	router.GET(staticPrefix+":file", staticHandler)
+	router.GET(serviceWorkerPath, serviceWorkerHandler)
+	router.GET(manifestPath, manifestHandler)
+	router.GET(pairPath, pairHandler)
+	router.GET(pastePrefix+":cid", limitHandler(downloadLimiter, getPasteHandler))
+	router.POST(pastePrefix+":cid", limitHandler(downloadLimiter, getPasteHandler))
+	router.GET(shortPrefix+":shortid", limitHandler(downloadLimiter, shortPasteHandler))
+	router.GET(namePrefix+":ipnskey", limitHandler(downloadLimiter, getNameHandler))
+	router.GET(namePrefix+":ipnskey/raw", limitHandler(downloadLimiter, rawNameHandler))
+	router.HEAD(pastePrefix+":cid", limitHandler(downloadLimiter, headPasteHandler))
+	router.GET(pastePrefix+":cid/related", limitHandler(renderLimiter, relatedHandler))
+	router.GET(pastePrefix+":cid/stats", pasteStatsHandler)
+	router.GET(pastePrefix+":cid/convert", limitHandler(renderLimiter, convertPasteHandler))
+	router.GET(pastePrefix+":cid/table", limitHandler(renderLimiter, tableHandler))
+	router.GET(pastePrefix+":cid/raw", limitHandler(downloadLimiter, rawPasteHandler))
+	router.GET(pastePrefix+":cid/icon.svg", identiconHandler)
+	router.GET(pastePrefix+":cid/verify", limitHandler(downloadLimiter, verifyPasteHandler))
+	if pasteDAG {
+		router.GET(pastePrefix+":cid/history", limitHandler(downloadLimiter, pasteHistoryHandler))
+	}
+	router.GET(pastePrefix+":cid/pake", limitHandler(downloadLimiter, pakeParamsHandler))
+	router.POST(pastePrefix+":cid/pake", limitHandler(downloadLimiter, pakeStartHandler))
+	router.POST(pastePrefix+":cid/pake/finish", limitHandler(downloadLimiter, pakeFinishHandler))
+	router.GET(signingKeyPath, signingKeyHandler)
+	router.GET(ageRecipientPath, ageRecipientHandler)
+	router.GET(jwksPath, jwksHandler)
+	router.POST(receiptVerifyPath, limitHandler(downloadLimiter, verifyReceiptHandler))
+	router.GET(prefsPath, getPrefsHandler)
+	router.POST(prefsPath, setPrefsHandler)
+	router.GET(pastePrefix+":cid/comments", limitHandler(downloadLimiter, getCommentsHandler))
+	router.GET(tagsPrefix+":tag", listTagHandler)
+	router.GET(recentPath, recentHandler)
+	router.GET(archivePath, limitHandler(downloadLimiter, archiveHandler))
+	router.GET(contentStatsPath, contentStatsHandler)
+	if dnslinkEnabled() {
+		router.GET(dnslinkPath, dnslinkHandler)
+	}
+	router.GET(collectionPrefix+"/:cid", getCollectionHandler)
+	router.GET(bundlePrefix+"/:cid", getBundleHandler)
+	router.GET(bundlePrefix+"/:cid/*file", limitHandler(downloadLimiter, getBundleFileHandler))
+	router.GET(sitePrefix+"/:cid", getSiteHandler)
+	router.GET(sitePrefix+"/:cid/*file", limitHandler(downloadLimiter, getSiteHandler))
+
+	// Add write HTTP routes if not a read-only replica
+	if !isReplica() {
+		router.POST("/", limitHandler(uploadLimiter, putPasteHandler))
+		router.POST(legacyPath, limitHandler(uploadLimiter, legacyPasteHandler))
+		router.POST(pastePrefix+":cid/fork", limitHandler(uploadLimiter, forkPasteHandler))
+		router.POST(apiPrefix+"ipns", limitHandler(uploadLimiter, publishNameHandler))
+		router.POST(pastePrefix+":cid/rekey", limitHandler(uploadLimiter, rekeyPasteHandler))
+		router.POST(pastePrefix+":cid/comments", limitHandler(uploadLimiter, postCommentHandler))
+		router.POST(pastePrefix+":cid/report", limitHandler(uploadLimiter, reportPasteHandler))
+		router.POST(collectionPrefix, limitHandler(uploadLimiter, createCollectionHandler))
+		router.POST(bundlePrefix, limitHandler(uploadLimiter, createBundleHandler))
+		router.POST(sitePrefix, limitHandler(uploadLimiter, createSiteHandler))
+		router.POST(sharePath, limitHandler(uploadLimiter, shareTargetHandler))
+		router.POST(collectionPrefix+"/:cid/:action", limitHandler(uploadLimiter, updateCollectionHandler))
+
+		// Pastes pushed by peer instances, only if some are trusted
+		if len(syncPeerKeys) > 0 {
+			router.POST(replicatePath+":cid", receivePasteHandler)
+		}
+	}
+
+	// Add federation HTTP routes if enabled
+	if federationEnabled {
+		router.GET(federationPrefix+"/self", federationSelfHandler)
+		router.GET(federationPrefix+"/peers", limitHandler(downloadLimiter, federationPeersHandler))
+		router.GET(federationPrefix+"/has/:cid", federationHasHandler)
+		router.POST(federationPrefix+"/announce", limitHandler(uploadLimiter, federationAnnounceHandler))
+	}
+
+	// Add admin HTTP routes if enabled
+	if adminToken != "" {
+		router.GET(apiPrefix+"blocks", adminHandler(listBlocksHandler))
+		router.GET(apiPrefix+"export.car", adminHandler(exportCarHandler))
+		router.GET(apiPrefix+"diagnostics", adminHandler(diagnosticsHandler))
+		router.POST(apiPrefix+"ipfs/restart", adminHandler(restartIPFSHandler))
+		router.GET(apiPrefix+"ipfs/metrics", adminHandler(ipfsMetricsHandler))
+		router.GET(metricsPath, adminHandler(metricsHandler))
+		router.POST(apiPrefix+"gc", adminHandler(repoGCHandler))
+		if mfsMirror {
+			router.GET(apiPrefix+"pastes", adminHandler(listPastesHandler))
+		}
+		if clusterEnabled() {
+			router.GET(apiPrefix+"replication/:cid", adminHandler(replicationHandler))
+		}
+		router.GET(apiPrefix+"block/:cid", adminHandler(getBlockHandler))
+		router.POST(apiPrefix+"sync", adminHandler(syncHandler))
+		router.GET(apiPrefix+"stats", adminHandler(adminStatsHandler))
+		router.GET(apiPrefix+"reports", adminHandler(abuseQueueHandler))
+		router.POST(apiPrefix+"reports/:cid/:action", adminHandler(moderatePasteHandler))
+		if !isReplica() {
+			router.POST(apiPrefix+"pin/:cid", adminHandler(pinHandler))
+			router.DELETE(apiPrefix+"pin/:cid", adminHandler(pinHandler))
+		}
+	}
 
 	// Create new HTTP server object
 	httpAddr := *httpBindAddr + ":" + strconv.Itoa(int(*httpPort))
@@ -473,7 +1858,8 @@ func main() {
 		WriteTimeout:      2 * time.Second,
 		IdleTimeout:       2 * time.Second,
 		ReadHeaderTimeout: 2 * time.Second,
-		Handler:           router,
+		Handler:           trackRequests(ipfsGate(router)),
+		ConnContext:       saveConn,
 		ErrorLog:          log.New(ioutil.Discard, "", 0),
 	}
 
@@ -482,8 +1868,25 @@ func main() {
 		*httpHostname = httpAddr
 	}
 
+	// Receipts name the instance by hostname, as does federation
+	instanceHostname = *httpHostname
+	if federationName == "" {
+		federationName = instanceHostname
+	}
+
 	// Construct the HTTP root site help string
-	rootHelpStr = fmt.Sprintf(rootHelpStr, *httpHostname, *httpHostname, *httpHostname, *httpHostname)
+	rootHelpStr = strings.ReplaceAll(rootHelpStr, "%s", *httpHostname)
+
+	// Obtain certificate via ACME if enabled, served in place of cert / key files
+	acmeDomains = parseACMEDomains(*acmeDomainsStr)
+	if acmeEnabled() {
+		err = setupACME(*acmeProvider, *acmeProviderConfig)
+		if err != nil {
+			fatalf("Failed to setup ACME - %s", err.Error())
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: acmeGetCertificate}
+		*certFile, *keyFile = "", ""
+	}
 
 	// Start HTTP server!
 	log.Printf("Starting HTTP server on: %s\n", httpAddr)
@@ -494,12 +1897,22 @@ func main() {
 		}
 	}()
 
+	// Start announcing to federation peers if any
+	if federationEnabled && len(federationPeers) > 0 {
+		startFederation()
+	}
+
 	// Setup channel for OS signals
 	log.Println("Listening for OS signals...")
-	signals := make(chan os.Signal)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
-
-	// Exit on signal
-	sig := <-signals
-	fatalf("Signal received %s, stopping!\n", sig)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	// Dump diagnostics on SIGQUIT, exit on anything else
+	for sig := range signals {
+		if sig == syscall.SIGQUIT {
+			dumpDiagnostics()
+			continue
+		}
+		fatalf("Signal received %s, stopping!\n", sig)
+	}
 }
@@ -1,14 +1,30 @@
 module github.com/grufwub/gibon
 
-go 1.14
+go 1.16
 
 require (
+	github.com/BurntSushi/toml v0.4.1
 	github.com/ipfs/fs-repo-migrations v1.6.3
+	github.com/ipfs/go-bitswap v0.2.19
+	github.com/ipfs/go-block-format v0.0.2
+	github.com/ipfs/go-cid v0.0.6
+	github.com/ipfs/go-datastore v0.4.4
 	github.com/ipfs/go-ipfs v0.6.0
 	github.com/ipfs/go-ipfs-config v0.8.0
 	github.com/ipfs/go-ipfs-files v0.0.8
+	github.com/ipfs/go-ipfs-pinner v0.0.4
+	github.com/ipfs/go-ipld-cbor v0.0.4
+	github.com/ipfs/go-mfs v0.1.2
 	github.com/ipfs/interface-go-ipfs-core v0.3.0
 	github.com/julienschmidt/httprouter v1.2.0
+	github.com/libp2p/go-libp2p-core v0.5.7
+	github.com/miekg/dns v1.1.29
+	github.com/miekg/pkcs11 v1.1.1
+	github.com/multiformats/go-multiaddr v0.2.2
+	github.com/multiformats/go-multihash v0.0.13
+	github.com/tetratelabs/wazero v1.0.0
+	github.com/yuin/goldmark v1.4.0
 	go.uber.org/ratelimit v0.1.0
 	golang.org/x/crypto v0.0.0-20200510223506-06a226fb4e37
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Paste event hook names
+	hookOnCreate = "on_create"
+	hookOnExpire = "on_expire"
+	hookOnDelete = "on_delete"
+
+	// Maximum hook output kept for logging
+	maxHookOutput = 4096
+)
+
+var (
+	// Shell commands run on paste events, by hook name (unset hooks are skipped)
+	hookCommands = map[string]*string{
+		hookOnCreate: new(string),
+		hookOnExpire: new(string),
+		hookOnDelete: new(string),
+	}
+
+	// Maximum hook run time before it is killed
+	hookTimeout time.Duration
+
+	// Limits concurrently running hook commands
+	hookLimiter limiter
+)
+
+type hookEvent struct {
+	Event     string     `json:"event"`
+	CID       string     `json:"cid"`
+	Size      int        `json:"size"`
+	Encrypted bool       `json:"encrypted"`
+	Public    bool       `json:"public"`
+	Meta      *pasteMeta `json:"meta"`
+	Time      time.Time  `json:"time"`
+}
+
+func newHookEvent(event string, c cid.Cid) *hookEvent {
+	hookEvt := &hookEvent{
+		Event: event,
+		CID:   c.String(),
+		Meta:  &pasteMeta{},
+		Time:  time.Now().UTC(),
+	}
+
+	// Gather what we know about the paste, missing info is left empty
+	content := &pasteContent{}
+	if indexGet(indexKey("content", c.String()), content) == nil {
+		hookEvt.Size = content.Size
+		hookEvt.Encrypted = content.Encrypted
+	}
+	hookEvt.Public, _ = isPublicPaste(c.String())
+	if meta, err := getPasteMeta(c); err == nil {
+		hookEvt.Meta = meta
+	}
+
+	return hookEvt
+}
+
+func runHook(event string, c cid.Cid) {
+	// Skip unconfigured hooks
+	command := *hookCommands[event]
+	if command == "" {
+		return
+	}
+
+	// Gather event info now, before any later changes
+	hookEvt := newHookEvent(event, c)
+
+	go func() {
+		// Wait for a slot, dropping the event if none free up in time
+		timer := time.NewTimer(hookTimeout)
+		defer timer.Stop()
+		if !hookLimiter.acquire(timer.C) {
+			log.Printf("Dropped %s hook for %s, too many hooks running\n", event, hookEvt.CID)
+			return
+		}
+		defer hookLimiter.release()
+
+		// Run the hook with timeout
+		err := execHook(command, hookEvt)
+		if err != nil {
+			log.Printf("Failed to run %s hook for %s - %s\n", event, hookEvt.CID, err.Error())
+		}
+	}()
+}
+
+func execHook(command string, hookEvt *hookEvent) error {
+	// Event JSON goes to stdin
+	stdin, err := json.Marshal(hookEvt)
+	if err != nil {
+		return err
+	}
+
+	// Run through shell with timeout
+	ctx, cancel := context.WithTimeout(globalContext, hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	// Event summary goes to environment
+	cmd.Env = append(os.Environ(),
+		"GIBON_EVENT="+hookEvt.Event,
+		"GIBON_CID="+hookEvt.CID,
+		"GIBON_PATH="+pastePrefix+hookEvt.CID,
+		"GIBON_SIZE="+strconv.Itoa(hookEvt.Size),
+		"GIBON_ENCRYPTED="+strconv.FormatBool(hookEvt.Encrypted),
+		"GIBON_PUBLIC="+strconv.FormatBool(hookEvt.Public),
+		"GIBON_TITLE="+hookEvt.Meta.Title,
+	)
+
+	// Run, logging (truncated) output on failure
+	output, err := cmd.CombinedOutput()
+	if err != nil && len(output) > 0 {
+		if len(output) > maxHookOutput {
+			output = output[:maxHookOutput]
+		}
+		log.Printf("Hook %s output: %s\n", hookEvt.Event, output)
+	}
+	return err
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RFC 9421 signature label used for all gibon signatures
+	httpSigLabel = "gibon"
+
+	// Maximum accepted signature age / clock skew
+	httpSigMaxAge = 5 * time.Minute
+)
+
+var (
+	// Signed request components (RFC 9421 derived components + RFC 9530 digest)
+	httpSigComponents = []string{"@method", "@authority", "@path", "@query", "content-digest"}
+
+	// Peer instance public keys accepted on sync requests (signatures not required if empty)
+	syncPeerKeys []ed25519.PublicKey
+)
+
+func parsePeerKeys(str string) ([]ed25519.PublicKey, error) {
+	keys := []ed25519.PublicKey{}
+	for _, keyStr := range strings.Split(str, ",") {
+		if keyStr = strings.TrimSpace(keyStr); keyStr == "" {
+			continue
+		}
+		b, err := decodeSigningKey(keyStr, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, errors.New("Invalid peer key " + keyStr)
+		}
+		keys = append(keys, ed25519.PublicKey(b))
+	}
+	return keys, nil
+}
+
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func httpSigComponent(request *http.Request, name string) (string, error) {
+	switch name {
+	case "@method":
+		return request.Method, nil
+	case "@authority":
+		return strings.ToLower(request.Host), nil
+	case "@path":
+		if path := request.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + request.URL.RawQuery, nil
+	default:
+		if strings.HasPrefix(name, "@") {
+			return "", errors.New("unsupported derived component " + name)
+		}
+		values, ok := request.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return "", errors.New("missing signed header " + name)
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.TrimSpace(value)
+		}
+		return strings.Join(trimmed, ", "), nil
+	}
+}
+
+func httpSigBase(request *http.Request, components []string, params string) ([]byte, error) {
+	// Each component line is '"name": value', ending with the signature params
+	buf := &strings.Builder{}
+	for _, name := range components {
+		value, err := httpSigComponent(request, name)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(strconv.Quote(name) + ": " + value + "\n")
+	}
+	buf.WriteString(`"@signature-params": ` + params)
+	return []byte(buf.String()), nil
+}
+
+func signHTTPRequest(request *http.Request, body []byte) error {
+	// Only sign if this instance has an identity
+	if serverSigningKey == nil {
+		return nil
+	}
+
+	// Authority must be set for outgoing requests
+	if request.Host == "" {
+		request.Host = request.URL.Host
+	}
+
+	// Digest the body so it's covered by the signature
+	request.Header.Set("Content-Digest", contentDigest(body))
+
+	// Build signature params, key ID is our base64url public key (as served at /signing-key)
+	quoted := make([]string, len(httpSigComponents))
+	for i, name := range httpSigComponents {
+		quoted[i] = strconv.Quote(name)
+	}
+	params := "(" + strings.Join(quoted, " ") + ")" +
+		";created=" + strconv.FormatInt(time.Now().Unix(), 10) +
+		";keyid=" + strconv.Quote(encodeSigningKey(serverSigningKey.Public().(ed25519.PublicKey))) +
+		`;alg="ed25519"`
+
+	// Sign the signature base, behind the HTTP signature context
+	base, err := httpSigBase(request, httpSigComponents, params)
+	if err != nil {
+		return err
+	}
+	sig := signWithContext(serverSigningKey, httpSigContext, base)
+
+	request.Header.Set("Signature-Input", httpSigLabel+"="+params)
+	request.Header.Set("Signature", httpSigLabel+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+func parseSigInput(input string) ([]string, string, map[string]string, error) {
+	// Only our own label is looked for, other signatures are ignored
+	var params string
+	for _, member := range strings.Split(input, ",") {
+		member = strings.TrimSpace(member)
+		if strings.HasPrefix(member, httpSigLabel+"=") {
+			params = strings.TrimPrefix(member, httpSigLabel+"=")
+			break
+		}
+	}
+	end := strings.IndexByte(params, ')')
+	if !strings.HasPrefix(params, "(") || end < 0 {
+		return nil, "", nil, errors.New("missing signature input")
+	}
+
+	// Covered components are space separated quoted strings
+	components := []string{}
+	for _, field := range strings.Fields(params[1:end]) {
+		name, err := strconv.Unquote(field)
+		if err != nil {
+			return nil, "", nil, errors.New("invalid signature component")
+		}
+		components = append(components, name)
+	}
+
+	// Parameters are ';name=value', strings quoted
+	values := map[string]string{}
+	for _, param := range strings.Split(params[end+1:], ";") {
+		if param == "" {
+			continue
+		}
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, "", nil, errors.New("invalid signature parameter")
+		}
+		if unquoted, err := strconv.Unquote(kv[1]); err == nil {
+			kv[1] = unquoted
+		}
+		values[kv[0]] = kv[1]
+	}
+
+	return components, params, values, nil
+}
+
+func verifyHTTPRequest(request *http.Request, body []byte, keys []ed25519.PublicKey) error {
+	// Parse signature input
+	components, params, values, err := parseSigInput(request.Header.Get("Signature-Input"))
+	if err != nil {
+		return err
+	}
+	if values["alg"] != "" && values["alg"] != "ed25519" {
+		return errors.New("unsupported signature algorithm")
+	}
+
+	// Every required component must be covered
+	for _, required := range httpSigComponents {
+		covered := false
+		for _, name := range components {
+			covered = covered || name == required
+		}
+		if !covered {
+			return errors.New("signature does not cover " + required)
+		}
+	}
+
+	// Reject stale (or future) signatures
+	created, err := strconv.ParseInt(values["created"], 10, 64)
+	if err != nil {
+		return errors.New("invalid signature creation time")
+	}
+	if age := time.Since(time.Unix(created, 0)); age > httpSigMaxAge || age < -httpSigMaxAge {
+		return errors.New("signature expired")
+	}
+
+	// Ensure body matches the signed digest
+	if request.Header.Get("Content-Digest") != contentDigest(body) {
+		return errors.New("content digest mismatch")
+	}
+
+	// Get signature value
+	var sigStr string
+	for _, member := range strings.Split(request.Header.Get("Signature"), ",") {
+		member = strings.TrimSpace(member)
+		if strings.HasPrefix(member, httpSigLabel+"=:") {
+			sigStr = strings.Trim(strings.TrimPrefix(member, httpSigLabel+"="), ":")
+			break
+		}
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigStr)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("invalid signature")
+	}
+
+	// Verify against the key named by key ID, which must be trusted
+	base, err := httpSigBase(request, components, params)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if encodeSigningKey(key) == values["keyid"] {
+			if !verifyWithContext(key, httpSigContext, base, sig) {
+				return errors.New("signature does not verify")
+			}
+			return nil
+		}
+	}
+	return errors.New("untrusted signing key")
+}
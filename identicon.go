@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Identicon grid size (left half mirrored onto right)
+	identiconSize = 5
+)
+
+func identiconSVG(c cid.Cid) []byte {
+	// Hash the CID so every bit is evenly distributed
+	hash := sha256.Sum256(c.Bytes())
+
+	// Foreground hue and lightness from the first bytes
+	hue := (int(hash[0])<<8 | int(hash[1])) % 360
+	lightness := 35 + int(hash[2])%20
+	colour := fmt.Sprintf("hsl(%d,65%%,%d%%)", hue, lightness)
+
+	// Fill cells of the left half (and centre column) from hash bits, mirrored
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="64" height="64" shape-rendering="crispEdges">`, identiconSize+2, identiconSize+2)
+	buf.WriteString(`<rect width="100%" height="100%" fill="#f0f0f0"/>`)
+	fmt.Fprintf(buf, `<g fill="%s">`, colour)
+	bit := 0
+	for x := 0; x < (identiconSize+1)/2; x++ {
+		for y := 0; y < identiconSize; y++ {
+			on := hash[3+bit/8]&(1<<uint(bit%8)) != 0
+			bit++
+			if !on {
+				continue
+			}
+			fmt.Fprintf(buf, `<rect x="%d" y="%d" width="1" height="1"/>`, x+1, y+1)
+			if mirror := identiconSize - 1 - x; mirror != x {
+				fmt.Fprintf(buf, `<rect x="%d" y="%d" width="1" height="1"/>`, mirror+1, y+1)
+			}
+		}
+	}
+	buf.WriteString(`</g></svg>`)
+
+	return []byte(buf.String())
+}
+
+func identiconHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/icon.svg", request.RemoteAddr)
+
+	// Decode the paste CID, the icon is derived from it alone so paste need not be fetched
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Icons never change for a CID
+	etag := `"` + c.String() + `"`
+	writer.Header().Set("Cache-Control", staticCacheImmutable)
+	writer.Header().Set("ETag", etag)
+	if request.Header.Get("If-None-Match") == etag {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Write the icon
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.Header().Set("content-type", "image/svg+xml")
+	writer.Write(identiconSVG(c))
+}
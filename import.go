@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+const (
+	importCommand = "import"
+)
+
+type importOptions struct {
+	input     string
+	overwrite bool
+	noPin     bool
+}
+
+type importStats struct {
+	blocks  int
+	pinned  int
+	entries int
+	skipped int
+	rebuilt int
+}
+
+func parseImportArgs(args []string) (*importOptions, error) {
+	opts := &importOptions{}
+	flags := flag.NewFlagSet(importCommand, flag.ContinueOnError)
+	flags.BoolVar(&opts.overwrite, "overwrite", false, "Replace local index entries with those in the CAR (kept by default)")
+	flags.BoolVar(&opts.noPin, "no-pin", false, "Leave imported pastes, bundles, comments and collections unpinned, so repo GC collects them")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	} else if flags.NArg() != 1 {
+		return nil, errors.New("Usage: gibon [flags] import [-overwrite] [-no-pin] <in.car>")
+	}
+	opts.input = flags.Arg(0)
+	return opts, nil
+}
+
+func openCarData(reader io.Reader) (io.Reader, error) {
+	// CARv2 files lead with a fixed pragma, anything else is read as CARv1
+	buffered := bufio.NewReader(reader)
+	pragma, err := buffered.Peek(len(carV2Pragma))
+	if err != nil || !bytes.Equal(pragma, carV2Pragma) {
+		return buffered, nil
+	}
+
+	// Read the CARv2 header for where the CARv1 data lies
+	header := make([]byte, len(carV2Pragma)+carV2HeaderSize)
+	_, err = io.ReadFull(buffered, header)
+	if err != nil {
+		return nil, err
+	}
+	header = header[len(carV2Pragma):]
+	dataOffset := binary.LittleEndian.Uint64(header[16:])
+	dataSize := binary.LittleEndian.Uint64(header[24:])
+	if dataOffset < uint64(len(carV2Pragma)+carV2HeaderSize) {
+		return nil, errors.New("Invalid CARv2 data offset")
+	}
+
+	// Skip to the data, reading no further than its end (the index after is unused)
+	_, err = io.CopyN(ioutil.Discard, buffered, int64(dataOffset)-int64(len(carV2Pragma)+carV2HeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(buffered, int64(dataSize)), nil
+}
+
+func importIndexPage(page *exportIndexPage, opts *importOptions, stats *importStats) error {
+	for _, entry := range page.Entries {
+		// Only ever restore into the local index namespace
+		key := ds.RawKey(entry.Key)
+		if !strings.HasPrefix(key.String(), "/gibon/") {
+			return errors.New("Invalid index key in CAR: " + entry.Key)
+		}
+
+		// Keep existing entries unless overwriting
+		if !opts.overwrite {
+			has, err := indexStore.Has(key)
+			if err != nil {
+				return err
+			} else if has {
+				stats.skipped++
+				continue
+			}
+		}
+
+		err := indexStore.Put(key, entry.Value)
+		if err != nil {
+			return err
+		}
+		stats.entries++
+	}
+	return nil
+}
+
+func rebuildPasteContent(c cid.Cid, b []byte, stats *importStats) error {
+	// Only enveloped pastes, legacy raw pastes can't be told apart from site files
+	if !bytes.HasPrefix(b, pasteEnvelopeMagic) {
+		return nil
+	}
+	p, err := unmarshalPaste(b)
+	if err != nil {
+		return nil
+	}
+
+	// Record content info if the index didn't carry it
+	has, err := indexStore.Has(indexKey("content", c.String()))
+	if err != nil || has {
+		return err
+	}
+	stats.rebuilt++
+	return recordPasteContent(c, len(b), p.encrypted)
+}
+
+func runImport(opts *importOptions) error {
+	// Open the CAR, v1 or v2
+	file, err := os.Open(opts.input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	data, err := openCarData(file)
+	if err != nil {
+		return err
+	}
+
+	// Block CIDs are verified as they are read, sections are size limited
+	carReader, err := newCarReader(data)
+	if err != nil {
+		return err
+	}
+
+	// Exports and sync CARs name their index root
+	var root cid.Cid
+	if len(carReader.header.Roots) == 1 {
+		root = carReader.header.Roots[0]
+	}
+	pages := map[cid.Cid]bool{}
+
+	// Stream each block into its shard, or the index if an export index page
+	stats := &importStats{}
+	pasteCIDs := []cid.Cid{}
+	pinCIDs := []cid.Cid{}
+	for {
+		block, err := carReader.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		c := block.Cid()
+
+		// Export root lists the index pages, written before them (other CARs' roots are just blocks)
+		if root.Defined() && c.Equals(root) && c.Type() == cid.DagCBOR {
+			exportRoot := &exportRoot{}
+			if cbor.DecodeInto(block.RawData(), exportRoot) == nil {
+				for _, page := range exportRoot.Index {
+					pages[page] = true
+				}
+				continue
+			}
+		}
+
+		// Index pages go into the local index
+		if pages[c] {
+			page := &exportIndexPage{}
+			err = cbor.DecodeInto(block.RawData(), page)
+			if err != nil {
+				return err
+			}
+			err = importIndexPage(page, opts, stats)
+			if err != nil {
+				return err
+			}
+			delete(pages, c)
+			continue
+		}
+
+		// Everything else is a block for the responsible shard
+		err = shardForCID(c).node.Blockstore.Put(block)
+		if err != nil {
+			return err
+		}
+		if isPasteCID(c) {
+			pasteCIDs = append(pasteCIDs, c)
+		}
+		if isPasteCID(c) || c.Type() == cid.DagCBOR {
+			pinCIDs = append(pinCIDs, c)
+		}
+		stats.blocks++
+	}
+	if len(pages) > 0 {
+		return errors.New("CAR is missing index pages listed by its root")
+	}
+
+	// Pin pastes and DAG nodes (bundles, comments, collections) so repo GC keeps them
+	if !opts.noPin {
+		err = pinNodes(globalContext, pinCIDs)
+		if err != nil {
+			return err
+		}
+		stats.pinned = len(pinCIDs)
+	}
+
+	// Rebuild what the index lacks for imported pastes, now any index entries are in
+	for _, c := range pasteCIDs {
+		block, err := shardForCID(c).node.Blockstore.Get(c)
+		if err != nil {
+			return err
+		}
+		err = rebuildPasteContent(c, block.RawData(), stats)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Imported %d blocks (%d pinned) and %d index entries (%d existing kept, %d rebuilt)\n", stats.blocks, stats.pinned, stats.entries, stats.skipped, stats.rebuilt)
+	return nil
+}
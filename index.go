@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+var (
+	// Local index store for paste bookkeeping (lives in first shard's datastore)
+	indexStore ds.Datastore
+)
+
+func indexKey(namespace, name string) ds.Key {
+	return ds.NewKey("/gibon/" + namespace).ChildString(name)
+}
+
+func indexGet(key ds.Key, v interface{}) error {
+	// Get raw value for key
+	b, err := indexStore.Get(key)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal into supplied value
+	return json.Unmarshal(b, v)
+}
+
+func indexPut(key ds.Key, v interface{}) error {
+	// Marshal the supplied value
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// Put raw value for key
+	return indexStore.Put(key, b)
+}
+
+func indexList(namespace string) ([]string, error) {
+	// Query all keys under namespace
+	results, err := indexStore.Query(dsq.Query{
+		Prefix:   "/gibon/" + namespace + "/",
+		KeysOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	// Collect the key names
+	names := []string{}
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		names = append(names, ds.RawKey(result.Key).BaseNamespace())
+	}
+
+	return names, nil
+}
+
+func indexDelete(key ds.Key) error {
+	// Deleting a missing key is not an error
+	err := indexStore.Delete(key)
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	return err
+}
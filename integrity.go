@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const (
+	// HMAC-SHA256 tag size, stored after any signature block
+	pasteMACSize = sha256.Size
+
+	// Integrity statuses returned in X-Paste-Integrity
+	integrityNone       = "none"
+	integrityValid      = "valid"
+	integrityUnverified = "unverified"
+)
+
+var (
+	// Server integrity secret file (integrity MACs disabled if unset)
+	integrityKeyFile string
+
+	// Server integrity secret, loaded from file
+	integrityKey []byte
+
+	// Returned when a paste block's MAC does not match
+	errPasteIntegrity = errors.New("paste failed integrity check")
+)
+
+func readSecretFile(path string, size int) ([]byte, error) {
+	// Generate new secret if file doesn't exist yet
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		secret := make([]byte, size)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(path, []byte(encodeSigningKey(secret)+"\n"), 0600)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Generated new secret at %s\n", path)
+		return secret, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Decode existing secret
+	secret, err := decodeSigningKey(string(b), size)
+	if err != nil {
+		return nil, errors.New("Invalid secret file " + path + " - " + err.Error())
+	}
+	return secret, nil
+}
+
+func setupIntegrityKey() error {
+	// Skip if disabled
+	if integrityKeyFile == "" {
+		return nil
+	}
+
+	// Load secret from file, generating a new one on first run
+	secret, err := readSecretFile(integrityKeyFile, sha256.Size)
+	if err != nil {
+		return err
+	}
+	integrityKey = secret
+	return nil
+}
+
+func (p *paste) integrityMAC() []byte {
+	// MAC covers everything stored after the header flags
+	mac := hmac.New(sha256.New, integrityKey)
+	mac.Write([]byte{p.codec})
+	mac.Write(p.signer)
+	mac.Write(p.signature)
+	mac.Write(p.text)
+	return mac.Sum(nil)
+}
+
+func (p *paste) sealIntegrity() {
+	// Only plaintext pastes are MACed, ciphertext is already authenticated
+	if integrityKey == nil || p.encrypted {
+		p.mac = nil
+		return
+	}
+	p.mac = p.integrityMAC()
+}
+
+func (p *paste) checkIntegrity() error {
+	switch {
+	case p.mac == nil:
+		p.integrity = integrityNone
+	case integrityKey == nil:
+		p.integrity = integrityUnverified
+	case !hmac.Equal(p.mac, p.integrityMAC()):
+		return errPasteIntegrity
+	default:
+		p.integrity = integrityValid
+	}
+	return nil
+}
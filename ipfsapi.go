@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// Storage backend name for an external IPFS daemon
+	ipfsAPIBackendName = "ipfs-api"
+
+	// Deadline for each IPFS HTTP API call
+	ipfsAPITimeout = time.Minute
+
+	// Maximum IPFS HTTP API error / JSON response size
+	maxIPFSAPIResponseSize = 64 * 1024
+)
+
+var (
+	// HTTP client used for IPFS HTTP API calls
+	ipfsAPIClient = &http.Client{Timeout: ipfsAPITimeout}
+)
+
+// ipfsAPIError is an error response from the IPFS HTTP API
+type ipfsAPIError struct {
+	Status  string
+	Message string `json:"Message"`
+}
+
+func (err *ipfsAPIError) Error() string {
+	return "IPFS API responded with: " + err.Status + " " + err.Message
+}
+
+// ipfsAPIBackend stores paste blocks in an external IPFS daemon over its
+// HTTP RPC API, so the embedded repo shards only hold the local index.
+type ipfsAPIBackend struct {
+	url string
+}
+
+func init() {
+	// Register external IPFS daemon backend, config is the API base URL
+	RegisterBackend(ipfsAPIBackendName, func(config string) (Backend, error) {
+		apiURL, err := url.Parse(config)
+		if err != nil {
+			return nil, err
+		} else if apiURL.Scheme != "http" && apiURL.Scheme != "https" {
+			return nil, errors.New("IPFS API backend config must be the daemon's API URL, e.g. http://127.0.0.1:5001")
+		}
+		return &ipfsAPIBackend{url: strings.TrimSuffix(config, "/")}, nil
+	})
+}
+
+func (backend *ipfsAPIBackend) call(ctx context.Context, command string, args url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	// Every RPC API command is a POST
+	request, err := http.NewRequestWithContext(ctx, "POST", backend.url+"/api/v0/"+command+"?"+args.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("content-type", contentType)
+	}
+
+	// Send, decoding the daemon's error message on failure
+	response, err := ipfsAPIClient.Do(request)
+	if err != nil {
+		return nil, err
+	} else if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		apiErr := &ipfsAPIError{}
+		json.NewDecoder(io.LimitReader(response.Body, maxIPFSAPIResponseSize)).Decode(apiErr)
+		apiErr.Status = response.Status
+		return nil, apiErr
+	}
+	return response, nil
+}
+
+func (backend *ipfsAPIBackend) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	// Stat offline, so the daemon doesn't go looking on the network
+	response, err := backend.call(ctx, "block/stat", url.Values{"arg": {c.String()}, "offline": {"true"}}, nil, "")
+	if apiErr, ok := err.(*ipfsAPIError); ok && strings.Contains(apiErr.Message, "not found") {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	return true, nil
+}
+
+func (backend *ipfsAPIBackend) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	response, err := backend.call(ctx, "block/get", url.Values{"arg": {c.String()}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return readPasteBlock(response.Body)
+}
+
+func (backend *ipfsAPIBackend) Put(ctx context.Context, c cid.Cid, b []byte, opts PutOptions) error {
+	// Block API takes the CID version as its format, v0 or the codec name
+	prefix := c.Prefix()
+	format := "v0"
+	if prefix.Version == 1 {
+		format = cid.CodecToStr[prefix.Codec]
+	}
+
+	// Send the block as a multipart file
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("file", c.String())
+	if err != nil {
+		return err
+	}
+	part.Write(b)
+	err = form.Close()
+	if err != nil {
+		return err
+	}
+	args := url.Values{
+		"format": {format},
+		"mhtype": {mh.Codes[prefix.MhType]},
+		"mhlen":  {"-1"},
+		"pin":    {strconv.FormatBool(opts.Pin)},
+	}
+	response, err := backend.call(ctx, "block/put", args, body, form.FormDataContentType())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// Ensure it resolved to the same CID
+	stat := struct {
+		Key string `json:"Key"`
+	}{}
+	err = json.NewDecoder(io.LimitReader(response.Body, maxIPFSAPIResponseSize)).Decode(&stat)
+	if err != nil {
+		return err
+	}
+	key, err := cid.Decode(stat.Key)
+	if err != nil {
+		return err
+	} else if !key.Equals(c) {
+		return errors.New("IPFS block CID mismatch")
+	}
+	return nil
+}
+
+func (backend *ipfsAPIBackend) Pin(ctx context.Context, c cid.Cid) error {
+	// Direct pin, paste blocks have no links to follow
+	response, err := backend.call(ctx, "pin/add", url.Values{"arg": {c.String()}, "recursive": {"false"}}, nil, "")
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (backend *ipfsAPIBackend) Unpin(ctx context.Context, c cid.Cid) error {
+	response, err := backend.call(ctx, "pin/rm", url.Values{"arg": {c.String()}}, nil, "")
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	bitswap "github.com/ipfs/go-bitswap"
+)
+
+const (
+	metricsPath = "/metrics"
+
+	// How long collected IPFS metrics are reused, counting blocks walks the whole blockstore
+	ipfsMetricsTTL = 30 * time.Second
+)
+
+var (
+	// Last collected IPFS metrics, guarded by mutex
+	ipfsMetricsCache      *ipfsMetrics
+	ipfsMetricsCacheMutex sync.Mutex
+)
+
+type shardMetrics struct {
+	Repo              string `json:"repo"`
+	Blocks            int    `json:"blocks"`
+	RepoSize          uint64 `json:"repo_size"`
+	Online            bool   `json:"online"`
+	Peers             int    `json:"peers"`
+	Wantlist          int    `json:"wantlist"`
+	BlocksReceived    uint64 `json:"blocks_received"`
+	BlocksSent        uint64 `json:"blocks_sent"`
+	DupBlocksReceived uint64 `json:"dup_blocks_received"`
+	BytesReceived     uint64 `json:"bytes_received"`
+	BytesSent         uint64 `json:"bytes_sent"`
+	SwarmBytesIn      int64  `json:"swarm_bytes_in"`
+	SwarmBytesOut     int64  `json:"swarm_bytes_out"`
+}
+
+type ipfsMetrics struct {
+	CollectedAt time.Time       `json:"collected_at"`
+	Shards      []*shardMetrics `json:"shards"`
+}
+
+func collectShardMetrics(ctx context.Context, shard *ipfsShard) (*shardMetrics, error) {
+	metrics := &shardMetrics{Repo: shard.repoPath, Online: ipfsOnline}
+
+	// Count stored blocks and repo size
+	keys, err := shard.node.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for range keys {
+		metrics.Blocks++
+	}
+	metrics.RepoSize, err = shard.node.Repo.GetStorageUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	// Bitswap only runs online, offline nodes use a plain exchange
+	if bs, ok := shard.node.Exchange.(*bitswap.Bitswap); ok {
+		stat, err := bs.Stat()
+		if err != nil {
+			return nil, err
+		}
+		metrics.Peers = len(stat.Peers)
+		metrics.Wantlist = len(stat.Wantlist)
+		metrics.BlocksReceived = stat.BlocksReceived
+		metrics.BlocksSent = stat.BlocksSent
+		metrics.DupBlocksReceived = stat.DupBlksReceived
+		metrics.BytesReceived = stat.DataReceived
+		metrics.BytesSent = stat.DataSent
+	}
+
+	// Swarm totals include DHT and pubsub traffic too
+	if shard.node.Reporter != nil {
+		totals := shard.node.Reporter.GetBandwidthTotals()
+		metrics.SwarmBytesIn = totals.TotalIn
+		metrics.SwarmBytesOut = totals.TotalOut
+	}
+
+	return metrics, nil
+}
+
+func collectIPFSMetrics(ctx context.Context) (*ipfsMetrics, error) {
+	ipfsMetricsCacheMutex.Lock()
+	defer ipfsMetricsCacheMutex.Unlock()
+
+	// Reuse recent metrics, scrapes are frequent
+	if ipfsMetricsCache != nil && time.Since(ipfsMetricsCache.CollectedAt) < ipfsMetricsTTL {
+		return ipfsMetricsCache, nil
+	}
+
+	metrics := &ipfsMetrics{CollectedAt: time.Now(), Shards: []*shardMetrics{}}
+	for _, shard := range ipfsShards {
+		shardMetrics, err := collectShardMetrics(ctx, shard)
+		if err != nil {
+			return nil, err
+		}
+		metrics.Shards = append(metrics.Shards, shardMetrics)
+	}
+
+	ipfsMetricsCache = metrics
+	return metrics, nil
+}
+
+func writePrometheusMetrics(writer http.ResponseWriter, metrics *ipfsMetrics) {
+	// One gauge or counter per field, labelled by repo
+	families := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(*shardMetrics) string
+	}{
+		{"gibon_ipfs_blocks", "gauge", "Blocks stored in the repo", func(m *shardMetrics) string { return strconv.Itoa(m.Blocks) }},
+		{"gibon_ipfs_repo_size_bytes", "gauge", "Repo storage usage", func(m *shardMetrics) string { return strconv.FormatUint(m.RepoSize, 10) }},
+		{"gibon_ipfs_bitswap_peers", "gauge", "Bitswap partner peers", func(m *shardMetrics) string { return strconv.Itoa(m.Peers) }},
+		{"gibon_ipfs_bitswap_wantlist", "gauge", "Blocks on the bitswap wantlist", func(m *shardMetrics) string { return strconv.Itoa(m.Wantlist) }},
+		{"gibon_ipfs_bitswap_blocks_received_total", "counter", "Blocks received over bitswap", func(m *shardMetrics) string { return strconv.FormatUint(m.BlocksReceived, 10) }},
+		{"gibon_ipfs_bitswap_blocks_sent_total", "counter", "Blocks sent over bitswap", func(m *shardMetrics) string { return strconv.FormatUint(m.BlocksSent, 10) }},
+		{"gibon_ipfs_bitswap_dup_blocks_received_total", "counter", "Duplicate blocks received over bitswap", func(m *shardMetrics) string { return strconv.FormatUint(m.DupBlocksReceived, 10) }},
+		{"gibon_ipfs_bitswap_received_bytes_total", "counter", "Block data received over bitswap", func(m *shardMetrics) string { return strconv.FormatUint(m.BytesReceived, 10) }},
+		{"gibon_ipfs_bitswap_sent_bytes_total", "counter", "Block data sent over bitswap", func(m *shardMetrics) string { return strconv.FormatUint(m.BytesSent, 10) }},
+		{"gibon_ipfs_swarm_received_bytes_total", "counter", "Bytes received over the libp2p swarm", func(m *shardMetrics) string { return strconv.FormatInt(m.SwarmBytesIn, 10) }},
+		{"gibon_ipfs_swarm_sent_bytes_total", "counter", "Bytes sent over the libp2p swarm", func(m *shardMetrics) string { return strconv.FormatInt(m.SwarmBytesOut, 10) }},
+	}
+
+	writer.Header().Set("content-type", "text/plain; version=0.0.4")
+	for _, family := range families {
+		fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		for _, shard := range metrics.Shards {
+			fmt.Fprintf(writer, "%s{repo=%q} %s\n", family.name, shard.Repo, family.value(shard))
+		}
+	}
+}
+
+func metricsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", metricsPath, request.RemoteAddr)
+
+	// Collect (or reuse) IPFS metrics
+	metrics, err := collectIPFSMetrics(request.Context())
+	if err != nil {
+		log.Printf("Failed to collect IPFS metrics - %s\n", err.Error())
+		http.Error(writer, "Failed to collect IPFS metrics", http.StatusInternalServerError)
+		return
+	}
+
+	writePrometheusMetrics(writer, metrics)
+}
+
+func ipfsMetricsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", apiPrefix+"ipfs/metrics", request.RemoteAddr)
+
+	// Collect (or reuse) IPFS metrics
+	metrics, err := collectIPFSMetrics(request.Context())
+	if err != nil {
+		log.Printf("Failed to collect IPFS metrics - %s\n", err.Error())
+		http.Error(writer, "Failed to collect IPFS metrics", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(writer, metrics)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	icorepath "github.com/ipfs/interface-go-ipfs-core/path"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	namePrefix = "/name/"
+
+	// Prefix for paste pointer IPNS keys in the node keystore
+	nameKeyPrefix = "paste-"
+
+	// Deadline for resolving a name to its current paste
+	nameResolveTimeout = time.Minute
+
+	// Maximum publish request body size
+	maxNameRequestSize = 1024
+)
+
+var (
+	// Serialises name record updates, so concurrent publishes can't interleave
+	nameMutex sync.Mutex
+
+	// Returned when a name's update token doesn't match
+	errNameToken = errors.New("invalid name update token")
+
+	// Returned when a name to update isn't an IPNS key
+	errInvalidName = errors.New("Invalid IPNS name!")
+
+	// Returned when creating a name would exceed the name limit
+	errNameLimit = errors.New("Name limit reached!")
+
+	// New names allowed per client per hour (0 disables creating names)
+	nameRate uint
+
+	// Maximum names, each holding a node keystore key (0 is unlimited)
+	maxNames uint
+
+	// Name creation allowance by client
+	nameRates = newClientRates()
+)
+
+type nameRecord struct {
+	KeyName   string `json:"key_name"`
+	TokenHash string `json:"token_hash"`
+	Paste     string `json:"paste"`
+}
+
+type namePublishRequest struct {
+	Paste string `json:"paste"`
+	Name  string `json:"name,omitempty"`
+}
+
+type namePublishResponse struct {
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	Paste string `json:"paste"`
+	Token string `json:"token,omitempty"`
+}
+
+func init() {
+	// Name update tokens are secrets like keys
+	secretHeaders["ipns_token"] = "X-Gibon-IPNS-Token"
+}
+
+func hashNameToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func createName(c cid.Cid) (cid.Cid, string, error) {
+	// Each name holds a keystore key, so they're capped
+	if maxNames > 0 {
+		names, err := indexList("ipns")
+		if err != nil {
+			return cid.Undef, "", err
+		} else if uint(len(names)) >= maxNames {
+			return cid.Undef, "", errNameLimit
+		}
+	}
+
+	// Random update token, whose hash also names the key
+	token, err := generatePasteKey()
+	if err != nil {
+		return cid.Undef, "", err
+	}
+	hash := hashNameToken(token)
+	keyName := nameKeyPrefix + hash[:32]
+
+	// Generate the name's key
+	key, err := ipfsAPI.Key().Generate(globalContext, keyName)
+	if err != nil {
+		return cid.Undef, "", err
+	}
+	name := cid.NewCidV1(cid.Libp2pKey, mh.Multihash(key.ID()))
+
+	// Record before publishing, so the token works even if publishing fails
+	err = indexPut(indexKey("ipns", name.String()), &nameRecord{
+		KeyName:   keyName,
+		TokenHash: hash,
+		Paste:     c.String(),
+	})
+	if err != nil {
+		return cid.Undef, "", err
+	}
+	return name, token, publishName(keyName, c)
+}
+
+func updateName(name, c cid.Cid, token string) error {
+	// Look up the name record, checking the token
+	record := &nameRecord{}
+	err := indexGet(indexKey("ipns", name.String()), record)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashNameToken(token)), []byte(record.TokenHash)) != 1 {
+		return errNameToken
+	}
+
+	// Point the name at the new paste
+	record.Paste = c.String()
+	err = indexPut(indexKey("ipns", name.String()), record)
+	if err != nil {
+		return err
+	}
+	return publishName(record.KeyName, c)
+}
+
+func publishName(keyName string, c cid.Cid) error {
+	// Recorded locally even if the node is offline
+	_, err := ipfsAPI.Name().Publish(globalContext, icorepath.IpfsPath(c), options.Name.Key(keyName), options.Name.AllowOffline(true))
+	return err
+}
+
+func resolveName(ctx context.Context, name cid.Cid) (cid.Cid, error) {
+	// Resolve to the currently published paste
+	p, err := ipfsAPI.Name().Resolve(ctx, "/ipns/"+name.String())
+	if err != nil {
+		return cid.Undef, err
+	}
+	resolved, err := ipfsAPI.ResolvePath(ctx, p)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return resolved.Cid(), nil
+}
+
+func republishEditedPaste(request *http.Request, c cid.Cid) (string, error) {
+	// Only if the editor supplied a name to move
+	nameStr := request.URL.Query().Get("ipns")
+	if nameStr == "" {
+		return "", nil
+	}
+	name, err := cid.Decode(nameStr)
+	if err != nil || name.Type() != cid.Libp2pKey {
+		return "", errInvalidName
+	}
+	token, err := requestSecret(request, "ipns_token")
+	if err != nil {
+		return "", err
+	}
+
+	nameMutex.Lock()
+	defer nameMutex.Unlock()
+	return namePrefix + name.String(), updateName(name, c, token)
+}
+
+func publishNameHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", apiPrefix+"ipns", request.RemoteAddr)
+
+	// Decode the publish request
+	publish := &namePublishRequest{}
+	err := json.NewDecoder(io.LimitReader(request.Body, maxNameRequestSize)).Decode(publish)
+	if err != nil {
+		http.Error(writer, "Invalid publish request!", http.StatusBadRequest)
+		return
+	}
+	c, err := cid.Decode(publish.Paste)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Only point names at available pastes stored here
+	if refuseUnavailablePaste(writer, c) {
+		return
+	}
+	has, err := storageBackend.Has(request.Context(), c)
+	if err != nil || !has {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// New names are limited per client
+	if publish.Name == "" {
+		if nameRate == 0 {
+			http.Error(writer, "Creating names not enabled!", http.StatusForbidden)
+			return
+		}
+		wait, _ := nameRates.take(request, nameRate, time.Hour)
+		if wait > 0 {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(writer, "Name rate limit exceeded, slow down!", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	nameMutex.Lock()
+	defer nameMutex.Unlock()
+
+	// Create a new name, or move an existing one with its token
+	response := &namePublishResponse{Paste: c.String()}
+	var name cid.Cid
+	if publish.Name == "" {
+		name, response.Token, err = createName(c)
+	} else {
+		name, err = cid.Decode(publish.Name)
+		if err != nil || name.Type() != cid.Libp2pKey {
+			http.Error(writer, errInvalidName.Error(), http.StatusBadRequest)
+			return
+		}
+		var token string
+		token, err = requestSecret(request, "ipns_token")
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = updateName(name, c, token)
+	}
+	if err == errNameToken || err == ds.ErrNotFound {
+		http.Error(writer, "Unauthorized!", http.StatusUnauthorized)
+		return
+	} else if err == errNameLimit {
+		http.Error(writer, err.Error(), http.StatusInsufficientStorage)
+		return
+	} else if err != nil {
+		log.Printf("Failed to publish paste name - %s\n", err.Error())
+		http.Error(writer, "Failed to publish paste name", http.StatusInternalServerError)
+		return
+	}
+	response.Name = name.String()
+	response.Path = namePrefix + name.String()
+
+	// Never cache responses carrying a token
+	if response.Token != "" {
+		writer.Header().Set("Cache-Control", "no-store")
+	}
+	writeJSON(writer, response)
+}
+
+func resolveNameParams(writer http.ResponseWriter, request *http.Request, params httprouter.Params) (httprouter.Params, bool) {
+	// Get the IPNS name string
+	nameStr := params.ByName("ipnskey")
+
+	// Decode the IPNS name
+	name, err := cid.Decode(nameStr)
+	if err != nil || name.Type() != cid.Libp2pKey {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return nil, false
+	}
+
+	// Resolve to the current paste
+	ctx, cancel := context.WithTimeout(request.Context(), nameResolveTimeout)
+	defer cancel()
+	c, err := resolveName(ctx, name)
+	if err != nil {
+		log.Printf("Paste name not resolved - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return nil, false
+	}
+
+	// Mutable, so never cached for long
+	writer.Header().Set("Cache-Control", "no-cache")
+	return httprouter.Params{{Key: "cid", Value: c.String()}}, true
+}
+
+func getNameHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Log the request
+	logRequest(request.Method, namePrefix+params.ByName("ipnskey"), request.RemoteAddr)
+
+	// Serve as the current paste's CID route
+	if params, ok := resolveNameParams(writer, request, params); ok {
+		getPasteHandler(writer, request, params)
+	}
+}
+
+func rawNameHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Log the request
+	logRequest("GET", namePrefix+params.ByName("ipnskey")+"/raw", request.RemoteAddr)
+
+	// Serve as the current paste's raw CID route
+	if params, ok := resolveNameParams(writer, request, params); ok {
+		rawPasteHandler(writer, request, params)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"math"
+
+	"github.com/grufwub/gibon/crypto"
+)
+
+const (
+	// Passphrase KDFs selectable for new pastes
+	kdfArgon2id = "argon2id"
+	kdfPBKDF2   = "pbkdf2"
+)
+
+var (
+	// Passphrase KDF used for new pastes, old pastes keep the one in their envelope
+	kdfName string
+
+	// Argon2id parameters used for new pastes (memory in KiB)
+	argon2Time    uint
+	argon2Memory  uint
+	argon2Threads uint
+
+	// Multiple of the Argon2id parameters above accepted from stored envelopes
+	argon2AcceptFactor uint
+
+	// PBKDF2-HMAC-SHA256 iterations used for new pastes
+	pbkdf2Iterations uint
+)
+
+func newPassphraseParams() (crypto.PassphraseParams, error) {
+	// New random salt with configured KDF and costs, both stored in the envelope
+	switch kdfName {
+	case kdfArgon2id:
+		return crypto.NewArgon2Params(uint32(argon2Time), uint32(argon2Memory), uint8(argon2Threads))
+	case kdfPBKDF2:
+		return crypto.NewPBKDF2Params(uint32(pbkdf2Iterations))
+	default:
+		return nil, errors.New("Unsupported KDF: " + kdfName)
+	}
+}
+
+func validKDFConfig() bool {
+	switch kdfName {
+	case kdfArgon2id:
+		return argon2Time >= 1 && argon2Time <= crypto.Argon2MaxTime &&
+			argon2Threads >= 1 && argon2Threads <= crypto.Argon2MaxThreads &&
+			argon2Memory >= 8*argon2Threads && argon2Memory <= crypto.Argon2MaxMemory
+	case kdfPBKDF2:
+		return pbkdf2Iterations >= 1 && pbkdf2Iterations <= crypto.PBKDF2MaxIterations
+	default:
+		return false
+	}
+}
+
+func limitKDFParams() {
+	// Pastes arrive from peers, gateways and imports, never spend much more than our own pastes cost to open
+	limit := func(v uint) uint {
+		if v > math.MaxUint32/argon2AcceptFactor {
+			return math.MaxUint32
+		}
+		return v * argon2AcceptFactor
+	}
+	threads := limit(argon2Threads)
+	if threads > math.MaxUint8 {
+		threads = math.MaxUint8
+	}
+	crypto.SetArgon2Limits(uint32(limit(argon2Time)), uint32(limit(argon2Memory)), uint8(threads))
+}
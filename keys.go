@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+const (
+	// Maximum form body size when keys are posted for retrieval
+	maxKeyFormSize = 4096
+
+	// Random bytes in server generated keys
+	generatedKeySize = 32
+)
+
+var (
+	// Reject keys supplied in the query string (they end up in logs and history)
+	rejectQueryKeys bool
+
+	// Request headers carrying each secret parameter
+	secretHeaders = map[string]string{
+		"key":      "X-Gibon-Key",
+		"new_key":  "X-Gibon-New-Key",
+		"identity": "X-Gibon-Identity",
+	}
+
+	// Returned when a secret is supplied in the query string but not allowed there
+	errQueryKey = errors.New("Keys must not be supplied in the query string!")
+)
+
+func requestSecret(request *http.Request, name string) (string, error) {
+	// Prefer header
+	if secret := request.Header.Get(secretHeaders[name]); secret != "" {
+		return secret, nil
+	}
+
+	// Then form body field on retrieval POSTs
+	if request.PostForm != nil {
+		if secret := request.PostForm.Get(name); secret != "" {
+			return secret, nil
+		}
+	}
+
+	// Finally the query string, if allowed
+	secret := request.URL.Query().Get(name)
+	if secret != "" && rejectQueryKeys {
+		return "", errQueryKey
+	}
+	return secret, nil
+}
+
+func generatePasteKey() (string, error) {
+	// Random key, URL safe so it can be passed anywhere
+	b := make([]byte, generatedKeySize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decryptionKey(request *http.Request) (string, error) {
+	// Symmetric key, else recipient identity
+	key, err := requestSecret(request, "key")
+	if err != nil || key != "" {
+		return key, err
+	}
+	return requestSecret(request, "identity")
+}
+
+func parseKeyForm(writer http.ResponseWriter, request *http.Request) error {
+	// Only retrieval POSTs carry form bodies
+	if request.Method != http.MethodPost {
+		return nil
+	}
+	request.Body = http.MaxBytesReader(writer, request.Body, maxKeyFormSize)
+	return request.ParseForm()
+}
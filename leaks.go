@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Actions taken on pastes matching a leak corpus
+	leakActionBlock = "block"
+	leakActionFlag  = "flag"
+)
+
+var (
+	// Known sensitive content SHA-256 hashes, whole pastes or single lines
+	leakHashes map[[sha256.Size]byte]struct{}
+
+	// Action on match, block refuses the upload, flag stores and queues for moderation
+	leakAction string
+
+	// Lines shorter than this (after trimming) are never matched, avoids trivial hits
+	leakMinLineLength int
+)
+
+func leakCheckEnabled() bool {
+	return len(leakHashes) > 0
+}
+
+func setupLeakHashes(files []string) error {
+	// Check action
+	if leakAction != leakActionBlock && leakAction != leakActionFlag {
+		return errors.New("Invalid leak action: " + leakAction)
+	}
+
+	// Load each hash list, hex SHA-256 one per line, '#' comments
+	leakHashes = map[[sha256.Size]byte]struct{}{}
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			b, err := hex.DecodeString(strings.Fields(text)[0])
+			if err != nil || len(b) != sha256.Size {
+				file.Close()
+				return errors.New("Invalid hash in " + path + " line " + strconv.Itoa(line))
+			}
+			var sum [sha256.Size]byte
+			copy(sum[:], b)
+			leakHashes[sum] = struct{}{}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(leakHashes) > 0 {
+		log.Printf("Loaded %d leak corpus hashes\n", len(leakHashes))
+	}
+	return nil
+}
+
+func matchLeakHashes(b []byte) int {
+	// Whole content first
+	matches := 0
+	if _, ok := leakHashes[sha256.Sum256(b)]; ok {
+		matches++
+	}
+
+	// Then each line, trimmed so re-indented or CRLF copies still match
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) < leakMinLineLength {
+			continue
+		}
+		if _, ok := leakHashes[sha256.Sum256(line)]; ok {
+			matches++
+		}
+	}
+	return matches
+}
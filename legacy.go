@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	legacyPath = "/compat"
+
+	// Form memory for legacy multipart uploads, rest spills to disk
+	legacyFormMemory = 1048576
+)
+
+var (
+	// Paste form fields used by sprunge.us and ix.io style clients, in preference order
+	legacyPasteFields = []string{"sprunge", "f:1", "f"}
+)
+
+func isLegacyPasteRequest(request *http.Request) bool {
+	// Native uploads are raw bodies, 'curl -F' style clients send multipart forms
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("content-type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+func legacyPasteHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", request.URL.Path, request.RemoteAddr)
+
+	// Parse the form (multipart or urlencoded)
+	request.Body = http.MaxBytesReader(writer, request.Body, maxPasteSize+legacyFormMemory)
+	err := request.ParseMultipartForm(legacyFormMemory)
+	if err == http.ErrNotMultipart {
+		err = request.ParseForm()
+	}
+	if err != nil {
+		http.Error(writer, "Invalid paste form!", http.StatusBadRequest)
+		return
+	}
+	if request.MultipartForm != nil {
+		defer request.MultipartForm.RemoveAll()
+	}
+
+	// Look for paste text in known fields ('-F f:1=@file' uploads arrive as files)
+	var b []byte
+	for _, field := range legacyPasteFields {
+		if value := request.PostFormValue(field); value != "" {
+			b = []byte(value)
+			break
+		}
+		if request.MultipartForm != nil && len(request.MultipartForm.File[field]) > 0 {
+			b, err = readSharedFile(request.MultipartForm.File[field][0])
+			if err != nil {
+				http.Error(writer, "Paste too large!", http.StatusRequestEntityTooLarge)
+				return
+			}
+			break
+		}
+	}
+	if len(b) == 0 {
+		http.Error(writer, "Nothing pasted!", http.StatusBadRequest)
+		return
+	} else if int64(len(b)) > maxPasteSize {
+		http.Error(writer, "Paste too large!", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	opts, err := pastePutOptions(request)
+	if err != nil {
+		http.Error(writer, "Unsupported hash!", http.StatusBadRequest)
+		return
+	}
+
+	// Store as a plain unlisted paste
+	c, err := putSharedText(request.Context(), b, request.UserAgent(), opts)
+	if err == errPluginRejected {
+		http.Error(writer, "Paste rejected by plugin!", http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		log.Printf("Failed to put legacy paste - %s\n", err.Error())
+		http.Error(writer, "Failed to put paste in store", http.StatusInternalServerError)
+		return
+	}
+
+	// Allocate short ID, old clients expect a short bare URL
+	short, err := shortIDForPaste(c)
+	if err != nil {
+		log.Printf("Failed to allocate short paste ID - %s\n", err.Error())
+		http.Error(writer, "Failed to allocate short paste ID", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the bare URL
+	scheme := "https"
+	if request.TLS == nil {
+		scheme = "http"
+	}
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(scheme + "://" + request.Host + shortPrefix + short + "\n"))
+}
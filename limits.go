@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// Maximum tracked clients per rate limit, idle ones are dropped past this
+	maxRateClients = 10000
+)
+
+var (
+	// Concurrency limiters, nil means unlimited
+	globalLimiter   limiter
+	uploadLimiter   limiter
+	downloadLimiter limiter
+	renderLimiter   limiter
+
+	// Maximum time a request waits for a concurrency slot
+	queueTimeout time.Duration
+)
+
+type limiter chan struct{}
+
+// clientRates are per-client token buckets, guarded by mutex
+type clientRates struct {
+	buckets map[string]*rateBucket
+	mutex   sync.Mutex
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newClientRates() *clientRates {
+	return &clientRates{buckets: map[string]*rateBucket{}}
+}
+
+func (rates *clientRates) take(request *http.Request, limit uint, period time.Duration) (time.Duration, int) {
+	rates.mutex.Lock()
+	defer rates.mutex.Unlock()
+
+	// Refill at limit per period, bursting at most a period's worth
+	now := time.Now()
+	rate := float64(limit) / float64(period)
+	key := throttleClient(request)
+	bucket, ok := rates.buckets[key]
+	if !ok {
+		// Drop clients whose allowance has fully refilled before tracking more
+		if len(rates.buckets) >= maxRateClients {
+			for key, idle := range rates.buckets {
+				if idle.tokens+float64(now.Sub(idle.last))*rate >= float64(limit) {
+					delete(rates.buckets, key)
+				}
+			}
+		}
+		bucket = &rateBucket{tokens: float64(limit), last: now}
+		rates.buckets[key] = bucket
+	}
+	bucket.tokens += float64(now.Sub(bucket.last)) * rate
+	if bucket.tokens > float64(limit) {
+		bucket.tokens = float64(limit)
+	}
+	bucket.last = now
+
+	// Take a token, else report how long until the next one, with whole tokens left
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / rate), 0
+	}
+	bucket.tokens--
+	return 0, int(bucket.tokens)
+}
+
+// connContextKey holds each request's connection in its context
+type connContextKey struct{}
+
+func saveConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+func extendWriteDeadline(request *http.Request, timeout time.Duration) {
+	// Connection is only known when served by our own server
+	conn, ok := request.Context().Value(connContextKey{}).(net.Conn)
+	if !ok {
+		return
+	}
+
+	// Zero timeout clears the deadline, for responses of unknown length
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	conn.SetWriteDeadline(deadline)
+}
+
+func newLimiter(max uint) limiter {
+	if max == 0 {
+		return nil
+	}
+	return make(limiter, max)
+}
+
+func (l limiter) acquire(timer <-chan time.Time) bool {
+	// Unlimited always acquires
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-timer:
+		return false
+	}
+}
+
+func (l limiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+func limitHandler(routeLimiter limiter, handle httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		// Shared queue timeout across both limiters
+		timer := time.NewTimer(queueTimeout)
+		defer timer.Stop()
+
+		// Acquire global then route slot, shedding load on timeout
+		if !globalLimiter.acquire(timer.C) {
+			shedRequest(writer, request)
+			return
+		}
+		defer globalLimiter.release()
+		if !routeLimiter.acquire(timer.C) {
+			shedRequest(writer, request)
+			return
+		}
+		defer routeLimiter.release()
+
+		handle(writer, request, params)
+	}
+}
+
+func shedRequest(writer http.ResponseWriter, request *http.Request) {
+	log.Printf("Shedding request from %s, concurrency limit reached\n", request.RemoteAddr)
+	writer.Header().Set("Retry-After", strconv.Itoa(int(queueTimeout.Seconds())+1))
+	http.Error(writer, "Server busy, try again later!", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// Registered linters by format name
+	linters = map[string]func([]byte) []string{
+		"json":   lintJSON,
+		"yaml":   lintYAML,
+		"toml":   lintTOML,
+		"dotenv": lintDotenv,
+	}
+
+	// Allowed dotenv variable names
+	dotenvKeyRegexp = regexp.MustCompile(`^(export\s+)?[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+func lintPaste(format string, b []byte) ([]string, error) {
+	linter, ok := linters[format]
+	if !ok {
+		return nil, errors.New("Unsupported lint format: " + format)
+	}
+	return linter(b), nil
+}
+
+func lintJSON(b []byte) []string {
+	// Try decode, reporting syntax error location
+	var v interface{}
+	err := json.Unmarshal(b, &v)
+	if err == nil {
+		return nil
+	}
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		line := bytes.Count(b[:syntaxErr.Offset], []byte{'\n'}) + 1
+		return []string{fmt.Sprintf("line %d: %s", line, syntaxErr.Error())}
+	}
+	return []string{err.Error()}
+}
+
+func lintYAML(b []byte) []string {
+	// Decode every document, strictly so duplicate keys are reported
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.SetStrict(true)
+	for {
+		var v interface{}
+		err := decoder.Decode(&v)
+		if err == io.EOF {
+			return nil
+		} else if typeErr, ok := err.(*yaml.TypeError); ok {
+			return typeErr.Errors
+		} else if err != nil {
+			return []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+		}
+	}
+}
+
+func lintTOML(b []byte) []string {
+	// Decode, reporting parse error location (duplicate keys included)
+	var v map[string]interface{}
+	_, err := toml.Decode(string(b), &v)
+	if err == nil {
+		return nil
+	}
+	if parseErr, ok := err.(toml.ParseError); ok {
+		return []string{fmt.Sprintf("line %d: %s", parseErr.Line, parseErr.Message)}
+	}
+	return []string{err.Error()}
+}
+
+func lintDotenv(b []byte) []string {
+	warnings := []string{}
+	seen := map[string]int{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		// Skip empty and comment lines
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// Every line must be KEY=VALUE
+		split := strings.SplitN(text, "=", 2)
+		if len(split) != 2 {
+			warnings = append(warnings, fmt.Sprintf("line %d: expected KEY=VALUE", line))
+			continue
+		}
+
+		// Check key format and duplicates
+		key := strings.TrimSpace(split[0])
+		if !dotenvKeyRegexp.MatchString(key) {
+			warnings = append(warnings, fmt.Sprintf("line %d: invalid variable name %q", line, key))
+		}
+		if prev, ok := seen[key]; ok {
+			warnings = append(warnings, fmt.Sprintf("line %d: duplicate variable %q (first defined on line %d)", line, key, prev))
+		} else {
+			seen[key] = line
+		}
+
+		// Check quoted values are terminated
+		value := strings.TrimSpace(split[1])
+		for _, quote := range []string{"\"", "'"} {
+			if strings.HasPrefix(value, quote) && (len(value) < 2 || !strings.HasSuffix(value, quote)) {
+				warnings = append(warnings, fmt.Sprintf("line %d: unterminated quoted value", line))
+			}
+		}
+	}
+
+	// Lines too long to scan mean the rest went unchecked
+	if err := scanner.Err(); err != nil {
+		warnings = append(warnings, "unable to read paste: "+err.Error())
+	}
+
+	return warnings
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grufwub/gibon/crypto"
+)
+
+const (
+	// Content key size for master key sealed pastes
+	masterContentKeySize = 32
+
+	// Maximum time for a master key wrap / unwrap
+	masterKeyTimeout = 10 * time.Second
+
+	// Maximum master key ID length, stored in each envelope
+	maxMasterKeyIDLength = 255
+)
+
+var (
+	// Registered master key providers by name
+	masterKeyFactories   = map[string]MasterKeyFactory{}
+	masterKeyFactoriesMu sync.Mutex
+
+	// Server master key wrapping new content keys (at-rest sealing disabled if nil)
+	masterKey MasterKey
+
+	// Current and retired master keys by ID, retired keys only unwrap
+	masterKeyRing = map[string]MasterKey{}
+
+	// Seal every otherwise unencrypted paste at rest with the master key
+	sealAtRest bool
+
+	// HTTP client used for KMS requests
+	kmsClient = &http.Client{Timeout: masterKeyTimeout}
+)
+
+// MasterKey wraps and unwraps per-paste content keys with a key held
+// outside gibon (HSM, KMS), so the master key itself never touches disk.
+// Wrapped keys are stored in the paste's crypto envelope along with the
+// key's ID, which must stay stable so rotated-out keys can still unwrap.
+type MasterKey interface {
+	ID() string
+	Wrap(ctx context.Context, contentKey []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// MasterKeyFactory constructs a MasterKey from its -master-key-config string.
+type MasterKeyFactory func(config map[string]string) (MasterKey, error)
+
+// RegisterMasterKey makes a master key provider selectable by name.
+func RegisterMasterKey(name string, factory MasterKeyFactory) {
+	masterKeyFactoriesMu.Lock()
+	defer masterKeyFactoriesMu.Unlock()
+
+	if _, ok := masterKeyFactories[name]; ok {
+		panic("master key provider registered twice: " + name)
+	}
+	masterKeyFactories[name] = factory
+}
+
+func init() {
+	// Register built-in local file and AWS KMS providers
+	RegisterMasterKey("local", newLocalMasterKey)
+	RegisterMasterKey("awskms", newAWSKMSMasterKey)
+
+	// Master key sealed envelopes need no user secret, the ring unwraps the content key
+	crypto.RegisterKDF(crypto.KDFMasterKey, crypto.KDFFunc(func(params []byte, _ string) ([]byte, error) {
+		return unwrapMasterContentKey(params)
+	}))
+}
+
+func setupMasterKey(name, configStr, retiredStr string) error {
+	// Skip if disabled
+	if name == "" {
+		if sealAtRest {
+			return errors.New("Sealing at rest requires -master-key")
+		}
+		return nil
+	}
+
+	// Current key wraps, retired keys ('name:config;...') still unwrap older pastes
+	key, err := newMasterKey(name, configStr)
+	if err != nil {
+		return err
+	}
+	masterKey = key
+	masterKeyRing[key.ID()] = key
+	for _, retired := range strings.Split(retiredStr, ";") {
+		if retired = strings.TrimSpace(retired); retired == "" {
+			continue
+		}
+		nameConfig := strings.SplitN(retired, ":", 2)
+		if len(nameConfig) != 2 {
+			return errors.New("Invalid retired master key: " + retired)
+		}
+		key, err := newMasterKey(nameConfig[0], nameConfig[1])
+		if err != nil {
+			return err
+		}
+		if _, ok := masterKeyRing[key.ID()]; ok {
+			return errors.New("Duplicate master key ID: " + key.ID())
+		}
+		masterKeyRing[key.ID()] = key
+	}
+
+	return nil
+}
+
+func newMasterKey(name, configStr string) (MasterKey, error) {
+	// Look up provider
+	masterKeyFactoriesMu.Lock()
+	factory, ok := masterKeyFactories[name]
+	names := []string{}
+	for name := range masterKeyFactories {
+		names = append(names, name)
+	}
+	masterKeyFactoriesMu.Unlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, errors.New("Unknown master key provider: " + name + " (available: " + strings.Join(names, ", ") + ")")
+	}
+
+	// Construct, then check it round trips before accepting pastes
+	config, err := parseProviderConfig(configStr)
+	if err != nil {
+		return nil, err
+	}
+	key, err := factory(config)
+	if err != nil {
+		return nil, err
+	} else if id := key.ID(); id == "" || len(id) > maxMasterKeyIDLength {
+		return nil, errors.New("Invalid master key ID: " + id)
+	}
+	ctx, cancel := context.WithTimeout(globalContext, masterKeyTimeout)
+	defer cancel()
+	probe := make([]byte, masterContentKeySize)
+	wrapped, err := key.Wrap(ctx, probe)
+	if err == nil {
+		_, err = key.Unwrap(ctx, wrapped)
+	}
+	if err != nil {
+		return nil, errors.New("Master key " + key.ID() + " check failed - " + err.Error())
+	}
+	return key, nil
+}
+
+func (p *paste) sealWithMasterKey() error {
+	// Random content key, stored wrapped by the master key
+	contentKey := make([]byte, masterContentKeySize)
+	_, err := rand.Read(contentKey)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(globalContext, masterKeyTimeout)
+	defer cancel()
+	wrapped, err := masterKey.Wrap(ctx, contentKey)
+	if err != nil {
+		return err
+	}
+
+	// Metadata is only stored after the put, so at-rest sealing binds none
+	return p.seal(crypto.NewKeyEncrypter(crypto.KDFMasterKey, marshalMasterKeyParams(masterKey.ID(), wrapped), contentKey), nil)
+}
+
+func marshalMasterKeyParams(id string, wrapped []byte) []byte {
+	// Params are: ID length, ID, wrapped content key
+	b := make([]byte, 0, 1+len(id)+len(wrapped))
+	b = append(b, byte(len(id)))
+	b = append(b, id...)
+	return append(b, wrapped...)
+}
+
+func unwrapMasterContentKey(params []byte) ([]byte, error) {
+	// Split master key ID from the wrapped content key
+	if len(params) < 1 || len(params) < 1+int(params[0]) {
+		return nil, errors.New("master key params truncated")
+	}
+	id := string(params[1 : 1+params[0]])
+	wrapped := params[1+params[0]:]
+
+	// Unwrap with whichever key sealed it, current or retired
+	key, ok := masterKeyRing[id]
+	if !ok {
+		return nil, errors.New("paste sealed with unknown master key: " + id)
+	}
+	ctx, cancel := context.WithTimeout(globalContext, masterKeyTimeout)
+	defer cancel()
+	return key.Unwrap(ctx, wrapped)
+}
+
+func (p *paste) openAtRest() error {
+	// Only master key sealed envelopes are opened transparently
+	if !p.encrypted || p.sealing != pasteSealingEnvelope {
+		return nil
+	}
+	env, err := p.cryptoEnvelope()
+	if err != nil || env.KDF != crypto.KDFMasterKey {
+		return err
+	}
+	return p.decrypt("", nil)
+}
+
+// Local: path=<secret file, generated if missing>
+
+type localMasterKey struct {
+	key []byte
+}
+
+func newLocalMasterKey(config map[string]string) (MasterKey, error) {
+	if err := requireConfig(config, "path"); err != nil {
+		return nil, err
+	}
+	key, err := readSecretFile(config["path"], crypto.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return &localMasterKey{key: key}, nil
+}
+
+func (local *localMasterKey) ID() string {
+	// Derived from the key, so rotating the file changes it
+	sum := sha256.Sum256(local.key)
+	return "local:" + hex.EncodeToString(sum[:8])
+}
+
+func (local *localMasterKey) Wrap(_ context.Context, contentKey []byte) ([]byte, error) {
+	aead, err := crypto.NewAEAD(crypto.CipherAES256GCM, local.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, contentKey, nil), nil
+}
+
+func (local *localMasterKey) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	aead, err := crypto.NewAEAD(crypto.CipherAES256GCM, local.key)
+	if err != nil {
+		return nil, err
+	} else if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key not long enough to contain nonce")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+}
+
+// AWS KMS: key_id=<key ID or ARN>,region=...,access_key=...,secret_key=...
+
+type awsKMSMasterKey struct {
+	keyID     string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func newAWSKMSMasterKey(config map[string]string) (MasterKey, error) {
+	if err := requireConfig(config, "key_id", "region", "access_key", "secret_key"); err != nil {
+		return nil, err
+	}
+	return &awsKMSMasterKey{
+		keyID:     config["key_id"],
+		region:    config["region"],
+		accessKey: config["access_key"],
+		secretKey: config["secret_key"],
+	}, nil
+}
+
+func (kms *awsKMSMasterKey) ID() string {
+	// KMS rotates key material itself, IDs only change on switching keys
+	return "awskms:" + kms.keyID
+}
+
+func (kms *awsKMSMasterKey) call(ctx context.Context, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", "https://kms."+kms.region+".amazonaws.com/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("content-type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", "TrentService."+action)
+	signAWSRequest(request, body, kms.region, "kms", kms.accessKey, kms.secretKey)
+
+	response, err := kmsClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(response.Body)
+		return errors.New("KMS responded with: " + response.Status + " " + string(msg))
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+func (kms *awsKMSMasterKey) Wrap(ctx context.Context, contentKey []byte) ([]byte, error) {
+	out := struct {
+		CiphertextBlob string
+	}{}
+	err := kms.call(ctx, "Encrypt", map[string]string{
+		"KeyId":     kms.keyID,
+		"Plaintext": base64.StdEncoding.EncodeToString(contentKey),
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(out.CiphertextBlob)
+}
+
+func (kms *awsKMSMasterKey) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out := struct {
+		Plaintext string
+	}{}
+	err := kms.call(ctx, "Decrypt", map[string]string{
+		"KeyId":          kms.keyID,
+		"CiphertextBlob": base64.StdEncoding.EncodeToString(wrapped),
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(out.Plaintext)
+}
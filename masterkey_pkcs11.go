@@ -0,0 +1,134 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+const (
+	// AES-GCM IV and tag sizes used for PKCS#11 wrapping
+	pkcs11IVSize     = 12
+	pkcs11TagBitSize = 128
+)
+
+func init() {
+	// Register PKCS#11 / HSM provider (needs cgo for the module loader)
+	RegisterMasterKey("pkcs11", newPKCS11MasterKey)
+}
+
+// PKCS#11: module=<library .so>,slot=<slot ID>,pin=<user PIN>,label=<AES key label>
+
+type pkcs11MasterKey struct {
+	label   string
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	mutex   sync.Mutex
+}
+
+func newPKCS11MasterKey(config map[string]string) (MasterKey, error) {
+	if err := requireConfig(config, "module", "slot", "pin", "label"); err != nil {
+		return nil, err
+	}
+	slot, err := strconv.ParseUint(config["slot"], 10, 32)
+	if err != nil {
+		return nil, errors.New("Invalid PKCS#11 slot: " + config["slot"])
+	}
+
+	// Load module and log in to the token
+	ctx := pkcs11.New(config["module"])
+	if ctx == nil {
+		return nil, errors.New("Failed to load PKCS#11 module: " + config["module"])
+	}
+	err = ctx.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	session, err := ctx.OpenSession(uint(slot), pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.Login(session, pkcs11.CKU_USER, config["pin"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the AES secret key by label, it never leaves the token
+	err = ctx.FindObjectsInit(session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, config["label"]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects, _, err := ctx.FindObjects(session, 2)
+	ctx.FindObjectsFinal(session)
+	if err != nil {
+		return nil, err
+	} else if len(objects) != 1 {
+		return nil, errors.New("Expected exactly one PKCS#11 secret key labelled " + config["label"])
+	}
+
+	return &pkcs11MasterKey{
+		label:   config["label"],
+		ctx:     ctx,
+		session: session,
+		key:     objects[0],
+	}, nil
+}
+
+func (hsm *pkcs11MasterKey) ID() string {
+	// Rotate by creating a new key under a new label
+	return "pkcs11:" + hsm.label
+}
+
+func (hsm *pkcs11MasterKey) Wrap(_ context.Context, contentKey []byte) ([]byte, error) {
+	// Random IV, prepended to the ciphertext
+	iv := make([]byte, pkcs11IVSize)
+	_, err := rand.Read(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sessions are not safe for concurrent use
+	hsm.mutex.Lock()
+	defer hsm.mutex.Unlock()
+	// GCM params hold C memory, freed once the operation is done
+	params := pkcs11.NewGCMParams(iv, nil, pkcs11TagBitSize)
+	defer params.Free()
+	mech := pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)
+	err = hsm.ctx.EncryptInit(hsm.session, []*pkcs11.Mechanism{mech}, hsm.key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := hsm.ctx.Encrypt(hsm.session, contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, sealed...), nil
+}
+
+func (hsm *pkcs11MasterKey) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < pkcs11IVSize {
+		return nil, errors.New("wrapped key not long enough to contain IV")
+	}
+
+	hsm.mutex.Lock()
+	defer hsm.mutex.Unlock()
+	params := pkcs11.NewGCMParams(wrapped[:pkcs11IVSize], nil, pkcs11TagBitSize)
+	defer params.Free()
+	mech := pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)
+	err := hsm.ctx.DecryptInit(hsm.session, []*pkcs11.Mechanism{mech}, hsm.key)
+	if err != nil {
+		return nil, err
+	}
+	return hsm.ctx.Decrypt(hsm.session, wrapped[pkcs11IVSize:])
+}
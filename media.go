@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	// Content types allowed for image pastes
+	imageContentTypes = map[string]bool{
+		"image/png":  true,
+		"image/jpeg": true,
+		"image/gif":  true,
+		"image/webp": true,
+	}
+)
+
+func parseContentType(contentType string, b []byte) (string, error) {
+	// Empty is plain text
+	if contentType == "" {
+		return "", nil
+	}
+
+	// Only allow known image types
+	if !imageContentTypes[contentType] {
+		return "", errors.New("Unsupported content type: " + contentType)
+	}
+
+	// Ensure content actually matches claimed type
+	if http.DetectContentType(b) != contentType {
+		return "", errors.New("Content does not match type: " + contentType)
+	}
+
+	return contentType, nil
+}
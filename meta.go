@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	// Maximum password hint length (in characters)
+	maxHintLength = 200
+)
+
+var (
+	// Guards metadata read-modify-write
+	pasteMetaMutex sync.Mutex
+
+	// Allowed syntax language hints
+	languageRegexp = regexp.MustCompile(`^[a-z0-9+#-]{1,24}$`)
+)
+
+type pasteMeta struct {
+	// Parent paste CID string, if forked
+	Parent string `json:"parent,omitempty"`
+
+	// Public discovery tags
+	Tags []string `json:"tags,omitempty"`
+
+	// Title derived from content (unencrypted only)
+	Title string `json:"title,omitempty"`
+
+	// Syntax language hint supplied by uploader
+	Language string `json:"language,omitempty"`
+
+	// Content type for non-text (image) pastes
+	ContentType string `json:"content_type,omitempty"`
+
+	// Kind of PGP armored content, if detected
+	PGP string `json:"pgp,omitempty"`
+
+	// End-to-end encrypted client-side, server never decrypts
+	E2E bool `json:"e2e,omitempty"`
+
+	// Unencrypted password hint supplied by uploader (encrypted only)
+	Hint string `json:"hint,omitempty"`
+
+	// Unix time after which the paste is never decrypted, sealed inside (encrypted only)
+	Expires int64 `json:"expires,omitempty"`
+}
+
+func parseLanguage(language string) (string, error) {
+	language = strings.ToLower(language)
+	if language != "" && !languageRegexp.MatchString(language) {
+		return "", errors.New("Invalid language: " + language)
+	}
+	return language, nil
+}
+
+func parseHint(hint string) (string, error) {
+	// Hints are served in headers, so single line printable text only
+	hint = strings.TrimSpace(hint)
+	if utf8.RuneCountInString(hint) > maxHintLength {
+		return "", errors.New("Hint too long")
+	}
+	for _, r := range hint {
+		if !unicode.IsPrint(r) {
+			return "", errors.New("Invalid hint character")
+		}
+	}
+	return hint, nil
+}
+
+func getPasteMeta(c cid.Cid) (*pasteMeta, error) {
+	return getPasteMetaStr(c.String())
+}
+
+func getPasteMetaStr(cidStr string) (*pasteMeta, error) {
+	// Look for metadata, none found is just empty
+	meta := &pasteMeta{}
+	err := indexGet(indexKey("meta", cidStr), meta)
+	if err != nil && err != ds.ErrNotFound {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func putPasteMeta(c cid.Cid, meta *pasteMeta) error {
+	return indexPut(indexKey("meta", c.String()), meta)
+}
+
+func updatePasteMeta(c cid.Cid, update func(*pasteMeta)) error {
+	pasteMetaMutex.Lock()
+	defer pasteMetaMutex.Unlock()
+
+	// Get existing metadata
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		return err
+	}
+
+	// Apply update and persist
+	update(meta)
+	return putPasteMeta(c, meta)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	// Sealed metadata length prefix size
+	sealedMetaLenSize = 4
+)
+
+func (p *paste) sealMeta(meta *pasteMeta) error {
+	// Prefix goes on the uncompressed text, so work on that
+	err := p.decompress()
+	if err != nil {
+		return err
+	}
+
+	// Length-prefixed JSON ahead of the text, encrypted along with it
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	text := make([]byte, sealedMetaLenSize, sealedMetaLenSize+len(b)+len(p.text))
+	binary.BigEndian.PutUint32(text, uint32(len(b)))
+	text = append(append(text, b...), p.text...)
+	p.text = text
+	p.metaSealed = true
+
+	return p.compress()
+}
+
+func (p *paste) openMeta() error {
+	// Only readable once decrypted and decompressed
+	if !p.metaSealed || p.encrypted || p.codec != pasteCodecNone {
+		return nil
+	}
+
+	// Split the metadata prefix from the text
+	if len(p.text) < sealedMetaLenSize {
+		return errors.New("sealed paste metadata truncated")
+	}
+	size := binary.BigEndian.Uint32(p.text)
+	if uint64(size) > uint64(len(p.text)-sealedMetaLenSize) {
+		return errors.New("sealed paste metadata truncated")
+	}
+	meta := &pasteMeta{}
+	err := json.Unmarshal(p.text[sealedMetaLenSize:sealedMetaLenSize+int(size)], meta)
+	if err != nil {
+		return err
+	}
+
+	// Expiry sealed with the content holds whatever the index says
+	if meta.expired() {
+		return errPasteExpired
+	}
+
+	// Text no longer carries the prefix, reseal to keep it
+	p.text = p.text[sealedMetaLenSize+int(size):]
+	p.sealedMeta = meta
+	p.metaSealed = false
+	return nil
+}
+
+func (meta *pasteMeta) mergeSealed(sealed *pasteMeta) {
+	// Only fields describing content are ever sealed
+	if sealed == nil {
+		return
+	}
+	meta.Title = sealed.Title
+	meta.Language = sealed.Language
+	meta.ContentType = sealed.ContentType
+	meta.PGP = sealed.PGP
+	meta.Expires = sealed.Expires
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-mfs"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+)
+
+const (
+	// MFS directory pastes are mirrored under, as /gibon/<date>/<cid>
+	mfsRoot = "/gibon"
+
+	// Date layout of MFS mirror directories
+	mfsDateLayout = "2006-01-02"
+)
+
+var (
+	// Mirror created pastes into their shard's MFS, listed by admin endpoints from there
+	mfsMirror bool
+)
+
+type mfsPaste struct {
+	CID  string `json:"cid"`
+	Date string `json:"date"`
+	Size int64  `json:"size"`
+}
+
+func mirrorPaste(ctx context.Context, c cid.Cid, b []byte) error {
+	shard := shardForCID(c)
+
+	// Add a UnixFS copy, the paste block itself isn't UnixFS so can't be linked into MFS
+	resolved, err := shard.api.Unixfs().Add(ctx, files.NewBytesFile(b), options.Unixfs.Pin(false))
+	if err != nil {
+		return err
+	}
+	node, err := shard.api.Dag().Get(ctx, resolved.Cid())
+	if err != nil {
+		return err
+	}
+
+	// Link under today's directory, an existing entry is the same paste
+	dir := mfsRoot + "/" + time.Now().UTC().Format(mfsDateLayout)
+	err = mfs.Mkdir(shard.node.FilesRoot, dir, mfs.MkdirOpts{Mkparents: true})
+	if err != nil {
+		return err
+	}
+	filePath := dir + "/" + c.String()
+	err = mfs.PutNode(shard.node.FilesRoot, filePath, node)
+	if err != nil && err != mfs.ErrDirExists {
+		return err
+	}
+
+	// Flush so the tree survives restarts
+	_, err = mfs.FlushPath(ctx, shard.node.FilesRoot, dir)
+	if err != nil {
+		return err
+	}
+
+	// Remember where, so the entry can be unlinked when the paste is released
+	return indexStore.Put(indexKey("mfs", c.String()), []byte(filePath))
+}
+
+func unmirrorPaste(c cid.Cid) error {
+	// Nothing to do if never mirrored
+	key := indexKey("mfs", c.String())
+	b, err := indexStore.Get(key)
+	if err == ds.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Unlink from its date directory, leaving the copy to repo GC
+	shard := shardForCID(c)
+	dirPath, name := path.Split(string(b))
+	fsNode, err := mfs.Lookup(shard.node.FilesRoot, dirPath)
+	if err == nil {
+		dir, ok := fsNode.(*mfs.Directory)
+		if !ok {
+			return errors.New("MFS mirror path is not a directory: " + dirPath)
+		}
+		err = dir.Unlink(name)
+		if err != nil && err != os.ErrNotExist {
+			return err
+		}
+		err = dir.Flush()
+		if err != nil {
+			return err
+		}
+	} else if err != os.ErrNotExist {
+		return err
+	}
+
+	return indexDelete(key)
+}
+
+func listMirroredPastes(ctx context.Context, date string) ([]*mfsPaste, error) {
+	pastes := []*mfsPaste{}
+	for _, shard := range ipfsShards {
+		// Shards without any mirrored pastes have no root
+		fsNode, err := mfs.Lookup(shard.node.FilesRoot, mfsRoot)
+		if err == os.ErrNotExist {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		root, ok := fsNode.(*mfs.Directory)
+		if !ok {
+			return nil, errors.New("MFS mirror root is not a directory")
+		}
+
+		// Every date directory, or just the one asked for
+		dates, err := root.ListNames(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range dates {
+			if date != "" && name != date {
+				continue
+			}
+			child, err := root.Child(name)
+			if err != nil {
+				return nil, err
+			}
+			dir, ok := child.(*mfs.Directory)
+			if !ok {
+				continue
+			}
+			err = dir.ForEachEntry(ctx, func(entry mfs.NodeListing) error {
+				pastes = append(pastes, &mfsPaste{CID: entry.Name, Date: name, Size: entry.Size})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Oldest first across shards
+	sort.Slice(pastes, func(i, j int) bool {
+		if pastes[i].Date != pastes[j].Date {
+			return pastes[i].Date < pastes[j].Date
+		}
+		return pastes[i].CID < pastes[j].CID
+	})
+	return pastes, nil
+}
+
+func listPastesHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", apiPrefix+"pastes", request.RemoteAddr)
+
+	// Check any date filter
+	date := request.URL.Query().Get("date")
+	if date != "" {
+		_, err := time.Parse(mfsDateLayout, date)
+		if err != nil {
+			http.Error(writer, "Invalid date!", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// List from the MFS mirror
+	pastes, err := listMirroredPastes(request.Context(), date)
+	if err != nil {
+		log.Printf("Failed to list mirrored pastes - %s\n", err.Error())
+		http.Error(writer, "Failed to list pastes", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(writer, pastes)
+}
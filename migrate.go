@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+const (
+	migrateCommand = "migrate"
+)
+
+var (
+	// Migrate out-of-date IPFS repos on startup instead of refusing to start
+	autoMigrate bool
+)
+
+type migrateOptions struct {
+	check bool
+}
+
+func parseMigrateArgs(args []string) (*migrateOptions, error) {
+	opts := &migrateOptions{}
+	flags := flag.NewFlagSet(migrateCommand, flag.ContinueOnError)
+	flags.BoolVar(&opts.check, "check", false, "Only report each repo's version, exiting non-zero if any need migrating")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	} else if flags.NArg() != 0 {
+		return nil, errors.New("Usage: gibon [flags] migrate [-check]")
+	}
+	return opts, nil
+}
+
+func repoVersion(repoPath string) (int, error) {
+	// Repos not yet initialized will be at the current version
+	if !fsrepo.IsInitialized(repoPath) {
+		return fsrepo.RepoVersion, nil
+	}
+	return mfsr.RepoPath(repoPath).Version()
+}
+
+func migrateRepo(repoPath string, from int) error {
+	log.Printf("Migrating IPFS repo at %s from version %d to %d...\n", repoPath, from, fsrepo.RepoVersion)
+
+	// fs-repo-migrations acts on $IPFS_PATH, one repo at a time
+	previous, set := os.LookupEnv("IPFS_PATH")
+	os.Setenv("IPFS_PATH", repoPath)
+	defer func() {
+		if set {
+			os.Setenv("IPFS_PATH", previous)
+		} else {
+			os.Unsetenv("IPFS_PATH")
+		}
+	}()
+
+	// Uses fs-repo-migrations from $PATH, else downloads it from dist.ipfs.io
+	err := mfsr.RunMigration(fsrepo.RepoVersion)
+	if err != nil {
+		return fmt.Errorf("Migrating IPFS repo %s failed, restore it from backup before retrying - %s", repoPath, err.Error())
+	}
+	return nil
+}
+
+func checkRepoVersions(migrate bool) error {
+	for _, shard := range ipfsShards {
+		version, err := repoVersion(shard.repoPath)
+		if err != nil {
+			return fmt.Errorf("Failed to read IPFS repo %s version - %s", shard.repoPath, err.Error())
+		}
+
+		switch {
+		// Repos written by a newer go-ipfs can't be migrated forward
+		case version > fsrepo.RepoVersion:
+			return fmt.Errorf("IPFS repo %s is version %d, newer than this build supports (%d) - run a newer gibon, or migrate the repo back with fs-repo-migrations", shard.repoPath, version, fsrepo.RepoVersion)
+
+		// Older repos need migrating, only if asked as it's not reversible here
+		case version < fsrepo.RepoVersion && !migrate:
+			return fmt.Errorf("IPFS repo %s is version %d, this build needs version %d - back it up, then run 'gibon -ipfs-repo ... migrate' or start with -auto-migrate", shard.repoPath, version, fsrepo.RepoVersion)
+		case version < fsrepo.RepoVersion:
+			err = migrateRepo(shard.repoPath, version)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runMigrate(opts *migrateOptions) error {
+	// Report every repo's version
+	outdated := 0
+	for _, shard := range ipfsShards {
+		version, err := repoVersion(shard.repoPath)
+		if err != nil {
+			return err
+		}
+		status := "up to date"
+		if version < fsrepo.RepoVersion {
+			status = "needs migration"
+			outdated++
+		} else if version > fsrepo.RepoVersion {
+			status = "newer than this build"
+		}
+		fmt.Printf("%s\tversion %d\t%s\n", shard.repoPath, version, status)
+	}
+
+	// Checking fails if there's anything to migrate
+	if opts.check {
+		if outdated > 0 {
+			return fmt.Errorf("%d IPFS repo(s) need migration to version %d", outdated, fsrepo.RepoVersion)
+		}
+		return nil
+	}
+
+	return checkRepoVersions(true)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	// Maximum abuse report reason size (in bytes)
+	maxAbuseReasonSize = 1024
+
+	// Maximum stored reasons per reported paste
+	maxAbuseReasons = 20
+)
+
+var (
+	// Guards abuse report read-modify-write
+	abuseReportsMutex sync.Mutex
+)
+
+type abuseReport struct {
+	CID     string    `json:"cid,omitempty"`
+	Count   uint64    `json:"count"`
+	Reasons []string  `json:"reasons,omitempty"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+}
+
+func isBlockedPaste(c cid.Cid) (bool, error) {
+	return indexStore.Has(indexKey("blocked", c.String()))
+}
+
+func refuseBlockedPaste(writer http.ResponseWriter, c cid.Cid) bool {
+	// Check paste is available at all
+	if refuseUnavailablePaste(writer, c) {
+		return true
+	}
+
+	// PAKE-protected pastes are only released through the handshake
+	pake, err := isPakePaste(c)
+	if err != nil {
+		log.Printf("Failed to check paste PAKE protection - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return true
+	} else if pake {
+		http.Error(writer, "Paste is PAKE-protected, retrieve it with the handshake at "+pastePrefix+c.String()+"/pake!", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+func refuseUnavailablePaste(writer http.ResponseWriter, c cid.Cid) bool {
+	// Check if paste has been blocked by moderation
+	blocked, err := isBlockedPaste(c)
+	if err != nil {
+		log.Printf("Failed to check paste block - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return true
+	} else if blocked {
+		http.Error(writer, "Paste unavailable for legal reasons!", http.StatusUnavailableForLegalReasons)
+		return true
+	}
+
+	// Check if paste was re-keyed and tombstoned
+	tombstoned, err := isTombstonedPaste(c)
+	if err != nil {
+		log.Printf("Failed to check paste tombstone - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return true
+	} else if tombstoned {
+		http.Error(writer, "Paste was re-keyed and is gone!", http.StatusGone)
+		return true
+	}
+
+	// Check expiry copied from the sealed metadata, refused before any decryption
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		log.Printf("Failed to get paste metadata - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return true
+	} else if meta.expired() {
+		http.Error(writer, "Paste has expired!", http.StatusGone)
+		return true
+	}
+
+	// Still available, say until when
+	writeExpiryHeaders(writer, expiryTime(meta.Expires))
+	return false
+}
+
+func addAbuseReport(c cid.Cid, reason string) error {
+	abuseReportsMutex.Lock()
+	defer abuseReportsMutex.Unlock()
+
+	// Look for existing report, none found is just empty
+	key := indexKey("abuse", c.String())
+	report := abuseReport{}
+	err := indexGet(key, &report)
+	if err != nil && err != ds.ErrNotFound {
+		return err
+	}
+
+	// Update and persist
+	now := time.Now().UTC()
+	if report.Count == 0 {
+		report.First = now
+	}
+	report.Count++
+	report.Last = now
+	if reason != "" && len(report.Reasons) < maxAbuseReasons {
+		report.Reasons = append(report.Reasons, reason)
+	}
+	return indexPut(key, &report)
+}
+
+func blockPaste(c cid.Cid) error {
+	abuseReportsMutex.Lock()
+	defer abuseReportsMutex.Unlock()
+
+	// Mark paste as blocked
+	err := indexStore.Put(indexKey("blocked", c.String()), []byte{})
+	if err != nil {
+		return err
+	}
+
+	// Remove from public listings
+	err = indexDelete(indexKey("public", c.String()))
+	if err != nil {
+		return err
+	}
+
+	// Unpin so the paste can be garbage collected
+	err = unpinPaste(c)
+	if err != nil {
+		log.Printf("Failed to unpin blocked paste %s - %s\n", c.String(), err.Error())
+	}
+	err = unmirrorPaste(c)
+	if err != nil {
+		log.Printf("Failed to unmirror blocked paste %s - %s\n", c.String(), err.Error())
+	}
+
+	err = indexDelete(indexKey("abuse", c.String()))
+	if err != nil {
+		return err
+	}
+
+	// Run delete hook
+	runHook(hookOnDelete, c)
+	return nil
+}
+
+func dismissAbuseReport(c cid.Cid) error {
+	abuseReportsMutex.Lock()
+	defer abuseReportsMutex.Unlock()
+	return indexDelete(indexKey("abuse", c.String()))
+}
+
+func abuseQueue() ([]*abuseReport, error) {
+	// Get all reported paste CIDs
+	cids, err := indexList("abuse")
+	if err != nil {
+		return nil, err
+	}
+
+	// Gather each report
+	queue := []*abuseReport{}
+	for _, cidStr := range cids {
+		report := &abuseReport{}
+		err = indexGet(indexKey("abuse", cidStr), report)
+		if err != nil {
+			return nil, err
+		}
+		report.CID = cidStr
+		queue = append(queue, report)
+	}
+
+	// Most reported first
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].Count > queue[j].Count
+	})
+
+	return queue, nil
+}
+
+func reportPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("POST", pastePrefix+cidStr+"/report", request.RemoteAddr)
+
+	// Decode the paste CID and ensure paste exists
+	c, err := cid.Decode(cidStr)
+	if err == nil {
+		var has bool
+		has, err = storageBackend.Has(globalContext, c)
+		if err == nil && !has {
+			err = ds.ErrNotFound
+		}
+	}
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Already blocked pastes need no further reports
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Read optional reason from body
+	request.Body = http.MaxBytesReader(writer, request.Body, maxAbuseReasonSize)
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, "Report reason too large!", http.StatusRequestEntityTooLarge)
+		return
+	}
+	reason := strings.TrimSpace(string(b))
+	if !utf8.ValidString(reason) {
+		http.Error(writer, "Invalid report reason!", http.StatusBadRequest)
+		return
+	}
+
+	// Add to the moderation queue
+	err = addAbuseReport(c, reason)
+	if err != nil {
+		log.Printf("Failed to add abuse report - %s\n", err.Error())
+		http.Error(writer, "Failed to report paste", http.StatusInternalServerError)
+		return
+	}
+
+	// Acknowledge the report
+	writer.Header().Set("content-type", "text/plain")
+	writer.WriteHeader(http.StatusAccepted)
+	writer.Write([]byte("Report received!"))
+}
+
+func abuseQueueHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", apiPrefix+"reports", request.RemoteAddr)
+
+	// Get the moderation queue
+	queue, err := abuseQueue()
+	if err != nil {
+		log.Printf("Failed to list abuse reports - %s\n", err.Error())
+		http.Error(writer, "Failed to list abuse reports", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(writer, queue)
+}
+
+func moderatePasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string and action
+	cidStr := params.ByName("cid")
+	action := params.ByName("action")
+
+	// Log the request
+	logRequest("POST", apiPrefix+"reports/"+cidStr+"/"+action, request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Invalid paste CID!", http.StatusBadRequest)
+		return
+	}
+
+	// Perform moderation action
+	switch action {
+	case "block":
+		err = blockPaste(c)
+	case "dismiss":
+		err = dismissAbuseReport(c)
+	default:
+		http.Error(writer, "Invalid moderation action!", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to moderate paste - %s\n", err.Error())
+		http.Error(writer, "Failed to moderate paste", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the paste path in response
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(pastePrefix + cidStr))
+}
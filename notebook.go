@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html"
+	"strings"
+
+	"github.com/yuin/goldmark"
+)
+
+const (
+	// Maximum notebook cells rendered
+	maxNotebookCells = 2000
+)
+
+var (
+	// Image output types rendered inline (as data URIs)
+	notebookImageTypes = []string{"image/png", "image/jpeg", "image/gif"}
+)
+
+// notebookSource is a cell source or output text, either a string or list of lines
+type notebookSource string
+
+type notebookOutput struct {
+	OutputType string                     `json:"output_type"`
+	Text       notebookSource             `json:"text"`
+	Data       map[string]json.RawMessage `json:"data"`
+	EName      string                     `json:"ename"`
+	EValue     string                     `json:"evalue"`
+	Traceback  []string                   `json:"traceback"`
+}
+
+type notebookCell struct {
+	CellType string           `json:"cell_type"`
+	Source   notebookSource   `json:"source"`
+	Outputs  []notebookOutput `json:"outputs"`
+}
+
+type notebook struct {
+	NBFormat int            `json:"nbformat"`
+	Cells    []notebookCell `json:"cells"`
+	Metadata struct {
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+}
+
+func (src *notebookSource) UnmarshalJSON(b []byte) error {
+	// Single string
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		*src = notebookSource(str)
+		return nil
+	}
+
+	// List of lines (newlines included)
+	lines := []string{}
+	err := json.Unmarshal(b, &lines)
+	if err != nil {
+		return err
+	}
+	*src = notebookSource(strings.Join(lines, ""))
+	return nil
+}
+
+func parseNotebook(b []byte) (*notebook, error) {
+	nb := &notebook{}
+	err := json.Unmarshal(b, nb)
+	if err != nil {
+		return nil, err
+	} else if nb.NBFormat < 4 {
+		return nil, errors.New("unsupported notebook format")
+	} else if len(nb.Cells) > maxNotebookCells {
+		return nil, errors.New("notebook has too many cells")
+	}
+	return nb, nil
+}
+
+func isNotebook(b []byte) bool {
+	// Cheap check before full parse
+	trimmed := bytes.TrimSpace(b)
+	if !bytes.HasPrefix(trimmed, []byte("{")) || !bytes.Contains(trimmed, []byte(`"nbformat"`)) {
+		return false
+	}
+	_, err := parseNotebook(b)
+	return err == nil
+}
+
+func notebookToText(b []byte) ([]byte, error) {
+	nb, err := parseNotebook(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cell sources separated by blank lines, code marked with a prompt
+	buf := &bytes.Buffer{}
+	for i, cell := range nb.Cells {
+		if i > 0 {
+			buf.WriteString("\n\n")
+		}
+		if cell.CellType == "code" {
+			buf.WriteString("In []:\n")
+		}
+		buf.WriteString(string(cell.Source))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func notebookToHTML(b []byte) ([]byte, error) {
+	nb, err := parseNotebook(b)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, cell := range nb.Cells {
+		switch cell.CellType {
+		case "markdown":
+			// Goldmark escapes raw HTML unless explicitly made unsafe
+			buf.WriteString("<div class=\"nb-markdown\">")
+			err = goldmark.Convert([]byte(cell.Source), buf)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString("</div>\n")
+
+		case "code":
+			buf.WriteString("<pre class=\"nb-code\" data-lang=\"" + html.EscapeString(nb.Metadata.LanguageInfo.Name) + "\">")
+			buf.WriteString(html.EscapeString(string(cell.Source)))
+			buf.WriteString("</pre>\n")
+			for _, output := range cell.Outputs {
+				renderNotebookOutput(buf, &output)
+			}
+
+		default:
+			buf.WriteString("<pre class=\"nb-raw\">" + html.EscapeString(string(cell.Source)) + "</pre>\n")
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (output *notebookOutput) dataSource(mimeType string) (notebookSource, bool) {
+	// Other output types may hold JSON objects, so only decode those we render
+	var src notebookSource
+	raw, ok := output.Data[mimeType]
+	if !ok || json.Unmarshal(raw, &src) != nil {
+		return "", false
+	}
+	return src, true
+}
+
+func renderNotebookOutput(buf *bytes.Buffer, output *notebookOutput) {
+	switch output.OutputType {
+	case "stream":
+		buf.WriteString("<pre class=\"nb-output\">" + html.EscapeString(string(output.Text)) + "</pre>\n")
+
+	case "error":
+		// Tracebacks are ANSI coloured
+		buf.WriteString("<div class=\"nb-output nb-error\">")
+		buf.Write(ansiToHTML([]byte(strings.Join(output.Traceback, "\n"))))
+		buf.WriteString("</div>\n")
+
+	case "execute_result", "display_data":
+		// Prefer images, else plain text (HTML outputs could run scripts, so never used)
+		for _, imageType := range notebookImageTypes {
+			if data, ok := output.dataSource(imageType); ok {
+				buf.WriteString("<img class=\"nb-output\" alt=\"Output image\" src=\"data:" + imageType + ";base64," +
+					html.EscapeString(strings.Join(strings.Fields(string(data)), "")) + "\">\n")
+				return
+			}
+		}
+		if text, ok := output.dataSource("text/plain"); ok {
+			buf.WriteString("<pre class=\"nb-output\">" + html.EscapeString(string(text)) + "</pre>\n")
+		}
+	}
+}
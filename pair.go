@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	pairPath = "/pair"
+)
+
+type pairPageData struct {
+	uiPrefs
+}
+
+func pairingCodes(request *http.Request, short, key string) ([]string, error) {
+	// Paste link first, short so the code stays small enough to scan from a terminal
+	base := "https://" + request.Host
+	code, err := encodeQR(base + short)
+	if err != nil {
+		return nil, err
+	}
+	codes := []string{"scan 1, paste link:\n" + code.renderText()}
+
+	// Key as its own code, in the fragment of the pairing page so it's never sent anywhere
+	if key != "" {
+		code, err = encodeQR(base + pairPath + "#" + url.PathEscape(key))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, "scan 2, key (only on the receiving device):\n"+code.renderText())
+	}
+	return codes, nil
+}
+
+func addPairingCodes(request *http.Request, response *putResponse, key string) {
+	// Skip unless requested, codes are only drawn in text responses
+	if request.URL.Query().Get("qr") != "1" || wantsJSON(request) {
+		return
+	}
+
+	// Pairing is a convenience, so warn rather than fail the upload
+	codes, err := pairingCodes(request, response.Short, key)
+	if err != nil {
+		log.Printf("Failed to encode pairing QR codes - %s\n", err.Error())
+		response.Warnings = append(response.Warnings, "QR codes not drawn, key too long")
+		return
+	}
+	response.QR = codes
+}
+
+func pairHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", pairPath, request.RemoteAddr)
+
+	// Key arrives in the URL fragment, the page holds it until the paste link is scanned
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Referrer-Policy", "no-referrer")
+	renderPage(writer, request, "pair.html", &pairPageData{})
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/grufwub/gibon/crypto"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Unconfirmed handshakes expire after this long, counting as failed attempts
+	pakeSessionTimeout = time.Minute
+
+	// Maximum handshakes awaiting confirmation
+	maxPakeSessions = 10000
+
+	// Maximum handshake request body size
+	maxPakeRequestSize = 1024
+
+	// Random bytes in handshake session IDs
+	pakeSessionIDSize = 16
+
+	// Header carrying a client computed verifier for E2E uploads, params then scalar (base64url, dot separated)
+	pakeVerifierHeader = "X-Gibon-Pake-Verifier"
+)
+
+var (
+	// Handshakes awaiting confirmation by session ID, guarded by mutex
+	pakeSessions      = map[string]*pakeSession{}
+	pakeSessionsMutex sync.Mutex
+)
+
+type pakeVerifier struct {
+	Params string `json:"params"`
+	W      string `json:"w"`
+}
+
+type pakeSession struct {
+	c            cid.Cid
+	result       *crypto.SPAKE2Result
+	throttleKeys []string
+	expires      time.Time
+}
+
+type pakeParamsResponse struct {
+	Params string `json:"params"`
+}
+
+type pakeStartRequest struct {
+	Message string `json:"message"`
+}
+
+type pakeStartResponse struct {
+	Session string `json:"session"`
+	Message string `json:"message"`
+	Confirm string `json:"confirm"`
+}
+
+type pakeFinishRequest struct {
+	Session string `json:"session"`
+	Confirm string `json:"confirm"`
+}
+
+func isPakePaste(c cid.Cid) (bool, error) {
+	return indexStore.Has(indexKey("pake", c.String()))
+}
+
+func newPakeVerifier(key string) (*pakeVerifier, error) {
+	// Password scalar derived with the configured Argon2id costs and a fresh salt
+	params, err := crypto.NewArgon2Params(uint32(argon2Time), uint32(argon2Memory), uint8(argon2Threads))
+	if err != nil {
+		return nil, err
+	}
+	w, err := crypto.SPAKE2PasswordScalar(key, params)
+	if err != nil {
+		return nil, err
+	}
+	return &pakeVerifier{
+		Params: base64.RawURLEncoding.EncodeToString(params.Marshal()),
+		W:      base64.RawURLEncoding.EncodeToString(w),
+	}, nil
+}
+
+func parsePakeVerifier(str string) (*pakeVerifier, error) {
+	// Split into params and scalar
+	parts := strings.Split(str, ".")
+	if len(parts) != 2 {
+		return nil, errors.New("Invalid PAKE verifier!")
+	}
+	verifier := &pakeVerifier{Params: parts[0], W: parts[1]}
+
+	// Check both decode and are in bounds
+	_, _, err := verifier.decode()
+	if err != nil {
+		return nil, errors.New("Invalid PAKE verifier!")
+	}
+	return verifier, nil
+}
+
+func (verifier *pakeVerifier) decode() (*crypto.Argon2Params, []byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(verifier.Params)
+	if err != nil {
+		return nil, nil, err
+	}
+	params, rest, err := crypto.UnmarshalArgon2Params(b)
+	if err != nil {
+		return nil, nil, err
+	} else if len(rest) != 0 {
+		return nil, nil, errors.New("trailing PAKE params")
+	}
+	w, err := base64.RawURLEncoding.DecodeString(verifier.W)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, w, crypto.ValidateSPAKE2Scalar(w)
+}
+
+func registerPakePaste(c cid.Cid, verifier *pakeVerifier) error {
+	return indexPut(indexKey("pake", c.String()), verifier)
+}
+
+func getPakeVerifier(c cid.Cid) (*pakeVerifier, error) {
+	verifier := &pakeVerifier{}
+	err := indexGet(indexKey("pake", c.String()), verifier)
+	if err != nil {
+		return nil, err
+	}
+	return verifier, nil
+}
+
+func expirePakeSessions(now time.Time) {
+	// Abandoned handshakes are treated as wrong guesses (caller holds mutex)
+	for id, session := range pakeSessions {
+		if now.After(session.expires) {
+			delete(pakeSessions, id)
+			recordDecryptFailureKeys(session.throttleKeys)
+		}
+	}
+}
+
+func pakeRequestCID(writer http.ResponseWriter, request *http.Request, params httprouter.Params, suffix string) (cid.Cid, bool) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest(request.Method, pastePrefix+cidStr+suffix, request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return cid.Undef, false
+	}
+
+	// Refuse pastes blocked by moderation or re-keyed
+	if refuseUnavailablePaste(writer, c) {
+		return cid.Undef, false
+	}
+	return c, true
+}
+
+func pakeParamsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the paste CID
+	c, ok := pakeRequestCID(writer, request, params, "/pake")
+	if !ok {
+		return
+	}
+
+	// Get the paste's verifier, only its params are given out
+	verifier, err := getPakeVerifier(c)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(writer, &pakeParamsResponse{Params: verifier.Params})
+}
+
+func pakeStartHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the paste CID
+	c, ok := pakeRequestCID(writer, request, params, "/pake")
+	if !ok {
+		return
+	}
+
+	// Each handshake is a password guess, throttled like keyed requests
+	if !checkDecryptThrottle(writer, request, c) {
+		return
+	}
+
+	// Decode the client's message
+	start := &pakeStartRequest{}
+	err := json.NewDecoder(io.LimitReader(request.Body, maxPakeRequestSize)).Decode(start)
+	if err != nil {
+		http.Error(writer, "Invalid handshake request!", http.StatusBadRequest)
+		return
+	}
+	message, err := base64.RawURLEncoding.DecodeString(start.Message)
+	if err != nil || len(message) != crypto.SPAKE2MessageSize {
+		http.Error(writer, "Invalid handshake message!", http.StatusBadRequest)
+		return
+	}
+
+	// Get the paste's verifier
+	verifier, err := getPakeVerifier(c)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+	_, w, err := verifier.decode()
+	if err != nil {
+		log.Printf("Failed to decode PAKE verifier - %s\n", err.Error())
+		http.Error(writer, "Failed to get PAKE verifier", http.StatusInternalServerError)
+		return
+	}
+
+	// Run our side of the exchange, bound to the paste CID
+	spake, err := crypto.NewSPAKE2(false, w, nil, []byte(c.String()))
+	if err != nil {
+		log.Printf("Failed to start PAKE handshake - %s\n", err.Error())
+		http.Error(writer, "Failed to start handshake", http.StatusInternalServerError)
+		return
+	}
+	result, err := spake.Finish(message)
+	if err != nil {
+		http.Error(writer, "Invalid handshake message!", http.StatusBadRequest)
+		return
+	}
+
+	// Generate session ID
+	b := make([]byte, pakeSessionIDSize)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate PAKE session ID - %s\n", err.Error())
+		http.Error(writer, "Failed to start handshake", http.StatusInternalServerError)
+		return
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	// Store session until confirmed or expired
+	pakeSessionsMutex.Lock()
+	now := time.Now()
+	expirePakeSessions(now)
+	if len(pakeSessions) >= maxPakeSessions {
+		pakeSessionsMutex.Unlock()
+		http.Error(writer, "Too many handshakes in progress, try again later!", http.StatusServiceUnavailable)
+		return
+	}
+	pakeSessions[id] = &pakeSession{
+		c:            c,
+		result:       result,
+		throttleKeys: decryptThrottleKeys(request, c),
+		expires:      now.Add(pakeSessionTimeout),
+	}
+	pakeSessionsMutex.Unlock()
+
+	// Reply with our message and key confirmation
+	writeJSON(writer, &pakeStartResponse{
+		Session: id,
+		Message: base64.RawURLEncoding.EncodeToString(spake.Message()),
+		Confirm: base64.RawURLEncoding.EncodeToString(result.ServerConfirm),
+	})
+}
+
+func pakeFinishHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the paste CID
+	c, ok := pakeRequestCID(writer, request, params, "/pake/finish")
+	if !ok {
+		return
+	}
+
+	// Decode the client's confirmation
+	finish := &pakeFinishRequest{}
+	err := json.NewDecoder(io.LimitReader(request.Body, maxPakeRequestSize)).Decode(finish)
+	if err != nil {
+		http.Error(writer, "Invalid handshake request!", http.StatusBadRequest)
+		return
+	}
+	confirm, err := base64.RawURLEncoding.DecodeString(finish.Confirm)
+	if err != nil {
+		http.Error(writer, "Invalid handshake confirmation!", http.StatusBadRequest)
+		return
+	}
+
+	// Take the session, each can only be finished once
+	pakeSessionsMutex.Lock()
+	expirePakeSessions(time.Now())
+	session, ok := pakeSessions[finish.Session]
+	if ok && session.c.Equals(c) {
+		delete(pakeSessions, finish.Session)
+	} else {
+		ok = false
+	}
+	pakeSessionsMutex.Unlock()
+	if !ok {
+		http.Error(writer, "Handshake not found!", http.StatusNotFound)
+		return
+	}
+
+	// Wrong passphrase gives a different confirmation
+	if !hmac.Equal(confirm, session.result.ClientConfirm) {
+		recordDecryptFailureKeys(session.throttleKeys)
+		http.Error(writer, "Handshake failed!", http.StatusForbidden)
+		return
+	}
+
+	// Try look for paste with CID
+	p, err := getPaste(c)
+	if err != nil {
+		log.Printf("Paste not retrieved - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Count this view against any view limit
+	ok, err = takeView(c)
+	if err != nil {
+		log.Printf("Failed to check paste view limit - %s\n", err.Error())
+		http.Error(writer, "Failed to check paste view limit", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(writer, "Paste view limit reached!", http.StatusGone)
+		return
+	}
+
+	// Record view statistics (non-fatal)
+	err = recordPasteView(c)
+	if err != nil {
+		log.Printf("Failed to record paste view - %s\n", err.Error())
+	}
+
+	// Release the stored envelope sealed under the handshake key, nonce first
+	aead, err := crypto.NewAEAD(crypto.CipherAES256GCM, session.result.Key)
+	if err != nil {
+		log.Printf("Failed to seal PAKE release - %s\n", err.Error())
+		http.Error(writer, "Failed to seal paste", http.StatusInternalServerError)
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		log.Printf("Failed to seal PAKE release - %s\n", err.Error())
+		http.Error(writer, "Failed to seal paste", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("content-type", "application/octet-stream")
+	writer.Write(aead.Seal(nonce, nonce, p.marshal(), []byte(c.String())))
+}
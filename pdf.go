@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// A4 page size and margin (in points)
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+
+	// Monospace font size and line height (Courier glyphs are 0.6em wide)
+	pdfFontSize   = 10
+	pdfLineHeight = 12
+	pdfLineChars  = (pdfPageWidth - 2*pdfMargin) * 10 / (pdfFontSize * 6)
+	pdfPageLines  = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+
+	// Maximum pages in a converted PDF
+	maxPDFPages = 500
+)
+
+func wrapPDFLines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		// Expand tabs, then hard wrap at page width
+		line = strings.ReplaceAll(line, "\t", "    ")
+		for utf8.RuneCountInString(line) > pdfLineChars {
+			runes := []rune(line)
+			lines = append(lines, string(runes[:pdfLineChars]))
+			line = string(runes[pdfLineChars:])
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func escapePDFString(line string) string {
+	buf := &strings.Builder{}
+	for _, r := range line {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			buf.WriteByte(' ')
+		case r < 0x80:
+			buf.WriteRune(r)
+		case r <= 0xff:
+			// Latin-1 as octal escape (WinAnsi matches Latin-1 here)
+			fmt.Fprintf(buf, "\\%03o", r)
+		default:
+			// Outside the standard font encoding
+			buf.WriteByte('?')
+		}
+	}
+	return buf.String()
+}
+
+func textToPDF(text string) ([]byte, error) {
+	// Split lines into pages
+	lines := wrapPDFLines(text)
+	pages := [][]string{}
+	for len(lines) > 0 {
+		n := pdfPageLines
+		if n > len(lines) {
+			n = len(lines)
+		}
+		pages = append(pages, lines[:n])
+		lines = lines[n:]
+	}
+	if len(pages) > maxPDFPages {
+		return nil, errors.New("converted PDF exceeds max pages")
+	}
+
+	// Objects 1-3 are catalog, page tree and font, then a page and content stream per page
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	}
+	kids := []string{}
+	for _, page := range pages {
+		pageObj := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+
+		// Content stream draws each line top down
+		content := &strings.Builder{}
+		fmt.Fprintf(content, "BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin-pdfFontSize)
+		for _, line := range page {
+			fmt.Fprintf(content, "(%s) Tj T*\n", escapePDFString(line))
+		}
+		content.WriteString("ET")
+
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, pageObj+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	// Write objects, recording offsets for the cross-reference table
+	buf := &bytes.Buffer{}
+	buf.WriteString("%PDF-1.4\n")
+	offsets := []int{}
+	for i, obj := range objects {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+const (
+	// Maximum public key pastes to encrypt to
+	maxPGPKeyPastes = 8
+)
+
+var (
+	// Armor headers by detected PGP paste kind
+	pgpArmorKinds = []struct {
+		header string
+		kind   string
+	}{
+		{"-----BEGIN PGP MESSAGE-----", "message"},
+		{"-----BEGIN PGP SIGNED MESSAGE-----", "signed"},
+		{"-----BEGIN PGP PUBLIC KEY BLOCK-----", "public-key"},
+		{"-----BEGIN PGP SIGNATURE-----", "signature"},
+	}
+
+	// Content types served for ?format=pgp by PGP paste kind
+	pgpContentTypes = map[string]string{
+		"message":    "application/pgp-encrypted",
+		"signed":     "text/plain",
+		"public-key": "application/pgp-keys",
+		"signature":  "application/pgp-signature",
+	}
+)
+
+func detectPGPArmor(b []byte) string {
+	// Look for armor header at start of (trimmed) paste
+	trimmed := bytes.TrimLeft(b, " \t\r\n")
+	for _, armorKind := range pgpArmorKinds {
+		if bytes.HasPrefix(trimmed, []byte(armorKind.header)) {
+			return armorKind.kind
+		}
+	}
+	return ""
+}
+
+func readPGPKeyPastes(keysStr string) (openpgp.EntityList, error) {
+	// Get the public key paste CIDs
+	cidStrs := strings.Split(keysStr, ",")
+	if len(cidStrs) > maxPGPKeyPastes {
+		return nil, errors.New("Too many PGP key pastes")
+	}
+
+	keyring := openpgp.EntityList{}
+	for _, cidStr := range cidStrs {
+		// Get the public key paste, must be readable
+		c, err := cid.Decode(strings.TrimPrefix(strings.TrimSpace(cidStr), pastePrefix))
+		if err != nil {
+			return nil, err
+		}
+		p, err := getPaste(c)
+		if err != nil {
+			return nil, err
+		} else if p.encrypted {
+			return nil, errors.New("PGP key paste is encrypted")
+		}
+		err = p.decompress()
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse the armored public key(s)
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(p.text))
+		if err != nil {
+			return nil, err
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	return keyring, nil
+}
+
+func encryptPGP(b []byte, keyring openpgp.EntityList) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	// Armor the encrypted output so it stays a text paste
+	armorWriter, err := armor.Encode(buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypt to every key in the keyring
+	pgpWriter, err := openpgp.Encrypt(armorWriter, keyring, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = pgpWriter.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = pgpWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = armorWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
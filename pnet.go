@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+const (
+	// File name IPFS repos read their private network key from
+	swarmKeyName = "swarm.key"
+)
+
+var (
+	// Private network swarm key file copied into each repo (public network if empty)
+	swarmKeyFile string
+
+	// Swarm key file contents, loaded once for every shard
+	swarmKey []byte
+)
+
+func setupSwarmKey() error {
+	// Skip if disabled
+	if swarmKeyFile == "" {
+		return nil
+	}
+
+	// Load key from file, generating a new network on first run
+	b, err := ioutil.ReadFile(swarmKeyFile)
+	if os.IsNotExist(err) {
+		psk := make([]byte, 32)
+		_, err = rand.Read(psk)
+		if err != nil {
+			return err
+		}
+		b = []byte("/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(psk) + "\n")
+		err = ioutil.WriteFile(swarmKeyFile, b, 0600)
+		if err != nil {
+			return err
+		}
+		log.Printf("Generated new private network swarm key at %s, copy it to every peer\n", swarmKeyFile)
+	} else if err != nil {
+		return err
+	}
+
+	// Check it's a key libp2p accepts
+	_, err = pnet.DecodeV1PSK(bytes.NewReader(b))
+	if err != nil {
+		return errors.New("Invalid swarm key file " + swarmKeyFile + " - " + err.Error())
+	}
+	swarmKey = b
+
+	// Refuse to start unprotected should a repo's key go missing
+	pnet.ForcePrivateNetwork = true
+	if ipfsBootstrap == nil {
+		log.Println("Private network enabled without -bootstrap, public bootstrap peers in repo configs will be unreachable")
+	}
+	return nil
+}
+
+func (shard *ipfsShard) installSwarmKey() error {
+	// Skip if public network
+	if swarmKey == nil {
+		return nil
+	}
+
+	// Replace any existing key, the flag decides which network this is
+	keyPath := path.Join(shard.repoPath, swarmKeyName)
+	existing, err := ioutil.ReadFile(keyPath)
+	if err == nil && bytes.Equal(existing, swarmKey) {
+		return nil
+	}
+	return ioutil.WriteFile(keyPath, swarmKey, 0600)
+}
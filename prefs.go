@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	prefsPath = "/prefs"
+
+	// Web UI preferences cookie name
+	prefsCookieName = "gibon-prefs"
+
+	// Signed cookie lifetime
+	signedCookieMaxAge = 365 * 24 * time.Hour
+
+	// Cookie signing key size (in bytes)
+	cookieKeySize = 32
+
+	// Cookie signing key ID size (in bytes of key hash)
+	cookieKeyIDSize = 4
+
+	// Allowed UI font size range (in pixels, 0 is the stylesheet default)
+	minPrefsFontSize = 10
+	maxPrefsFontSize = 24
+)
+
+var (
+	// Cookie signing key file, generated if missing (random per-process key if unset)
+	cookieKeyFile string
+
+	// Retired cookie signing key files, still accepted while cookies roll over
+	cookieOldKeyFiles []string
+
+	// Cookie signing keys, the first signs and all verify
+	cookieKeys [][]byte
+
+	// Web UI themes selectable in preferences
+	prefsThemes = map[string]bool{"": true, "light": true, "dark": true}
+
+	errInvalidCookie = errors.New("invalid signed cookie")
+)
+
+// uiPrefs are web UI preferences, embedded in page data so every template can apply them.
+type uiPrefs struct {
+	Theme    string `json:"theme,omitempty"`
+	Wrap     bool   `json:"wrap,omitempty"`
+	FontSize int    `json:"font_size,omitempty"`
+}
+
+func (prefs *uiPrefs) setPrefs(p uiPrefs) {
+	*prefs = p
+}
+
+// prefsPage is implemented by page data embedding uiPrefs.
+type prefsPage interface {
+	setPrefs(uiPrefs)
+}
+
+func setupCookieKeys() error {
+	// Without a key file cookies only survive until restart
+	var key []byte
+	var err error
+	if cookieKeyFile != "" {
+		key, err = readSecretFile(cookieKeyFile, cookieKeySize)
+	} else {
+		key = make([]byte, cookieKeySize)
+		_, err = rand.Read(key)
+	}
+	if err != nil {
+		return err
+	}
+	cookieKeys = [][]byte{key}
+
+	// Retired keys must exist, never generate them
+	for _, path := range cookieOldKeyFiles {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, err := decodeSigningKey(string(b), cookieKeySize)
+		if err != nil {
+			return errors.New("Invalid secret file " + path + " - " + err.Error())
+		}
+		cookieKeys = append(cookieKeys, key)
+	}
+
+	return nil
+}
+
+func cookieKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:cookieKeyIDSize])
+}
+
+func cookieMAC(key []byte, name, payload string) string {
+	// Bind the MAC to the cookie name so values can't be swapped between cookies
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "=" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func setSignedCookie(writer http.ResponseWriter, name string, v interface{}) error {
+	// Value is 'keyid.payload.mac', payload being base64url JSON
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	key := cookieKeys[0]
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(writer, &http.Cookie{
+		Name:     name,
+		Value:    cookieKeyID(key) + "." + payload + "." + cookieMAC(key, name, payload),
+		Path:     "/",
+		MaxAge:   int(signedCookieMaxAge / time.Second),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func readSignedCookie(request *http.Request, name string, v interface{}) (bool, error) {
+	// Get the cookie, a missing one is not an error
+	cookie, err := request.Cookie(name)
+	if err == http.ErrNoCookie {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return false, errInvalidCookie
+	}
+
+	// Find the signing key, reporting whether it has since been rotated out
+	for i, key := range cookieKeys {
+		if parts[0] != cookieKeyID(key) {
+			continue
+		}
+		if !hmac.Equal([]byte(parts[2]), []byte(cookieMAC(key, name, parts[1]))) {
+			return false, errInvalidCookie
+		}
+		b, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false, errInvalidCookie
+		}
+		return i > 0, json.Unmarshal(b, v)
+	}
+	return false, errInvalidCookie
+}
+
+func (prefs *uiPrefs) validate() error {
+	if !prefsThemes[prefs.Theme] {
+		return errors.New("Unknown theme: " + prefs.Theme)
+	} else if prefs.FontSize != 0 && (prefs.FontSize < minPrefsFontSize || prefs.FontSize > maxPrefsFontSize) {
+		return errors.New("Font size out of range")
+	}
+	return nil
+}
+
+func requestPrefs(writer http.ResponseWriter, request *http.Request) uiPrefs {
+	// Defaults on missing or invalid cookie
+	prefs := uiPrefs{}
+	rotated, err := readSignedCookie(request, prefsCookieName, &prefs)
+	if err == nil {
+		err = prefs.validate()
+	}
+	if err != nil {
+		return uiPrefs{}
+	}
+
+	// Re-sign with the current key so retired keys can eventually be dropped
+	if rotated {
+		err = setSignedCookie(writer, prefsCookieName, &prefs)
+		if err != nil {
+			log.Printf("Failed to re-sign preferences cookie - %s\n", err.Error())
+		}
+	}
+	return prefs
+}
+
+func prefsRedirect(request *http.Request) string {
+	// Back to the referring page, but only on this host
+	referer, err := url.Parse(request.Referer())
+	if err != nil || referer.Host != request.Host || !strings.HasPrefix(referer.Path, "/") {
+		return "/"
+	}
+	return referer.RequestURI()
+}
+
+func getPrefsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", prefsPath, request.RemoteAddr)
+
+	// Cookie is HttpOnly, so scripts read preferences here
+	prefs := requestPrefs(writer, request)
+	writeJSON(writer, &prefs)
+}
+
+func setPrefsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", prefsPath, request.RemoteAddr)
+
+	// Parse preferences from form
+	prefs := uiPrefs{
+		Theme: request.FormValue("theme"),
+		Wrap:  request.FormValue("wrap") != "",
+	}
+	if fontSize := request.FormValue("font_size"); fontSize != "" {
+		size, err := strconv.Atoi(fontSize)
+		if err != nil {
+			http.Error(writer, "Invalid font size!", http.StatusBadRequest)
+			return
+		}
+		prefs.FontSize = size
+	}
+	err := prefs.validate()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Store in signed cookie, no server-side state
+	err = setSignedCookie(writer, prefsCookieName, &prefs)
+	if err != nil {
+		log.Printf("Failed to set preferences cookie - %s\n", err.Error())
+		http.Error(writer, "Failed to set preferences", http.StatusInternalServerError)
+		return
+	}
+
+	// Scripts get the stored preferences, forms go back where they came from
+	if wantsJSON(request) {
+		writeJSON(writer, &prefs)
+		return
+	}
+	http.Redirect(writer, request, prefsRedirect(request), http.StatusSeeOther)
+}
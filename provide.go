@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	icorepath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+const (
+	// Pending immediate provides, anything beyond is left to the schedule
+	provideQueueSize = 1024
+
+	// Deadline for one DHT provide, finding the closest peers can be slow
+	provideTimeout = 2 * time.Minute
+
+	// Period between checks for scheduled re-provides
+	provideCheckPeriod = time.Minute
+
+	// First retry delay after a failed provide, doubled per failure
+	provideRetryDelay = time.Minute
+)
+
+var (
+	// Provide new pastes to the DHT straight away and re-provide them on our own schedule
+	provideNew bool
+
+	// Period between re-provides of each paste (DHT provider records last 24 hours)
+	reprovidePeriod time.Duration
+
+	// New paste CIDs waiting to be provided
+	provideQueue = make(chan cid.Cid, provideQueueSize)
+)
+
+type provideSchedule struct {
+	Next     int64 `json:"next"`
+	Failures int   `json:"failures,omitempty"`
+}
+
+func setupProvide() error {
+	// Providing announces us in the public DHT
+	if !ipfsOnline {
+		return errors.New("Providing pastes requires -online")
+	}
+
+	// Only blocks in the shards' own blockstores can be provided
+	if storageBackendName != ipfsBackendName {
+		return errors.New("Providing pastes requires the IPFS storage backend")
+	}
+
+	log.Printf("Providing new pastes to the DHT, re-provided every %s\n", reprovidePeriod)
+	go provideNewPastes()
+	go reprovidePastes()
+	return nil
+}
+
+func queueProvide(c cid.Cid) {
+	// Schedule first, so it's provided even if the queue is full or we restart
+	err := indexPut(indexKey("provide", c.String()), &provideSchedule{Next: time.Now().Unix()})
+	if err != nil {
+		log.Printf("Failed to schedule paste %s provide - %s\n", c.String(), err.Error())
+		return
+	}
+
+	select {
+	case provideQueue <- c:
+	default:
+	}
+}
+
+func providePaste(c cid.Cid) error {
+	// Hold the nodes like a request, so a restart waits for the provide
+	ipfsGateMutex.RLock()
+	defer ipfsGateMutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(globalContext, provideTimeout)
+	defer cancel()
+	return shardForCID(c).api.Dht().Provide(ctx, icorepath.IpldPath(c))
+}
+
+func provideAndReschedule(c cid.Cid, schedule *provideSchedule) error {
+	// Provide, backing off exponentially (up to the period) on failure
+	err := providePaste(c)
+	now := time.Now()
+	if err != nil {
+		delay := provideRetryDelay << uint(schedule.Failures)
+		if delay <= 0 || delay > reprovidePeriod {
+			delay = reprovidePeriod
+		}
+		schedule.Next = now.Add(delay).Unix()
+		schedule.Failures++
+	} else {
+		schedule.Next = now.Add(reprovidePeriod).Unix()
+		schedule.Failures = 0
+	}
+
+	// Record when to provide next
+	putErr := indexPut(indexKey("provide", c.String()), schedule)
+	if err == nil {
+		err = putErr
+	}
+	return err
+}
+
+func provideNewPastes() {
+	for {
+		select {
+		case <-globalContext.Done():
+			return
+		case c := <-provideQueue:
+			err := provideAndReschedule(c, &provideSchedule{})
+			if err != nil {
+				log.Printf("Failed to provide paste %s - %s\n", c.String(), err.Error())
+			}
+		}
+	}
+}
+
+func reprovideDuePastes() error {
+	names, err := indexList("provide")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, name := range names {
+		if globalContext.Err() != nil {
+			return nil
+		}
+
+		// Skip those not yet due
+		key := indexKey("provide", name)
+		schedule := &provideSchedule{}
+		err = indexGet(key, schedule)
+		if err == ds.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		} else if schedule.Next > now {
+			continue
+		}
+
+		// Stop advertising pastes that are gone or must no longer spread
+		c, err := cid.Decode(name)
+		if err != nil {
+			indexDelete(key)
+			continue
+		}
+		has, err := storageBackend.Has(globalContext, c)
+		if err != nil {
+			return err
+		} else if !has || !isReplicable(c) {
+			indexDelete(key)
+			continue
+		}
+
+		err = provideAndReschedule(c, schedule)
+		if err != nil {
+			log.Printf("Failed to re-provide paste %s - %s\n", name, err.Error())
+		}
+	}
+	return nil
+}
+
+func reprovidePastes() {
+	for {
+		select {
+		case <-globalContext.Done():
+			return
+		case <-time.After(provideCheckPeriod):
+		}
+
+		err := reprovideDuePastes()
+		if err != nil {
+			log.Printf("Failed to re-provide pastes - %s\n", err.Error())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	icore "github.com/ipfs/interface-go-ipfs-core"
+	icorepath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+const (
+	// Maximum announcement message size, anything larger isn't ours
+	maxAnnouncementSize = 4096
+
+	// Deadline for fetching an announced paste from the network
+	announceFetchTimeout = time.Minute
+)
+
+var (
+	// libp2p pubsub topic public pastes are announced on (disabled if empty)
+	pubsubTopic string
+
+	// Mirror pastes announced on the topic by other instances
+	pubsubMirror bool
+)
+
+// pasteAnnouncement is published for each new public paste. Flags describe
+// the paste without revealing it: encrypted, e2e, pgp and expires.
+type pasteAnnouncement struct {
+	CID      string   `json:"cid"`
+	Size     int      `json:"size"`
+	Flags    []string `json:"flags,omitempty"`
+	Instance string   `json:"instance,omitempty"`
+}
+
+func pubsubEnabled() bool {
+	return pubsubTopic != ""
+}
+
+func setupPubsub() error {
+	// Pubsub runs over the swarm
+	if !ipfsOnline {
+		return errors.New("Pubsub announcements require -online")
+	}
+
+	// Subscribe up front if mirroring, so no announcements are missed
+	if pubsubMirror {
+		return subscribePubsub()
+	}
+	return nil
+}
+
+func subscribePubsub() error {
+	subscription, err := ipfsAPI.PubSub().Subscribe(globalContext, pubsubTopic)
+	if err != nil {
+		return err
+	}
+	log.Printf("Mirroring pastes announced on pubsub topic %s\n", pubsubTopic)
+	go mirrorAnnouncedPastes(subscription)
+	return nil
+}
+
+func announcePaste(c cid.Cid, size int, flags []string) {
+	b, err := json.Marshal(&pasteAnnouncement{
+		CID:      c.String(),
+		Size:     size,
+		Flags:    flags,
+		Instance: instanceHostname,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal paste announcement - %s\n", err.Error())
+		return
+	}
+
+	err = ipfsAPI.PubSub().Publish(globalContext, pubsubTopic, b)
+	if err != nil {
+		log.Printf("Failed to announce paste %s - %s\n", c.String(), err.Error())
+	}
+}
+
+func mirrorAnnouncedPastes(subscription icore.PubSubSubscription) {
+	defer subscription.Close()
+	for {
+		message, err := subscription.Next(globalContext)
+		if err != nil {
+			if globalContext.Err() == nil {
+				log.Printf("Pubsub subscription ended - %s\n", err.Error())
+			}
+			return
+		}
+
+		// Skip our own announcements and anything malformed
+		if message.From() == ipfsShards[0].node.Identity || len(message.Data()) > maxAnnouncementSize {
+			continue
+		}
+		announcement := &pasteAnnouncement{}
+		err = json.Unmarshal(message.Data(), announcement)
+		if err != nil {
+			continue
+		}
+
+		err = mirrorAnnouncedPaste(announcement)
+		if err != nil {
+			log.Printf("Failed to mirror announced paste %s - %s\n", announcement.CID, err.Error())
+		}
+	}
+}
+
+func mirrorAnnouncedPaste(announcement *pasteAnnouncement) error {
+	// Only paste CIDs within our size limit
+	c, err := cid.Decode(announcement.CID)
+	if err != nil {
+		return err
+	} else if !isPasteCID(c) {
+		return errors.New("Not a paste CID")
+	} else if int64(announcement.Size) > maxPasteBlockSize() {
+		return errors.New("Announced paste too large")
+	}
+
+	// Skip pastes we have, and those blocked or view-limited here
+	has, err := storageBackend.Has(globalContext, c)
+	if err != nil || has || !isReplicable(c) {
+		return err
+	}
+
+	// Fetch from the swarm, the announcing peer provides it
+	ctx, cancel := context.WithTimeout(globalContext, announceFetchTimeout)
+	defer cancel()
+	reader, err := shardForCID(c).api.Block().Get(ctx, icorepath.IpldPath(c))
+	if err != nil {
+		return err
+	}
+	b, err := readPasteBlock(reader)
+	if err != nil {
+		return err
+	}
+
+	// Store like any replicated paste, pinned if uploads are
+	err = storageBackend.Put(ctx, c, b, PutOptions{Pin: pinPastes})
+	if err != nil {
+		return err
+	}
+	log.Printf("Mirrored paste %s announced by %s\n", c.String(), announcement.Instance)
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	manifestPath = "/manifest.webmanifest"
+	sharePath    = "/share"
+
+	// Multipart form memory before spilling to temp files
+	shareFormMemory = 32 * 1048576
+)
+
+type webManifest struct {
+	Name            string             `json:"name"`
+	ShortName       string             `json:"short_name"`
+	Description     string             `json:"description"`
+	StartURL        string             `json:"start_url"`
+	Display         string             `json:"display"`
+	BackgroundColor string             `json:"background_color"`
+	ThemeColor      string             `json:"theme_color"`
+	Icons           []*webManifestIcon `json:"icons"`
+	ShareTarget     *webManifestShare  `json:"share_target"`
+}
+
+type webManifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+type webManifestShare struct {
+	Action  string                  `json:"action"`
+	Method  string                  `json:"method"`
+	Enctype string                  `json:"enctype"`
+	Params  *webManifestShareParams `json:"params"`
+}
+
+type webManifestShareParams struct {
+	Title string                  `json:"title"`
+	Text  string                  `json:"text"`
+	URL   string                  `json:"url"`
+	Files []*webManifestShareFile `json:"files"`
+}
+
+type webManifestShareFile struct {
+	Name   string   `json:"name"`
+	Accept []string `json:"accept"`
+}
+
+type sharedPageData struct {
+	uiPrefs
+	Path string
+	URL  string
+}
+
+func manifestHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", manifestPath, request.RemoteAddr)
+
+	// Build manifest with fingerprinted icon paths
+	manifest := &webManifest{
+		Name:            "Gibon",
+		ShortName:       "Gibon",
+		Description:     "An IPFS-backed pastebin service with encryption support",
+		StartURL:        "/",
+		Display:         "standalone",
+		BackgroundColor: "#fafafa",
+		ThemeColor:      "#2e7d5b",
+		Icons:           []*webManifestIcon{},
+	}
+	for _, size := range []string{"192", "512"} {
+		src, err := assetPath("icon-" + size + ".png")
+		if err != nil {
+			continue
+		}
+		manifest.Icons = append(manifest.Icons, &webManifestIcon{
+			Src:   src,
+			Sizes: size + "x" + size,
+			Type:  "image/png",
+		})
+	}
+
+	// Replicas are read-only, so can't be share targets
+	if !isReplica() {
+		manifest.ShareTarget = &webManifestShare{
+			Action:  sharePath,
+			Method:  "POST",
+			Enctype: "multipart/form-data",
+			Params: &webManifestShareParams{
+				Title: "title",
+				Text:  "text",
+				URL:   "url",
+				Files: []*webManifestShareFile{{Name: "file", Accept: []string{"*/*"}}},
+			},
+		}
+	}
+
+	// Write the manifest
+	writer.Header().Set("content-type", "application/manifest+json")
+	json.NewEncoder(writer).Encode(manifest)
+}
+
+func readSharedFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Read file content with paste size limit
+	b, err := ioutil.ReadAll(io.LimitReader(file, maxPasteSize+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(b)) > maxPasteSize {
+		return nil, errors.New("Shared file too large: " + header.Filename)
+	}
+	return b, nil
+}
+
+func putSharedText(ctx context.Context, b []byte, userAgent string, opts PutOptions) (cid.Cid, error) {
+	// Run WASM content plugins
+	var tags []string
+	if len(wasmPlugins) > 0 {
+		result, err := runWASMPlugins(b)
+		if err != nil {
+			return cid.Undef, err
+		}
+		b, tags = result.text, result.tags
+	}
+
+	// Create new paste, compress
+	p := &paste{text: b}
+	err := p.compress()
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Place the paste into the IPFS store
+	c, duplicate, err := putPaste(ctx, p, opts)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Store derived title and plugin tags in metadata, tags in index, existing pastes keep theirs
+	if !duplicate {
+		title := extractTitle(b)
+		var oldTags []string
+		err = updatePasteMeta(c, func(meta *pasteMeta) {
+			oldTags = meta.Tags
+			meta.Title = title
+			meta.Tags = tags
+		})
+		if err == nil {
+			err = retagPaste(c, oldTags, tags)
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	// Record upload for operator reports and run create hook
+	if !duplicate {
+		recordReportUpload(userAgent, len(b))
+		runHook(hookOnCreate, c)
+	}
+
+	return c, nil
+}
+
+func shareTargetHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", sharePath, request.RemoteAddr)
+
+	// Parse the shared form
+	request.Body = http.MaxBytesReader(writer, request.Body, maxPasteSize*maxBundleSizeFactor)
+	err := request.ParseMultipartForm(shareFormMemory)
+	if err != nil {
+		http.Error(writer, "Invalid share request!", http.StatusBadRequest)
+		return
+	}
+	defer request.MultipartForm.RemoveAll()
+	opts, err := pastePutOptions(request)
+	if err != nil {
+		http.Error(writer, "Unsupported hash!", http.StatusBadRequest)
+		return
+	}
+
+	var pathStr string
+	files := request.MultipartForm.File["file"]
+	switch {
+	// Single file is shared as its own paste
+	case len(files) == 1:
+		b, err := readSharedFile(files[0])
+		if err != nil {
+			http.Error(writer, "Shared file too large!", http.StatusRequestEntityTooLarge)
+			return
+		}
+		filePath, err := cleanBundlePath(files[0].Filename)
+		if err != nil {
+			filePath = "shared"
+		}
+		c, err := putBundleFile(request.Context(), filePath, b, "", opts)
+		if err != nil {
+			log.Printf("Failed to put shared file - %s\n", err.Error())
+			http.Error(writer, "Failed to put shared file", http.StatusInternalServerError)
+			return
+		}
+		pathStr = pastePrefix + c.String()
+
+	// Multiple files are shared as a bundle
+	case len(files) > 1:
+		if len(files) > maxBundleFiles {
+			http.Error(writer, "Too many shared files!", http.StatusBadRequest)
+			return
+		}
+		bndl := &bundle{Files: []bundleFile{}}
+		for _, header := range files {
+			b, err := readSharedFile(header)
+			if err != nil {
+				http.Error(writer, "Shared file too large!", http.StatusRequestEntityTooLarge)
+				return
+			}
+			filePath, err := cleanBundlePath(header.Filename)
+			if err != nil {
+				http.Error(writer, "Invalid shared file name!", http.StatusBadRequest)
+				return
+			} else if _, ok := bndl.lookup(filePath); ok {
+				continue
+			}
+			c, err := putBundleFile(request.Context(), filePath, b, "", opts)
+			if err != nil {
+				log.Printf("Failed to put shared file - %s\n", err.Error())
+				http.Error(writer, "Failed to put shared file", http.StatusInternalServerError)
+				return
+			}
+			bndl.Files = append(bndl.Files, bundleFile{Path: filePath, Paste: c, Size: len(b)})
+		}
+		c, err := putBundle(bndl)
+		if err != nil {
+			log.Printf("Failed to put bundle - %s\n", err.Error())
+			http.Error(writer, "Failed to put bundle", http.StatusInternalServerError)
+			return
+		}
+		pathStr = bundlePrefix + "/" + c.String()
+
+	// Otherwise share the text fields as a paste
+	default:
+		parts := []string{}
+		for _, field := range []string{"title", "text", "url"} {
+			if value := strings.TrimSpace(request.FormValue(field)); value != "" {
+				parts = append(parts, value)
+			}
+		}
+		if len(parts) == 0 {
+			http.Error(writer, "Nothing shared!", http.StatusBadRequest)
+			return
+		}
+		b := []byte(strings.Join(parts, "\n\n"))
+		if int64(len(b)) > maxPasteSize {
+			http.Error(writer, "Shared text too large!", http.StatusRequestEntityTooLarge)
+			return
+		}
+		c, err := putSharedText(request.Context(), b, request.UserAgent(), opts)
+		if err == errPluginRejected {
+			http.Error(writer, "Shared text rejected by plugin!", http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			log.Printf("Failed to put shared text - %s\n", err.Error())
+			http.Error(writer, "Failed to put shared text", http.StatusInternalServerError)
+			return
+		}
+		pathStr = pastePrefix + c.String()
+	}
+
+	// Show the link back to the sharer
+	renderPage(writer, request, "shared.html", &sharedPageData{
+		Path: pathStr,
+		URL:  "https://" + request.Host + pathStr,
+	})
+}
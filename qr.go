@@ -0,0 +1,470 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// Largest supported QR version (57x57 modules, 213 bytes at EC level M)
+	qrMaxVersion = 10
+
+	// Light modules around the code, scanners need at least 4
+	qrQuietZone = 4
+)
+
+// qrBlocks is the EC level M block structure of a QR version: EC codewords
+// per block, then the count and data codewords of each of two block groups.
+type qrBlocks struct {
+	ecPerBlock  int
+	groups      [2]int
+	groupLength [2]int
+}
+
+var (
+	// EC level M block structure by version
+	qrVersionBlocks = [qrMaxVersion + 1]qrBlocks{
+		1:  {10, [2]int{1, 0}, [2]int{16, 0}},
+		2:  {16, [2]int{1, 0}, [2]int{28, 0}},
+		3:  {26, [2]int{1, 0}, [2]int{44, 0}},
+		4:  {18, [2]int{2, 0}, [2]int{32, 0}},
+		5:  {24, [2]int{2, 0}, [2]int{43, 0}},
+		6:  {16, [2]int{4, 0}, [2]int{27, 0}},
+		7:  {18, [2]int{4, 0}, [2]int{31, 0}},
+		8:  {22, [2]int{2, 2}, [2]int{38, 39}},
+		9:  {22, [2]int{3, 2}, [2]int{36, 37}},
+		10: {26, [2]int{4, 1}, [2]int{43, 44}},
+	}
+
+	// Alignment pattern centre coordinates by version
+	qrAlignment = [qrMaxVersion + 1][]int{
+		2:  {6, 18},
+		3:  {6, 22},
+		4:  {6, 26},
+		5:  {6, 30},
+		6:  {6, 34},
+		7:  {6, 22, 38},
+		8:  {6, 24, 42},
+		9:  {6, 26, 46},
+		10: {6, 28, 50},
+	}
+)
+
+// qrCode is an encoded QR symbol, modules indexed [y][x] with true dark.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func (blocks qrBlocks) dataLength() int {
+	return blocks.groups[0]*blocks.groupLength[0] + blocks.groups[1]*blocks.groupLength[1]
+}
+
+func qrMultiply(x, y byte) byte {
+	// Multiply in GF(256) modulo x^8 + x^4 + x^3 + x^2 + 1
+	var z byte
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x1d)
+		z ^= ((y >> uint(i)) & 1) * x
+	}
+	return z
+}
+
+func qrErrorCorrection(data []byte, degree int) []byte {
+	// Generator polynomial (x - 2^0)...(x - 2^(degree-1)), leading term dropped
+	divisor := make([]byte, degree)
+	divisor[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			divisor[j] = qrMultiply(divisor[j], root)
+			if j+1 < degree {
+				divisor[j] ^= divisor[j+1]
+			}
+		}
+		root = qrMultiply(root, 0x02)
+	}
+
+	// Remainder of data divided by the generator is the EC codewords
+	result := make([]byte, degree)
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[degree-1] = 0
+		for i := range result {
+			result[i] ^= qrMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+func qrCodewords(text []byte) ([]byte, int, error) {
+	// Find the smallest version that fits the text in byte mode
+	version := 1
+	for ; version <= qrMaxVersion; version++ {
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+len(text)*8 <= qrVersionBlocks[version].dataLength()*8 {
+			break
+		}
+	}
+	if version > qrMaxVersion {
+		return nil, 0, errors.New("text too long for QR code")
+	}
+	blocks := qrVersionBlocks[version]
+
+	// Byte mode indicator, character count, then the text
+	bits := []bool{}
+	appendBits := func(value, count int) {
+		for i := count - 1; i >= 0; i-- {
+			bits = append(bits, (value>>uint(i))&1 == 1)
+		}
+	}
+	appendBits(0x4, 4)
+	if version >= 10 {
+		appendBits(len(text), 16)
+	} else {
+		appendBits(len(text), 8)
+	}
+	for _, b := range text {
+		appendBits(int(b), 8)
+	}
+
+	// Terminator and padding to a whole byte, then alternating pad bytes
+	capacity := blocks.dataLength() * 8
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	data := make([]byte, 0, blocks.dataLength())
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << uint(7-j)
+			}
+		}
+		data = append(data, b)
+	}
+	for pad := byte(0xec); len(data) < cap(data); pad ^= 0xec ^ 0x11 {
+		data = append(data, pad)
+	}
+
+	// Split into blocks, each with its own EC codewords
+	dataBlocks, ecBlocks := [][]byte{}, [][]byte{}
+	for group := range blocks.groups {
+		for i := 0; i < blocks.groups[group]; i++ {
+			block := data[:blocks.groupLength[group]]
+			data = data[blocks.groupLength[group]:]
+			dataBlocks = append(dataBlocks, block)
+			ecBlocks = append(ecBlocks, qrErrorCorrection(block, blocks.ecPerBlock))
+		}
+	}
+
+	// Interleave data codewords then EC codewords across blocks
+	result := []byte{}
+	for i := 0; i < blocks.groupLength[0] || i < blocks.groupLength[1]; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result, version, nil
+}
+
+func (code *qrCode) setFunction(x, y int, dark bool) {
+	code.modules[y][x] = dark
+	code.function[y][x] = true
+}
+
+func (code *qrCode) drawFunctionPatterns(version int) {
+	// Timing patterns
+	for i := 0; i < code.size; i++ {
+		code.setFunction(6, i, i%2 == 0)
+		code.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators, in three corners
+	for _, centre := range [][2]int{{3, 3}, {code.size - 4, 3}, {3, code.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := centre[0]+dx, centre[1]+dy
+				if x < 0 || x >= code.size || y < 0 || y >= code.size {
+					continue
+				}
+				dist := qrMax(qrAbs(dx), qrAbs(dy))
+				code.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, except where they'd overlap finders
+	positions := qrAlignment[version]
+	for i, y := range positions {
+		for j, x := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					code.setFunction(x+dx, y+dy, qrMax(qrAbs(dx), qrAbs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve format areas, then version info for larger codes
+	code.drawFormat(0)
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 == 1
+			a, b := code.size-11+i%3, i/3
+			code.setFunction(a, b, dark)
+			code.setFunction(b, a, dark)
+		}
+	}
+}
+
+func (code *qrCode) drawFormat(mask int) {
+	// EC level M (00) and mask, BCH protected and masked
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 == 1
+	}
+
+	// First copy, around the top left finder
+	for i := 0; i <= 5; i++ {
+		code.setFunction(8, i, bit(i))
+	}
+	code.setFunction(8, 7, bit(6))
+	code.setFunction(8, 8, bit(7))
+	code.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		code.setFunction(14-i, 8, bit(i))
+	}
+
+	// Second copy, split between the other finders, and the always dark module
+	for i := 0; i < 8; i++ {
+		code.setFunction(code.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		code.setFunction(8, code.size-15+i, bit(i))
+	}
+	code.setFunction(8, code.size-8, true)
+}
+
+func (code *qrCode) drawCodewords(data []byte) {
+	// Zigzag up and down column pairs from the right, skipping the vertical timing column
+	i := 0
+	for right := code.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < code.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = code.size - 1 - vert
+				}
+				if !code.function[y][x] && i < len(data)*8 {
+					code.modules[y][x] = (data[i>>3]>>uint(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func qrMasked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (code *qrCode) applyMask(mask int) {
+	// Masking is its own inverse, so also undoes a previous mask
+	for y := 0; y < code.size; y++ {
+		for x := 0; x < code.size; x++ {
+			if !code.function[y][x] && qrMasked(mask, x, y) {
+				code.modules[y][x] = !code.modules[y][x]
+			}
+		}
+	}
+}
+
+func (code *qrCode) penalty() int {
+	score := 0
+	get := func(x, y int, vertical bool) bool {
+		if vertical {
+			return code.modules[x][y]
+		}
+		return code.modules[y][x]
+	}
+
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < code.size; y++ {
+			// Runs of five or more same coloured modules
+			run := 1
+			for x := 1; x <= code.size; x++ {
+				if x < code.size && get(x, y, vertical) == get(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// Finder-like patterns with four light modules on either side
+			for x := 0; x+11 <= code.size; x++ {
+				pattern := ""
+				for i := 0; i < 11; i++ {
+					if get(x+i, y, vertical) {
+						pattern += "1"
+					} else {
+						pattern += "0"
+					}
+				}
+				if pattern == "10111010000" || pattern == "00001011101" {
+					score += 40
+				}
+			}
+		}
+	}
+
+	// Two by two blocks of same coloured modules, and overall dark balance
+	dark := 0
+	for y := 0; y < code.size; y++ {
+		for x := 0; x < code.size; x++ {
+			if code.modules[y][x] {
+				dark++
+			}
+			if x+1 < code.size && y+1 < code.size {
+				c := code.modules[y][x]
+				if c == code.modules[y][x+1] && c == code.modules[y+1][x] && c == code.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+	total := code.size * code.size
+	score += (qrAbs(dark*20-total*10)+total-1)/total*10 - 10
+	return score
+}
+
+func encodeQR(text string) (*qrCode, error) {
+	// Get interleaved codewords and the version they fit
+	data, version, err := qrCodewords([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+
+	// Draw function patterns, then the codewords around them
+	code := &qrCode{size: version*4 + 17}
+	code.modules = make([][]bool, code.size)
+	code.function = make([][]bool, code.size)
+	for y := range code.modules {
+		code.modules[y] = make([]bool, code.size)
+		code.function[y] = make([]bool, code.size)
+	}
+	code.drawFunctionPatterns(version)
+	code.drawCodewords(data)
+
+	// Pick the mask giving the lowest penalty
+	best, bestScore := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormat(mask)
+		score := code.penalty()
+		if bestScore < 0 || score < bestScore {
+			best, bestScore = mask, score
+		}
+		code.applyMask(mask)
+	}
+	code.applyMask(best)
+	code.drawFormat(best)
+
+	return code, nil
+}
+
+func (code *qrCode) dark(x, y int) bool {
+	// Quiet zone is light
+	x, y = x-qrQuietZone, y-qrQuietZone
+	return x >= 0 && x < code.size && y >= 0 && y < code.size && code.modules[y][x]
+}
+
+// renderText draws the code with half blocks, two module rows per line.
+// Light modules are drawn, so it scans on the usual dark terminal background.
+func (code *qrCode) renderText() string {
+	builder := &strings.Builder{}
+	size := code.size + 2*qrQuietZone
+	for y := 0; y < size; y += 2 {
+		for x := 0; x < size; x++ {
+			top, bottom := !code.dark(x, y), y+1 < size && !code.dark(x, y+1)
+			switch {
+			case top && bottom:
+				builder.WriteString("█")
+			case top:
+				builder.WriteString("▀")
+			case bottom:
+				builder.WriteString("▄")
+			default:
+				builder.WriteString(" ")
+			}
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+func qrAbs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func qrMax(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
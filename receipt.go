@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	jwksPath          = "/.well-known/jwks.json"
+	receiptVerifyPath = "/receipt/verify"
+
+	// Maximum receipt size accepted for verification
+	maxReceiptSize = 4096
+
+	// JWS header type for upload receipts
+	receiptType = "gibon-receipt+jwt"
+)
+
+var (
+	// Instance hostname, identifies the issuer in receipts
+	instanceHostname string
+
+	errReceiptsDisabled = errors.New("receipts require server signing, none issued")
+)
+
+type receiptHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type receiptClaims struct {
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	CID       string `json:"cid"`
+	Path      string `json:"path"`
+	Size      int    `json:"size,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+func wantsReceipt(request *http.Request) bool {
+	// Opt-in per upload, query or header so it works with any body encoding
+	return request.URL.Query().Get("receipt") != "" || request.Header.Get("X-Gibon-Receipt") != ""
+}
+
+func issueReceipt(response *putResponse) (string, error) {
+	// Receipts are signed with the server identity key
+	if serverSigningKey == nil {
+		return "", errReceiptsDisabled
+	}
+	kid := encodeSigningKey(serverSigningKey.Public().(ed25519.PublicKey))
+
+	// Compact JWS: header.claims.signature, EdDSA over the first two behind
+	// the receipt context, so no other use of the key can produce one
+	header, err := json.Marshal(&receiptHeader{Alg: "EdDSA", Typ: receiptType, Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(&receiptClaims{
+		Issuer:    "https://" + instanceHostname,
+		IssuedAt:  time.Now().Unix(),
+		CID:       response.CID,
+		Path:      response.Path,
+		Size:      response.Size,
+		Duplicate: response.Duplicate,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature := signWithContext(serverSigningKey, receiptSigContext, []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func addReceipt(request *http.Request, response *putResponse) {
+	if !wantsReceipt(request) {
+		return
+	}
+
+	// Upload already succeeded, so failures are only warned about
+	receipt, err := issueReceipt(response)
+	if err == errReceiptsDisabled {
+		response.Warnings = append(response.Warnings, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("Failed to issue upload receipt - %s\n", err.Error())
+		response.Warnings = append(response.Warnings, "failed to issue receipt")
+		return
+	}
+	response.Receipt = receipt
+}
+
+func jwksHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", jwksPath, request.RemoteAddr)
+
+	// Ensure server signing enabled
+	if serverSigningKey == nil {
+		http.Error(writer, "Server signing not enabled!", http.StatusNotFound)
+		return
+	}
+
+	// Publish the signing key as an Ed25519 JWK, for verifying receipts offline
+	pub := encodeSigningKey(serverSigningKey.Public().(ed25519.PublicKey))
+	writer.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(writer, map[string][]*jwk{"keys": {{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   pub,
+		Kid: pub,
+		Use: "sig",
+		Alg: "EdDSA",
+	}}})
+}
+
+func verifyReceipt(receipt string, pub ed25519.PublicKey) (*receiptClaims, error) {
+	// Split compact JWS and check the header is ours
+	parts := strings.Split(strings.TrimSpace(receipt), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed receipt")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	header := &receiptHeader{}
+	err = json.Unmarshal(b, header)
+	if err != nil {
+		return nil, err
+	} else if header.Alg != "EdDSA" || header.Typ != receiptType {
+		return nil, errors.New("unsupported receipt type")
+	}
+
+	// Check signature, then decode claims
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	} else if !verifyWithContext(pub, receiptSigContext, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, errors.New("invalid receipt signature")
+	}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := &receiptClaims{}
+	return claims, json.Unmarshal(b, claims)
+}
+
+func verifyReceiptHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", receiptVerifyPath, request.RemoteAddr)
+
+	// Ensure server signing enabled
+	if serverSigningKey == nil {
+		http.Error(writer, "Server signing not enabled!", http.StatusNotFound)
+		return
+	}
+
+	// Read the receipt from body
+	request.Body = http.MaxBytesReader(writer, request.Body, maxReceiptSize)
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	// Verify against this instance's key
+	claims, err := verifyReceipt(string(b), serverSigningKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		http.Error(writer, "Invalid receipt!", http.StatusBadRequest)
+		return
+	}
+	writeJSON(writer, claims)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+
+	"github.com/grufwub/gibon/crypto"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	// Bech32 human readable parts of age-style keys
+	recipientHRP = "age"
+	identityHRP  = "age-secret-key-"
+
+	// Maximum recipients per paste
+	maxPasteRecipients = 16
+
+	// Bech32 character set
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+var (
+	// Bech32 checksum generator
+	bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+)
+
+func init() {
+	// Identities open recipient pastes by unwrapping the content key
+	crypto.RegisterKDF(crypto.KDFX25519, crypto.KDFFunc(unwrapContentKey))
+}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	b := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		b = append(b, hrp[i]>>5)
+	}
+	b = append(b, 0)
+	for i := 0; i < len(hrp); i++ {
+		b = append(b, hrp[i]&31)
+	}
+	return b
+}
+
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	maxv := uint(1)<<to - 1
+	out := []byte{}
+	for _, v := range data {
+		if uint(v)>>from != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<from | uint(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
+
+func bech32Encode(hrp string, data []byte) (string, error) {
+	// Convert to 5-bit groups and append checksum
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	polymod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>uint(5*(5-i))&31))
+	}
+
+	// Encode with separator
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String(), nil
+}
+
+func bech32Decode(s string) (string, []byte, error) {
+	// Must not be mixed case
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case bech32 string")
+	}
+	s = strings.ToLower(s)
+
+	// Split human readable part from data at last separator
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 separator position")
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, errors.New("invalid bech32 character")
+		}
+		values = append(values, byte(v))
+	}
+
+	// Verify checksum and convert back to bytes
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+func parseRecipients(recipientsStr string) ([][]byte, error) {
+	recipients := [][]byte{}
+	for _, recipientStr := range strings.Split(recipientsStr, ",") {
+		// Decode age-style public key
+		hrp, data, err := bech32Decode(strings.TrimSpace(recipientStr))
+		if err != nil {
+			return nil, err
+		} else if hrp != recipientHRP || len(data) != curve25519.PointSize {
+			return nil, errors.New("Invalid recipient: " + recipientStr)
+		}
+		recipients = append(recipients, data)
+	}
+
+	if len(recipients) > maxPasteRecipients {
+		return nil, errors.New("Too many recipients")
+	}
+	return recipients, nil
+}
+
+func parseIdentity(identity string) ([]byte, error) {
+	// Decode age-style secret key
+	hrp, data, err := bech32Decode(strings.TrimSpace(identity))
+	if err != nil {
+		return nil, err
+	} else if hrp != identityHRP || len(data) != curve25519.ScalarSize {
+		return nil, errors.New("invalid identity")
+	}
+	return data, nil
+}
+
+func unwrapContentKey(stanzas []byte, identity string) ([]byte, error) {
+	// Get our secret key from identity, then try each recipient stanza
+	secret, err := parseIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.UnwrapRecipientKey(stanzas, secret)
+}
+
+func (p *paste) encryptToRecipients(recipients [][]byte, binding *pasteBinding) error {
+	// Generate new random content key
+	contentKey := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(contentKey); err != nil {
+		return err
+	}
+
+	// Wrap content key for each recipient
+	stanzas := []byte{}
+	for _, recipient := range recipients {
+		stanza, err := crypto.WrapRecipientKey(contentKey, recipient)
+		if err != nil {
+			return err
+		}
+		stanzas = append(stanzas, stanza...)
+	}
+
+	return p.seal(crypto.NewKeyEncrypter(crypto.KDFX25519, stanzas, contentKey), binding)
+}
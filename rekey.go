@@ -0,0 +1,189 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func isTombstonedPaste(c cid.Cid) (bool, error) {
+	return indexStore.Has(indexKey("tombstone", c.String()))
+}
+
+func tombstonePaste(c, replacement cid.Cid) error {
+	return indexPut(indexKey("tombstone", c.String()), replacement.String())
+}
+
+func rekeyPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("POST", pastePrefix+cidStr+"/rekey", request.RemoteAddr)
+
+	// Decode the paste CID, DAG nodes resolving to their paste
+	old, err := cid.Decode(cidStr)
+	if err == nil {
+		old, err = resolvePasteCID(request.Context(), old)
+	}
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, old) {
+		return
+	}
+
+	// Try look for paste with CID
+	p, err := getPaste(old)
+	if err != nil {
+		log.Printf("Paste not retrieved - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Only server-side encrypted pastes can be re-keyed
+	if !p.encrypted {
+		http.Error(writer, "Paste is not encrypted!", http.StatusBadRequest)
+		return
+	} else if p.sealing == pasteSealingE2E {
+		http.Error(writer, "E2E paste, re-key client-side!", http.StatusBadRequest)
+		return
+	}
+
+	// View-limited pastes would get a fresh limit, so refuse
+	limited, err := indexStore.Has(indexKey("views", old.String()))
+	if err != nil {
+		log.Printf("Failed to check paste view limit - %s\n", err.Error())
+		http.Error(writer, "Failed to check paste view limit", http.StatusInternalServerError)
+		return
+	} else if limited {
+		http.Error(writer, "View-limited pastes can't be re-keyed!", http.StatusBadRequest)
+		return
+	}
+
+	// Get old key (or identity) and new key, may be posted as form
+	err = parseKeyForm(writer, request)
+	if err != nil {
+		http.Error(writer, "Invalid key form!", http.StatusBadRequest)
+		return
+	}
+	key, err := decryptionKey(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	newKey, err := requestSecret(request, "new_key")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	} else if key == "" || newKey == "" {
+		http.Error(writer, "Both key and new key required!", http.StatusBadRequest)
+		return
+	}
+
+	// Parse new password hint if supplied (old hint described the old key)
+	hint, err := parseHint(request.URL.Query().Get("hint"))
+	if err != nil {
+		http.Error(writer, "Invalid hint!", http.StatusBadRequest)
+		return
+	}
+
+	// Decrypt with old key, text stays compressed (throttled against guessing)
+	if !checkDecryptThrottle(writer, request, old) {
+		return
+	}
+	binding, err := getPasteBinding(old)
+	if err != nil {
+		log.Printf("Failed to get paste binding - %s\n", err.Error())
+		http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+		return
+	}
+	started := time.Now()
+	err = p.decrypt(key, binding)
+	if err != nil {
+		log.Printf("Failed to decrypt paste - %s\n", err.Error())
+		recordDecryptFailure(request, old)
+		writeDecryptFailure(writer, request, started, "")
+		return
+	}
+
+	// Re-encrypt with new key, the content type carries over (view-limited pastes were refused)
+	err = p.encrypt(newKey, &pasteBinding{ContentType: binding.ContentType})
+	if err != nil {
+		log.Printf("Failed to encrypt paste - %s\n", err.Error())
+		http.Error(writer, "Paste encryption failed!", http.StatusInternalServerError)
+		return
+	}
+
+	opts, err := pastePutOptions(request)
+	if err != nil {
+		http.Error(writer, "Unsupported hash!", http.StatusBadRequest)
+		return
+	}
+
+	// Place the re-keyed paste into the IPFS store
+	c, duplicate, err := putPaste(request.Context(), p, opts)
+	if err != nil {
+		log.Printf("Failed to put paste in store - %s\n", err.Error())
+		http.Error(writer, "Failed to put paste in store", http.StatusInternalServerError)
+		return
+	}
+
+	// Carry over metadata describing the content
+	oldMeta, err := getPasteMeta(old)
+	if err == nil {
+		err = updatePasteMeta(c, func(meta *pasteMeta) {
+			meta.Language = oldMeta.Language
+			meta.ContentType = oldMeta.ContentType
+			meta.PGP = oldMeta.PGP
+			meta.Hint = hint
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to put paste metadata - %s\n", err.Error())
+		http.Error(writer, "Failed to put paste metadata", http.StatusInternalServerError)
+		return
+	}
+
+	// Tombstone the old paste if requested, it's no longer served
+	if request.URL.Query().Get("tombstone") == "1" {
+		err = tombstonePaste(old, c)
+		if err != nil {
+			log.Printf("Failed to tombstone paste - %s\n", err.Error())
+			http.Error(writer, "Failed to tombstone paste", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Run create hook for new pastes
+	if !duplicate {
+		runHook(hookOnCreate, c)
+	}
+
+	// Link to the old paste's DAG node as the previous version if enabled
+	dag := createPasteNode(request.Context(), c, old)
+
+	// Allocate short ID for sharing
+	short, err := shortIDForPaste(c)
+	if err != nil {
+		log.Printf("Failed to allocate short paste ID - %s\n", err.Error())
+		http.Error(writer, "Failed to allocate short paste ID", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the store path in response
+	writePutResponse(writer, request, &putResponse{
+		Path:      pastePrefix + c.String(),
+		CID:       c.String(),
+		Short:     shortPrefix + short,
+		Duplicate: duplicate,
+		DAG:       dag,
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	// Maximum simhash Hamming distance to be considered related
+	maxRelatedDistance = 20
+
+	// Score bonus per shared tag
+	relatedTagBonus = 8
+
+	// Maximum number of related pastes returned
+	maxRelatedPastes = 10
+
+	// Number of words per shingle
+	simhashShingleSize = 3
+)
+
+func simhash(b []byte) uint64 {
+	// Split into lowercase words
+	words := strings.FieldsFunc(strings.ToLower(string(b)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	// Build word shingles (short texts are a single shingle)
+	shingles := []string{}
+	if len(words) <= simhashShingleSize {
+		shingles = append(shingles, strings.Join(words, " "))
+	} else {
+		for i := 0; i+simhashShingleSize <= len(words); i++ {
+			shingles = append(shingles, strings.Join(words[i:i+simhashShingleSize], " "))
+		}
+	}
+
+	// Sum weights for each bit across shingle hashes
+	weights := [64]int{}
+	for _, shingle := range shingles {
+		hash := fnv.New64a()
+		hash.Write([]byte(shingle))
+		sum := hash.Sum64()
+
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	// Set bits with positive weight
+	var result uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			result |= 1 << uint(bit)
+		}
+	}
+	return result
+}
+
+func putSimhash(c cid.Cid, b []byte) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, simhash(b))
+	return indexStore.Put(indexKey("simhash", c.String()), value)
+}
+
+func getSimhash(cidStr string) (uint64, error) {
+	value, err := indexStore.Get(indexKey("simhash", cidStr))
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+func relatedPastes(c cid.Cid, hash uint64) ([]string, error) {
+	// Get tags of the target paste
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]bool{}
+	for _, tag := range meta.Tags {
+		tags[tag] = true
+	}
+
+	// Score every public paste with a simhash
+	candidates, err := indexList("simhash")
+	if err != nil {
+		return nil, err
+	}
+	candidates, err = filterPublicPastes(candidates)
+	if err != nil {
+		return nil, err
+	}
+	scores := map[string]int{}
+	for _, cidStr := range candidates {
+		if cidStr == c.String() {
+			continue
+		}
+
+		// Skip anything not similar enough
+		other, err := getSimhash(cidStr)
+		if err != nil {
+			continue
+		}
+		distance := bits.OnesCount64(hash ^ other)
+		if distance > maxRelatedDistance {
+			continue
+		}
+
+		// Score by similarity plus shared tags
+		score := 64 - distance
+		if otherMeta, err := getPasteMetaStr(cidStr); err == nil {
+			for _, tag := range otherMeta.Tags {
+				if tags[tag] {
+					score += relatedTagBonus
+				}
+			}
+		}
+		scores[cidStr] = score
+	}
+
+	// Sort highest score first
+	related := make([]string, 0, len(scores))
+	for cidStr := range scores {
+		related = append(related, cidStr)
+	}
+	sort.Slice(related, func(i, j int) bool {
+		if scores[related[i]] != scores[related[j]] {
+			return scores[related[i]] > scores[related[j]]
+		}
+		return related[i] < related[j]
+	})
+	if len(related) > maxRelatedPastes {
+		related = related[:maxRelatedPastes]
+	}
+
+	return related, nil
+}
+
+func relatedHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/related", request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Look for indexed simhash, else compute from unencrypted content
+	hash, err := getSimhash(c.String())
+	if err == ds.ErrNotFound {
+		p, err := getPaste(c)
+		if err == nil && !p.encrypted {
+			err = p.decompress()
+		}
+		if err != nil || p.encrypted {
+			http.Error(writer, "Paste not found!", http.StatusNotFound)
+			return
+		}
+		hash = simhash(p.text)
+	} else if err != nil {
+		log.Printf("Failed to get paste simhash - %s\n", err.Error())
+		http.Error(writer, "Failed to find related pastes", http.StatusInternalServerError)
+		return
+	}
+
+	// Find related public pastes
+	related, err := relatedPastes(c, hash)
+	if err != nil {
+		log.Printf("Failed to find related pastes - %s\n", err.Error())
+		http.Error(writer, "Failed to find related pastes", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the paste list in response
+	writePasteList(writer, request, "Related pastes", related)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	// Cache of rendered views, content is immutable so renders are too
+	renderCache *lruCache
+)
+
+type lruCache struct {
+	mutex    sync.Mutex
+	maxBytes int
+	curBytes int
+	order    *list.List
+	entries  map[string]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+type lruCacheStats struct {
+	Entries  int
+	Bytes    int
+	MaxBytes int
+	Hits     uint64
+	Misses   uint64
+}
+
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+func newLRUCache(maxBytes int) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (cache *lruCache) get(key string) ([]byte, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		cache.misses++
+		return nil, false
+	}
+
+	// Mark as most recently used
+	cache.hits++
+	cache.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+func (cache *lruCache) put(key string, value []byte) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	// Don't cache anything larger than the whole cache
+	if len(value) > cache.maxBytes {
+		return
+	}
+
+	// Replace existing entry
+	if elem, ok := cache.entries[key]; ok {
+		cache.curBytes -= len(elem.Value.(*lruEntry).value)
+		cache.order.Remove(elem)
+	}
+
+	// Add new entry as most recently used
+	cache.entries[key] = cache.order.PushFront(&lruEntry{key, value})
+	cache.curBytes += len(value)
+
+	// Evict least recently used until within size
+	for cache.curBytes > cache.maxBytes {
+		elem := cache.order.Back()
+		entry := elem.Value.(*lruEntry)
+		cache.order.Remove(elem)
+		delete(cache.entries, entry.key)
+		cache.curBytes -= len(entry.value)
+	}
+}
+
+func (cache *lruCache) stats() lruCacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return lruCacheStats{
+		Entries:  len(cache.entries),
+		Bytes:    cache.curBytes,
+		MaxBytes: cache.maxBytes,
+		Hits:     cache.hits,
+		Misses:   cache.misses,
+	}
+}
+
+func renderCacheKey(c cid.Cid, mode string, params ...string) string {
+	return c.String() + "|" + mode + "|" + strings.Join(params, "|")
+}
+
+func renderCached(key string, render func() ([]byte, error)) ([]byte, error) {
+	// Caching disabled
+	if renderCache == nil {
+		return render()
+	}
+
+	// Return cached render if we have it
+	if b, ok := renderCache.get(key); ok {
+		return b, nil
+	}
+
+	// Render and cache
+	b, err := render()
+	if err != nil {
+		return nil, err
+	}
+	renderCache.put(key, b)
+	return b, nil
+}
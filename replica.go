@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	// Primary instance base URL to replicate from (replica mode if set)
+	replicaOf string
+
+	// Primary instance admin token used for replication
+	replicaToken string
+
+	// Period between replica syncs
+	replicaSyncPeriod time.Duration
+)
+
+func isReplica() bool {
+	return replicaOf != ""
+}
+
+func isReplicable(c cid.Cid) bool {
+	// View-limited and PAKE pastes must only be served from here
+	gated, err := isGatedPaste(c)
+	if err != nil || gated {
+		return false
+	}
+
+	// Blocked pastes must not spread
+	blocked, err := isBlockedPaste(c)
+	if err != nil || blocked {
+		return false
+	}
+
+	// Nor may tombstoned ones
+	tombstoned, err := isTombstonedPaste(c)
+	return err == nil && !tombstoned
+}
+
+func listBlocksHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", apiPrefix+"blocks", request.RemoteAddr)
+
+	writer.Header().Set("content-type", "text/plain")
+
+	// Write out block CIDs from every shard
+	for _, shard := range ipfsShards {
+		keys, err := shard.node.Blockstore.AllKeysChan(request.Context())
+		if err != nil {
+			log.Printf("Failed to list blocks - %s\n", err.Error())
+			return
+		}
+
+		for c := range keys {
+			// View-limited and blocked pastes are never replicated
+			if !isReplicable(c) {
+				continue
+			}
+
+			writer.Write([]byte(c.String() + "\n"))
+		}
+	}
+}
+
+func getBlockHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", apiPrefix+"block/"+cidStr, request.RemoteAddr)
+
+	// Decode the block CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Invalid block CID!", http.StatusBadRequest)
+		return
+	}
+
+	// Only replicable blocks are served
+	if !isReplicable(c) {
+		http.Error(writer, "Block not found!", http.StatusNotFound)
+		return
+	}
+
+	// Get the raw block from responsible shard
+	block, err := shardForCID(c).node.Blockstore.Get(c)
+	if err != nil {
+		http.Error(writer, "Block not found!", http.StatusNotFound)
+		return
+	}
+
+	// Write the raw block
+	writer.Header().Set("content-type", "application/octet-stream")
+	writer.Write(block.RawData())
+}
+
+func replicaSync() error {
+	// Fetch only the blocks we're missing from primary
+	fetched, err := syncFrom(replicaOf, replicaToken)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Replica sync complete, fetched %d new blocks\n", fetched)
+	return nil
+}
+
+func startReplicaSync() {
+	log.Printf("Starting replica sync from %s every %s\n", replicaOf, replicaSyncPeriod)
+	go func() {
+		for {
+			err := replicaSync()
+			if err != nil {
+				log.Printf("Replica sync failed - %s\n", err.Error())
+			}
+
+			select {
+			case <-globalContext.Done():
+				return
+			case <-time.After(replicaSyncPeriod):
+			}
+		}
+	}()
+}
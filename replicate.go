@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	replicatePath = apiPrefix + "replicate/"
+
+	// Delay before pushing a new paste, its view limit and blocks are set just after the put
+	replicatePushDelay = 5 * time.Second
+
+	// Period between checks for due pushes
+	replicateCheckPeriod = 5 * time.Second
+
+	// Deadline for each push to a peer
+	replicatePushTimeout = 30 * time.Second
+
+	// Longest wait between retries of a failed push, doubled from the push delay
+	replicateMaxBackoff = time.Hour
+
+	// Failed pushes to a peer given up after this many attempts
+	maxReplicateAttempts = 20
+)
+
+var (
+	// Peer gibon instance base URLs every new paste is pushed to (disabled if empty)
+	replicatePeers []string
+
+	// HTTP client used for paste pushes
+	replicateClient = &http.Client{Timeout: replicatePushTimeout}
+)
+
+// replicateState is a pending push of one paste, to the peers still missing it
+type replicateState struct {
+	Peers    []string `json:"peers"`
+	Next     int64    `json:"next"`
+	Attempts int      `json:"attempts,omitempty"`
+}
+
+func replicateEnabled() bool {
+	return len(replicatePeers) > 0
+}
+
+func setupReplicate() error {
+	// Peers only accept pushes signed by instances they trust
+	if serverSigningKey == nil {
+		return errors.New("Pushing pastes to peers requires -signing-key")
+	}
+	log.Printf("Pushing new pastes to peers: %s\n", strings.Join(replicatePeers, ", "))
+	go pushPastes()
+	return nil
+}
+
+func queueReplicate(c cid.Cid) {
+	err := indexPut(indexKey("replicate", c.String()), &replicateState{
+		Peers: replicatePeers,
+		Next:  time.Now().Add(replicatePushDelay).Unix(),
+	})
+	if err != nil {
+		log.Printf("Failed to queue paste %s for peers - %s\n", c.String(), err.Error())
+	}
+}
+
+func pushPaste(ctx context.Context, peer string, c cid.Cid, b []byte, expires int64) error {
+	// Expiry goes in the query, covered by the request signature
+	url := peer + replicatePath + c.String()
+	if expires != 0 {
+		url += "?expires=" + strconv.FormatInt(expires, 10)
+	}
+
+	// Signed push of the raw block, peers verify it hashes to the CID
+	ctx, cancel := context.WithTimeout(ctx, replicatePushTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("content-type", "application/octet-stream")
+	err = signHTTPRequest(request, b)
+	if err != nil {
+		return err
+	}
+
+	response, err := replicateClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return errors.New("Peer responded with: " + response.Status)
+	}
+	return nil
+}
+
+func pushDuePaste(name string, state *replicateState) error {
+	key := indexKey("replicate", name)
+
+	// Drop pushes of pastes that are gone or must not spread
+	c, err := cid.Decode(name)
+	if err != nil {
+		return indexDelete(key)
+	}
+	has, err := storageBackend.Has(globalContext, c)
+	if err != nil {
+		return err
+	} else if !has || !isReplicable(c) {
+		return indexDelete(key)
+	}
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		return err
+	} else if meta.expired() {
+		return indexDelete(key)
+	}
+	b, err := storageBackend.Get(globalContext, c)
+	if err != nil {
+		return err
+	}
+
+	// Push to each peer still missing it, with its expiry
+	remaining := []string{}
+	for _, peer := range state.Peers {
+		err = pushPaste(globalContext, peer, c, b, meta.Expires)
+		if err != nil {
+			log.Printf("Failed to push paste %s to %s - %s\n", name, peer, err.Error())
+			remaining = append(remaining, peer)
+		}
+	}
+
+	// Done, or retry the rest with backoff until giving up
+	state.Attempts++
+	if len(remaining) == 0 {
+		return indexDelete(key)
+	} else if state.Attempts >= maxReplicateAttempts {
+		log.Printf("Giving up pushing paste %s to %s\n", name, strings.Join(remaining, ", "))
+		return indexDelete(key)
+	}
+	backoff := replicatePushDelay << uint(state.Attempts)
+	if backoff <= 0 || backoff > replicateMaxBackoff {
+		backoff = replicateMaxBackoff
+	}
+	state.Peers = remaining
+	state.Next = time.Now().Add(backoff).Unix()
+	return indexPut(key, state)
+}
+
+func pushDuePastes() error {
+	names, err := indexList("replicate")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, name := range names {
+		if globalContext.Err() != nil {
+			return nil
+		}
+
+		state := &replicateState{}
+		err = indexGet(indexKey("replicate", name), state)
+		if err == ds.ErrNotFound || (err == nil && state.Next > now) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = pushDuePaste(name, state)
+		if err != nil {
+			log.Printf("Failed to push paste %s - %s\n", name, err.Error())
+		}
+	}
+	return nil
+}
+
+func pushPastes() {
+	for {
+		select {
+		case <-globalContext.Done():
+			return
+		case <-time.After(replicateCheckPeriod):
+		}
+
+		err := pushDuePastes()
+		if err != nil {
+			log.Printf("Failed to push pastes to peers - %s\n", err.Error())
+		}
+	}
+}
+
+func receivePasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("POST", replicatePath+cidStr, request.RemoteAddr)
+
+	// Read the pushed block, the stored paste with its envelope
+	request.Body = http.MaxBytesReader(writer, request.Body, maxPasteBlockSize())
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, "Invalid paste!", http.StatusBadRequest)
+		return
+	}
+
+	// Only accept pushes from trusted peers
+	err = verifyHTTPRequest(request, b, syncPeerKeys)
+	if err != nil {
+		log.Printf("Rejected paste push from %s - %s\n", request.RemoteAddr, err.Error())
+		http.Error(writer, "Invalid request signature!", http.StatusUnauthorized)
+		return
+	}
+
+	// Check it's a paste block hashing to its CID
+	c, err := cid.Decode(cidStr)
+	if err != nil || !isPasteCID(c) {
+		http.Error(writer, "Invalid paste CID!", http.StatusBadRequest)
+		return
+	}
+	chk, err := c.Prefix().Sum(b)
+	if err != nil || !chk.Equals(c) {
+		http.Error(writer, "Paste doesn't match CID!", http.StatusBadRequest)
+		return
+	}
+
+	// Refuse pastes blocked or tombstoned here
+	if !isReplicable(c) {
+		http.Error(writer, "Paste not accepted!", http.StatusForbidden)
+		return
+	}
+
+	// Get the pushed expiry, if any
+	var expires int64
+	if str := request.URL.Query().Get("expires"); str != "" {
+		expires, err = strconv.ParseInt(str, 10, 64)
+		if err != nil || expires <= 0 {
+			http.Error(writer, "Invalid expiry!", http.StatusBadRequest)
+			return
+		} else if expires <= time.Now().Unix() {
+			http.Error(writer, "Paste has expired!", http.StatusGone)
+			return
+		}
+
+		// Record it before storing, so the paste is never served here without it (earliest expiry wins)
+		err = updatePasteMeta(c, func(meta *pasteMeta) {
+			if meta.Expires == 0 || expires < meta.Expires {
+				meta.Expires = expires
+			}
+		})
+		if err != nil {
+			log.Printf("Failed to update pushed paste metadata - %s\n", err.Error())
+			http.Error(writer, "Failed to store paste", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Store like an upload, pinned if uploads are
+	err = storageBackend.Put(request.Context(), c, b, PutOptions{Pin: pinPastes})
+	if err != nil {
+		log.Printf("Failed to store pushed paste - %s\n", err.Error())
+		http.Error(writer, "Failed to store paste", http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	// Number of top user agents / pastes included in reports
+	maxReportTop = 10
+
+	// Maximum recorded user agent length
+	maxReportAgentLength = 128
+)
+
+var (
+	// Operator report webhook URL (reports disabled if empty)
+	reportWebhook string
+
+	// Period between operator reports
+	reportPeriod time.Duration
+
+	// Guards report state read-modify-write
+	reportMutex sync.Mutex
+
+	// HTTP client used for report delivery
+	reportClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+type reportState struct {
+	Since          time.Time         `json:"since"`
+	NewPastes      uint64            `json:"new_pastes"`
+	NewBytes       uint64            `json:"new_bytes"`
+	StorageAtStart uint64            `json:"storage_at_start"`
+	UserAgents     map[string]uint64 `json:"user_agents"`
+}
+
+type reportCount struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+type operatorReport struct {
+	PeriodStart   time.Time      `json:"period_start"`
+	PeriodEnd     time.Time      `json:"period_end"`
+	NewPastes     uint64         `json:"new_pastes"`
+	NewBytes      uint64         `json:"new_bytes"`
+	StorageBytes  uint64         `json:"storage_bytes"`
+	StorageGrowth int64          `json:"storage_growth_bytes"`
+	TopUserAgents []*reportCount `json:"top_user_agents"`
+	TotalViews    uint64         `json:"total_views"`
+	TopPastes     []*pasteStats  `json:"top_pastes"`
+}
+
+func reportsEnabled() bool {
+	return reportWebhook != ""
+}
+
+func storageUsage() (uint64, error) {
+	var total uint64
+	for _, shard := range ipfsShards {
+		usage, err := shard.node.Repo.GetStorageUsage()
+		if err != nil {
+			return 0, err
+		}
+		total += usage
+	}
+	return total, nil
+}
+
+func newReportState() (*reportState, error) {
+	usage, err := storageUsage()
+	if err != nil {
+		return nil, err
+	}
+	return &reportState{
+		Since:          time.Now().UTC(),
+		StorageAtStart: usage,
+		UserAgents:     map[string]uint64{},
+	}, nil
+}
+
+func getReportState() (*reportState, error) {
+	// Look for persisted state, else start new
+	state := &reportState{}
+	err := indexGet(indexKey("report", "state"), state)
+	if err == ds.ErrNotFound {
+		return newReportState()
+	} else if err != nil {
+		return nil, err
+	}
+	if state.UserAgents == nil {
+		state.UserAgents = map[string]uint64{}
+	}
+	return state, nil
+}
+
+func recordReportUpload(userAgent string, size int) {
+	// Nothing to record if disabled
+	if !reportsEnabled() {
+		return
+	}
+
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+
+	state, err := getReportState()
+	if err != nil {
+		log.Printf("Failed to get report state - %s\n", err.Error())
+		return
+	}
+
+	// Update and persist
+	state.NewPastes++
+	state.NewBytes += uint64(size)
+	if userAgent == "" {
+		userAgent = "unknown"
+	} else if len(userAgent) > maxReportAgentLength {
+		userAgent = userAgent[:maxReportAgentLength]
+	}
+	state.UserAgents[userAgent]++
+	err = indexPut(indexKey("report", "state"), state)
+	if err != nil {
+		log.Printf("Failed to put report state - %s\n", err.Error())
+	}
+}
+
+func buildOperatorReport(state *reportState) (*operatorReport, error) {
+	// Get current storage usage
+	usage, err := storageUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	// Get most viewed pastes
+	aggregate, err := aggregatePasteStats(maxReportTop)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort user agents by count
+	agents := []*reportCount{}
+	for name, count := range state.UserAgents {
+		agents = append(agents, &reportCount{Name: name, Count: count})
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Count > agents[j].Count
+	})
+	if len(agents) > maxReportTop {
+		agents = agents[:maxReportTop]
+	}
+
+	return &operatorReport{
+		PeriodStart:   state.Since,
+		PeriodEnd:     time.Now().UTC(),
+		NewPastes:     state.NewPastes,
+		NewBytes:      state.NewBytes,
+		StorageBytes:  usage,
+		StorageGrowth: int64(usage) - int64(state.StorageAtStart),
+		TopUserAgents: agents,
+		TotalViews:    aggregate.TotalViews,
+		TopPastes:     aggregate.Top,
+	}, nil
+}
+
+func sendOperatorReport() error {
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+
+	// Build report from current state
+	state, err := getReportState()
+	if err != nil {
+		return err
+	}
+	report, err := buildOperatorReport(state)
+	if err != nil {
+		return err
+	}
+
+	// Deliver to webhook
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(globalContext, "POST", reportWebhook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("content-type", "application/json")
+
+	// Sign request so the receiver can authenticate this instance
+	err = signHTTPRequest(request, b)
+	if err != nil {
+		return err
+	}
+	response, err := reportClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return errors.New("Report webhook responded with: " + response.Status)
+	}
+
+	// Start new period
+	state, err = newReportState()
+	if err != nil {
+		return err
+	}
+	return indexPut(indexKey("report", "state"), state)
+}
+
+func startReports() {
+	log.Printf("Sending operator reports to %s every %s\n", reportWebhook, reportPeriod)
+	go func() {
+		for {
+			select {
+			case <-globalContext.Done():
+				return
+			case <-time.After(reportPeriod):
+			}
+
+			err := sendOperatorReport()
+			if err != nil {
+				log.Printf("Failed to send operator report - %s\n", err.Error())
+			}
+		}
+	}()
+}
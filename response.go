@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type putResponse struct {
+	Path      string     `json:"path"`
+	CID       string     `json:"cid"`
+	Short     string     `json:"short,omitempty"`
+	Duplicate bool       `json:"duplicate"`
+	Warnings  []string   `json:"warnings,omitempty"`
+	Key       string     `json:"key,omitempty"`
+	Size      int        `json:"size,omitempty"`
+	Receipt   string     `json:"receipt,omitempty"`
+	IPNS      string     `json:"ipns,omitempty"`
+	DAG       string     `json:"dag,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	QR        []string   `json:"-"`
+}
+
+func wantsJSON(request *http.Request) bool {
+	return strings.Contains(request.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(writer http.ResponseWriter, v interface{}) {
+	writer.Header().Set("content-type", "application/json")
+	json.NewEncoder(writer).Encode(v)
+}
+
+func writePutResponse(writer http.ResponseWriter, request *http.Request, response *putResponse) {
+	// Never cache responses carrying a generated key (or a key's QR code)
+	if response.Key != "" || len(response.QR) > 0 {
+		writer.Header().Set("Cache-Control", "no-store")
+	}
+
+	// Sign an upload receipt if requested
+	addReceipt(request, response)
+
+	// Include expiry in headers, whatever the format
+	writeExpiryHeaders(writer, response.ExpiresAt)
+
+	// Write JSON if requested
+	if wantsJSON(request) {
+		writeJSON(writer, response)
+		return
+	}
+
+	// Flag duplicates in header
+	if response.Duplicate {
+		writer.Header().Set("X-Paste-Duplicate", "true")
+	}
+
+	// Include short path in header
+	if response.Short != "" {
+		writer.Header().Set("X-Paste-Short", response.Short)
+	}
+
+	// Write the store path in response, followed by any generated key and warnings
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(response.Path))
+	if response.Key != "" {
+		writer.Write([]byte("\nkey: " + response.Key))
+	}
+	if response.IPNS != "" {
+		writer.Write([]byte("\nipns: " + response.IPNS))
+	}
+	if response.ExpiresAt != nil {
+		writer.Write([]byte("\nexpires: " + response.ExpiresAt.Format(time.RFC3339)))
+	}
+	if response.Receipt != "" {
+		writer.Write([]byte("\nreceipt: " + response.Receipt))
+	}
+	for _, warning := range response.Warnings {
+		writer.Write([]byte("\nwarning: " + warning))
+	}
+	for _, code := range response.QR {
+		writer.Write([]byte("\n\n" + code))
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// Retry-After given to requests refused while the IPFS node restarts
+	ipfsRestartRetryAfter = 10 * time.Second
+)
+
+var (
+	// Held for reading by every request, for writing while the IPFS node restarts
+	ipfsGateMutex sync.RWMutex
+
+	// Set while a restart waits for or runs without requests, new ones are refused
+	ipfsRestarting int32
+)
+
+func ipfsGate(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Refuse new requests while restarting, they'd only queue behind it
+		if atomic.LoadInt32(&ipfsRestarting) == 1 {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(ipfsRestartRetryAfter.Seconds())))
+			http.Error(writer, "Storage restarting, try again shortly!", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Hold the node for the whole request, a restart waits for in-flight requests
+		ipfsGateMutex.RLock()
+		defer ipfsGateMutex.RUnlock()
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+func restartIPFS() error {
+	// Only one restart at a time
+	if !atomic.CompareAndSwapInt32(&ipfsRestarting, 0, 1) {
+		return nil
+	}
+	defer atomic.StoreInt32(&ipfsRestarting, 0)
+
+	// Wait for in-flight requests to finish, new ones are refused meanwhile
+	ipfsGateMutex.Lock()
+	defer ipfsGateMutex.Unlock()
+	started := time.Now()
+
+	// Tear down and reconstruct each shard, picking up any repo config changes
+	for _, shard := range ipfsShards {
+		log.Printf("Restarting IPFS node for repo at %s\n", shard.repoPath)
+		err := shard.close()
+		if err != nil {
+			log.Printf("Failed to close IPFS node for repo at %s - %s\n", shard.repoPath, err.Error())
+		}
+		err = shard.open()
+		if err != nil {
+			return err
+		}
+		shard.startGC()
+	}
+
+	// First shard's API and datastore are used for non-block operations and the index
+	ipfsAPI = ipfsShards[0].api
+	indexStore = ipfsShards[0].node.Repo.Datastore()
+
+	// Old node's pubsub subscription ended with it
+	if pubsubEnabled() && pubsubMirror {
+		err := subscribePubsub()
+		if err != nil {
+			log.Printf("Failed to resubscribe to pubsub topic - %s\n", err.Error())
+		}
+	}
+
+	log.Printf("Restarted IPFS nodes in %s\n", time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+func restartIPFSHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", apiPrefix+"ipfs/restart", request.RemoteAddr)
+
+	// Restart in the background, it waits for requests including this one to finish
+	go func() {
+		err := restartIPFS()
+		if err != nil {
+			fatalf("Failed to restart IPFS node - %s", err.Error())
+		}
+	}()
+
+	writer.Header().Set("content-type", "text/plain")
+	writer.WriteHeader(http.StatusAccepted)
+	writer.Write([]byte("IPFS node restarting"))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+
+	cid "github.com/ipfs/go-cid"
+	icore "github.com/ipfs/interface-go-ipfs-core"
+)
+
+var (
+	// IPFS repo shards, pastes are spread across these by CID hash
+	ipfsShards []*ipfsShard
+
+	// Run shards online, joining the DHT so public gateways can fetch pastes
+	ipfsOnline bool
+
+	// Swarm port of the first shard when online, each further shard takes the next
+	ipfsSwarmPort uint
+
+	// Datastore profile applied to newly initialized repos, flatfs or badgerds
+	ipfsDatastore string
+
+	// Shard storage budgets, e.g. 10GB
+	shardStorageRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[a-zA-Z]*$`)
+)
+
+type ipfsShard struct {
+	repoPath   string
+	storageMax string
+	gcPeriod   string
+	swarmPort  uint
+	node       *core.IpfsNode
+	api        icore.CoreAPI
+	stopGC     context.CancelFunc
+}
+
+func splitShardSpec(spec string) (string, string, string) {
+	// Options are taken off the end only if they parse, so repo paths may contain colons (e.g. 'C:\repo')
+	isStorage := func(s string) bool { return s == "" || shardStorageRegexp.MatchString(s) }
+	isPeriod := func(s string) bool {
+		_, err := time.ParseDuration(s)
+		return s == "" || err == nil
+	}
+	i := strings.LastIndex(spec, ":")
+	if i < 0 {
+		return spec, "", ""
+	}
+	rest, last := spec[:i], spec[i+1:]
+	if j := strings.LastIndex(rest, ":"); j >= 0 && isStorage(rest[j+1:]) && isPeriod(last) {
+		return rest[:j], rest[j+1:], last
+	} else if isStorage(last) {
+		return rest, last, ""
+	}
+	return spec, "", ""
+}
+
+func parseShardSpecs(specs string) ([]*ipfsShard, error) {
+	shards := []*ipfsShard{}
+
+	// Each spec in form 'path[:storageMax[:gcPeriod]]'
+	for _, spec := range strings.Split(specs, ",") {
+		repoPath, storageMax, gcPeriod := splitShardSpec(spec)
+		if repoPath == "" {
+			return nil, errors.New("Invalid IPFS repo spec: " + spec)
+		}
+		shard := &ipfsShard{
+			repoPath:   repoPath,
+			storageMax: storageMax,
+			gcPeriod:   gcPeriod,
+			swarmPort:  ipfsSwarmPort + uint(len(shards)),
+		}
+
+		// Periodic GC collects unpinned blocks, so like repo GC it needs pinned pastes
+		if shard.gcPeriod != "" && !pinPastes {
+			return nil, errors.New("GC period on IPFS repo " + shard.repoPath + " - " + errGCUnpinned.Error())
+		}
+
+		shards = append(shards, shard)
+	}
+
+	return shards, nil
+}
+
+func (shard *ipfsShard) open() error {
+	var err error
+
+	// Check if repo initialized, if not try initialize
+	if !fsrepo.IsInitialized(shard.repoPath) {
+		log.Printf("IPFS repo at %s does not exist!\n", shard.repoPath)
+		err = initIPFSRepo(shard.repoPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Join the private network if set, before the node starts
+	err = shard.installSwarmKey()
+	if err != nil {
+		return err
+	}
+
+	// Set shard storage budget and GC schedule if provided
+	err = shard.configure()
+	if err != nil {
+		return err
+	}
+
+	// Get new IPFS node API instance
+	shard.node, shard.api, err = constructIPFSNodeAPI(shard.repoPath, ipfsOnline)
+	if err != nil {
+		return err
+	}
+
+	// Log how peers reach us, blocks are provided to the DHT as they're added
+	if ipfsOnline {
+		log.Printf("IPFS repo at %s online as peer %s, listening on %v\n", shard.repoPath, shard.node.Identity.Pretty(), shard.node.PeerHost.Addrs())
+	}
+	return nil
+}
+
+func (shard *ipfsShard) configure() error {
+	// Nothing to do if no options set
+	if shard.storageMax == "" && shard.gcPeriod == "" && !ipfsOnline && ipfsBootstrap == nil {
+		return nil
+	}
+
+	// Open the repo
+	repo, err := fsrepo.Open(shard.repoPath)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	// Set storage budget
+	if shard.storageMax != "" {
+		err = repo.SetConfigKey("Datastore.StorageMax", shard.storageMax)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Set GC period
+	if shard.gcPeriod != "" {
+		err = repo.SetConfigKey("Datastore.GCPeriod", shard.gcPeriod)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replace bootstrap peers if set, none at all for private deployments
+	if ipfsBootstrap != nil {
+		err = repo.SetConfigKey("Bootstrap", ipfsBootstrap)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Give each online shard its own swarm port, they'd clash on the default
+	if ipfsOnline {
+		err = repo.SetConfigKey("Addresses.Swarm", shard.swarmAddrs())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (shard *ipfsShard) startGC() {
+	// Only run GC on shards with a schedule
+	if shard.gcPeriod == "" {
+		return
+	}
+
+	// Stopped along with the node on restart
+	var ctx context.Context
+	ctx, shard.stopGC = context.WithCancel(globalContext)
+	node := shard.node
+
+	log.Printf("Starting periodic GC for IPFS repo at %s\n", shard.repoPath)
+	go func() {
+		err := corerepo.PeriodicGC(ctx, node)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Periodic GC failed for IPFS repo at %s - %s\n", shard.repoPath, err.Error())
+		}
+	}()
+}
+
+func (shard *ipfsShard) close() error {
+	// Stop GC first, it holds the node
+	if shard.stopGC != nil {
+		shard.stopGC()
+		shard.stopGC = nil
+	}
+
+	// Close the node, releasing the repo lock
+	err := shard.node.Close()
+	shard.node, shard.api = nil, nil
+	return err
+}
+
+func shardForCID(c cid.Cid) *ipfsShard {
+	// Single shard, nothing to pick
+	if len(ipfsShards) == 1 {
+		return ipfsShards[0]
+	}
+
+	// Use trailing bytes of the multihash digest to pick
+	h := c.Hash()
+	if len(h) < 4 {
+		return ipfsShards[0]
+	}
+	idx := binary.BigEndian.Uint32(h[len(h)-4:]) % uint32(len(ipfsShards))
+	return ipfsShards[idx]
+}
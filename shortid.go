@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	shortPrefix = "/p/"
+
+	// Short paste ID length (58^8 ~ 1.3e14 IDs)
+	shortIDLength = 8
+
+	// Base58 (bitcoin) alphabet, avoids visually ambiguous characters
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+	// Attempts at finding an unused short ID before giving up
+	shortIDAttempts = 10
+)
+
+var (
+	// Guards short ID allocation
+	shortIDMutex sync.Mutex
+)
+
+func newShortID() (string, error) {
+	// Read random bytes, rejecting those that would bias the alphabet
+	id := make([]byte, 0, shortIDLength)
+	buf := make([]byte, shortIDLength*2)
+	for len(id) < shortIDLength {
+		_, err := rand.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < 256-256%len(base58Alphabet) && len(id) < shortIDLength {
+				id = append(id, base58Alphabet[int(b)%len(base58Alphabet)])
+			}
+		}
+	}
+	return string(id), nil
+}
+
+func shortIDForPaste(c cid.Cid) (string, error) {
+	shortIDMutex.Lock()
+	defer shortIDMutex.Unlock()
+
+	// Reuse existing short ID for this paste
+	b, err := indexStore.Get(indexKey("shortref", c.String()))
+	if err == nil {
+		return string(b), nil
+	} else if err != ds.ErrNotFound {
+		return "", err
+	}
+
+	// Allocate new unused short ID
+	for i := 0; i < shortIDAttempts; i++ {
+		id, err := newShortID()
+		if err != nil {
+			return "", err
+		}
+
+		// Check for collision
+		has, err := indexStore.Has(indexKey("short", id))
+		if err != nil {
+			return "", err
+		} else if has {
+			continue
+		}
+
+		// Store mapping both ways
+		err = indexStore.Put(indexKey("short", id), c.Bytes())
+		if err != nil {
+			return "", err
+		}
+		return id, indexStore.Put(indexKey("shortref", c.String()), []byte(id))
+	}
+
+	return "", errors.New("Failed to allocate unused short ID")
+}
+
+func resolveShortID(id string) (cid.Cid, error) {
+	// Look up mapped CID bytes
+	b, err := indexStore.Get(indexKey("short", id))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.Cast(b)
+}
+
+func shortPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the short ID string
+	id := params.ByName("shortid")
+
+	// Log the request
+	logRequest("GET", shortPrefix+id, request.RemoteAddr)
+
+	// Resolve to full paste CID
+	c, err := resolveShortID(id)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Serve as the full CID route
+	getPasteHandler(writer, request, httprouter.Params{{Key: "cid", Value: c.String()}})
+}
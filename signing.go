@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Signer public key followed by signature, stored after the envelope header
+	pasteSignatureBlockSize = ed25519.PublicKeySize + ed25519.SignatureSize
+
+	signingKeyPath = "/signing-key"
+
+	// Contexts prefixed to every signed message, so a signature made for one
+	// use of a key (e.g. ?sign=1 over arbitrary uploaded bytes) is never
+	// valid for another
+	pasteSigContext   = "gibon-paste-sig-v1\x00"
+	receiptSigContext = "gibon-receipt-v1\x00"
+	httpSigContext    = "gibon-httpsig-v1\x00"
+	dagSigContext     = "gibon-dag-v1\x00"
+)
+
+var (
+	// Server signing key file (server signing disabled if unset)
+	signingKeyFile string
+
+	// Server signing identity, loaded from file
+	serverSigningKey ed25519.PrivateKey
+)
+
+type verifyResponse struct {
+	Signed bool   `json:"signed"`
+	Signer string `json:"signer,omitempty"`
+	Valid  bool   `json:"valid"`
+	Server bool   `json:"server"`
+}
+
+func encodeSigningKey(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSigningKey(str string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(str), "="))
+	if err != nil {
+		return nil, err
+	} else if len(b) != size {
+		return nil, errors.New("invalid key length")
+	}
+	return b, nil
+}
+
+func signWithContext(key ed25519.PrivateKey, context string, b []byte) []byte {
+	return ed25519.Sign(key, append([]byte(context), b...))
+}
+
+func verifyWithContext(pub ed25519.PublicKey, context string, b, sig []byte) bool {
+	return ed25519.Verify(pub, append([]byte(context), b...), sig)
+}
+
+func setupSigningKey() error {
+	// Skip if disabled
+	if signingKeyFile == "" {
+		return nil
+	}
+
+	// Load seed from file, generating a new one on first run
+	seed, err := readSecretFile(signingKeyFile, ed25519.SeedSize)
+	if err != nil {
+		return err
+	}
+
+	serverSigningKey = ed25519.NewKeyFromSeed(seed)
+	log.Printf("Signing pastes as %s\n", encodeSigningKey(serverSigningKey.Public().(ed25519.PublicKey)))
+	return nil
+}
+
+func requestSignature(request *http.Request, b []byte) ([]byte, []byte, error) {
+	// Get uploader signature from headers or query
+	query := request.URL.Query()
+	sigStr := request.Header.Get("X-Gibon-Signature")
+	if sigStr == "" {
+		sigStr = query.Get("signature")
+	}
+	signerStr := request.Header.Get("X-Gibon-Signer")
+	if signerStr == "" {
+		signerStr = query.Get("signer")
+	}
+
+	switch {
+	// Server signs with its own identity
+	case query.Get("sign") == "1":
+		if sigStr != "" || signerStr != "" {
+			return nil, nil, errors.New("Only one of sign or signature may be supplied")
+		} else if serverSigningKey == nil {
+			return nil, nil, errors.New("Server signing not enabled")
+		}
+		return serverSigningKey.Public().(ed25519.PublicKey), signWithContext(serverSigningKey, pasteSigContext, b), nil
+
+	// Not signed
+	case sigStr == "" && signerStr == "":
+		return nil, nil, nil
+
+	// Uploader signed, must verify
+	default:
+		signer, err := decodeSigningKey(signerStr, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, nil, errors.New("Invalid signer")
+		}
+		sig, err := decodeSigningKey(sigStr, ed25519.SignatureSize)
+		if err != nil {
+			return nil, nil, errors.New("Invalid signature")
+		}
+		if !verifyWithContext(signer, pasteSigContext, b, sig) {
+			return nil, nil, errors.New("Signature does not verify")
+		}
+		return signer, sig, nil
+	}
+}
+
+func verifyPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/verify", request.RemoteAddr)
+
+	// Decode the paste CID, DAG nodes resolving to their paste
+	c, err := cid.Decode(cidStr)
+	if err == nil {
+		c, err = resolvePasteCID(request.Context(), c)
+	}
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Try look for paste with CID
+	p, err := getPaste(c)
+	if err != nil {
+		log.Printf("Paste not retrieved - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+	response := &verifyResponse{}
+
+	if p.signature != nil {
+		response.Signed = true
+		response.Signer = encodeSigningKey(p.signer)
+		response.Server = serverSigningKey != nil && bytes.Equal(p.signer, serverSigningKey.Public().(ed25519.PublicKey))
+
+		// Signature covers content as uploaded, E2E blobs are signed as-is
+		if p.encrypted && p.sealing != pasteSealingE2E {
+			key, err := decryptionKey(request)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			} else if key == "" {
+				http.Error(writer, "Key required to verify encrypted paste!", http.StatusBadRequest)
+				return
+			} else if !checkDecryptThrottle(writer, request, c) {
+				return
+			}
+			binding, err := getPasteBinding(c)
+			if err != nil {
+				log.Printf("Failed to get paste binding - %s\n", err.Error())
+				http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+				return
+			}
+			started := time.Now()
+			err = p.decrypt(key, binding)
+			if err != nil {
+				log.Printf("Failed to decrypt paste - %s\n", err.Error())
+				recordDecryptFailure(request, c)
+				writeDecryptFailure(writer, request, started, "")
+				return
+			}
+		}
+		if !p.encrypted {
+			err = p.decompress()
+			if err != nil {
+				log.Printf("Failed to decompress paste - %s\n", err.Error())
+				http.Error(writer, "Paste decompression failed!", http.StatusInternalServerError)
+				return
+			}
+		}
+		response.Valid = verifyWithContext(p.signer, pasteSigContext, p.text, p.signature)
+	}
+
+	// Write JSON if requested
+	if wantsJSON(request) {
+		writeJSON(writer, response)
+		return
+	}
+
+	// Write verification summary
+	writer.Header().Set("content-type", "text/plain")
+	switch {
+	case !response.Signed:
+		writer.Write([]byte("unsigned\n"))
+	case !response.Valid:
+		writer.Write([]byte("invalid signature from " + response.Signer + "\n"))
+	case response.Server:
+		writer.Write([]byte("valid signature from " + response.Signer + " (this server)\n"))
+	default:
+		writer.Write([]byte("valid signature from " + response.Signer + "\n"))
+	}
+}
+
+func signingKeyHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", signingKeyPath, request.RemoteAddr)
+
+	// Ensure server signing enabled
+	if serverSigningKey == nil {
+		http.Error(writer, "Server signing not enabled!", http.StatusNotFound)
+		return
+	}
+
+	// Write the server's public key
+	writer.Header().Set("content-type", "text/plain")
+	writer.Write([]byte(encodeSigningKey(serverSigningKey.Public().(ed25519.PublicKey)) + "\n"))
+}
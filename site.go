@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	icorepath "github.com/ipfs/interface-go-ipfs-core/path"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	sitePrefix = "/site"
+
+	// Site root document, required at upload and served for directories
+	siteIndexFile = "index.html"
+
+	// Prefix for site IPNS keys in the node keystore
+	siteKeyPrefix = "site-"
+
+	// Deadline for resolving and serving a single site file
+	siteGetTimeout = time.Minute
+
+	// Served sites run in an opaque origin, so they can't touch gibon's cookies or storage
+	siteContentSecurityPolicy = "sandbox allow-scripts allow-forms allow-popups"
+)
+
+var (
+	siteNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+)
+
+type siteTree struct {
+	files map[string][]byte
+	dirs  map[string]*siteTree
+}
+
+func newSiteTree() *siteTree {
+	return &siteTree{files: map[string][]byte{}, dirs: map[string]*siteTree{}}
+}
+
+func (tree *siteTree) add(filePath string, b []byte) error {
+	// Walk down to the file's directory, creating any missing on the way
+	split := strings.Split(filePath, "/")
+	dir := tree
+	for _, name := range split[:len(split)-1] {
+		if _, ok := dir.files[name]; ok {
+			return errors.New("Site path is both file and directory: " + filePath)
+		}
+		sub, ok := dir.dirs[name]
+		if !ok {
+			sub = newSiteTree()
+			dir.dirs[name] = sub
+		}
+		dir = sub
+	}
+
+	name := split[len(split)-1]
+	if _, ok := dir.dirs[name]; ok {
+		return errors.New("Site path is both file and directory: " + filePath)
+	}
+	dir.files[name] = b
+	return nil
+}
+
+func (tree *siteTree) node() files.Node {
+	// Build fresh UnixFS nodes each time, file readers are consumed on add
+	nodes := map[string]files.Node{}
+	for name, b := range tree.files {
+		nodes[name] = files.NewBytesFile(b)
+	}
+	for name, sub := range tree.dirs {
+		nodes[name] = sub.node()
+	}
+	return files.NewMapDirectory(nodes)
+}
+
+func putSite(tree *siteTree) (cid.Cid, error) {
+	// Hash first so the site is stored on its responsible shard
+	resolved, err := ipfsAPI.Unixfs().Add(globalContext, tree.node(), options.Unixfs.HashOnly(true))
+	if err != nil {
+		return cid.Undef, err
+	}
+	root := resolved.Cid()
+
+	// Add and pin the site directory to the shard
+	_, err = shardForCID(root).api.Unixfs().Add(globalContext, tree.node(), options.Unixfs.Pin(true))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Record it, only sites uploaded here are served
+	return root, indexStore.Put(indexKey("site", root.String()), []byte{})
+}
+
+func isLocalSite(c cid.Cid) (bool, error) {
+	return indexStore.Has(indexKey("site", c.String()))
+}
+
+func publishSiteName(name string, root cid.Cid) (cid.Cid, error) {
+	// Get the site key, generating it on first publish
+	keyName := siteKeyPrefix + name
+	keys, err := ipfsAPI.Key().List(globalContext)
+	if err != nil {
+		return cid.Undef, err
+	}
+	var id string
+	for _, key := range keys {
+		if key.Name() == keyName {
+			id = string(key.ID())
+			break
+		}
+	}
+	if id == "" {
+		key, err := ipfsAPI.Key().Generate(globalContext, keyName)
+		if err != nil {
+			return cid.Undef, err
+		}
+		id = string(key.ID())
+	}
+
+	// Point the name at the site root (recorded locally even if the node is offline)
+	_, err = ipfsAPI.Name().Publish(globalContext, icorepath.IpfsPath(root), options.Name.Key(keyName), options.Name.AllowOffline(true))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Return the name as a libp2p-key CID, usable in place of the site root, recorded like roots
+	ipns := cid.NewCidV1(cid.Libp2pKey, mh.Multihash(id))
+	return ipns, indexStore.Put(indexKey("site", ipns.String()), []byte{})
+}
+
+func resolveSiteRoot(ctx context.Context, c cid.Cid) (cid.Cid, error) {
+	// Immutable sites are addressed by their root directly
+	if c.Type() != cid.Libp2pKey {
+		return c, nil
+	}
+
+	// Resolve IPNS names to the currently published root
+	p, err := ipfsAPI.Name().Resolve(ctx, "/ipns/"+c.String())
+	if err != nil {
+		return cid.Undef, err
+	}
+	resolved, err := ipfsAPI.ResolvePath(ctx, p)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return resolved.Cid(), nil
+}
+
+func createSiteHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", sitePrefix, request.RemoteAddr)
+
+	// Validate IPNS name if supplied, publishing is restricted to admins
+	name := request.URL.Query().Get("ipns")
+	if name != "" {
+		if !siteNameRegexp.MatchString(name) {
+			http.Error(writer, "Invalid IPNS name!", http.StatusBadRequest)
+			return
+		} else if !isAdminRequest(request) {
+			http.Error(writer, "Unauthorized!", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Read uploaded files into the site tree
+	tree := newSiteTree()
+	size := 0
+	_, err := readMultipartFiles(writer, request, func(filePath string, b []byte) error {
+		size += len(b)
+		return tree.add(filePath, b)
+	})
+	if err != nil {
+		log.Printf("Failed to read site files - %s\n", err.Error())
+		http.Error(writer, "Invalid site upload!", http.StatusBadRequest)
+		return
+	} else if _, ok := tree.files[siteIndexFile]; !ok {
+		http.Error(writer, "Site requires a root index.html!", http.StatusBadRequest)
+		return
+	}
+
+	// Store the site directory
+	c, err := putSite(tree)
+	if err != nil {
+		log.Printf("Failed to put site - %s\n", err.Error())
+		http.Error(writer, "Failed to put site", http.StatusInternalServerError)
+		return
+	}
+	response := &putResponse{
+		Path: sitePrefix + "/" + c.String() + "/",
+		CID:  c.String(),
+		Size: size,
+	}
+
+	// Publish under IPNS name if requested
+	if name != "" {
+		ipns, err := publishSiteName(name, c)
+		if err != nil {
+			log.Printf("Failed to publish site name - %s\n", err.Error())
+			http.Error(writer, "Failed to publish site name", http.StatusInternalServerError)
+			return
+		}
+		response.IPNS = sitePrefix + "/" + ipns.String() + "/"
+	}
+
+	// Write the site path in response
+	writePutResponse(writer, request, response)
+}
+
+func getSiteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string and file path
+	cidStr := params.ByName("cid")
+	filePath := params.ByName("file")
+
+	// Log the request
+	logRequest("GET", sitePrefix+"/"+cidStr+filePath, request.RemoteAddr)
+
+	// Decode the site CID, only serving sites and names created here
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Site not found!", http.StatusNotFound)
+		return
+	}
+	local, err := isLocalSite(c)
+	if err != nil || !local {
+		http.Error(writer, "Site not found!", http.StatusNotFound)
+		return
+	}
+
+	// Relative links resolve against the site root only with a trailing slash
+	if filePath == "" {
+		http.Redirect(writer, request, sitePrefix+"/"+cidStr+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	// Refuse sites blocked by moderation
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Resolving and serving may take longer than the server write timeout
+	extendWriteDeadline(request, siteGetTimeout)
+
+	// Resolve the site root, refusing blocked or foreign roots behind IPNS names too
+	ctx, cancel := context.WithTimeout(request.Context(), siteGetTimeout)
+	defer cancel()
+	root, err := resolveSiteRoot(ctx, c)
+	if err != nil {
+		log.Printf("Site name not resolved - %s\n", err.Error())
+		http.Error(writer, "Site not found!", http.StatusNotFound)
+		return
+	} else if !root.Equals(c) {
+		local, err = isLocalSite(root)
+		if err != nil || !local {
+			http.Error(writer, "Site not found!", http.StatusNotFound)
+			return
+		} else if refuseBlockedPaste(writer, root) {
+			return
+		}
+	}
+
+	// Get the file (or directory) under the site root
+	segments := []string{}
+	for _, segment := range strings.Split(filePath, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	api := shardForCID(root).api
+	node, err := api.Unixfs().Get(ctx, icorepath.Join(icorepath.IpfsPath(root), segments...))
+	if err != nil {
+		http.Error(writer, "File not found!", http.StatusNotFound)
+		return
+	}
+
+	// Directories serve their index, with a trailing slash for relative links
+	name := path.Base(filePath)
+	if _, ok := node.(files.Directory); ok {
+		if !strings.HasSuffix(filePath, "/") {
+			http.Redirect(writer, request, sitePrefix+"/"+cidStr+filePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		node, err = api.Unixfs().Get(ctx, icorepath.Join(icorepath.IpfsPath(root), append(segments, siteIndexFile)...))
+		if err != nil {
+			http.Error(writer, "File not found!", http.StatusNotFound)
+			return
+		}
+		name = siteIndexFile
+	}
+	file, ok := node.(files.File)
+	if !ok {
+		http.Error(writer, "File not found!", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	// Serve sandboxed, typed by extension (sniffed if unknown)
+	writer.Header().Set("Content-Security-Policy", siteContentSecurityPolicy)
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		writer.Header().Set("content-type", contentType)
+	}
+	http.ServeContent(writer, request, name, time.Time{}, file)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	smokeCommand = "e2e"
+
+	// Expiry set on the expiring paste, then waited out
+	smokeExpiry = 2 * time.Second
+)
+
+type smokeOptions struct {
+	server     string
+	adminToken string
+	maxSize    float64
+	insecure   bool
+	timeout    time.Duration
+}
+
+type smokeClient struct {
+	opts   *smokeOptions
+	client *http.Client
+}
+
+type smokeCheck struct {
+	name string
+	run  func(*smokeClient) error
+}
+
+var (
+	// Checks run in order against the server, each with fresh pastes
+	smokeChecks = []smokeCheck{
+		{"help", smokeHelp},
+		{"create", smokeCreate},
+		{"encrypted round trip", smokeEncrypted},
+		{"short link", smokeShort},
+		{"view limit", smokeViewLimit},
+		{"expiry", smokeExpire},
+		{"delete", smokeDelete},
+		{"size limit", smokeSizeLimit},
+	}
+
+	// Returned by checks that can't run with the supplied options
+	errSmokeSkipped = errors.New("skipped")
+)
+
+func parseSmokeArgs(args []string) (*smokeOptions, error) {
+	opts := &smokeOptions{}
+	flags := flag.NewFlagSet(smokeCommand, flag.ContinueOnError)
+	flags.StringVar(&opts.server, "server", "", "Base URL of the running instance to test, e.g. https://paste.example.com")
+	flags.StringVar(&opts.adminToken, "admin-token", "", "Instance admin token, enables the delete check (skipped if unset)")
+	flags.Float64Var(&opts.maxSize, "paste-size-max", 1.0, "Instance maximum paste size (in megabytes), for the size limit check")
+	flags.BoolVar(&opts.insecure, "insecure", false, "Skip TLS certificate verification, for self-signed staging instances")
+	flags.DurationVar(&opts.timeout, "timeout", 30*time.Second, "Timeout for each request")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	} else if opts.server == "" {
+		return nil, errors.New("Usage: gibon e2e -server <URL> [-admin-token <token>]")
+	}
+	opts.server = strings.TrimSuffix(opts.server, "/")
+	return opts, nil
+}
+
+func runSmokeTests(opts *smokeOptions) bool {
+	client := &smokeClient{
+		opts: opts,
+		client: &http.Client{
+			Timeout: opts.timeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.insecure},
+			},
+			// Redirects are results to check, not follow
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	// Run every check, reporting each
+	failed := 0
+	for _, check := range smokeChecks {
+		err := check.run(client)
+		switch err {
+		case nil:
+			log.Printf("PASS %s\n", check.name)
+		case errSmokeSkipped:
+			log.Printf("SKIP %s\n", check.name)
+		default:
+			log.Printf("FAIL %s - %s\n", check.name, err.Error())
+			failed++
+		}
+	}
+
+	log.Printf("%d of %d checks failed against %s\n", failed, len(smokeChecks), opts.server)
+	return failed == 0
+}
+
+func (sc *smokeClient) do(method, path string, body []byte, headers map[string]string) (int, []byte, http.Header, error) {
+	// Build request, plain text unless asked otherwise
+	request, err := http.NewRequest(method, sc.opts.server+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	request.Header.Set("Accept", "text/plain")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	// Send and read the full response
+	response, err := sc.client.Do(request)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(response.Body, int64(sc.opts.maxSize*1048576.0)+4096))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return response.StatusCode, b, response.Header, nil
+}
+
+func (sc *smokeClient) put(query string, text []byte, headers map[string]string) (*putResponse, error) {
+	// Upload asking for the JSON response
+	all := map[string]string{"Accept": "application/json"}
+	for name, value := range headers {
+		all[name] = value
+	}
+	status, b, _, err := sc.do("POST", "/"+query, text, all)
+	if err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return nil, errors.New("upload returned " + strconv.Itoa(status) + ": " + strings.TrimSpace(string(b)))
+	}
+
+	response := &putResponse{}
+	err = json.Unmarshal(b, response)
+	if err != nil {
+		return nil, err
+	} else if response.Path == "" {
+		return nil, errors.New("upload response has no path")
+	}
+	return response, nil
+}
+
+func (sc *smokeClient) expect(path string, headers map[string]string, status int, text []byte) error {
+	got, b, _, err := sc.do("GET", path, nil, headers)
+	if err != nil {
+		return err
+	} else if got != status {
+		return errors.New("GET " + path + " returned " + strconv.Itoa(got) + ", expected " + strconv.Itoa(status))
+	} else if text != nil && !bytes.Equal(b, text) {
+		return errors.New("GET " + path + " returned different content")
+	}
+	return nil
+}
+
+func smokeText() []byte {
+	// Unique per run, so no check sees another's (or an earlier run's) paste
+	b := make([]byte, 16)
+	rand.Read(b)
+	return []byte("gibon e2e " + hex.EncodeToString(b) + "\n")
+}
+
+func smokeHelp(sc *smokeClient) error {
+	return sc.expect("/", nil, http.StatusOK, nil)
+}
+
+func smokeCreate(sc *smokeClient) error {
+	text := smokeText()
+	response, err := sc.put("", text, nil)
+	if err != nil {
+		return err
+	}
+	return sc.expect(response.Path, nil, http.StatusOK, text)
+}
+
+func smokeEncrypted(sc *smokeClient) error {
+	// Upload with a key in the header, never the query string
+	text := smokeText()
+	key := hex.EncodeToString(smokeText())
+	response, err := sc.put("", text, map[string]string{secretHeaders["key"]: key})
+	if err != nil {
+		return err
+	}
+
+	// Only the right key opens it
+	err = sc.expect(response.Path, map[string]string{secretHeaders["key"]: key}, http.StatusOK, text)
+	if err != nil {
+		return err
+	}
+	status, b, _, err := sc.do("GET", response.Path, nil, nil)
+	if err != nil {
+		return err
+	} else if bytes.Contains(b, text) {
+		return errors.New("paste readable without key (status " + strconv.Itoa(status) + ")")
+	}
+	status, b, _, err = sc.do("GET", response.Path, nil, map[string]string{secretHeaders["key"]: key + "wrong"})
+	if err != nil {
+		return err
+	} else if status == http.StatusOK || bytes.Contains(b, text) {
+		return errors.New("paste readable with the wrong key")
+	}
+	return nil
+}
+
+func smokeShort(sc *smokeClient) error {
+	text := smokeText()
+	response, err := sc.put("", text, nil)
+	if err != nil {
+		return err
+	} else if response.Short == "" {
+		return errors.New("upload response has no short link")
+	}
+	return sc.expect(response.Short, nil, http.StatusOK, text)
+}
+
+func smokeViewLimit(sc *smokeClient) error {
+	// Burn after reading
+	text := smokeText()
+	response, err := sc.put("?max_views=1", text, nil)
+	if err != nil {
+		return err
+	}
+	err = sc.expect(response.Path, nil, http.StatusOK, text)
+	if err != nil {
+		return err
+	}
+	return sc.expect(response.Path, nil, http.StatusGone, nil)
+}
+
+func smokeExpire(sc *smokeClient) error {
+	// Expiry needs server-side encryption
+	text := smokeText()
+	key := hex.EncodeToString(smokeText())
+	headers := map[string]string{secretHeaders["key"]: key}
+	response, err := sc.put("?expires="+smokeExpiry.String(), text, headers)
+	if err != nil {
+		return err
+	} else if response.ExpiresAt == nil {
+		return errors.New("upload response has no expiry")
+	}
+
+	// Readable until expiry, gone after
+	err = sc.expect(response.Path, headers, http.StatusOK, text)
+	if err != nil {
+		return err
+	}
+	time.Sleep(smokeExpiry + time.Second)
+	return sc.expect(response.Path, headers, http.StatusGone, nil)
+}
+
+func smokeDelete(sc *smokeClient) error {
+	if sc.opts.adminToken == "" {
+		return errSmokeSkipped
+	}
+
+	// Block by moderation, the only way pastes are taken down
+	text := smokeText()
+	response, err := sc.put("", text, nil)
+	if err != nil {
+		return err
+	}
+	status, b, _, err := sc.do("POST", apiPrefix+"reports/"+response.CID+"/block", nil, map[string]string{"Authorization": "Bearer " + sc.opts.adminToken})
+	if err != nil {
+		return err
+	} else if status != http.StatusOK {
+		return errors.New("block returned " + strconv.Itoa(status) + ": " + strings.TrimSpace(string(b)))
+	}
+	return sc.expect(response.Path, nil, http.StatusUnavailableForLegalReasons, nil)
+}
+
+func smokeSizeLimit(sc *smokeClient) error {
+	// One byte over the limit must be refused
+	text := bytes.Repeat([]byte("x"), int(sc.opts.maxSize*1048576.0)+1)
+	status, _, _, err := sc.do("POST", "/", text, nil)
+	if err != nil {
+		return err
+	} else if status < http.StatusBadRequest {
+		return errors.New("oversized upload returned " + strconv.Itoa(status))
+	}
+	return nil
+}
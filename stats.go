@@ -0,0 +1,256 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	contentStatsPath = "/stats/content"
+
+	// Number of most viewed pastes in admin stats
+	maxTopStats = 20
+
+	// How long aggregated content stats are reused for
+	contentStatsCacheTime = time.Minute
+)
+
+var (
+	// Guards paste stats read-modify-write
+	pasteStatsMutex sync.Mutex
+
+	// Content stats size histogram bucket upper bounds (in bytes)
+	contentSizeBuckets = []int{1024, 10 * 1024, 100 * 1024, 1024 * 1024}
+
+	// Last aggregated content stats, guarded by mutex
+	contentStatsMutex  sync.Mutex
+	contentStatsCached *contentStats
+	contentStatsTime   time.Time
+)
+
+type pasteStats struct {
+	CID        string    `json:"cid,omitempty"`
+	Views      uint64    `json:"views"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+type pasteStatsResponse struct {
+	*pasteStats
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type pasteContent struct {
+	Size      int  `json:"size"`
+	Encrypted bool `json:"encrypted"`
+}
+
+type contentStats struct {
+	Pastes        int            `json:"pastes"`
+	Encrypted     int            `json:"encrypted"`
+	Plaintext     int            `json:"plaintext"`
+	PublicPastes  int            `json:"public_pastes"`
+	Languages     map[string]int `json:"languages"`
+	SizeHistogram map[string]int `json:"size_histogram"`
+}
+
+type adminStats struct {
+	Pastes     int           `json:"pastes"`
+	TotalViews uint64        `json:"total_views"`
+	Top        []*pasteStats `json:"top"`
+}
+
+func getPasteStats(cidStr string) (*pasteStats, error) {
+	// Look for stats, none found is just empty
+	stats := &pasteStats{}
+	err := indexGet(indexKey("stats", cidStr), stats)
+	if err != nil && err != ds.ErrNotFound {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func recordPasteView(c cid.Cid) error {
+	pasteStatsMutex.Lock()
+	defer pasteStatsMutex.Unlock()
+
+	// Get existing stats
+	stats, err := getPasteStats(c.String())
+	if err != nil {
+		return err
+	}
+
+	// Update and persist
+	stats.Views++
+	stats.LastAccess = time.Now().UTC()
+	return indexPut(indexKey("stats", c.String()), stats)
+}
+
+func pasteStatsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/stats", request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Get the paste stats
+	stats, err := getPasteStats(c.String())
+	if err != nil {
+		log.Printf("Failed to get paste stats - %s\n", err.Error())
+		http.Error(writer, "Failed to get paste stats", http.StatusInternalServerError)
+		return
+	}
+
+	// Include expiry so consumers know how long the link stays valid
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		log.Printf("Failed to get paste metadata - %s\n", err.Error())
+		http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := expiryTime(meta.Expires)
+	writeExpiryHeaders(writer, expiresAt)
+
+	writeJSON(writer, &pasteStatsResponse{stats, expiresAt})
+}
+
+func aggregatePasteStats(top int) (*adminStats, error) {
+	// Get all pastes with stats
+	cids, err := indexList("stats")
+	if err != nil {
+		return nil, err
+	}
+
+	// Aggregate stats
+	aggregate := &adminStats{Pastes: len(cids), Top: []*pasteStats{}}
+	for _, cidStr := range cids {
+		stats, err := getPasteStats(cidStr)
+		if err != nil {
+			continue
+		}
+		stats.CID = cidStr
+		aggregate.TotalViews += stats.Views
+		aggregate.Top = append(aggregate.Top, stats)
+	}
+
+	// Keep only the most viewed
+	sort.Slice(aggregate.Top, func(i, j int) bool {
+		return aggregate.Top[i].Views > aggregate.Top[j].Views
+	})
+	if len(aggregate.Top) > top {
+		aggregate.Top = aggregate.Top[:top]
+	}
+
+	return aggregate, nil
+}
+
+func adminStatsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", apiPrefix+"stats", request.RemoteAddr)
+
+	// Aggregate all paste stats
+	aggregate, err := aggregatePasteStats(maxTopStats)
+	if err != nil {
+		log.Printf("Failed to aggregate paste stats - %s\n", err.Error())
+		http.Error(writer, "Failed to aggregate paste stats", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(writer, aggregate)
+}
+
+func recordPasteContent(c cid.Cid, size int, encrypted bool) error {
+	return indexPut(indexKey("content", c.String()), &pasteContent{Size: size, Encrypted: encrypted})
+}
+
+func contentSizeBucket(size int) string {
+	for _, bound := range contentSizeBuckets {
+		if size < bound {
+			return "<" + strconv.Itoa(bound/1024) + "KiB"
+		}
+	}
+	return ">=" + strconv.Itoa(contentSizeBuckets[len(contentSizeBuckets)-1]/1024) + "KiB"
+}
+
+func aggregateContentStats() (*contentStats, error) {
+	// Get all pastes with recorded content info
+	cids, err := indexList("content")
+	if err != nil {
+		return nil, err
+	}
+
+	// Aggregate encryption over all, language and size over public only
+	aggregate := &contentStats{
+		Pastes:        len(cids),
+		Languages:     map[string]int{},
+		SizeHistogram: map[string]int{},
+	}
+	for _, cidStr := range cids {
+		content := &pasteContent{}
+		err = indexGet(indexKey("content", cidStr), content)
+		if err != nil {
+			continue
+		}
+		if content.Encrypted {
+			aggregate.Encrypted++
+			continue
+		}
+		aggregate.Plaintext++
+
+		public, err := isPublicPaste(cidStr)
+		if err != nil || !public {
+			continue
+		}
+		aggregate.PublicPastes++
+
+		meta, err := getPasteMetaStr(cidStr)
+		if err != nil {
+			continue
+		}
+		language := meta.Language
+		if language == "" {
+			language = "plain"
+		}
+		aggregate.Languages[language]++
+		aggregate.SizeHistogram[contentSizeBucket(content.Size)]++
+	}
+
+	return aggregate, nil
+}
+
+func contentStatsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", contentStatsPath, request.RemoteAddr)
+
+	contentStatsMutex.Lock()
+	defer contentStatsMutex.Unlock()
+
+	// Aggregate content stats if cached copy is stale
+	if contentStatsCached == nil || time.Since(contentStatsTime) > contentStatsCacheTime {
+		aggregate, err := aggregateContentStats()
+		if err != nil {
+			log.Printf("Failed to aggregate content stats - %s\n", err.Error())
+			http.Error(writer, "Failed to aggregate content stats", http.StatusInternalServerError)
+			return
+		}
+		contentStatsCached = aggregate
+		contentStatsTime = time.Now()
+	}
+
+	writeJSON(writer, contentStatsCached)
+}
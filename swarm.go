@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// Replaced in swarm address templates by each shard's swarm port
+	swarmPortPlaceholder = "{port}"
+)
+
+var (
+	// Bootstrap peers set on every repo, nil keeps each repo's own, empty for none
+	ipfsBootstrap []string
+
+	// Swarm listen address templates, the defaults on all interfaces if empty
+	ipfsSwarmAddrs []string
+)
+
+func parseBootstrapPeers(peers string) ([]string, error) {
+	// Unset keeps whatever the repo has, 'none' bootstraps from nobody
+	switch peers {
+	case "":
+		return nil, nil
+	case "none":
+		return []string{}, nil
+	}
+
+	// Each must be a full peer address, including the peer ID
+	addrs := []string{}
+	for _, peer := range strings.Split(peers, ",") {
+		addr, err := ma.NewMultiaddr(strings.TrimSpace(peer))
+		if err != nil {
+			return nil, errors.New("Invalid bootstrap peer: " + peer)
+		}
+		_, err = addr.ValueForProtocol(ma.P_P2P)
+		if err != nil {
+			return nil, errors.New("Bootstrap peer has no /p2p/ peer ID: " + peer)
+		}
+		addrs = append(addrs, addr.String())
+	}
+	return addrs, nil
+}
+
+func parseSwarmAddrs(addrs string, shards int) ([]string, error) {
+	if addrs == "" {
+		return nil, nil
+	}
+
+	templates := []string{}
+	for _, template := range strings.Split(addrs, ",") {
+		template = strings.TrimSpace(template)
+
+		// Shards listening on one port would clash
+		if shards > 1 && !strings.Contains(template, swarmPortPlaceholder) {
+			return nil, errors.New("Swarm address needs a " + swarmPortPlaceholder + " placeholder with multiple shards: " + template)
+		}
+
+		// Check it parses once the port is filled in
+		_, err := ma.NewMultiaddr(strings.ReplaceAll(template, swarmPortPlaceholder, "4001"))
+		if err != nil {
+			return nil, errors.New("Invalid swarm address: " + template)
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func (shard *ipfsShard) swarmAddrs() []string {
+	port := strconv.FormatUint(uint64(shard.swarmPort), 10)
+
+	// Listen on all interfaces by default
+	if len(ipfsSwarmAddrs) == 0 {
+		return []string{
+			"/ip4/0.0.0.0/tcp/" + port,
+			"/ip6/::/tcp/" + port,
+			"/ip4/0.0.0.0/udp/" + port + "/quic",
+			"/ip6/::/udp/" + port + "/quic",
+		}
+	}
+
+	addrs := []string{}
+	for _, template := range ipfsSwarmAddrs {
+		addrs = append(addrs, strings.ReplaceAll(template, swarmPortPlaceholder, port))
+	}
+	return addrs
+}
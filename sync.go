@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+const (
+	// Bloom filter target false positive rate
+	syncBloomFPRate = 0.001
+
+	// Maximum accepted bloom filter request size
+	syncBloomMaxSize = 128 * 1048576
+)
+
+var (
+	// HTTP client used for sync requests
+	syncClient = &http.Client{Timeout: 10 * time.Minute}
+
+	// Index namespaces shipped with every sync, replaced wholesale on the requester
+	syncIndexNamespaces = []string{"blocked", "tombstone", "views", "pake", "meta"}
+)
+
+type syncBloom struct {
+	Seed   []byte `json:"seed"`
+	Hashes uint   `json:"hashes"`
+	Bits   []byte `json:"bits"`
+}
+
+func newSyncBloom(count int) (*syncBloom, error) {
+	// Size filter for target false positive rate
+	if count < 1 {
+		count = 1
+	}
+	m := uint64(math.Ceil(-float64(count) * math.Log(syncBloomFPRate) / (math.Ln2 * math.Ln2)))
+	k := uint(math.Ceil(math.Ln2 * float64(m) / float64(count)))
+
+	// Use new random seed each sync so false positives differ between syncs
+	seed := make([]byte, 16)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+
+	return &syncBloom{
+		Seed:   seed,
+		Hashes: k,
+		Bits:   make([]byte, (m+7)/8),
+	}, nil
+}
+
+func (bloom *syncBloom) locations(c cid.Cid) []uint64 {
+	// Hash seed + CID bytes, double hashing for k locations
+	hash := sha256.Sum256(append(append([]byte{}, bloom.Seed...), c.Bytes()...))
+	h1 := binary.BigEndian.Uint64(hash[0:8])
+	h2 := binary.BigEndian.Uint64(hash[8:16])
+
+	m := uint64(len(bloom.Bits)) * 8
+	locs := make([]uint64, bloom.Hashes)
+	for i := range locs {
+		locs[i] = (h1 + uint64(i)*h2) % m
+	}
+	return locs
+}
+
+func (bloom *syncBloom) add(c cid.Cid) {
+	for _, loc := range bloom.locations(c) {
+		bloom.Bits[loc/8] |= 1 << (loc % 8)
+	}
+}
+
+func (bloom *syncBloom) has(c cid.Cid) bool {
+	for _, loc := range bloom.locations(c) {
+		if bloom.Bits[loc/8]&(1<<(loc%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (bloom *syncBloom) valid() bool {
+	return len(bloom.Bits) > 0 && bloom.Hashes > 0 && bloom.Hashes <= 64
+}
+
+func syncHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("POST", apiPrefix+"sync", request.RemoteAddr)
+
+	// Read the request body
+	request.Body = http.MaxBytesReader(writer, request.Body, syncBloomMaxSize)
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, "Invalid sync request!", http.StatusBadRequest)
+		return
+	}
+
+	// Require signature from a known peer instance if configured
+	if len(syncPeerKeys) > 0 {
+		err = verifyHTTPRequest(request, body, syncPeerKeys)
+		if err != nil {
+			log.Printf("Rejected sync request from %s - %s\n", request.RemoteAddr, err.Error())
+			http.Error(writer, "Invalid request signature!", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Decode the requester's bloom filter of blocks it has
+	bloom := &syncBloom{}
+	err = json.Unmarshal(body, bloom)
+	if err != nil || !bloom.valid() {
+		http.Error(writer, "Invalid sync request!", http.StatusBadRequest)
+		return
+	}
+
+	// The diff streams for as long as the requester's client allows, not the server write timeout
+	extendWriteDeadline(request, syncClient.Timeout)
+
+	// Moderation, gating and expiry entries go with the diff, the requester serves by them
+	root, pages, err := exportIndexNodes(syncIndexNamespaces...)
+	if err != nil {
+		log.Printf("Failed to gather sync index - %s\n", err.Error())
+		http.Error(writer, "Failed to sync", http.StatusInternalServerError)
+		return
+	}
+
+	// Write CAR header naming the index root, then the index blocks
+	writer.Header().Set("content-type", "application/vnd.ipld.car")
+	err = writeCarHeader(writer, []cid.Cid{root.Cid()})
+	if err == nil {
+		err = writeCarBlock(writer, root)
+	}
+	for _, page := range pages {
+		if err == nil {
+			err = writeCarBlock(writer, page)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to write sync CAR - %s\n", err.Error())
+		return
+	}
+
+	// Write out pinned blocks the requester is missing from every shard
+	for _, shard := range ipfsShards {
+		keys, err := pinnedBlocks(shard)
+		if err != nil {
+			log.Printf("Failed to list blocks - %s\n", err.Error())
+			return
+		}
+
+		for _, c := range keys {
+			// Skip blocks the requester (probably) has
+			if bloom.has(c) {
+				continue
+			}
+
+			// View-limited and blocked pastes are never replicated
+			if !isReplicable(c) {
+				continue
+			}
+
+			// Get and write the block
+			block, err := shard.node.Blockstore.Get(c)
+			if err != nil {
+				continue
+			}
+			err = writeCarBlock(writer, block)
+			if err != nil {
+				log.Printf("Failed to write sync CAR - %s\n", err.Error())
+				return
+			}
+		}
+	}
+}
+
+func isSyncIndexKey(key string) bool {
+	for _, namespace := range syncIndexNamespaces {
+		if strings.HasPrefix(key, "/gibon/"+namespace+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func replaceSyncIndex(entries map[string][]byte) error {
+	// Drop local entries the peer no longer has, so unblocks and the like apply here too
+	for _, namespace := range syncIndexNamespaces {
+		names, err := indexList(namespace)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			key := indexKey(namespace, name)
+			if _, ok := entries[key.String()]; ok {
+				continue
+			}
+			err = indexDelete(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Put every shipped entry, only ever into the synced namespaces
+	for key, value := range entries {
+		if !isSyncIndexKey(key) {
+			return errors.New("Invalid index key in sync: " + key)
+		}
+		err := indexStore.Put(ds.RawKey(key), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func localSyncBloom() (*syncBloom, error) {
+	// Gather all locally pinned block CIDs, unpinned ones are sent again to be pinned
+	cids := []cid.Cid{}
+	for _, shard := range ipfsShards {
+		keys, err := pinnedBlocks(shard)
+		if err != nil {
+			return nil, err
+		}
+		cids = append(cids, keys...)
+	}
+
+	// Build bloom filter from them
+	bloom, err := newSyncBloom(len(cids))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cids {
+		bloom.add(c)
+	}
+
+	return bloom, nil
+}
+
+func syncFrom(baseURL, token string) (int, error) {
+	// Build bloom filter of local blocks
+	bloom, err := localSyncBloom()
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(bloom)
+	if err != nil {
+		return 0, err
+	}
+
+	// Build sync request with admin token
+	request, err := http.NewRequestWithContext(globalContext, "POST", strings.TrimSuffix(baseURL, "/")+apiPrefix+"sync", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("content-type", "application/json")
+
+	// Sign request so the peer can authenticate this instance
+	err = signHTTPRequest(request, body)
+	if err != nil {
+		return 0, err
+	}
+
+	// Perform request, checking response status
+	response, err := syncClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, errors.New("Sync peer responded with: " + response.Status)
+	}
+
+	// Read the index then missing blocks from the CAR response
+	carReader, err := newCarReader(response.Body)
+	if err != nil {
+		return 0, err
+	}
+	if len(carReader.header.Roots) != 1 {
+		return 0, errors.New("Sync peer sent no index")
+	}
+	root := carReader.header.Roots[0]
+	pages := map[cid.Cid]bool{}
+	entries := map[string][]byte{}
+	indexed := false
+	fetched := 0
+	for {
+		block, err := carReader.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fetched, err
+		}
+		c := block.Cid()
+
+		// Index root lists the index pages, all written before any block
+		if !indexed && c.Equals(root) {
+			syncRoot := &exportRoot{}
+			err = cbor.DecodeInto(block.RawData(), syncRoot)
+			if err != nil {
+				return fetched, err
+			}
+			for _, page := range syncRoot.Index {
+				pages[page] = true
+			}
+		} else if pages[c] {
+			page := &exportIndexPage{}
+			err = cbor.DecodeInto(block.RawData(), page)
+			if err != nil {
+				return fetched, err
+			}
+			for _, entry := range page.Entries {
+				entries[entry.Key] = entry.Value
+			}
+			delete(pages, c)
+		} else {
+			if !indexed {
+				return fetched, errors.New("Sync peer sent an incomplete index")
+			}
+
+			// Put block into the responsible shard, pinned so repo GC keeps it
+			err = shardForCID(c).node.Blockstore.Put(block)
+			if err != nil {
+				return fetched, err
+			}
+			err = pinNode(globalContext, c)
+			if err != nil {
+				return fetched, err
+			}
+			fetched++
+			continue
+		}
+
+		// Replace the local index once every page is in
+		if len(pages) == 0 {
+			err = replaceSyncIndex(entries)
+			if err != nil {
+				return fetched, err
+			}
+			indexed = true
+		}
+	}
+	if !indexed {
+		return fetched, errors.New("Sync peer sent an incomplete index")
+	}
+
+	return fetched, nil
+}
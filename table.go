@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Lines sampled when detecting delimited pastes
+	tableDetectLines = 10
+
+	// Maximum table rows rendered in the HTML view
+	maxTableRows = 10000
+)
+
+type tablePageData struct {
+	uiPrefs
+	Path      string
+	Title     string
+	Header    []string
+	Rows      [][]string
+	Truncated bool
+}
+
+func detectDelimiter(b []byte, language string) (rune, bool) {
+	// Language hint wins
+	switch language {
+	case "csv":
+		return ',', true
+	case "tsv":
+		return '\t', true
+	}
+
+	// Look for delimiter giving a consistent field count (more than one) over first lines
+	for _, delim := range []rune{'\t', ','} {
+		reader := csv.NewReader(bytes.NewReader(b))
+		reader.Comma = delim
+		reader.LazyQuotes = true
+		fields := 0
+		lines := 0
+		for ; lines < tableDetectLines; lines++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil || len(record) < 2 || (fields != 0 && len(record) != fields) {
+				lines = 0
+				break
+			}
+			fields = len(record)
+		}
+		if lines >= 2 {
+			return delim, true
+		}
+	}
+
+	return 0, false
+}
+
+func readTable(b []byte, delim rune) ([][]string, error) {
+	reader := csv.NewReader(bytes.NewReader(b))
+	reader.Comma = delim
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	return reader.ReadAll()
+}
+
+func parseColumns(columnsStr string, header []string) ([]int, error) {
+	columns := []int{}
+	for _, column := range strings.Split(columnsStr, ",") {
+		column = strings.TrimSpace(column)
+
+		// Match by header name first, then 1-based index
+		found := -1
+		for i, name := range header {
+			if name == column {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			i, err := strconv.Atoi(column)
+			if err != nil || i < 1 || i > len(header) {
+				return nil, errors.New("Unknown column: " + column)
+			}
+			found = i - 1
+		}
+
+		columns = append(columns, found)
+	}
+	return columns, nil
+}
+
+func projectTable(b []byte, delim rune, columnsStr string) ([]byte, error) {
+	// Read full table, the first row names columns
+	records, err := readTable(b, delim)
+	if err != nil {
+		return nil, err
+	} else if len(records) == 0 {
+		return nil, errors.New("empty table")
+	}
+	columns, err := parseColumns(columnsStr, records[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Write only the requested columns, in requested order
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	writer.Comma = delim
+	for _, record := range records {
+		projected := make([]string, len(columns))
+		for i, column := range columns {
+			if column < len(record) {
+				projected[i] = record[column]
+			}
+		}
+		writer.Write(projected)
+	}
+	writer.Flush()
+
+	return buf.Bytes(), writer.Error()
+}
+
+func tableHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/table", request.RemoteAddr)
+
+	// Decode the paste CID
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Look for unencrypted text paste
+	p, err := getPaste(c)
+	if err != nil {
+		log.Printf("Paste not retrieved - %s\n", err.Error())
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	} else if p.encrypted {
+		http.Error(writer, "Only unencrypted pastes have a table view!", http.StatusBadRequest)
+		return
+	}
+	err = p.decompress()
+	if err != nil {
+		log.Printf("Failed to decompress paste - %s\n", err.Error())
+		http.Error(writer, "Paste decompression failed!", http.StatusInternalServerError)
+		return
+	}
+	meta, err := getPasteMeta(c)
+	if err != nil {
+		log.Printf("Failed to get paste metadata - %s\n", err.Error())
+		http.Error(writer, "Failed to get paste metadata", http.StatusInternalServerError)
+		return
+	}
+
+	// Ensure paste is CSV/TSV and read it
+	delim, ok := detectDelimiter(p.text, meta.Language)
+	if !ok || meta.ContentType != "" {
+		http.Error(writer, "Paste is not CSV/TSV!", http.StatusNotAcceptable)
+		return
+	}
+	records, err := readTable(p.text, delim)
+	if err != nil || len(records) == 0 {
+		http.Error(writer, "Paste is not CSV/TSV!", http.StatusNotAcceptable)
+		return
+	}
+
+	// Count this view against any view limit
+	ok, err = takeView(c)
+	if err != nil {
+		log.Printf("Failed to check paste view limit - %s\n", err.Error())
+		http.Error(writer, "Failed to check paste view limit", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(writer, "Paste view limit reached!", http.StatusGone)
+		return
+	}
+
+	// Render table page, sorting and filtering happen client-side
+	data := &tablePageData{
+		Path:   pastePrefix + c.String(),
+		Title:  meta.Title,
+		Header: records[0],
+		Rows:   records[1:],
+	}
+	if len(data.Rows) > maxTableRows {
+		data.Rows = data.Rows[:maxTableRows]
+		data.Truncated = true
+	}
+	renderPage(writer, request, "table.html", data)
+}
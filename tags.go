@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	tagsPrefix = "/tags/"
+
+	// Maximum number of tags per paste
+	maxPasteTags = 10
+)
+
+var (
+	// Allowed tag format
+	tagRegexp = regexp.MustCompile(`^[a-z0-9_.+-]{1,32}$`)
+)
+
+func parseTags(tagsStr string) ([]string, error) {
+	tags := []string{}
+	for _, tag := range strings.Split(tagsStr, ",") {
+		// Normalize and skip empty
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+
+		// Ensure valid tag
+		if !tagRegexp.MatchString(tag) {
+			return nil, errors.New("Invalid tag: " + tag)
+		}
+
+		tags = append(tags, tag)
+	}
+
+	// Ensure not too many
+	if len(tags) > maxPasteTags {
+		return nil, errors.New("Too many tags")
+	}
+
+	return tags, nil
+}
+
+func mergeTags(tags, extra []string) []string {
+	for _, tag := range extra {
+		// Stop when full
+		if len(tags) >= maxPasteTags {
+			break
+		}
+
+		// Skip duplicates
+		duplicate := false
+		for _, existing := range tags {
+			if existing == tag {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func tagPaste(c cid.Cid, tags []string) error {
+	for _, tag := range tags {
+		err := indexStore.Put(indexKey("tags/"+tag, c.String()), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func retagPaste(c cid.Cid, old, tags []string) error {
+	// Drop index entries for tags no longer on the paste
+	for _, tag := range old {
+		kept := false
+		for _, newTag := range tags {
+			kept = kept || newTag == tag
+		}
+		if kept {
+			continue
+		}
+		err := indexStore.Delete(indexKey("tags/"+tag, c.String()))
+		if err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return tagPaste(c, tags)
+}
+
+func listTagHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the tag
+	tag := strings.ToLower(params.ByName("tag"))
+
+	// Log the request
+	logRequest("GET", tagsPrefix+tag, request.RemoteAddr)
+
+	// Ensure valid tag
+	if !tagRegexp.MatchString(tag) {
+		http.Error(writer, "Invalid tag!", http.StatusBadRequest)
+		return
+	}
+
+	// Get public CIDs with the tag
+	cids, err := indexList("tags/" + tag)
+	if err == nil {
+		cids, err = filterPublicPastes(cids)
+	}
+	if err != nil {
+		log.Printf("Failed to list tag - %s\n", err.Error())
+		http.Error(writer, "Failed to list tag", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the paste list in response
+	writePasteList(writer, request, "Tagged "+tag, cids)
+}
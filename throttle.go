@@ -0,0 +1,242 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	// Failures are forgotten this long after the last one
+	decryptFailureWindow = 24 * time.Hour
+
+	// Lockout after the first throttled failure, doubling with each after
+	decryptLockoutBase = time.Second
+
+	// Period between pruning forgotten failure records
+	decryptPrunePeriod = 10 * time.Minute
+
+	// Maximum tracked failure records, those not locked out are pruned past this
+	maxDecryptFailureRecords = 100000
+
+	// IPv6 clients are tracked per /64, they usually hold a whole one
+	ipv6ThrottlePrefix = 64
+
+	// Multiple of the free attempts allowed against one paste from all clients together
+	decryptPasteAttemptsFactor = 100
+)
+
+var (
+	// Failed decryptions allowed per client, and per client on one paste, before backoff (0 disables)
+	decryptFreeAttempts uint
+
+	// Maximum lockout after repeated failed decryptions
+	decryptLockoutMax time.Duration
+
+	// Report failed decryptions as paste not found, so key guessers can't tell a wrong key from a missing paste
+	decryptFailureNotFound bool
+
+	// Minimum response time for failed keyed requests, masks key derivation and lookup timing
+	decryptFailureFloor time.Duration
+
+	// Failure records by client, by client and paste, and by paste, guarded by mutex
+	decryptFailures      = map[string]*decryptFailure{}
+	decryptFailuresMutex sync.Mutex
+)
+
+type decryptFailure struct {
+	count uint
+	last  time.Time
+	until time.Time
+}
+
+func throttleClient(request *http.Request) string {
+	// Track by address without port, IPv6 by prefix
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	} else if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(ipv6ThrottlePrefix, 128)).String()
+	}
+	return ip.String()
+}
+
+func decryptThrottleKeys(request *http.Request, c cid.Cid) []string {
+	// Throttle one client on one paste and across many pastes, and many clients on one paste far more loosely,
+	// so no single client can lock everyone else out of a paste
+	client := throttleClient(request)
+	return []string{"cid:" + c.String() + "/ip:" + client, "ip:" + client, "paste:" + c.String()}
+}
+
+func decryptFreeAttemptsFor(key string) uint {
+	if strings.HasPrefix(key, "paste:") {
+		return decryptFreeAttempts * decryptPasteAttemptsFactor
+	}
+	return decryptFreeAttempts
+}
+
+func lockoutFor(count, free uint) time.Duration {
+	// Exponential backoff once past the free attempts, capped
+	if count < free {
+		return 0
+	}
+	shift := count - free
+	if shift > 30 {
+		return decryptLockoutMax
+	}
+	lockout := decryptLockoutBase << shift
+	if lockout > decryptLockoutMax {
+		return decryptLockoutMax
+	}
+	return lockout
+}
+
+func checkDecryptThrottle(writer http.ResponseWriter, request *http.Request, c cid.Cid) bool {
+	// Skip if disabled
+	if decryptFreeAttempts == 0 {
+		return true
+	}
+
+	decryptFailuresMutex.Lock()
+	defer decryptFailuresMutex.Unlock()
+
+	// Refuse while the paste, client or both together are locked out
+	now := time.Now()
+	wait := time.Duration(0)
+	for _, key := range decryptThrottleKeys(request, c) {
+		if failure, ok := decryptFailures[key]; ok && failure.until.After(now) && failure.until.Sub(now) > wait {
+			wait = failure.until.Sub(now)
+		}
+	}
+	if wait == 0 {
+		return true
+	}
+	writer.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	http.Error(writer, "Too many failed decryption attempts, try again later!", http.StatusTooManyRequests)
+	return false
+}
+
+func recordDecryptFailure(request *http.Request, c cid.Cid) {
+	recordDecryptFailureKeys(decryptThrottleKeys(request, c))
+}
+
+func recordDecryptFailureKeys(keys []string) {
+	// Skip if disabled
+	if decryptFreeAttempts == 0 {
+		return
+	}
+
+	decryptFailuresMutex.Lock()
+	defer decryptFailuresMutex.Unlock()
+
+	// Count failure against paste and client, extending their lockouts
+	now := time.Now()
+	for _, key := range keys {
+		failure, ok := decryptFailures[key]
+		if !ok || now.Sub(failure.last) > decryptFailureWindow {
+			failure = &decryptFailure{}
+			decryptFailures[key] = failure
+		}
+		free := decryptFreeAttemptsFor(key)
+		failure.count++
+		failure.last = now
+		failure.until = now.Add(lockoutFor(failure.count, free))
+		if failure.count == free {
+			log.Printf("Throttling decryption attempts for %s\n", key)
+		}
+	}
+}
+
+func waitDecryptFailureFloor(request *http.Request, started time.Time) {
+	// Pad from when the keyed request started, unless the client gives up first
+	wait := decryptFailureFloor - time.Since(started)
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-request.Context().Done():
+	}
+}
+
+func writeDecryptFailure(writer http.ResponseWriter, request *http.Request, started time.Time, hint string) {
+	// Wrong keys and missing pastes take the same time
+	waitDecryptFailureFloor(request, started)
+
+	// Optionally look exactly like a missing paste, a hint would give it away
+	if decryptFailureNotFound {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	} else if hint != "" {
+		writer.Header().Set("X-Paste-Hint", hint)
+		http.Error(writer, "Paste decryption failed! Hint: "+hint, http.StatusInternalServerError)
+		return
+	}
+	http.Error(writer, "Paste decryption failed!", http.StatusInternalServerError)
+}
+
+func writeKeyedNotFound(writer http.ResponseWriter, request *http.Request, c cid.Cid, started time.Time) {
+	// Misses count as failed attempts when they must look the same, else throttling would tell them apart
+	if decryptFailureNotFound {
+		recordDecryptFailure(request, c)
+	}
+	waitDecryptFailureFloor(request, started)
+	http.Error(writer, "Paste not found!", http.StatusNotFound)
+}
+
+func pruneDecryptFailures() {
+	decryptFailuresMutex.Lock()
+	defer decryptFailuresMutex.Unlock()
+
+	// Drop forgotten failures
+	now := time.Now()
+	for key, failure := range decryptFailures {
+		if now.Sub(failure.last) > decryptFailureWindow && now.After(failure.until) {
+			delete(decryptFailures, key)
+		}
+	}
+
+	// Still too many, drop any not currently locked out
+	if len(decryptFailures) > maxDecryptFailureRecords {
+		for key, failure := range decryptFailures {
+			if now.After(failure.until) {
+				delete(decryptFailures, key)
+			}
+			if len(decryptFailures) <= maxDecryptFailureRecords {
+				break
+			}
+		}
+	}
+}
+
+func startDecryptThrottle() {
+	// Skip if disabled
+	if decryptFreeAttempts == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(decryptPrunePeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-globalContext.Done():
+				return
+			case <-ticker.C:
+				pruneDecryptFailures()
+			}
+		}
+	}()
+}
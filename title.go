@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Maximum title length (in runes)
+	maxTitleLength = 80
+
+	// Number of lines searched for a Markdown H1
+	titleSearchLines = 20
+)
+
+func extractTitle(b []byte) string {
+	// Binary content has no title
+	if !utf8.Valid(b) {
+		return ""
+	}
+
+	firstLine := ""
+	shebang := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 0; line < titleSearchLines && scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		// Markdown H1 takes priority
+		if strings.HasPrefix(text, "# ") {
+			return truncateTitle(strings.TrimSpace(text[2:]))
+		}
+
+		// Shebang on the first line
+		if line == 0 && strings.HasPrefix(text, "#!") {
+			fields := strings.Fields(text[2:])
+			if len(fields) > 0 {
+				interp := path.Base(fields[0])
+				if interp == "env" && len(fields) > 1 {
+					interp = fields[1]
+				}
+				shebang = truncateTitle(interp + " script")
+			}
+			continue
+		}
+
+		// Remember first non-empty line
+		if firstLine == "" {
+			firstLine = text
+		}
+	}
+
+	if shebang != "" {
+		return shebang
+	}
+	return truncateTitle(firstLine)
+}
+
+func truncateTitle(title string) string {
+	// Drop any control characters (safe for headers and listings)
+	title = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, title)
+
+	if utf8.RuneCountInString(title) <= maxTitleLength {
+		return title
+	}
+	runes := []rune(title)
+	return string(runes[:maxTitleLength-3]) + "..."
+}
+
+type pasteListEntry struct {
+	Path  string
+	Title string
+}
+
+type listPageData struct {
+	uiPrefs
+	Heading string
+	Pastes  []*pasteListEntry
+}
+
+func writePasteList(writer http.ResponseWriter, request *http.Request, heading string, cids []string) {
+	// Browsers get a page with identicons
+	if wantsHTML(request) {
+		data := &listPageData{Heading: heading, Pastes: []*pasteListEntry{}}
+		for _, cidStr := range cids {
+			entry := &pasteListEntry{Path: pastePrefix + cidStr}
+			if meta, err := getPasteMetaStr(cidStr); err == nil {
+				entry.Title = meta.Title
+			}
+			data.Pastes = append(data.Pastes, entry)
+		}
+		renderPage(writer, request, "list.html", data)
+		return
+	}
+
+	// Write the paste paths in response
+	writer.Header().Set("content-type", "text/plain")
+	for _, cidStr := range cids {
+		writer.Write([]byte(pasteListLine(cidStr)))
+	}
+}
+
+func pasteListLine(cidStr string) string {
+	// Include the title if there is one
+	line := pastePrefix + cidStr
+	if meta, err := getPasteMetaStr(cidStr); err == nil && meta.Title != "" {
+		line += "\t" + meta.Title
+	}
+	return line + "\n"
+}
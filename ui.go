@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	staticPrefix = "/static/"
+
+	// Service worker path, served from root so its scope covers pastes
+	serviceWorkerPath = "/sw.js"
+
+	// Cache lifetime for fingerprinted assets (they never change)
+	staticCacheImmutable = "public, max-age=31536000, immutable"
+
+	// Cache policy for unfingerprinted asset names
+	staticCacheRevalidate = "public, no-cache"
+)
+
+var (
+	// Web UI assets and templates compiled into the binary
+	//go:embed ui
+	embeddedUI embed.FS
+
+	// Optional on-disk directory overriding embedded UI files
+	uiOverrideDir string
+
+	// Filesystem UI files are served from
+	uiFS fs.FS
+
+	// Pre-compiled page templates
+	uiTemplates *template.Template
+
+	// Static assets by logical name, and logical names by fingerprinted name
+	staticAssets      map[string]*staticAsset
+	staticFingerprint map[string]string
+)
+
+type staticAsset struct {
+	name        string
+	fingerprint string
+	data        []byte
+}
+
+type uiPageData struct {
+	uiPrefs
+	Version      string
+	MaxPasteSize int64
+}
+
+// overlayFS serves files from the upper filesystem, falling back to the lower
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	file, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	return file, err
+}
+
+func fingerprintName(name string, data []byte) string {
+	// Insert short content hash before the file extension
+	sum := sha256.Sum256(data)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+}
+
+func assetPath(name string) (string, error) {
+	asset, ok := staticAssets[name]
+	if !ok {
+		return "", errors.New("Unknown static asset: " + name)
+	}
+	return staticPrefix + asset.fingerprint, nil
+}
+
+func setupUI() error {
+	// Get embedded UI root, overlaying override directory if set
+	embedded, err := fs.Sub(embeddedUI, "ui")
+	if err != nil {
+		return err
+	}
+	uiFS = embedded
+	if uiOverrideDir != "" {
+		uiFS = overlayFS{upper: os.DirFS(uiOverrideDir), lower: embedded}
+	}
+
+	// Load and fingerprint each static asset
+	staticAssets = map[string]*staticAsset{}
+	staticFingerprint = map[string]string{}
+	err = fs.WalkDir(uiFS, "static", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(uiFS, name)
+		if err != nil {
+			return err
+		}
+		logical := strings.TrimPrefix(name, "static/")
+		asset := &staticAsset{
+			name:        logical,
+			fingerprint: fingerprintName(logical, data),
+			data:        data,
+		}
+		staticAssets[logical] = asset
+		staticFingerprint[asset.fingerprint] = logical
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Pre-compile all page templates, with asset path lookup available
+	uiTemplates, err = template.New("").Funcs(template.FuncMap{
+		"asset": assetPath,
+	}).ParseFS(uiFS, "templates/*.html")
+	return err
+}
+
+func renderPage(writer http.ResponseWriter, request *http.Request, name string, data interface{}) {
+	// Apply the visitor's UI preferences
+	if page, ok := data.(prefsPage); ok {
+		page.setPrefs(requestPrefs(writer, request))
+	}
+
+	// Execute into buffer so template errors don't produce partial pages
+	buf := &bytes.Buffer{}
+	err := uiTemplates.ExecuteTemplate(buf, name, data)
+	if err != nil {
+		log.Printf("Failed to render page %s - %s\n", name, err.Error())
+		http.Error(writer, "Failed to render page!", http.StatusInternalServerError)
+		return
+	}
+
+	// Write out the rendered page
+	writer.Header().Set("content-type", "text/html; charset=utf-8")
+	writer.Write(buf.Bytes())
+}
+
+func wantsHTML(request *http.Request) bool {
+	return strings.Contains(request.Header.Get("Accept"), "text/html")
+}
+
+func staticHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Log the request
+	name := params.ByName("file")
+	logRequest("GET", staticPrefix+name, request.RemoteAddr)
+
+	// Look for asset by fingerprinted name first, then logical name
+	var asset *staticAsset
+	cacheControl := staticCacheImmutable
+	if logical, ok := staticFingerprint[name]; ok {
+		asset = staticAssets[logical]
+	} else if asset, ok = staticAssets[name]; ok {
+		cacheControl = staticCacheRevalidate
+	} else {
+		http.Error(writer, "Asset not found!", http.StatusNotFound)
+		return
+	}
+
+	// Set headers from asset
+	contentType := mime.TypeByExtension(path.Ext(asset.name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	writer.Header().Set("content-type", contentType)
+	writer.Header().Set("Cache-Control", cacheControl)
+	writer.Header().Set("ETag", `"`+asset.fingerprint+`"`)
+
+	// Check for unchanged cached copy
+	if request.Header.Get("If-None-Match") == `"`+asset.fingerprint+`"` {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Write out the asset
+	writer.Write(asset.data)
+}
+
+func serviceWorkerHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", serviceWorkerPath, request.RemoteAddr)
+
+	// Look for the service worker asset
+	asset, ok := staticAssets["sw.js"]
+	if !ok {
+		http.Error(writer, "Asset not found!", http.StatusNotFound)
+		return
+	}
+
+	// Service worker URL is fixed, browsers must always revalidate it
+	writer.Header().Set("content-type", "application/javascript")
+	writer.Header().Set("Cache-Control", staticCacheRevalidate)
+	writer.Write(asset.data)
+}
@@ -0,0 +1,527 @@
+"use strict";
+
+(function () {
+	var DRAFT_KEY = "gibon-draft";
+	var INDENT = "\t";
+
+	var form = document.getElementById("paste-form");
+	var text = document.getElementById("paste-text");
+	var gutter = document.getElementById("paste-gutter");
+	var lang = document.getElementById("paste-lang");
+	var wrap = document.getElementById("paste-wrap");
+	var draftStatus = document.getElementById("draft-status");
+	var key = document.getElementById("paste-key");
+	var hint = document.getElementById("paste-hint");
+	var e2e = document.getElementById("paste-e2e");
+	var visibility = document.getElementById("paste-visibility");
+	var result = document.getElementById("paste-result");
+	var editor = document.getElementById("paste-editor");
+	var imagePreview = document.getElementById("image-preview");
+	var imagePreviewImg = document.getElementById("image-preview-img");
+	var imageRemove = document.getElementById("image-remove");
+	var bundleView = document.getElementById("bundle");
+	var bundleList = document.getElementById("bundle-list");
+	var bundleClear = document.getElementById("bundle-clear");
+	var bundleFiles = document.getElementById("bundle-files");
+	var bundleFolder = document.getElementById("bundle-folder");
+	var saveTimer = null;
+	var pendingImage = null;
+	var pendingFiles = [];
+
+	// Image types the server accepts
+	var IMAGE_TYPES = ["image/png", "image/jpeg", "image/gif", "image/webp"];
+
+	function showResult(message, iconPath) {
+		result.textContent = message;
+		result.hidden = false;
+
+		// Identicon lets the sender compare against what the recipient sees
+		if (iconPath) {
+			var icon = new Image(32, 32);
+			icon.className = "identicon";
+			icon.alt = "Paste identicon";
+			icon.src = iconPath + "/icon.svg";
+			result.insertBefore(icon, result.firstChild);
+		}
+	}
+
+	// Line numbers
+
+	function updateGutter() {
+		// Soft wrapped lines can't be numbered reliably, hide the gutter
+		gutter.hidden = wrap.checked;
+		if (gutter.hidden) {
+			return;
+		}
+
+		var lines = text.value.split("\n").length;
+		var numbers = [];
+		for (var i = 1; i <= lines; i++) {
+			numbers.push(i);
+		}
+		gutter.textContent = numbers.join("\n");
+		gutter.scrollTop = text.scrollTop;
+	}
+
+	function setWrap(enabled) {
+		wrap.checked = enabled;
+		text.setAttribute("wrap", enabled ? "soft" : "off");
+		updateGutter();
+	}
+
+	// Draft autosave
+
+	function saveDraft() {
+		try {
+			if (text.value === "") {
+				localStorage.removeItem(DRAFT_KEY);
+				draftStatus.textContent = "";
+				return;
+			}
+			localStorage.setItem(DRAFT_KEY, JSON.stringify({
+				text: text.value,
+				lang: lang.value,
+				wrap: wrap.checked
+			}));
+			draftStatus.textContent = "Draft saved";
+		} catch (err) {
+			draftStatus.textContent = "Draft not saved: " + err.message;
+		}
+	}
+
+	function scheduleSave() {
+		draftStatus.textContent = "";
+		clearTimeout(saveTimer);
+		saveTimer = setTimeout(saveDraft, 500);
+	}
+
+	function restoreDraft() {
+		var draft = null;
+		try {
+			draft = JSON.parse(localStorage.getItem(DRAFT_KEY));
+		} catch (err) {
+			return;
+		}
+		if (!draft) {
+			return;
+		}
+		text.value = draft.text || "";
+		lang.value = draft.lang || "";
+		setWrap(!!draft.wrap);
+		draftStatus.textContent = "Draft restored";
+	}
+
+	function clearDraft() {
+		clearTimeout(saveTimer);
+		try {
+			localStorage.removeItem(DRAFT_KEY);
+		} catch (err) {
+			// Nothing saved then
+		}
+		draftStatus.textContent = "";
+	}
+
+	// Keyboard handling
+
+	function lineStart(value, index) {
+		return value.lastIndexOf("\n", index - 1) + 1;
+	}
+
+	function indentSelection(dedent) {
+		var value = text.value;
+		var start = text.selectionStart;
+		var end = text.selectionEnd;
+
+		// Plain tab insert when nothing selected
+		if (!dedent && start === end) {
+			text.setRangeText(INDENT, start, end, "end");
+			return;
+		}
+
+		// (De)indent every selected line
+		var from = lineStart(value, start);
+		var lines = value.slice(from, end).split("\n");
+		var removedFirst = 0;
+		lines = lines.map(function (line, i) {
+			if (!dedent) {
+				return INDENT + line;
+			}
+			var strip = line.match(/^(\t| {1,4})/);
+			if (!strip) {
+				return line;
+			}
+			if (i === 0) {
+				removedFirst = strip[0].length;
+			}
+			return line.slice(strip[0].length);
+		});
+		var replaced = lines.join("\n");
+		text.setRangeText(replaced, from, end, "preserve");
+
+		// Keep the selection over the same lines
+		if (dedent) {
+			text.selectionStart = Math.max(from, start - removedFirst);
+		} else {
+			text.selectionStart = start + INDENT.length;
+		}
+		text.selectionEnd = from + replaced.length;
+	}
+
+	text.addEventListener("keydown", function (event) {
+		if (event.key === "Tab" && !event.ctrlKey && !event.altKey && !event.metaKey) {
+			event.preventDefault();
+			indentSelection(event.shiftKey);
+			text.dispatchEvent(new Event("input"));
+		} else if (event.key === "Escape") {
+			text.blur();
+		} else if (event.key === "Enter" && (event.ctrlKey || event.metaKey)) {
+			event.preventDefault();
+			form.requestSubmit();
+		}
+	});
+
+	text.addEventListener("input", function () {
+		updateGutter();
+		scheduleSave();
+	});
+	text.addEventListener("scroll", function () {
+		gutter.scrollTop = text.scrollTop;
+	});
+	wrap.addEventListener("change", function () {
+		setWrap(wrap.checked);
+		scheduleSave();
+	});
+	lang.addEventListener("change", scheduleSave);
+
+	// Screenshot pasting
+
+	function setImage(blob) {
+		// Release any previous preview
+		if (imagePreviewImg.src) {
+			URL.revokeObjectURL(imagePreviewImg.src);
+			imagePreviewImg.removeAttribute("src");
+		}
+
+		// Swap between image preview and text editor
+		pendingImage = blob;
+		imagePreview.hidden = !blob;
+		editor.hidden = !!blob;
+		text.required = !blob;
+		if (blob) {
+			imagePreviewImg.src = URL.createObjectURL(blob);
+		} else {
+			text.focus();
+		}
+	}
+
+	document.addEventListener("paste", function (event) {
+		var items = (event.clipboardData || {}).items || [];
+		for (var i = 0; i < items.length; i++) {
+			if (items[i].kind === "file" && IMAGE_TYPES.indexOf(items[i].type) !== -1) {
+				event.preventDefault();
+				setImage(items[i].getAsFile());
+				return;
+			}
+		}
+	});
+	imageRemove.addEventListener("click", function () {
+		setImage(null);
+	});
+
+	// Multi-file bundles
+
+	function setFiles(files) {
+		// Each file gets a row with its own progress bar
+		pendingFiles = files.map(function (file) {
+			var item = document.createElement("li");
+			var name = document.createElement("span");
+			var progress = document.createElement("progress");
+			name.textContent = file.path;
+			progress.max = Math.max(file.file.size, 1);
+			progress.value = 0;
+			item.appendChild(name);
+			item.appendChild(progress);
+			return { path: file.path, file: file.file, item: item, progress: progress };
+		});
+
+		bundleList.textContent = "";
+		pendingFiles.forEach(function (file) {
+			bundleList.appendChild(file.item);
+		});
+
+		// Swap between bundle list and text editor
+		var hasFiles = pendingFiles.length > 0;
+		bundleView.hidden = !hasFiles;
+		editor.hidden = hasFiles || !!pendingImage;
+		text.required = !hasFiles && !pendingImage;
+	}
+
+	function readEntry(entry, prefix) {
+		// Recursively collect files from dropped directory entries
+		return new Promise(function (resolve, reject) {
+			if (entry.isFile) {
+				entry.file(function (file) {
+					resolve([{ path: prefix + file.name, file: file }]);
+				}, reject);
+				return;
+			}
+
+			var reader = entry.createReader();
+			var collected = [];
+			(function readBatch() {
+				reader.readEntries(function (entries) {
+					if (entries.length === 0) {
+						Promise.all(collected).then(function (lists) {
+							resolve([].concat.apply([], lists));
+						}, reject);
+						return;
+					}
+					entries.forEach(function (child) {
+						collected.push(readEntry(child, prefix + entry.name + "/"));
+					});
+					readBatch();
+				}, reject);
+			})();
+		});
+	}
+
+	function fromFileList(list) {
+		return Array.prototype.map.call(list, function (file) {
+			return { path: file.webkitRelativePath || file.name, file: file };
+		});
+	}
+
+	document.addEventListener("dragover", function (event) {
+		event.preventDefault();
+		document.body.classList.add("dragging");
+	});
+	document.addEventListener("dragleave", function (event) {
+		if (event.target === document.documentElement || event.relatedTarget === null) {
+			document.body.classList.remove("dragging");
+		}
+	});
+	document.addEventListener("drop", function (event) {
+		event.preventDefault();
+		document.body.classList.remove("dragging");
+
+		// Prefer entries so dropped directories can be walked
+		var items = event.dataTransfer.items;
+		if (items && items.length && items[0].webkitGetAsEntry) {
+			var entries = [];
+			for (var i = 0; i < items.length; i++) {
+				var entry = items[i].webkitGetAsEntry();
+				if (entry) {
+					entries.push(readEntry(entry, ""));
+				}
+			}
+			Promise.all(entries).then(function (lists) {
+				setFiles([].concat.apply([], lists));
+			}).catch(function (err) {
+				showResult("Failed to read dropped files: " + err.message);
+			});
+			return;
+		}
+		setFiles(fromFileList(event.dataTransfer.files));
+	});
+	bundleFiles.addEventListener("change", function () {
+		setFiles(fromFileList(bundleFiles.files));
+		bundleFiles.value = "";
+	});
+	bundleFolder.addEventListener("change", function () {
+		setFiles(fromFileList(bundleFolder.files));
+		bundleFolder.value = "";
+	});
+	bundleClear.addEventListener("click", function () {
+		setFiles([]);
+	});
+
+	function uploadBundle() {
+		var body = new FormData();
+		var offsets = [];
+		var total = 0;
+		pendingFiles.forEach(function (file) {
+			body.append("file", file.file, file.path);
+			offsets.push(total);
+			total += file.file.size;
+		});
+
+		return new Promise(function (resolve, reject) {
+			var xhr = new XMLHttpRequest();
+			xhr.open("POST", "/bundle");
+			xhr.setRequestHeader("Accept", "application/json");
+
+			// Key goes in a header, keeping it out of URLs and logs
+			if (key.value !== "") {
+				xhr.setRequestHeader("X-Gibon-Key", key.value);
+			}
+
+			// Spread overall progress across files in upload order
+			xhr.upload.addEventListener("progress", function (event) {
+				var loaded = event.lengthComputable ? event.loaded * total / event.total : 0;
+				pendingFiles.forEach(function (file, i) {
+					file.progress.value = Math.min(Math.max(loaded - offsets[i], 0), file.progress.max);
+				});
+			});
+			xhr.addEventListener("load", function () {
+				if (xhr.status !== 200) {
+					reject(new Error(xhr.responseText));
+					return;
+				}
+				resolve(JSON.parse(xhr.responseText));
+			});
+			xhr.addEventListener("error", function () {
+				reject(new Error("network error"));
+			});
+			xhr.send(body);
+		});
+	}
+
+	// In-browser encryption, E2E format from crypto/e2e.go
+
+	var E2E_PBKDF2_ITERATIONS = 600000;
+
+	function base64URLEncode(bytes) {
+		var bin = "";
+		for (var i = 0; i < bytes.length; i++) {
+			bin += String.fromCharCode(bytes[i]);
+		}
+		return btoa(bin).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	}
+
+	// Resolves { blob, fragment }, fragment holds a generated key for the share link
+	function encryptE2E(plain, password) {
+		var nonce = crypto.getRandomValues(new Uint8Array(12));
+		var kdf, params, keyPromise, fragment = "";
+
+		if (password === "") {
+			// Random raw key, delivered in the link fragment
+			var raw = crypto.getRandomValues(new Uint8Array(32));
+			kdf = 0;
+			params = new Uint8Array(0);
+			fragment = "#" + base64URLEncode(raw);
+			keyPromise = crypto.subtle.importKey("raw", raw, "AES-GCM", false, ["encrypt"]);
+		} else {
+			// Password derived key, shared separately
+			var salt = crypto.getRandomValues(new Uint8Array(16));
+			kdf = 1;
+			params = new Uint8Array(4 + salt.length);
+			new DataView(params.buffer).setUint32(0, E2E_PBKDF2_ITERATIONS);
+			params.set(salt, 4);
+			keyPromise = crypto.subtle.importKey("raw", new TextEncoder().encode(password), "PBKDF2", false, ["deriveKey"]).then(function (base) {
+				return crypto.subtle.deriveKey(
+					{ name: "PBKDF2", hash: "SHA-256", salt: salt, iterations: E2E_PBKDF2_ITERATIONS },
+					base, { name: "AES-GCM", length: 256 }, false, ["encrypt"]);
+			});
+		}
+
+		return keyPromise.then(function (cryptoKey) {
+			return crypto.subtle.encrypt({ name: "AES-GCM", iv: nonce }, cryptoKey, new TextEncoder().encode(plain));
+		}).then(function (sealed) {
+			// Marker, version, kdf, cipher, nonce size, params size, params, nonce, ciphertext
+			var header = [0xE2, 1, kdf, 1, nonce.length, params.length >> 8, params.length & 0xff];
+			return {
+				blob: new Blob([new Uint8Array(header), params, nonce, new Uint8Array(sealed)]),
+				fragment: fragment
+			};
+		});
+	}
+
+	function uploadE2E() {
+		var query = new URLSearchParams({ e2e: "1" });
+		if (lang.value !== "") {
+			query.set("lang", lang.value);
+		}
+		if (hint.value !== "") {
+			query.set("hint", hint.value);
+		}
+
+		encryptE2E(text.value, key.value).then(function (encrypted) {
+			return fetch("/?" + query.toString(), {
+				method: "POST",
+				headers: { "Accept": "application/json" },
+				body: encrypted.blob
+			}).then(function (response) {
+				if (!response.ok) {
+					return response.text().then(function (msg) { throw new Error(msg); });
+				}
+				return response.json();
+			}).then(function (paste) {
+				clearDraft();
+				showResult(location.origin + paste.path + encrypted.fragment, paste.path);
+			});
+		}).catch(function (err) {
+			showResult("Paste failed: " + err.message);
+		});
+	}
+
+	// Upload
+
+	form.addEventListener("submit", function (event) {
+		event.preventDefault();
+
+		// Text encrypted in browser uploads separately
+		if (e2e.checked && !pendingImage && pendingFiles.length === 0) {
+			uploadE2E();
+			return;
+		}
+
+		// Bundles upload separately with progress
+		if (pendingFiles.length > 0) {
+			uploadBundle().then(function (bundle) {
+				setFiles([]);
+				showResult(location.origin + bundle.path);
+			}).catch(function (err) {
+				showResult("Bundle upload failed: " + err.message);
+			});
+			return;
+		}
+
+		// Build upload query
+		var query = new URLSearchParams();
+		if (pendingImage) {
+			query.set("type", pendingImage.type);
+		} else if (lang.value !== "") {
+			query.set("lang", lang.value);
+		}
+		var headers = { "Accept": "application/json" };
+		if (key.value !== "") {
+			headers["X-Gibon-Key"] = key.value;
+			if (hint.value !== "") {
+				query.set("hint", hint.value);
+			}
+		} else {
+			query.set("visibility", visibility.value);
+		}
+
+		// Upload the paste
+		fetch("/?" + query.toString(), {
+			method: "POST",
+			headers: headers,
+			body: pendingImage || text.value
+		}).then(function (response) {
+			if (!response.ok) {
+				return response.text().then(function (msg) { throw new Error(msg); });
+			}
+			return response.json();
+		}).then(function (paste) {
+			if (pendingImage) {
+				setImage(null);
+			} else {
+				clearDraft();
+			}
+			showResult(location.origin + (paste.short || paste.path), paste.path);
+		}).catch(function (err) {
+			showResult("Paste failed: " + err.message);
+		});
+	});
+
+	// Offline viewing of previously fetched pastes
+	if ("serviceWorker" in navigator) {
+		navigator.serviceWorker.register("/sw.js").catch(function (err) {
+			console.log("Service worker registration failed: " + err.message);
+		});
+	}
+
+	restoreDraft();
+	setWrap(wrap.checked);
+})();
@@ -0,0 +1,247 @@
+"use strict";
+
+(function () {
+	// Paste envelope, see envelope.go
+	var ENVELOPE_MAGIC = "GBN";
+	var FLAG_ENCRYPTED = 1 << 0;
+	var FLAG_ARGON2ID = 1 << 1;
+	var FLAG_CRYPTO_ENVELOPE = 1 << 2;
+	var FLAG_E2E = 1 << 3;
+	var FLAG_SIGNED = 1 << 4;
+	var FLAG_MAC = 1 << 5;
+	var CODEC_GZIP = 1;
+
+	// Ed25519 signer public key + signature, HMAC-SHA256 tag
+	var SIGNATURE_BLOCK_SIZE = 32 + 64;
+	var MAC_SIZE = 32;
+
+	// Crypto envelope, see cryptoenvelope.go
+	var CRYPTO_ENVELOPE_MAGIC = 0xC7;
+	var KDF_SHA256 = 0;
+
+	// E2E format, see crypto/e2e.go
+	var E2E_MARKER = 0xE2;
+	var E2E_KDF_RAW = 0;
+	var E2E_KDF_PBKDF2 = 1;
+
+	var CIPHER_AES256GCM = 1;
+	var GCM_NONCE_SIZE = 12;
+
+	var form = document.getElementById("decrypt-form");
+	var keyInput = document.getElementById("decrypt-key");
+	var scanButton = document.getElementById("decrypt-scan");
+	var video = document.getElementById("decrypt-video");
+	var status = document.getElementById("decrypt-status");
+	var output = document.getElementById("decrypt-text");
+	var raw = null;
+
+	function showStatus(message) {
+		status.textContent = message;
+	}
+
+	function base64URLDecode(str) {
+		var b64 = str.replace(/-/g, "+").replace(/_/g, "/");
+		while (b64.length % 4 !== 0) {
+			b64 += "=";
+		}
+		var bin = atob(b64);
+		var bytes = new Uint8Array(bin.length);
+		for (var i = 0; i < bin.length; i++) {
+			bytes[i] = bin.charCodeAt(i);
+		}
+		return bytes;
+	}
+
+	// Parse stored envelope into { codec, kdf, params, nonce, sealed }
+	function parseEnvelope(bytes) {
+		var magic = String.fromCharCode(bytes[0], bytes[1], bytes[2]);
+		if (bytes.length < 6 || magic !== ENVELOPE_MAGIC) {
+			throw new Error("unrecognised paste format");
+		}
+		var flags = bytes[4];
+		var codec = bytes[5];
+
+		// Skip signature and integrity MAC, both are checked server-side
+		var start = 6;
+		if (flags & FLAG_SIGNED) {
+			start += SIGNATURE_BLOCK_SIZE;
+		}
+		if (flags & FLAG_MAC) {
+			start += MAC_SIZE;
+		}
+		if (bytes.length < start) {
+			throw new Error("paste header truncated");
+		}
+		var text = bytes.subarray(start);
+
+		if (!(flags & FLAG_ENCRYPTED)) {
+			return { codec: codec, plain: text };
+		} else if (flags & FLAG_E2E) {
+			return parseSealed(text, E2E_MARKER, codec, true);
+		} else if (flags & FLAG_CRYPTO_ENVELOPE) {
+			// Version 1 had a single byte params size
+			return parseSealed(text, CRYPTO_ENVELOPE_MAGIC, codec, text[1] !== 1);
+		} else if (flags & FLAG_ARGON2ID) {
+			throw new Error("this paste uses Argon2id keys, which browsers can't derive; fetch it with ?key= or X-Gibon-Key instead");
+		}
+
+		// Legacy layout: nonce then ciphertext, SHA-256 key
+		return {
+			codec: codec,
+			kdf: KDF_SHA256,
+			params: new Uint8Array(0),
+			nonce: text.subarray(0, GCM_NONCE_SIZE),
+			sealed: text.subarray(GCM_NONCE_SIZE)
+		};
+	}
+
+	// Both sealed layouts are magic, version, kdf, cipher, nonce size, params size
+	function parseSealed(text, magic, codec, wideParams) {
+		if (text.length < 7 || text[0] !== magic) {
+			throw new Error("encrypted paste header missing");
+		} else if (text[3] !== CIPHER_AES256GCM || text[4] !== GCM_NONCE_SIZE) {
+			throw new Error("unsupported cipher");
+		}
+		var paramsSize = wideParams ? (text[5] << 8) | text[6] : text[5];
+		var start = wideParams ? 7 : 6;
+		return {
+			codec: codec,
+			e2e: magic === E2E_MARKER,
+			kdf: text[2],
+			params: text.subarray(start, start + paramsSize),
+			nonce: text.subarray(start + paramsSize, start + paramsSize + GCM_NONCE_SIZE),
+			sealed: text.subarray(start + paramsSize + GCM_NONCE_SIZE)
+		};
+	}
+
+	function deriveKey(env, key) {
+		var encoded = new TextEncoder().encode(key);
+
+		if (env.e2e && env.kdf === E2E_KDF_RAW) {
+			return crypto.subtle.importKey("raw", base64URLDecode(key), "AES-GCM", false, ["decrypt"]);
+		} else if (env.e2e && env.kdf === E2E_KDF_PBKDF2) {
+			var view = new DataView(env.params.buffer, env.params.byteOffset, env.params.byteLength);
+			var iterations = view.getUint32(0);
+			return crypto.subtle.importKey("raw", encoded, "PBKDF2", false, ["deriveKey"]).then(function (base) {
+				return crypto.subtle.deriveKey(
+					{ name: "PBKDF2", hash: "SHA-256", salt: env.params.subarray(4), iterations: iterations },
+					base, { name: "AES-GCM", length: 256 }, false, ["decrypt"]);
+			});
+		} else if (!env.e2e && env.kdf === KDF_SHA256) {
+			return crypto.subtle.digest("SHA-256", encoded).then(function (hash) {
+				return crypto.subtle.importKey("raw", hash, "AES-GCM", false, ["decrypt"]);
+			});
+		}
+		return Promise.reject(new Error("this paste's key derivation isn't supported in browsers"));
+	}
+
+	function decompress(codec, bytes) {
+		if (codec !== CODEC_GZIP) {
+			return Promise.resolve(bytes);
+		}
+		var stream = new Blob([bytes]).stream().pipeThrough(new DecompressionStream("gzip"));
+		return new Response(stream).arrayBuffer();
+	}
+
+	function fetchRaw() {
+		// Only fetched once, each fetch counts against any view limit
+		if (raw) {
+			return Promise.resolve(raw);
+		}
+		return fetch(form.dataset.raw).then(function (response) {
+			if (!response.ok) {
+				return response.text().then(function (msg) { throw new Error(msg); });
+			}
+			return response.arrayBuffer();
+		}).then(function (buf) {
+			raw = new Uint8Array(buf);
+			return raw;
+		});
+	}
+
+	function fetchDecrypted(key) {
+		// Key sent in a header, never in the URL, and the response is never cached
+		return fetch(form.dataset.path, {
+			headers: { "X-Gibon-Key": key, "Accept": "text/plain" },
+			cache: "no-store"
+		}).then(function (response) {
+			if (!response.ok) {
+				return response.text().then(function (msg) { throw new Error(msg); });
+			}
+			return response.arrayBuffer();
+		});
+	}
+
+	function decrypt(key) {
+		showStatus("Decrypting...");
+		var decrypted = "server" in form.dataset ? fetchDecrypted(key) : fetchRaw().then(function (bytes) {
+			var env = parseEnvelope(bytes);
+			if (env.plain) {
+				return decompress(env.codec, env.plain);
+			}
+			return deriveKey(env, key).then(function (cryptoKey) {
+				return crypto.subtle.decrypt({ name: "AES-GCM", iv: env.nonce }, cryptoKey, env.sealed);
+			}).catch(function (err) {
+				throw new Error(err.name === "OperationError" ? "wrong key" : err.message);
+			}).then(function (plain) {
+				return decompress(env.codec, new Uint8Array(plain));
+			});
+		});
+		decrypted.then(function (plain) {
+			output.textContent = new TextDecoder().decode(plain);
+			output.hidden = false;
+			form.hidden = true;
+			showStatus("");
+		}).catch(function (err) {
+			showStatus("Decryption failed: " + err.message);
+		});
+	}
+
+	form.addEventListener("submit", function (event) {
+		event.preventDefault();
+		decrypt(keyInput.value);
+	});
+
+	// Key QR codes from upload pairing link to the pairing page, with the key in the fragment
+	scanButton.hidden = !gibonQR.supported();
+	scanButton.addEventListener("click", function () {
+		scanButton.disabled = true;
+		gibonQR.scan(video, gibonQR.pairedKey).then(function (key) {
+			keyInput.value = key;
+			decrypt(key);
+		}).catch(function (err) {
+			showStatus("Scanning failed: " + err.message);
+		}).then(function () {
+			scanButton.disabled = false;
+		});
+	});
+
+	// Count down to expiry, if the paste has one
+	var expiry = document.getElementById("decrypt-expiry");
+	if (expiry) {
+		var expiresAt = new Date(expiry.getAttribute("datetime")).getTime();
+		var countdown = function () {
+			var remaining = Math.max(0, Math.floor((expiresAt - Date.now()) / 1000));
+			var days = Math.floor(remaining / 86400);
+			var hours = Math.floor(remaining % 86400 / 3600);
+			var minutes = Math.floor(remaining % 3600 / 60);
+			var seconds = remaining % 60;
+			expiry.textContent = (days > 0 ? days + "d " : "") + hours + "h " + minutes + "m " + seconds + "s";
+			if (remaining === 0) {
+				clearInterval(timer);
+				showStatus("This paste has expired.");
+				form.hidden = true;
+			}
+		};
+		var timer = setInterval(countdown, 1000);
+		countdown();
+	}
+
+	// Key in URL fragment decrypts straight away (fragments are never sent to the server)
+	if (location.hash.length > 1) {
+		var key = decodeURIComponent(location.hash.slice(1));
+		history.replaceState(null, "", location.pathname + location.search);
+		keyInput.value = key;
+		decrypt(key);
+	}
+})();
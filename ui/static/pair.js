@@ -0,0 +1,76 @@
+"use strict";
+
+(function () {
+	var status = document.getElementById("pair-status");
+	var scanButton = document.getElementById("pair-scan");
+	var video = document.getElementById("pair-video");
+	var form = document.getElementById("pair-form");
+	var linkInput = document.getElementById("pair-link");
+	var key = null;
+
+	function showStatus(message) {
+		status.textContent = message;
+	}
+
+	// Only paste links on this site, so a planted QR code can't lead the key elsewhere
+	function pasteLink(value) {
+		var url;
+		try {
+			url = new URL(value, location.href);
+		} catch (err) {
+			return null;
+		}
+		if (url.origin !== location.origin || !/^\/(p|paste)\/[^/]+$/.test(url.pathname)) {
+			return null;
+		}
+		return url.origin + url.pathname;
+	}
+
+	function openPaste(link) {
+		// Key goes in the fragment, where the decryption page picks it up
+		location.replace(link + "#" + encodeURIComponent(key));
+	}
+
+	function update() {
+		if (key) {
+			showStatus("Key received. Now scan the paste link QR code, or paste the link.");
+			form.hidden = false;
+		} else {
+			showStatus("Scan the key QR code.");
+		}
+		scanButton.hidden = !gibonQR.supported();
+	}
+
+	scanButton.addEventListener("click", function () {
+		scanButton.disabled = true;
+		gibonQR.scan(video, key ? pasteLink : gibonQR.pairedKey).then(function (value) {
+			if (key) {
+				openPaste(value);
+				return;
+			}
+			key = value;
+			update();
+		}).catch(function (err) {
+			showStatus("Scanning failed: " + err.message);
+		}).then(function () {
+			scanButton.disabled = false;
+		});
+	});
+
+	form.addEventListener("submit", function (event) {
+		event.preventDefault();
+		var link = pasteLink(linkInput.value);
+		if (!link) {
+			showStatus("That isn't a paste link on this site.");
+			return;
+		}
+		openPaste(link);
+	});
+
+	// Key in URL fragment (fragments are never sent to the server), dropped from history
+	if (location.hash.length > 1) {
+		key = decodeURIComponent(location.hash.slice(1));
+		history.replaceState(null, "", location.pathname);
+	}
+	update();
+})();
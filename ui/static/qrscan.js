@@ -0,0 +1,68 @@
+"use strict";
+
+// Camera QR scanning for the pairing flow, see pair.go
+var gibonQR = (function () {
+	var PAIR_PATH = "/pair";
+
+	// Scanning needs BarcodeDetector and a camera, else pages fall back to pasting links
+	function supported() {
+		return "BarcodeDetector" in window && !!(navigator.mediaDevices && navigator.mediaDevices.getUserMedia);
+	}
+
+	// Scan into video until accept returns a value for a payload, then stop the camera
+	function scan(video, accept) {
+		var detector = new BarcodeDetector({ formats: ["qr_code"] });
+		return navigator.mediaDevices.getUserMedia({ video: { facingMode: "environment" } }).then(function (stream) {
+			function stop() {
+				stream.getTracks().forEach(function (track) {
+					track.stop();
+				});
+				video.srcObject = null;
+				video.hidden = true;
+			}
+
+			video.srcObject = stream;
+			video.hidden = false;
+			return video.play().then(function () {
+				return new Promise(function (resolve, reject) {
+					function tick() {
+						detector.detect(video).then(function (codes) {
+							for (var i = 0; i < codes.length; i++) {
+								var value = accept(codes[i].rawValue);
+								if (value) {
+									stop();
+									resolve(value);
+									return;
+								}
+							}
+							requestAnimationFrame(tick);
+						}).catch(function (err) {
+							stop();
+							reject(err);
+						});
+					}
+					tick();
+				});
+			}).catch(function (err) {
+				stop();
+				throw err;
+			});
+		});
+	}
+
+	// Key from a pairing page link, null if the payload isn't one
+	function pairedKey(value) {
+		var url;
+		try {
+			url = new URL(value);
+		} catch (err) {
+			return null;
+		}
+		if (url.pathname !== PAIR_PATH || url.hash.length < 2) {
+			return null;
+		}
+		return decodeURIComponent(url.hash.slice(1));
+	}
+
+	return { supported: supported, scan: scan, pairedKey: pairedKey };
+})();
@@ -0,0 +1,97 @@
+"use strict";
+
+// Pastes are content-addressed so cached copies never go stale
+var PASTE_CACHE = "gibon-pastes-v1";
+var STATIC_CACHE = "gibon-static-v1";
+var PAGE_CACHE = "gibon-pages-v1";
+var MAX_CACHED_PASTES = 200;
+
+self.addEventListener("install", function () {
+	self.skipWaiting();
+});
+
+self.addEventListener("activate", function (event) {
+	var current = [PASTE_CACHE, STATIC_CACHE, PAGE_CACHE];
+	event.waitUntil(caches.keys().then(function (names) {
+		return Promise.all(names.filter(function (name) {
+			return current.indexOf(name) === -1;
+		}).map(function (name) {
+			return caches.delete(name);
+		}));
+	}).then(function () {
+		return self.clients.claim();
+	}));
+});
+
+function trimCache(name, max) {
+	// Drop oldest entries beyond the limit (keys are in insertion order)
+	return caches.open(name).then(function (cache) {
+		return cache.keys().then(function (keys) {
+			return Promise.all(keys.slice(0, Math.max(keys.length - max, 0)).map(function (key) {
+				return cache.delete(key);
+			}));
+		});
+	});
+}
+
+function cacheFirst(request, cacheName, max) {
+	return caches.open(cacheName).then(function (cache) {
+		return cache.match(request).then(function (cached) {
+			if (cached) {
+				return cached;
+			}
+			return fetch(request).then(function (response) {
+				if (response.ok) {
+					cache.put(request, response.clone()).then(function () {
+						if (max) {
+							trimCache(cacheName, max);
+						}
+					});
+				}
+				return response;
+			});
+		});
+	});
+}
+
+function networkFirst(request, cacheName) {
+	return fetch(request).then(function (response) {
+		if (response.ok) {
+			var copy = response.clone();
+			caches.open(cacheName).then(function (cache) {
+				cache.put(request, copy);
+			});
+		}
+		return response;
+	}).catch(function () {
+		return caches.match(request).then(function (cached) {
+			return cached || Response.error();
+		});
+	});
+}
+
+self.addEventListener("fetch", function (event) {
+	var request = event.request;
+	var url = new URL(request.url);
+	if (request.method !== "GET" || url.origin !== self.location.origin) {
+		return;
+	}
+
+	// Unencrypted paste content, never cache anything fetched with a key or decryption pages
+	if (/^\/paste\/[^/]+$/.test(url.pathname) && !url.searchParams.has("key") &&
+		!request.headers.has("X-Gibon-Key") && request.mode !== "navigate") {
+		event.respondWith(cacheFirst(request, PASTE_CACHE, MAX_CACHED_PASTES));
+		return;
+	}
+
+	// Fingerprinted static assets never change
+	if (url.pathname.indexOf("/static/") === 0) {
+		event.respondWith(cacheFirst(request, STATIC_CACHE, 0));
+		return;
+	}
+
+	// Keep the editor page available offline
+	if (url.pathname === "/" && request.mode === "navigate") {
+		event.respondWith(networkFirst(request, PAGE_CACHE));
+	}
+});
@@ -0,0 +1,64 @@
+(function () {
+	"use strict";
+
+	var table = document.getElementById("paste-table");
+	var filter = document.getElementById("table-filter");
+	var body = table.tBodies[0];
+	var rows = Array.prototype.slice.call(body.rows);
+	var sortColumn = -1;
+	var sortAscending = true;
+
+	function cellValue(row, column) {
+		var cell = row.cells[column];
+		return cell ? cell.textContent : "";
+	}
+
+	// Numbers compare numerically, everything else as text
+	function compare(a, b) {
+		var na = parseFloat(a);
+		var nb = parseFloat(b);
+		if (!isNaN(na) && !isNaN(nb) && String(na) === a.trim() && String(nb) === b.trim()) {
+			return na - nb;
+		}
+		return a.localeCompare(b, undefined, { numeric: true, sensitivity: "base" });
+	}
+
+	function sortBy(column) {
+		// Same column toggles direction
+		sortAscending = column === sortColumn ? !sortAscending : true;
+		sortColumn = column;
+
+		rows.sort(function (a, b) {
+			var result = compare(cellValue(a, column), cellValue(b, column));
+			return sortAscending ? result : -result;
+		});
+		rows.forEach(function (row) {
+			body.appendChild(row);
+		});
+
+		// Mark sorted heading
+		Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th, i) {
+			th.setAttribute("aria-sort", i === column ? (sortAscending ? "ascending" : "descending") : "none");
+		});
+	}
+
+	Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th, i) {
+		th.addEventListener("click", function () {
+			sortBy(i);
+		});
+		th.addEventListener("keydown", function (event) {
+			if (event.key === "Enter" || event.key === " ") {
+				event.preventDefault();
+				sortBy(i);
+			}
+		});
+	});
+
+	// Hide rows not containing the filter text
+	filter.addEventListener("input", function () {
+		var query = filter.value.toLowerCase();
+		rows.forEach(function (row) {
+			row.hidden = query !== "" && row.textContent.toLowerCase().indexOf(query) < 0;
+		});
+	});
+})();
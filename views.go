@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+var (
+	// Guards view limit read-modify-write
+	viewLimitsMutex sync.Mutex
+
+	// Returned when an upload asks to gate a paste that already existed
+	errPasteExists = errors.New("paste already exists")
+)
+
+type viewLimit struct {
+	Max   uint64 `json:"max"`
+	Count uint64 `json:"count"`
+}
+
+func setViewLimit(c cid.Cid, max uint64) error {
+	viewLimitsMutex.Lock()
+	defer viewLimitsMutex.Unlock()
+
+	// Pin paste so it survives until the limit is reached
+	err := pinPaste(c)
+	if err != nil {
+		return err
+	}
+
+	return indexPut(indexKey("views", c.String()), &viewLimit{Max: max})
+}
+
+func gatePaste(c cid.Cid, duplicate bool, verifier *pakeVerifier, maxViews uint64) error {
+	// Never gate an existing paste others may already read
+	if duplicate && (verifier != nil || maxViews > 0) {
+		return errPasteExists
+	}
+
+	// Register PAKE verifier if requested
+	if verifier != nil {
+		err := registerPakePaste(c, verifier)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Set view limit if requested
+	if maxViews > 0 {
+		return setViewLimit(c, maxViews)
+	}
+	return nil
+}
+
+func isGatedPaste(c cid.Cid) (bool, error) {
+	// View-limited and PAKE pastes are only released by their own handlers
+	has, err := indexStore.Has(indexKey("views", c.String()))
+	if err != nil || has {
+		return has, err
+	}
+	return indexStore.Has(indexKey("pake", c.String()))
+}
+
+func takeView(c cid.Cid) (bool, error) {
+	viewLimitsMutex.Lock()
+	defer viewLimitsMutex.Unlock()
+
+	// Look for view limit, none means unlimited
+	key := indexKey("views", c.String())
+	limit := viewLimit{}
+	err := indexGet(key, &limit)
+	if err == ds.ErrNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// Check if views already exhausted
+	if limit.Count >= limit.Max {
+		return false, nil
+	}
+
+	// Increment view count and persist
+	limit.Count++
+	err = indexPut(key, &limit)
+	if err != nil {
+		return false, err
+	}
+
+	// If this was the last view, unpin the paste and run expire hook
+	if limit.Count >= limit.Max {
+		err = unpinPaste(c)
+		if err != nil {
+			log.Printf("Failed to unpin view-limited paste %s - %s\n", c.String(), err.Error())
+		}
+		err = unmirrorPaste(c)
+		if err != nil {
+			log.Printf("Failed to unmirror view-limited paste %s - %s\n", c.String(), err.Error())
+		}
+		runHook(hookOnExpire, c)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// memoryBackend is an in-memory Backend that pins itself
+type memoryBackend struct {
+	blocks map[string][]byte
+	pinned map[string]bool
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{blocks: map[string][]byte{}, pinned: map[string]bool{}}
+}
+
+func (backend *memoryBackend) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	_, ok := backend.blocks[c.KeyString()]
+	return ok, nil
+}
+
+func (backend *memoryBackend) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	b, ok := backend.blocks[c.KeyString()]
+	if !ok {
+		return nil, ds.ErrNotFound
+	}
+	return b, nil
+}
+
+func (backend *memoryBackend) Put(ctx context.Context, c cid.Cid, b []byte, opts PutOptions) error {
+	backend.blocks[c.KeyString()] = b
+	backend.pinned[c.KeyString()] = backend.pinned[c.KeyString()] || opts.Pin
+	return nil
+}
+
+func (backend *memoryBackend) Pin(ctx context.Context, c cid.Cid) error {
+	backend.pinned[c.KeyString()] = true
+	return nil
+}
+
+func (backend *memoryBackend) Unpin(ctx context.Context, c cid.Cid) error {
+	delete(backend.pinned, c.KeyString())
+	return nil
+}
+
+func TestGatePasteDuplicate(t *testing.T) {
+	indexStore = ds.NewMapDatastore()
+	backend := newMemoryBackend()
+	storageBackend = backend
+
+	c, err := newPasteCIDPrefix(mh.SHA2_256).Sum([]byte("someone else's paste"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := indexKey("views", c.String())
+
+	// Re-uploads of an existing paste must not view-limit it
+	err = gatePaste(c, true, nil, 1)
+	if err != errPasteExists {
+		t.Fatalf("expected errPasteExists on duplicate, got %v", err)
+	}
+	has, err := indexStore.Has(key)
+	if err != nil || has {
+		t.Fatalf("duplicate upload set a view limit (has %v, err %v)", has, err)
+	}
+	if backend.pinned[c.KeyString()] {
+		t.Fatal("duplicate upload pinned the existing paste")
+	}
+
+	// Nor gate it behind PAKE
+	err = gatePaste(c, true, &pakeVerifier{}, 0)
+	if err != errPasteExists {
+		t.Fatalf("expected errPasteExists on duplicate PAKE, got %v", err)
+	}
+
+	// New pastes still get their limit
+	err = gatePaste(c, false, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	has, err = indexStore.Has(key)
+	if err != nil || !has {
+		t.Fatalf("new paste view limit not set (has %v, err %v)", has, err)
+	}
+	if !backend.pinned[c.KeyString()] {
+		t.Fatal("view-limited paste not pinned")
+	}
+}
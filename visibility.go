@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	recentPath = "/recent"
+
+	// Maximum number of pastes in recent feed
+	maxRecentPastes = 50
+)
+
+func parseVisibility(visibility string) (bool, error) {
+	switch visibility {
+	case "", "unlisted":
+		return false, nil
+	case "public":
+		return true, nil
+	default:
+		return false, errors.New("Invalid visibility: " + visibility)
+	}
+}
+
+func publishPaste(c cid.Cid, text []byte) error {
+	// Mark paste as public
+	err := indexStore.Put(indexKey("public", c.String()), []byte{})
+	if err != nil {
+		return err
+	}
+
+	// Index simhash for related paste suggestions
+	err = putSimhash(c, text)
+	if err != nil {
+		return err
+	}
+
+	// Add to time-ordered recent feed
+	name := fmt.Sprintf("%020d_%s", time.Now().UnixNano(), c.String())
+	return indexStore.Put(indexKey("recent", name), []byte{})
+}
+
+func isPublicPaste(cidStr string) (bool, error) {
+	return indexStore.Has(indexKey("public", cidStr))
+}
+
+func filterPublicPastes(cids []string) ([]string, error) {
+	public := []string{}
+	for _, cidStr := range cids {
+		ok, err := isPublicPaste(cidStr)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			public = append(public, cidStr)
+		}
+	}
+	return public, nil
+}
+
+func recentPublicPastes(limit int) ([]string, error) {
+	// Get time-ordered feed entries
+	names, err := indexList("recent")
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	// Extract CIDs, skipping duplicates
+	cids := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		split := strings.SplitN(name, "_", 2)
+		if len(split) != 2 || seen[split[1]] {
+			continue
+		}
+		seen[split[1]] = true
+		cids = append(cids, split[1])
+		if len(cids) >= limit {
+			break
+		}
+	}
+
+	// Only include those still public
+	return filterPublicPastes(cids)
+}
+
+func recentHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	// Log the request
+	logRequest("GET", recentPath, request.RemoteAddr)
+
+	// Get recent public pastes
+	cids, err := recentPublicPastes(maxRecentPastes)
+	if err != nil {
+		log.Printf("Failed to list recent pastes - %s\n", err.Error())
+		http.Error(writer, "Failed to list recent pastes", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the paste list in response
+	writePasteList(writer, request, "Recent pastes", cids)
+}
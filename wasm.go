@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WASM content plugin ABI
+//
+// A plugin is a .wasm module exporting its linear "memory", an allocator
+// and any of the content functions below. Each paste gets a fresh module
+// instance with no filesystem, network or environment access.
+//
+//	gibon_alloc(size i32) -> ptr i32
+//	  Allocate size bytes of module memory for the paste content.
+//
+//	gibon_transform(ptr i32, len i32) -> i64
+//	  Return replacement content (e.g. redacted or converted).
+//	gibon_validate(ptr i32, len i32) -> i64
+//	  Return a rejection reason, empty to accept the paste.
+//	gibon_classify(ptr i32, len i32) -> i64
+//	  Return comma-separated tags to add to the paste.
+//
+// Content functions return their output packed as (ptr << 32 | len).
+// Plugins run in file name order, each seeing the previous plugin's output.
+const (
+	wasmAllocExport     = "gibon_alloc"
+	wasmTransformExport = "gibon_transform"
+	wasmValidateExport  = "gibon_validate"
+	wasmClassifyExport  = "gibon_classify"
+
+	// WASM memory page size
+	wasmPageSize = 65536
+)
+
+var (
+	// Directory of .wasm content plugins (disabled if unset)
+	wasmPluginsDir string
+
+	// Maximum run time per plugin per paste
+	wasmPluginTimeout time.Duration
+
+	// Maximum memory per plugin instance (in bytes)
+	wasmPluginMemory uint64
+
+	// Shared runtime and loaded plugins
+	wasmRuntime wazero.Runtime
+	wasmPlugins []*wasmPlugin
+
+	// Returned when a paste is rejected by a validator plugin
+	errPluginRejected = errors.New("rejected by plugin")
+)
+
+type wasmPlugin struct {
+	name     string
+	compiled wazero.CompiledModule
+	exports  map[string]bool
+}
+
+type pluginResult struct {
+	text   []byte
+	tags   []string
+	reason string
+}
+
+func setupWASMPlugins() error {
+	// Skip if disabled
+	if wasmPluginsDir == "" {
+		return nil
+	}
+
+	// Create the sandboxed runtime, interrupted on timeout
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(wasmPluginMemory / wasmPageSize)).
+		WithCloseOnContextDone(true)
+	wasmRuntime = wazero.NewRuntimeWithConfig(globalContext, config)
+
+	// WASI for toolchains that expect it, no host resources are configured
+	_, err := wasi_snapshot_preview1.Instantiate(globalContext, wasmRuntime)
+	if err != nil {
+		return err
+	}
+
+	// Compile each plugin in name order
+	paths, err := filepath.Glob(filepath.Join(wasmPluginsDir, "*.wasm"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		plugin, err := loadWASMPlugin(path)
+		if err != nil {
+			return errors.New("Failed to load WASM plugin " + path + " - " + err.Error())
+		}
+		wasmPlugins = append(wasmPlugins, plugin)
+		log.Printf("Loaded WASM plugin %s\n", plugin.name)
+	}
+
+	return nil
+}
+
+func loadWASMPlugin(path string) (*wasmPlugin, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := wasmRuntime.CompileModule(globalContext, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check plugin implements the ABI
+	exports := map[string]bool{}
+	for name := range compiled.ExportedFunctions() {
+		exports[name] = true
+	}
+	if !exports[wasmAllocExport] {
+		return nil, errors.New("missing " + wasmAllocExport + " export")
+	} else if !exports[wasmTransformExport] && !exports[wasmValidateExport] && !exports[wasmClassifyExport] {
+		return nil, errors.New("no content function exports")
+	}
+
+	return &wasmPlugin{
+		name:     strings.TrimSuffix(filepath.Base(path), ".wasm"),
+		compiled: compiled,
+		exports:  exports,
+	}, nil
+}
+
+func runWASMPlugins(b []byte) (*pluginResult, error) {
+	result := &pluginResult{text: b}
+	for _, plugin := range wasmPlugins {
+		err := plugin.run(result)
+		if err != nil {
+			return nil, errors.New(plugin.name + ": " + err.Error())
+		} else if result.reason != "" {
+			return result, errPluginRejected
+		}
+	}
+	return result, nil
+}
+
+func (plugin *wasmPlugin) run(result *pluginResult) error {
+	// Fresh instance per paste, so no state leaks between pastes
+	ctx, cancel := context.WithTimeout(globalContext, wasmPluginTimeout)
+	defer cancel()
+	mod, err := wasmRuntime.InstantiateModule(ctx, plugin.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return err
+	}
+	defer mod.Close(ctx)
+
+	// Transform content
+	if plugin.exports[wasmTransformExport] {
+		out, err := callWASMContent(ctx, mod, wasmTransformExport, result.text)
+		if err != nil {
+			return err
+		} else if int64(len(out)) > maxPasteSize {
+			return errors.New("transformed paste exceeds max paste size")
+		}
+		result.text = out
+	}
+
+	// Validate content
+	if plugin.exports[wasmValidateExport] {
+		out, err := callWASMContent(ctx, mod, wasmValidateExport, result.text)
+		if err != nil {
+			return err
+		}
+		result.reason = string(out)
+		if result.reason != "" {
+			return nil
+		}
+	}
+
+	// Classify content
+	if plugin.exports[wasmClassifyExport] {
+		out, err := callWASMContent(ctx, mod, wasmClassifyExport, result.text)
+		if err != nil {
+			return err
+		}
+		tags, err := parseTags(string(out))
+		if err != nil {
+			return err
+		}
+		result.tags = append(result.tags, tags...)
+	}
+
+	return nil
+}
+
+func callWASMContent(ctx context.Context, mod api.Module, export string, b []byte) ([]byte, error) {
+	// Copy content into module memory
+	ret, err := mod.ExportedFunction(wasmAllocExport).Call(ctx, uint64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(ret[0])
+	if !mod.Memory().Write(ptr, b) {
+		return nil, errors.New("allocation out of memory range")
+	}
+
+	// Call content function, unpack output location
+	ret, err = mod.ExportedFunction(export).Call(ctx, uint64(ptr), uint64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	outPtr, outLen := uint32(ret[0]>>32), uint32(ret[0])
+	out, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, errors.New("output out of memory range")
+	}
+
+	// Copy out, the instance memory goes away on close
+	return append([]byte{}, out...), nil
+}
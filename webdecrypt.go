@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/grufwub/gibon/crypto"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+type decryptPageData struct {
+	uiPrefs
+	Path   string
+	Raw    string
+	Hint   string
+	Server bool
+
+	// RFC 3339 expiry time, counted down in the page
+	ExpiresAt string
+}
+
+func browserDecryptable(p *paste) bool {
+	// Browsers can derive SHA-256 keys and open E2E pastes, not Argon2id, wrapped keys or bound metadata
+	switch p.sealing {
+	case pasteSealingLegacy, pasteSealingE2E:
+		return true
+	case pasteSealingEnvelope:
+		env, err := p.cryptoEnvelope()
+		return err == nil && env.KDF == crypto.KDFSHA256 && env.Version < 3
+	default:
+		return false
+	}
+}
+
+func renderDecryptPage(writer http.ResponseWriter, request *http.Request, c cid.Cid, p *paste, meta *pasteMeta) {
+	// Key stays in the URL fragment, the page fetches raw ciphertext and decrypts in browser,
+	// else sends the key in a header for the server to decrypt
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Referrer-Policy", "no-referrer")
+	data := &decryptPageData{
+		Path:   pastePrefix + c.String(),
+		Raw:    pastePrefix + c.String() + "/raw",
+		Hint:   meta.Hint,
+		Server: !browserDecryptable(p),
+	}
+	if at := expiryTime(meta.Expires); at != nil {
+		data.ExpiresAt = at.Format(time.RFC3339)
+	}
+	renderPage(writer, request, "decrypt.html", data)
+}
+
+func rawPasteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// Get the CID string
+	cidStr := params.ByName("cid")
+
+	// Log the request
+	logRequest("GET", pastePrefix+cidStr+"/raw", request.RemoteAddr)
+
+	// Decode the paste CID, DAG nodes resolving to their paste
+	c, err := cid.Decode(cidStr)
+	if err == nil {
+		c, err = resolvePasteCID(request.Context(), c)
+	}
+	if err != nil {
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Refuse pastes blocked by moderation
+	if refuseBlockedPaste(writer, c) {
+		return
+	}
+
+	// Try look for paste with CID, fetching from public gateways if not stored here
+	p, err := getPaste(c)
+	if err != nil && cacheFromGateways(request.Context(), c) {
+		p, err = getPaste(c)
+	}
+	if err != nil {
+		log.Printf("Paste not retrieved - %s\n", err.Error())
+		if serveFromPeer(writer, request, c) {
+			return
+		}
+		http.Error(writer, "Paste not found!", http.StatusNotFound)
+		return
+	}
+
+	// Count this view against any view limit
+	ok, err := takeView(c)
+	if err != nil {
+		log.Printf("Failed to check paste view limit - %s\n", err.Error())
+		http.Error(writer, "Failed to check paste view limit", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(writer, "Paste view limit reached!", http.StatusGone)
+		return
+	}
+
+	// Record view statistics (non-fatal)
+	err = recordPasteView(c)
+	if err != nil {
+		log.Printf("Failed to record paste view - %s\n", err.Error())
+	}
+
+	// Write the stored paste envelope as-is, header describes how to open it
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.Header().Set("content-type", "application/octet-stream")
+	writer.Write(p.marshal())
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/grufwub/gibon/crypto"
+)
+
+// signedE2EFixture returns a stored signed E2E paste block, its raw key and plaintext
+func signedE2EFixture(t *testing.T) ([]byte, []byte, []byte) {
+	key := bytes.Repeat([]byte{0x42}, crypto.KeySize)
+	nonce := bytes.Repeat([]byte{0x24}, crypto.GCMNonceSize)
+	plain := []byte("signed e2e paste")
+
+	// E2E blob as a browser would upload it: raw key, no KDF params
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := []byte{crypto.E2EMarker, crypto.E2EVersion, crypto.E2EKDFRaw, crypto.CipherAES256GCM, crypto.GCMNonceSize, 0, 0}
+	blob = append(blob, nonce...)
+	blob = gcm.Seal(blob, nonce, plain, nil)
+
+	// Signed by the uploader over the blob as-is
+	p, err := newE2EPaste(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, signingKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.signer, p.signature = signer, signWithContext(signingKey, pasteSigContext, blob)
+	return p.marshal(), key, plain
+}
+
+func TestSignedE2EBrowserDecryptable(t *testing.T) {
+	b, key, plain := signedE2EFixture(t)
+
+	p, err := unmarshalPaste(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.signature == nil || !verifyWithContext(p.signer, pasteSigContext, p.text, p.signature) {
+		t.Fatal("fixture signature lost or invalid")
+	}
+	if !browserDecryptable(p) {
+		t.Fatal("signed E2E paste not browser decryptable")
+	}
+
+	// Browsers skip the header and signature block (decrypt.js parseEnvelope), then open the blob there
+	start := len(pasteEnvelopeMagic) + 3 + pasteSignatureBlockSize
+	text := b[start:]
+	if text[0] != crypto.E2EMarker {
+		t.Fatalf("E2E marker not at offset %d after signature block", start)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := text[crypto.E2EHeaderSize : crypto.E2EHeaderSize+crypto.GCMNonceSize]
+	opened, err := gcm.Open(nil, nonce, text[crypto.E2EHeaderSize+crypto.GCMNonceSize:], nil)
+	if err != nil {
+		t.Fatalf("failed to open blob at browser offset - %s", err.Error())
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Fatal("opened text doesn't match fixture plaintext")
+	}
+}